@@ -166,6 +166,13 @@ function eyot_test {
 		return 1
 	}
 
+    # tests written in eyot
+	$TempBinary test "$ScriptDir"/../tests/testing || {
+		echo "Eyot tests failed"
+		popd > /dev/null
+		return 1
+	}
+
 	echo "All tests passed!"
 	popd > /dev/null
 }
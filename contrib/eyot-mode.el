@@ -22,7 +22,7 @@
         "print" "print_ln"
         "cpu" "gpu" "send" "receive" "worker" "drain"
         "pixel" "vertex" "pipeline" "gpubuiltin"
-        "import" "as"
+        "import" "as" "from" "pub"
      )
 
     ; misc font locks
@@ -169,9 +169,11 @@
             buildInputs = [
               pkgs.mkdocs
               deb
+              eyot_package
             ];
             
             buildPhase = ''
+              eyot doc -markdown=docs/reference std
               mkdocs build
             '';
 
@@ -0,0 +1,84 @@
+/*
+  Eyot runtime benchmarking
+
+  eyot bench runs the binary once for each bench block, which is timed here and reported on a
+  single line that eyot bench reads back
+ */
+
+// for clock_gettime under -std=c99
+#define _POSIX_C_SOURCE 200809L
+
+#include "eyot-runtime-cpu.h"
+
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <time.h>
+
+// never run more than this many iterations, however quick the body is
+#define k_bench_max_iterations 1000000000LL
+
+// elements sent to workers during the current round
+static int64_t bench_elements = 0;
+
+static int64_t bench_now(void) {
+    struct timespec ts;
+    clock_gettime(CLOCK_MONOTONIC, &ts);
+    return (int64_t)ts.tv_sec * 1000000000 + (int64_t)ts.tv_nsec;
+}
+
+static int64_t bench_target_ns(void) {
+    const char *target = getenv("EyotBenchTime");
+    if (target) {
+        const long long ns = atoll(target);
+        if (ns > 0) {
+            return ns;
+        }
+    }
+    return 1000000000;
+}
+
+EyVector *ey_runtime_bench_sent(EyExecutionContext *ctx, EyVector *values) {
+    __atomic_add_fetch(&bench_elements, ey_vector_length(ctx, values), __ATOMIC_RELAXED);
+    return values;
+}
+
+void ey_runtime_bench(EyExecutionContext *ctx, void (*body)(EyExecutionContext *), EyBoolean gpu) {
+    if (gpu && !ey_runtime_check_cl(ctx)) {
+        printf("ey-bench-skip no gpu\n");
+        return;
+    }
+
+    const int64_t target = bench_target_ns();
+    int64_t n = 1;
+    for (;;) {
+        const EyGCStats before = ey_runtime_gc_get_stats(ey_runtime_gc(ctx));
+        __atomic_store_n(&bench_elements, 0, __ATOMIC_RELAXED);
+
+        const int64_t start = bench_now();
+        for (int64_t i = 0; i < n; i += 1) {
+            body(ctx);
+        }
+        const int64_t elapsed = bench_now() - start;
+
+        if (elapsed >= target || n >= k_bench_max_iterations) {
+            const EyGCStats after = ey_runtime_gc_get_stats(ey_runtime_gc(ctx));
+            fflush(stdout);
+            printf("ey-bench %lld %lld %lld %lld %lld\n", (long long)n, (long long)elapsed,
+                   (long long)(after.allocations - before.allocations),
+                   (long long)(after.total_bytes - before.total_bytes),
+                   (long long)__atomic_load_n(&bench_elements, __ATOMIC_RELAXED));
+            return;
+        }
+
+        // aim a little past the target, but grow by no more than 100 times a round
+        double next = elapsed > 0 ? (double)n * (double)target * 1.2 / (double)elapsed : n * 100.0;
+        if (next > n * 100.0) {
+            next = n * 100.0;
+        }
+        if (next > k_bench_max_iterations) {
+            next = k_bench_max_iterations;
+        }
+        n = (int64_t)next > n ? (int64_t)next : n + 1;
+    }
+}
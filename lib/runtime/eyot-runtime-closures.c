@@ -7,7 +7,8 @@
 #include <string.h>
 
 EyClosure ey_closure_create(int fid, void **args) {
-    EyClosure c = ey_runtime_gc_alloc(ey_runtime_gc(0), ey_generated_closure_size(fid), 0);
+    EyClosure c = ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), ey_generated_closure_size(fid), 0,
+                                              "closure");
     *(int *)c = fid;
 
     const int ac = ey_generated_arg_count(fid);
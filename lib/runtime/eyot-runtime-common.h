@@ -1,3 +1,7 @@
+// only the generated program calls closures, so the runtime is compiled without knowing the count
+#ifndef EYOT_RUNTIME_MAX_ARGS
+#define EYOT_RUNTIME_MAX_ARGS 1
+#endif
 #define k_ey_max_arg_count EYOT_RUNTIME_MAX_ARGS
 
 #define EYOT_RUNTIME_DEV_CHECKS 1
@@ -81,7 +81,8 @@ static EyVector *ey_pipeline_drain(EyWorker *wrkr) {
 EyWorker *ey_worker_create_pipeline(EyWorker *lhs, EyWorker *rhs) {
     EyGCRegion *gc = ey_runtime_gc(0);
 
-    EyNaivePipeline *pipeline = ey_runtime_gc_alloc(gc, sizeof(EyNaivePipeline), 0);
+    EyNaivePipeline *pipeline =
+        ey_runtime_gc_alloc_tagged(gc, sizeof(EyNaivePipeline), 0, "pipeline");
     if (!pipeline) {
         ey_runtime_panic("ey_worker_create_pipeline", "failed to allocate pipeline");
     }
@@ -94,7 +95,7 @@ EyWorker *ey_worker_create_pipeline(EyWorker *lhs, EyWorker *rhs) {
 
     pthread_create(&pipeline->thread, 0, thread_entry, pipeline);
 
-    EyWorker *w = ey_runtime_gc_alloc(gc, sizeof(EyWorker), 0);
+    EyWorker *w = ey_runtime_gc_alloc_tagged(gc, sizeof(EyWorker), 0, "worker");
     if (!w) {
         ey_runtime_panic("ey_worker_create_pipeline", "failed to allocate worker");
     }
@@ -7,6 +7,7 @@
 #include "eyot-runtime-cpu.h"
 #include "eyot-runtime-pipe.h"
 
+#include <stdio.h>
 #include <string.h>
 #include <pthread.h>
 
@@ -40,6 +41,7 @@ static void ey_worker_send(EyWorker *wrkr, EyVector *values) {
     EyCpuWorker *w = wrkr->ctx;
 
     const int l = ey_vector_length(0, values);
+    const uint64_t trace_start = ey_trace_enabled() ? ey_trace_now() : 0;
 
     pthread_mutex_lock(&w->mutex);
     w->underway_count += l;
@@ -48,10 +50,17 @@ static void ey_worker_send(EyWorker *wrkr, EyVector *values) {
     for (int i = 0; i < l; i += 1) {
         ey_pipe_send(w->input_pipe, ey_vector_access(0, values, i));
     }
+
+    if (trace_start) {
+        char args[64];
+        snprintf(args, sizeof(args), "{\"count\":%d}", l);
+        ey_trace_event("worker", "cpu send", trace_start, ey_trace_now(), args);
+    }
 }
 
 static void ey_worker_receive(EyWorker *wrkr, void *value) {
     EyCpuWorker *w = wrkr->ctx;
+    const uint64_t trace_start = ey_trace_enabled() ? ey_trace_now() : 0;
 
     if (ey_pipe_receive(w->output_pipe, value)) {
         pthread_mutex_lock(&w->mutex);
@@ -60,6 +69,10 @@ static void ey_worker_receive(EyWorker *wrkr, void *value) {
     } else {
         ey_runtime_panic("ey_worker_receive", "failed to receive");
     }
+
+    if (trace_start) {
+        ey_trace_event("worker", "cpu receive", trace_start, ey_trace_now(), 0);
+    }
 }
 
 static EyVector *ey_worker_drain(EyWorker *wrkr) {
@@ -110,7 +123,12 @@ void ey_worker_entry_point(EyCpuWorker *w) {
     EyExecutionContext *ectx = 0;
 
     while (ey_pipe_receive(w->input_pipe, input)) {
+        const uint64_t trace_start = ey_trace_enabled() ? ey_trace_now() : 0;
         w->fn(ectx, input, output, w->ctx);
+        if (trace_start) {
+            ey_trace_event("worker", "cpu run", trace_start, ey_trace_now(), 0);
+        }
+
         if (output) {
             ey_pipe_send(w->output_pipe, output);
         } else {
@@ -144,11 +162,12 @@ EyWorker *ey_worker_create_cpu(EyWorkerFunction fn, int input_size, int output_s
     */
     void *ctx = 0;
     if (raw_ctx) {
-        ctx = ey_runtime_gc_alloc(ey_runtime_gc(0), ctx_size, 0);
+        ctx = ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), ctx_size, 0, "worker context");
         memcpy(ctx, raw_ctx, ctx_size);
     }
 
-    EyCpuWorker *wrkr = ey_runtime_gc_alloc(ey_runtime_gc(0), sizeof(EyCpuWorker), 0);
+    EyCpuWorker *wrkr =
+        ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), sizeof(EyCpuWorker), 0, "cpu worker");
     if (!wrkr) {
         ey_runtime_panic("ey_worker_create_cpu", "failed to allocate cpu worker");
     }
@@ -173,7 +192,8 @@ EyWorker *ey_worker_create_cpu(EyWorkerFunction fn, int input_size, int output_s
     }
     pthread_create(&wrkr->thread, 0, thread_entry, wrkr);
 
-    EyWorker *w = ey_runtime_gc_alloc(ey_runtime_gc(0), sizeof(EyWorker), finalise_cpu_worker);
+    EyWorker *w = ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), sizeof(EyWorker),
+                                             finalise_cpu_worker, "worker");
     if (!w) {
         ey_runtime_panic("ey_worker_create_cpu", "failed to allocate worker");
     }
@@ -26,6 +26,18 @@ typedef void (*EyWorkerFunction)(EyExecutionContext *ey_execution_context, void
 */
 void ey_generated_main(EyExecutionContext *ctx);
 
+/*
+  Start the runtime, with the program's arguments, before any eyot code is run
+
+  main does this for a program, and a library's init function for a library
+*/
+void ey_runtime_init(EyExecutionContext *ctx, int argc, const char **argv);
+
+/*
+  Stop the runtime, once no more eyot code will be run
+*/
+void ey_runtime_shutdown(void);
+
 /*
   No return panic
 
@@ -175,13 +187,78 @@ void ey_init_opencl(const char *src);
  */
 EyBoolean ey_runtime_check_cl(EyExecutionContext *ey_execution_context);
 
+/*
+  Device queries used by std::runtime, the index is into the list of all devices on all platforms
+ */
+EyInteger ey_runtime_cl_device_count(EyExecutionContext *ctx);
+EyInteger ey_runtime_cl_default_device(EyExecutionContext *ctx);
+EyString ey_runtime_cl_device_name(EyExecutionContext *ctx, EyInteger index);
+EyInteger ey_runtime_cl_device_memory_mb(EyExecutionContext *ctx, EyInteger index);
+EyInteger ey_runtime_cl_device_compute_units(EyExecutionContext *ctx, EyInteger index);
+
 /*
   Initialise OpenCl
 
+  A negative device uses the default device (EyotClDevice, or the first GPU, or failing that the
+  first device)
   NB the parameter count is expected to have the same lif
  */
-EyWorker *ey_worker_create_opencl(const char *kernel, int input_size, int output_size,
-                                  void *closure_ptr, int closure_size);
+EyWorker *ey_worker_create_opencl(EyInteger device, const char *kernel, int input_size,
+                                  int output_size, void *closure_ptr, int closure_size);
+
+/*
+ * Tracing
+ */
+
+/*
+  Start writing a Chrome trace_event file to path, stopping any trace already underway
+ */
+void ey_trace_start(const char *path);
+
+/*
+  Start a trace if EyotTrace is set to a path
+ */
+void ey_trace_start_from_environment(void);
+
+/*
+  Finish and close the trace file, this is safe to call when not tracing
+ */
+void ey_trace_stop(void);
+
+/*
+  True when a trace is being written, this is cheap enough to check before timing anything
+ */
+EyBoolean ey_trace_enabled(void);
+
+/*
+  Current time in microseconds, in the trace's timebase
+ */
+uint64_t ey_trace_now(void);
+
+/*
+  Record a complete event on the calling thread
+
+  args is either 0 or a json object
+ */
+void ey_trace_event(const char *category, const char *name, uint64_t start, uint64_t end,
+                    const char *args);
+
+/*
+  Record a complete event on a track that does not correspond to a thread (e.g. a device)
+ */
+void ey_trace_event_on_track(int track, const char *category, const char *name, uint64_t start,
+                             uint64_t end, const char *args);
+
+/*
+  Give a track a display name
+ */
+void ey_trace_name_track(int track, const char *name);
+
+/*
+  std::runtime::trace_start and trace_stop
+ */
+void ey_runtime_trace_start(EyExecutionContext *ctx, EyString path);
+void ey_runtime_trace_stop(EyExecutionContext *ctx);
 
 /*
  * Closure
@@ -201,8 +278,30 @@ EyClosure ey_closure_create(int fid, void **args);
  */
 typedef struct EyGCRegion EyGCRegion;
 typedef struct EyGCStats {
-    int bytes_allocated;
-    int pages_allocated;
+    int64_t bytes_allocated;
+    int64_t pages_allocated;
+
+    // the most bytes allocated at any one time
+    int64_t peak_bytes;
+
+    // bytes still allocated at the end of the most recent collection
+    int64_t live_bytes;
+
+    // collections of either kind, and how many of those were young only
+    int64_t collections;
+    int64_t young_collections;
+
+    // time spent collecting, in microseconds
+    int64_t total_pause_us;
+    int64_t max_pause_us;
+    int64_t last_pause_us;
+
+    // root objects and stack pointers currently remembered
+    int64_t roots;
+
+    // every allocation so far and the bytes they asked for, neither goes down as memory is freed
+    int64_t allocations;
+    int64_t total_bytes;
 } EyGCStats;
 
 /*
@@ -213,11 +312,42 @@ typedef struct EyGCStats {
  */
 typedef void (*Finaliser)(void *);
 
+typedef struct EyGCOptions {
+    /*
+      Share one heap between every thread, and hold its lock for the whole of a collection
+
+      This is how the collector originally worked, and is kept to measure against
+     */
+    EyBoolean serial;
+
+    /*
+      ey_runtime_gc_collect_if_needed does a full collection once the heap has grown by this
+      factor since the last full collection (EyotGcGrowth)
+     */
+    double growth_factor;
+
+    /*
+      ey_runtime_gc_collect_if_needed does a young collection once this many bytes have been
+      allocated since the last collection (EyotGcThreshold)
+     */
+    int64_t threshold_bytes;
+} EyGCOptions;
+
+/*
+  The options used by ey_runtime_gc_create, read from the environment where set
+ */
+EyGCOptions ey_runtime_gc_default_options(void);
+
 /*
   Create a new GC region
  */
 EyGCRegion *ey_runtime_gc_create(void);
 
+/*
+  Create a new GC region with non-default options
+ */
+EyGCRegion *ey_runtime_gc_create_with_options(EyGCOptions options);
+
 /*
   The global active GC
  */
@@ -240,6 +370,14 @@ void ey_runtime_gc_free(EyGCRegion *);
  */
 void *ey_runtime_gc_alloc(EyGCRegion *region, int block_size, Finaliser fn);
 
+/*
+  Alloc a new block, tagged with what it is for the heap dump
+
+  NB the tag must outlive the allocation, which in practice means it is a literal
+ */
+void *ey_runtime_gc_alloc_tagged(EyGCRegion *region, int block_size, Finaliser fn,
+                                 const char *tag);
+
 /*
   Resize an existing block
 
@@ -270,6 +408,35 @@ void ey_runtime_gc_forget_root_object(EyGCRegion *region, void *ptr);
  */
 void ey_runtime_gc_collect(EyGCRegion *region);
 
+/*
+  Trigger a collection of the young generation only
+
+  Every old page is scanned as a root, as there is no write barrier to record which old pages
+  point to young ones. Old pages are not marked or swept, so this is cheaper than a full
+  collection, but it still takes time proportional to the size of the old generation
+  NB this assumes it is safe to do so
+ */
+void ey_runtime_gc_collect_young(EyGCRegion *region);
+
+/*
+  Collect if the tuning says it is worthwhile, see EyGCOptions
+
+  NB this assumes it is safe to do so
+ */
+void ey_runtime_gc_collect_if_needed(EyGCRegion *region);
+
+/*
+  Change the tuning of a region, values <= 0 leave that setting alone
+ */
+void ey_runtime_gc_set_tuning(EyGCRegion *region, double growth_factor, int64_t threshold_bytes);
+
+/*
+  Write every allocation to path as JSON, grouped by tag, along with what each root retains
+
+  Returns false if the file could not be written
+ */
+EyBoolean ey_runtime_gc_dump(EyGCRegion *region, const char *path);
+
 /*
   How much has been allocated
 
@@ -294,6 +461,38 @@ void ey_runtime_gc_forget_root_pointer(EyGCRegion *region, const void *ptr);
  */
 void ey_runtime_collect(EyExecutionContext *ctx);
 
+/*
+  Trigger a collection of the young generation
+
+  NB this is exposed to eyot
+ */
+void ey_runtime_collect_young(EyExecutionContext *ctx);
+
+/*
+  GC statistics, tuning and heap dumps
+
+  NB these are exposed to eyot, which reads the statistics one at a time
+ */
+typedef enum {
+    k_gc_stat_bytes_allocated = 0,
+    k_gc_stat_pages_allocated = 1,
+    k_gc_stat_peak_bytes = 2,
+    k_gc_stat_live_bytes = 3,
+    k_gc_stat_collections = 4,
+    k_gc_stat_young_collections = 5,
+    k_gc_stat_total_pause_us = 6,
+    k_gc_stat_max_pause_us = 7,
+    k_gc_stat_last_pause_us = 8,
+    k_gc_stat_roots = 9,
+    k_gc_stat_allocations = 10,
+    k_gc_stat_total_bytes = 11,
+} EyGCStat;
+EyInteger ey_runtime_gc_stat(EyExecutionContext *ctx, EyInteger which);
+void ey_runtime_collect_if_needed(EyExecutionContext *ctx);
+void ey_runtime_gc_set_growth_factor(EyExecutionContext *ctx, EyFloat64 factor);
+void ey_runtime_gc_set_threshold(EyExecutionContext *ctx, EyInteger bytes);
+EyBoolean ey_runtime_gc_dump_heap(EyExecutionContext *ctx, EyString path);
+
 /*
  * String functions
  */
@@ -348,6 +547,29 @@ EyString ey_runtime_string_resize(EyExecutionContext *ctx, EyString s, EyInteger
 // Get args passed on boot
 EyVector *ey_runtime_get_args(EyExecutionContext *ctx);
 
+/*
+  A failed assert, this prints the message and where the assert is then exits
+ */
+__attribute__((noreturn)) void ey_runtime_assert_failed(EyExecutionContext *ctx, const char *where, EyString msg);
+
+/*
+  The test or bench that eyot test or eyot bench asked for (EyotTestIndex), or -1 when run otherwise
+ */
+EyInteger ey_runtime_test_index(void);
+
+/*
+  Run a bench block until it has taken EyotBenchTime nanoseconds (a second by default), growing
+  the iteration count each round, then print one result line for eyot bench
+
+  A bench that creates gpu workers is skipped when there is no usable device
+ */
+void ey_runtime_bench(EyExecutionContext *ctx, void (*body)(EyExecutionContext *), EyBoolean gpu);
+
+/*
+  Count the elements sent to a worker within a bench, returning the values to be sent
+ */
+EyVector *ey_runtime_bench_sent(EyExecutionContext *ctx, EyVector *values);
+
 /*
   String to utf8 string for syscalls
   This should be manually released after
@@ -36,8 +36,78 @@ void ey_runtime_collect(EyExecutionContext *ctx) {
     return ey_runtime_gc_collect(ey_runtime_gc(ctx));
 }
 
+void ey_runtime_collect_young(EyExecutionContext *ctx) {
+    ey_runtime_gc_collect_young(ey_runtime_gc(ctx));
+}
+
+/*
+  Eyot integers are a 32-bit C int, narrower than the statistics, so saturate rather than wrap
+ */
+static EyInteger saturate_stat(int64_t value) {
+    if (value > INT32_MAX) {
+        return INT32_MAX;
+    }
+    return (EyInteger)value;
+}
+
 EyInteger ey_runtime_allocated_bytes(EyExecutionContext *ctx) {
-    return ey_runtime_gc_get_stats(ey_runtime_gc(ctx)).bytes_allocated;
+    return saturate_stat(ey_runtime_gc_get_stats(ey_runtime_gc(ctx)).bytes_allocated);
+}
+
+EyInteger ey_runtime_gc_stat(EyExecutionContext *ctx, EyInteger which) {
+    const EyGCStats stats = ey_runtime_gc_get_stats(ey_runtime_gc(ctx));
+    switch (which) {
+        case k_gc_stat_bytes_allocated:
+            return saturate_stat(stats.bytes_allocated);
+        case k_gc_stat_pages_allocated:
+            return saturate_stat(stats.pages_allocated);
+        case k_gc_stat_peak_bytes:
+            return saturate_stat(stats.peak_bytes);
+        case k_gc_stat_live_bytes:
+            return saturate_stat(stats.live_bytes);
+        case k_gc_stat_collections:
+            return saturate_stat(stats.collections);
+        case k_gc_stat_young_collections:
+            return saturate_stat(stats.young_collections);
+        case k_gc_stat_total_pause_us:
+            return saturate_stat(stats.total_pause_us);
+        case k_gc_stat_max_pause_us:
+            return saturate_stat(stats.max_pause_us);
+        case k_gc_stat_last_pause_us:
+            return saturate_stat(stats.last_pause_us);
+        case k_gc_stat_roots:
+            return saturate_stat(stats.roots);
+        case k_gc_stat_allocations:
+            return saturate_stat(stats.allocations);
+        case k_gc_stat_total_bytes:
+            return saturate_stat(stats.total_bytes);
+    }
+    ey_runtime_panic("ey_runtime_gc_stat", "unknown statistic");
+}
+
+void ey_runtime_collect_if_needed(EyExecutionContext *ctx) {
+    ey_runtime_gc_collect_if_needed(ey_runtime_gc(ctx));
+}
+
+void ey_runtime_gc_set_growth_factor(EyExecutionContext *ctx, EyFloat64 factor) {
+    if (factor <= 1.0) {
+        ey_runtime_panic("ey_runtime_gc_set_growth_factor", "the growth factor must be above 1");
+    }
+    ey_runtime_gc_set_tuning(ey_runtime_gc(ctx), factor, 0);
+}
+
+void ey_runtime_gc_set_threshold(EyExecutionContext *ctx, EyInteger bytes) {
+    if (bytes <= 0) {
+        ey_runtime_panic("ey_runtime_gc_set_threshold", "the threshold must be positive");
+    }
+    ey_runtime_gc_set_tuning(ey_runtime_gc(ctx), 0, bytes);
+}
+
+EyBoolean ey_runtime_gc_dump_heap(EyExecutionContext *ctx, EyString path) {
+    const char *cpath = ey_runtime_string_create_c_string(path);
+    const EyBoolean written = ey_runtime_gc_dump(ey_runtime_gc(ctx), cpath);
+    ey_runtime_manual_free((void *)cpath);
+    return written;
 }
 
 static EyVector *args_vector = 0;
@@ -46,8 +116,25 @@ EyVector *ey_runtime_get_args(EyExecutionContext *ctx __attribute__((unused))) {
     return args_vector;
 }
 
-int main(int argc, const char **argv) {
+void ey_runtime_assert_failed(EyExecutionContext *ctx __attribute__((unused)), const char *where, EyString msg) {
+    const char *cmsg = ey_runtime_string_create_c_string(msg);
+    fflush(stdout);
+    fprintf(stderr, "%s: assertion failed: %s\n", where, cmsg);
+    ey_runtime_manual_free((void *)cmsg);
+    exit(1);
+}
+
+EyInteger ey_runtime_test_index(void) {
+    const char *index = getenv("EyotTestIndex");
+    if (!index) {
+        return -1;
+    }
+    return (EyInteger)atoi(index);
+}
+
+void ey_runtime_init(EyExecutionContext *ctx, int argc, const char **argv) {
     global_gc = ey_runtime_gc_create();
+    ey_trace_start_from_environment();
 
 #if defined(EYOT_OPENCL_INCLUDED)
     if (ey_runtime_cl_src) {
@@ -55,18 +142,30 @@ int main(int argc, const char **argv) {
     }
 #endif  // EYOT_OPENCL_INCLUDED
 
-    EyExecutionContext ctx = {};
-
-    args_vector = ey_vector_create(&ctx, sizeof(EyString));
+    args_vector = ey_vector_create(ctx, sizeof(EyString));
     for (int i = 0; i < argc; i += 1) {
-        EyString arg = ey_runtime_string_create_literal(&ctx, argv[i]);
-        ey_vector_append(&ctx, args_vector, &arg);
+        EyString arg = ey_runtime_string_create_literal(ctx, argv[i]);
+        ey_vector_append(ctx, args_vector, &arg);
     }
     ey_runtime_gc_remember_root_object(global_gc, args_vector);
+}
 
-    ey_generated_main(&ctx);
-
+void ey_runtime_shutdown(void) {
     ey_runtime_gc_forget_root_object(global_gc, args_vector);
     ey_runtime_gc_free(global_gc);
+    ey_trace_stop();
+}
+
+/*
+  A library is started by the program it is linked into, see ey_runtime_init
+ */
+#ifndef EYOT_LIBRARY
+int main(int argc, const char **argv) {
+    EyExecutionContext ctx = {};
+
+    ey_runtime_init(&ctx, argc, argv);
+    ey_generated_main(&ctx);
+    ey_runtime_shutdown();
     return 0;
 }
+#endif  // EYOT_LIBRARY
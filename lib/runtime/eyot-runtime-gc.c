@@ -1,11 +1,33 @@
 /*
   Eyot Garbage collector
+
+  Each thread allocates into its own heap of young pages, so allocation only takes that heap's
+  lock. Pages that survive a collection are promoted to the shared old generation.
+
+  A young collection only frees young pages, treating every old page as a root. A full
+  collection frees from both generations.
+
+  Generated code stores pointers without a write barrier, so there is no remembered set of the
+  old pages that point to young ones. Instead a young collection scans the contents of every old
+  page for pointers to young pages. The scan does not mark or sweep the old pages, and only
+  follows pointers into young pages, but it is still proportional to the size of the old
+  generation. A write barrier with a card table would remove that scan, at the cost of every
+  pointer store in generated code.
+
+  Neither kind of collection stops threads that are allocating. The young pages are taken from
+  each heap at the start, and pages allocated after that are kept until the next collection,
+  though they are scanned at the end in case they reference a collecting page. Roots can't be
+  added or removed whilst marking, as the region's lock is held from taking the pages to the end
+  of the scan.
+
+  Locks are always taken in the order collect_mutex, old_mutex, the region's mutex, then a heap's.
  */
 
 #include "eyot-runtime-cpu.h"
 
 #include <stdlib.h>
 #include <stdio.h>
+#include <errno.h>
 #include <stdint.h>
 #include <string.h>
 #include <pthread.h>
@@ -18,13 +40,21 @@ const char *__asan_default_options(void) {
 // clearly this is platform dependent
 static const int k_pointer_alignment = 8;
 
+struct EyGCHeap;
+
 typedef struct PageHeader {
     // linked list
     struct PageHeader *next, *prev;
 
+    // The heap this is in whilst young, null once it is being collected or old
+    struct EyGCHeap *heap;
+
     // Finaliser
     Finaliser finaliser;
 
+    // What this is, for the heap dump
+    const char *tag;
+
     // Size of this allocation
     int size;
 
@@ -33,6 +63,9 @@ typedef struct PageHeader {
 
     // all marked
     EyBoolean marked;
+
+    // set once this has survived a collection
+    EyBoolean old;
 } PageHeader;
 
 /*
@@ -54,32 +87,159 @@ typedef struct {
     const void *pointer_to_pointer;
 } EyStackPointer;
 
+/*
+  The young pages allocated by one thread
+ */
+typedef struct EyGCHeap {
+    struct EyGCRegion *region;
+    struct EyGCHeap *next_heap;
+
+    // The lead page pointer in the linked list of young pages
+    PageHeader *young;
+
+    // set when the owning thread exits, so the heap can be picked up by a new thread
+    EyBoolean orphaned;
+
+    pthread_mutex_t mutex;
+} EyGCHeap;
+
 typedef struct EyGCRegion {
-    // The lead page pointer in the linked list of pages
-    PageHeader *root_page;
+    EyGCOptions options;
+
+    // Pages that have survived a collection, guarded by old_mutex
+    PageHeader *old_pages;
+
+    // Young pages taken from the heaps by the collection in progress, guarded by old_mutex
+    PageHeader *collecting_pages;
+    pthread_mutex_t old_mutex;
 
+    // All heaps, guarded by mutex. In serial mode there is only one
+    EyGCHeap *heaps;
+    pthread_key_t heap_key;
+
+    // updated atomically, so allocation doesn't need a shared lock
     EyGCStats stats;
+    int64_t allocated_since_collection;
+
+    // what the last full collection left, which the growth factor applies to
+    int64_t live_after_full_collection;
+
+    // stack pointers and root counts, guarded by mutex
     EyStackPointer *pointers;
     int pointers_allocated;
     pthread_mutex_t mutex;
+
+    // only one collection runs at a time
+    pthread_mutex_t collect_mutex;
 } EyGCRegion;
 
+// called with collect_mutex held, so no collection is part way through updating them
+static EyGCStats gc_read_stats(EyGCRegion *region) {
+    const EyGCStats *stats = &region->stats;
+    return (EyGCStats){
+        .bytes_allocated = __atomic_load_n(&stats->bytes_allocated, __ATOMIC_RELAXED),
+        .pages_allocated = __atomic_load_n(&stats->pages_allocated, __ATOMIC_RELAXED),
+        .peak_bytes = __atomic_load_n(&stats->peak_bytes, __ATOMIC_RELAXED),
+        .live_bytes = __atomic_load_n(&stats->live_bytes, __ATOMIC_RELAXED),
+        .collections = __atomic_load_n(&stats->collections, __ATOMIC_RELAXED),
+        .young_collections = __atomic_load_n(&stats->young_collections, __ATOMIC_RELAXED),
+        .total_pause_us = __atomic_load_n(&stats->total_pause_us, __ATOMIC_RELAXED),
+        .max_pause_us = __atomic_load_n(&stats->max_pause_us, __ATOMIC_RELAXED),
+        .last_pause_us = __atomic_load_n(&stats->last_pause_us, __ATOMIC_RELAXED),
+        .roots = __atomic_load_n(&stats->roots, __ATOMIC_RELAXED),
+        .allocations = __atomic_load_n(&stats->allocations, __ATOMIC_RELAXED),
+        .total_bytes = __atomic_load_n(&stats->total_bytes, __ATOMIC_RELAXED),
+    };
+}
+
 EyGCStats ey_runtime_gc_get_stats(EyGCRegion *region) {
-    return region->stats;
+    pthread_mutex_lock(&region->collect_mutex);
+    const EyGCStats stats = gc_read_stats(region);
+    pthread_mutex_unlock(&region->collect_mutex);
+    return stats;
 }
 
-EyGCRegion *ey_runtime_gc_create(void) {
+static EyGCHeap *gc_heap_create(EyGCRegion *region) {
+    EyGCHeap *heap = ey_runtime_manual_alloc(sizeof(EyGCHeap));
+    *heap = (EyGCHeap){
+        .region = region,
+        .next_heap = region->heaps,
+        .young = 0,
+        .orphaned = k_false,
+    };
+    pthread_mutex_init(&heap->mutex, 0);
+    region->heaps = heap;
+    return heap;
+}
+
+/*
+  Called as a thread exits, leaving its young pages to the next collection
+ */
+static void gc_heap_release(void *ptr) {
+    EyGCHeap *heap = ptr;
+    pthread_mutex_lock(&heap->region->mutex);
+    heap->orphaned = k_true;
+    pthread_mutex_unlock(&heap->region->mutex);
+}
+
+/*
+  Find the heap for the calling thread, creating it on first use
+ */
+static EyGCHeap *gc_thread_heap(EyGCRegion *region) {
+    if (region->options.serial) {
+        return region->heaps;
+    }
+
+    EyGCHeap *heap = pthread_getspecific(region->heap_key);
+    if (heap) {
+        return heap;
+    }
+
+    pthread_mutex_lock(&region->mutex);
+
+    // reuse the heap of a thread that has exited
+    heap = region->heaps;
+    while (heap && !heap->orphaned) {
+        heap = heap->next_heap;
+    }
+    if (heap) {
+        heap->orphaned = k_false;
+    } else {
+        heap = gc_heap_create(region);
+    }
+
+    pthread_mutex_unlock(&region->mutex);
+
+    pthread_setspecific(region->heap_key, heap);
+    return heap;
+}
+
+EyGCRegion *ey_runtime_gc_create_with_options(EyGCOptions options) {
     EyGCRegion *region = ey_runtime_manual_alloc(sizeof(EyGCRegion));
     *region = (EyGCRegion){
-        .root_page = 0,
+        .options = options,
+        .old_pages = 0,
+        .collecting_pages = 0,
+        .heaps = 0,
         .stats =
             {
                 .pages_allocated = 0,
                 .bytes_allocated = 0,
             },
+        .allocated_since_collection = 0,
+        .live_after_full_collection = 0,
         .pointers_allocated = 10,
     };
     pthread_mutex_init(&region->mutex, 0);
+    pthread_mutex_init(&region->old_mutex, 0);
+    pthread_mutex_init(&region->collect_mutex, 0);
+    if (pthread_key_create(&region->heap_key, gc_heap_release) != 0) {
+        ey_runtime_panic("ey_runtime_gc_create", "Failed to create the thread heap key");
+    }
+
+    if (options.serial) {
+        gc_heap_create(region);
+    }
 
     region->pointers = ey_runtime_manual_alloc(sizeof(EyStackPointer) * region->pointers_allocated);
     for (int i = 0; i < region->pointers_allocated; i += 1) {
@@ -96,11 +256,167 @@ EyGCRegion *ey_runtime_gc_create(void) {
     return region;
 }
 
+static const double k_default_growth_factor = 2.0;
+static const int64_t k_default_threshold_bytes = 4 * 1024 * 1024;
+
+EyGCOptions ey_runtime_gc_default_options(void) {
+    EyGCOptions options = {
+        .serial = k_false,
+        .growth_factor = k_default_growth_factor,
+        .threshold_bytes = k_default_threshold_bytes,
+    };
+
+    const char *growth = getenv("EyotGcGrowth");
+    if (growth && strlen(growth) > 0) {
+        char *end;
+        const double value = strtod(growth, &end);
+        if (*end != 0 || value <= 1.0) {
+            ey_print("ey_runtime_gc: EyotGcGrowth '%s' should be a number above 1\n", growth);
+        } else {
+            options.growth_factor = value;
+        }
+    }
+
+    const char *threshold = getenv("EyotGcThreshold");
+    if (threshold && strlen(threshold) > 0) {
+        char *end;
+        errno = 0;
+        const long long value = strtoll(threshold, &end, 10);
+        if (*end != 0 || value <= 0 || errno != 0) {
+            ey_print("ey_runtime_gc: EyotGcThreshold '%s' should be a positive number of bytes\n",
+                     threshold);
+        } else {
+            options.threshold_bytes = value;
+        }
+    }
+
+    return options;
+}
+
+EyGCRegion *ey_runtime_gc_create(void) {
+    return ey_runtime_gc_create_with_options(ey_runtime_gc_default_options());
+}
+
+void ey_runtime_gc_set_tuning(EyGCRegion *region, double growth_factor, int64_t threshold_bytes) {
+    pthread_mutex_lock(&region->collect_mutex);
+    if (growth_factor > 0) {
+        region->options.growth_factor = growth_factor;
+    }
+    if (threshold_bytes > 0) {
+        region->options.threshold_bytes = threshold_bytes;
+    }
+    pthread_mutex_unlock(&region->collect_mutex);
+}
+
 /*
-  Check if a pointer belongs to us
+  The list a page is currently in
+
+  NB this assumes the page's lock is held
+ */
+static PageHeader **gc_list_head(EyGCRegion *region, PageHeader *page) {
+    if (page->heap) {
+        return &page->heap->young;
+    }
+    return page->old ? &region->old_pages : &region->collecting_pages;
+}
+
+static void gc_link_page(PageHeader **head, PageHeader *page) {
+    page->prev = 0;
+    page->next = *head;
+    if (*head) {
+        (*head)->prev = page;
+    }
+    *head = page;
+}
+
+static void gc_unlink_page(PageHeader **head, PageHeader *page) {
+    if (page->prev) {
+        page->prev->next = page->next;
+    } else {
+        *head = page->next;
+    }
+    if (page->next) {
+        page->next->prev = page->prev;
+    }
+    page->next = 0;
+    page->prev = 0;
+}
+
+/*
+  Lock whichever of the heap or old generation currently holds the page, returning that lock
+
+  The collector can move a page out of its heap, so this checks again once locked
+ */
+static pthread_mutex_t *gc_lock_page(EyGCRegion *region, PageHeader *page) {
+    for (;;) {
+        EyGCHeap *heap = __atomic_load_n(&page->heap, __ATOMIC_ACQUIRE);
+        pthread_mutex_t *lock = heap ? &heap->mutex : &region->old_mutex;
+        pthread_mutex_lock(lock);
+        if (page->heap == heap) {
+            return lock;
+        }
+        pthread_mutex_unlock(lock);
+    }
+}
+
+/*
+  The collector locks heaps as it goes, other than in serial mode where the one heap is held
+  throughout
+ */
+static void gc_lock_heap(EyGCRegion *region, EyGCHeap *heap) {
+    if (!region->options.serial) {
+        pthread_mutex_lock(&heap->mutex);
+    }
+}
+
+static void gc_unlock_heap(EyGCRegion *region, EyGCHeap *heap) {
+    if (!region->options.serial) {
+        pthread_mutex_unlock(&heap->mutex);
+    }
+}
+
+/*
+  An open addressed set of the pages a collection may free, so checking a pointer is cheap
+ */
+typedef struct {
+    PageHeader **slots;
+    uint64_t mask;
+} GCPageSet;
+
+static uint64_t gc_page_hash(const PageHeader *page) {
+    uint64_t h = (uint64_t)(uintptr_t)page >> 4;
+    h ^= h >> 33;
+    h *= 0xff51afd7ed558ccdULL;
+    h ^= h >> 33;
+    return h;
+}
+
+static void gc_page_set_init(GCPageSet *set, int count) {
+    uint64_t capacity = 16;
+    while (capacity < (uint64_t)count * 2) {
+        capacity *= 2;
+    }
+    set->mask = capacity - 1;
+    set->slots = ey_runtime_manual_alloc(sizeof(PageHeader *) * capacity);
+    if (!set->slots) {
+        ey_runtime_panic("gc_page_set_init", "Failed to allocate the page set");
+    }
+    memset(set->slots, 0, sizeof(PageHeader *) * capacity);
+}
+
+static void gc_page_set_add(GCPageSet *set, PageHeader *page) {
+    uint64_t i = gc_page_hash(page) & set->mask;
+    while (set->slots[i]) {
+        i = (i + 1) & set->mask;
+    }
+    set->slots[i] = page;
+}
+
+/*
+  Check if a pointer belongs to a page in the set
   NB we can't be sure that the page is real, so no accesses on it
  */
-static EyBoolean gc_owns_ptr(EyGCRegion *region, void *ptr) {
+static EyBoolean gc_page_set_owns_ptr(const GCPageSet *set, void *ptr) {
     if ((uint64_t)ptr < sizeof(PageHeader)) {
         // checking like this avoids a runtime rollover error
         return k_false;
@@ -108,28 +424,62 @@ static EyBoolean gc_owns_ptr(EyGCRegion *region, void *ptr) {
 
     PageHeader *theoretical_page = gc_page_from_ptr(ptr);
 
-    PageHeader *ph = region->root_page;
-    while (ph) {
-        if (theoretical_page == ph) {
+    uint64_t i = gc_page_hash(theoretical_page) & set->mask;
+    while (set->slots[i]) {
+        if (set->slots[i] == theoretical_page) {
             return k_true;
         }
-        ph = ph->next;
+        i = (i + 1) & set->mask;
     }
 
     return k_false;
 }
 
-static void gc_log(const EyGCRegion *region) {
-    ey_print("start gc_log %p\n", region);
-    const PageHeader *ph = region->root_page;
+static void gc_page_set_free(GCPageSet *set) {
+    ey_runtime_manual_free(set->slots);
+}
+
+/*
+  Pages that are marked, but not yet scanned
+ */
+typedef struct {
+    PageHeader **pages;
+    int count;
+    int allocated;
+} GCMarkStack;
+
+static void gc_mark_stack_push(GCMarkStack *stack, PageHeader *page) {
+    if (stack->count == stack->allocated) {
+        stack->allocated = stack->allocated ? stack->allocated * 2 : 64;
+        stack->pages =
+            ey_runtime_manual_realloc(stack->pages, sizeof(PageHeader *) * stack->allocated);
+        if (!stack->pages) {
+            ey_runtime_panic("gc_mark_stack_push", "Failed to grow the mark stack");
+        }
+    }
+    stack->pages[stack->count] = page;
+    stack->count += 1;
+}
+
+static void gc_log_list(const char *label, const PageHeader *ph) {
+    ey_print(" %s\n", label);
     while (ph) {
         ey_print(" - %p (%i) follows %p (marked = %i)\n", ph, ph->size, ph->prev, (int)ph->marked);
         ph = ph->next;
     }
 }
 
-// called locked
-static void gc_check(EyGCRegion *region, const char *label) {
+static void gc_log(const EyGCRegion *region) {
+    ey_print("start gc_log %p\n", region);
+    gc_log_list("old", region->old_pages);
+    gc_log_list("collecting", region->collecting_pages);
+    for (const EyGCHeap *heap = region->heaps; heap; heap = heap->next_heap) {
+        gc_log_list("young", heap->young);
+    }
+}
+
+// called with the list's lock held
+static void gc_check(EyGCRegion *region, const PageHeader *head, const char *label) {
     static EyBoolean gc_check_enabled_set = k_false;
     static EyBoolean gc_check_enabled = k_false;
 
@@ -145,7 +495,7 @@ static void gc_check(EyGCRegion *region, const char *label) {
     if (gc_check_enabled) {
         const PageHeader *prev = 0;
 
-        const PageHeader *ph = region->root_page;
+        const PageHeader *ph = head;
         while (ph) {
             if (ph->prev != prev) {
                 gc_log(region);
@@ -158,65 +508,90 @@ static void gc_check(EyGCRegion *region, const char *label) {
     }
 }
 
+/*
+  Raise the peak to at least bytes
+ */
+static void gc_update_peak(EyGCRegion *region, int64_t bytes) {
+    int64_t peak = __atomic_load_n(&region->stats.peak_bytes, __ATOMIC_RELAXED);
+    while (bytes > peak && !__atomic_compare_exchange_n(&region->stats.peak_bytes, &peak, bytes,
+                                                        k_true, __ATOMIC_RELAXED,
+                                                        __ATOMIC_RELAXED)) {
+    }
+}
+
 void *ey_runtime_gc_alloc(EyGCRegion *region, int block_size, Finaliser finaliser) {
+    return ey_runtime_gc_alloc_tagged(region, block_size, finaliser, "untagged");
+}
+
+void *ey_runtime_gc_alloc_tagged(EyGCRegion *region, int block_size, Finaliser finaliser,
+                                 const char *tag) {
     PageHeader *page = ey_runtime_manual_alloc(sizeof(PageHeader) + block_size);
     if (!page) {
         ey_runtime_panic("ey_runtime_gc_alloc", "Failed to allocate a page");
     }
+
+    EyGCHeap *heap = gc_thread_heap(region);
     *page = (PageHeader){
         .finaliser = finaliser,
+        .tag = tag,
         .prev = 0,
         .next = 0,
+        .heap = heap,
         .size = block_size,
         .root_count = 0,
+        .old = k_false,
     };
 
-    pthread_mutex_lock(&region->mutex);
-
-    gc_check(region, "pre-alloc");
-
-    if (region->root_page) {
-        region->root_page->prev = page;
-        page->next = region->root_page;
-        region->root_page = page;
-    } else {
-        region->root_page = page;
-    }
-
-    region->stats.pages_allocated += 1;
-    region->stats.bytes_allocated += block_size;
-
     void *ptr = gc_ptr_from_page(page);
     memset(ptr, 0, block_size);
 
-    gc_check(region, "alloc");
+    pthread_mutex_lock(&heap->mutex);
+    gc_check(region, heap->young, "pre-alloc");
+    gc_link_page(&heap->young, page);
+    gc_check(region, heap->young, "alloc");
+    pthread_mutex_unlock(&heap->mutex);
 
-    pthread_mutex_unlock(&region->mutex);
+    __atomic_add_fetch(&region->stats.pages_allocated, 1, __ATOMIC_RELAXED);
+    __atomic_add_fetch(&region->stats.allocations, 1, __ATOMIC_RELAXED);
+    __atomic_add_fetch(&region->stats.total_bytes, block_size, __ATOMIC_RELAXED);
+    __atomic_add_fetch(&region->allocated_since_collection, block_size, __ATOMIC_RELAXED);
+    gc_update_peak(region, __atomic_add_fetch(&region->stats.bytes_allocated, block_size,
+                                              __ATOMIC_RELAXED));
 
     return ptr;
 }
 
 void *ey_runtime_gc_realloc(EyGCRegion *region, void *ptr, int new_size) {
-    pthread_mutex_lock(&region->mutex);
-
     PageHeader *page = gc_page_from_ptr(ptr);
+    pthread_mutex_t *lock = gc_lock_page(region, page);
+
     const int old_size = page->size;
     if (old_size == new_size) {
-        pthread_mutex_unlock(&region->mutex);
+        pthread_mutex_unlock(lock);
         return ptr;
     }
 
-    region->stats.bytes_allocated += new_size - old_size;
+    if (new_size > old_size) {
+        __atomic_add_fetch(&region->allocated_since_collection, new_size - old_size,
+                           __ATOMIC_RELAXED);
+        __atomic_add_fetch(&region->stats.total_bytes, new_size - old_size, __ATOMIC_RELAXED);
+    }
+    gc_update_peak(region, __atomic_add_fetch(&region->stats.bytes_allocated,
+                                              new_size - old_size, __ATOMIC_RELAXED));
 
     // NB this may move us, so adjust accordingly
+    PageHeader **head = gc_list_head(region, page);
     PageHeader *next = page->next;
     PageHeader *previous = page->prev;
     page->size = new_size;
     page = ey_runtime_manual_realloc(page, sizeof(PageHeader) + new_size);
+    if (!page) {
+        ey_runtime_panic("ey_runtime_gc_realloc", "Failed to reallocate a page");
+    }
     if (previous) {
         previous->next = page;
     } else {
-        region->root_page = page;
+        *head = page;
     }
     if (next) {
         next->prev = page;
@@ -226,9 +601,9 @@ void *ey_runtime_gc_realloc(EyGCRegion *region, void *ptr, int new_size) {
         memset(ptr + old_size, 0, new_size - old_size);
     }
 
-    gc_check(region, "realloc");
+    gc_check(region, *head, "realloc");
 
-    pthread_mutex_unlock(&region->mutex);
+    pthread_mutex_unlock(lock);
 
     return ptr;
 }
@@ -237,112 +612,455 @@ void *ey_runtime_gc_realloc(EyGCRegion *region, void *ptr, int new_size) {
 void ey_runtime_gc_forget_root_object(EyGCRegion *region __attribute__((unused)), void *ptr) {
     pthread_mutex_lock(&region->mutex);
     gc_page_from_ptr(ptr)->root_count -= 1;
+    __atomic_sub_fetch(&region->stats.roots, 1, __ATOMIC_RELAXED);
     pthread_mutex_unlock(&region->mutex);
 }
 
 /*
-  Recursively mark this page and any other possible pages underneath
+  Mark the page pointed to, if it is one that could be freed, and queue it to be scanned
  */
-static void gc_mark_page(EyGCRegion *region, PageHeader *ph) {
+static void gc_mark_ptr(const GCPageSet *set, GCMarkStack *stack, void *ptr) {
+    if (!gc_page_set_owns_ptr(set, ptr)) {
+        return;
+    }
+
+    PageHeader *ph = gc_page_from_ptr(ptr);
     if (ph->marked) {
         // escaping here will avoid infinite loops
         return;
     }
 
     ph->marked = k_true;
+    gc_mark_stack_push(stack, ph);
+}
 
+/*
+  Mark anything that this page could point to
+ */
+static void gc_scan_page(const GCPageSet *set, GCMarkStack *stack, PageHeader *ph) {
     const void *base_ptr = gc_ptr_from_page(ph);
     if ((uint64_t)base_ptr % k_pointer_alignment != 0) {
-        printf("gc_mark_page: badly aligned page ptr\n");
+        printf("gc_scan_page: badly aligned page ptr\n");
         exit(1);
     }
 
     for (int offset = 0; offset <= (ph->size - k_pointer_alignment);
          offset += k_pointer_alignment) {
-        void *offset_ptr = *(void **)(base_ptr + offset);
-        if (gc_owns_ptr(region, offset_ptr)) {
-            gc_mark_page(region, gc_page_from_ptr(offset_ptr));
-        }
+        gc_mark_ptr(set, stack, *(void **)(base_ptr + offset));
+    }
+}
+
+/*
+  Scan the queued pages until nothing more is reachable
+ */
+static void gc_mark(const GCPageSet *set, GCMarkStack *stack) {
+    while (stack->count > 0) {
+        stack->count -= 1;
+        gc_scan_page(set, stack, stack->pages[stack->count]);
     }
 }
 
-// called locked
-static void gc_free_page(EyGCRegion *region, PageHeader *ph) {
+// called with the old generation locked
+static void gc_free_page(EyGCRegion *region, PageHeader **head, PageHeader *ph) {
     if (ph->finaliser) {
         ph->finaliser(gc_ptr_from_page(ph));
     }
 
-    region->stats.pages_allocated -= 1;
-    region->stats.bytes_allocated -= ph->size;
-
-    PageHeader *next = ph->next;
+    __atomic_sub_fetch(&region->stats.pages_allocated, 1, __ATOMIC_RELAXED);
+    __atomic_sub_fetch(&region->stats.bytes_allocated, ph->size, __ATOMIC_RELAXED);
 
-    // unlink the allocation
-    if (ph->prev) {
-        ph->prev->next = ph->next;
-    } else {
-        region->root_page = ph->next;
-    }
-    if (next) {
-        next->prev = ph->prev;
-    }
+    gc_unlink_page(head, ph);
 
-    gc_check(region, "free");
+    gc_check(region, *head, "free");
 
     // free the memory (recycling would be an improvement)
     ey_runtime_manual_free(ph);
 }
 
-void ey_runtime_gc_collect(EyGCRegion *region) {
+static void gc_collect(EyGCRegion *region, EyBoolean young_only) {
+    pthread_mutex_lock(&region->collect_mutex);
+    const uint64_t start = ey_trace_now();
+    pthread_mutex_lock(&region->old_mutex);
+    const EyGCStats stats_before = gc_read_stats(region);
+    __atomic_store_n(&region->allocated_since_collection, 0, __ATOMIC_RELAXED);
+
+    /*
+      take the young pages from every heap, later allocations are left for the next collection
+
+      The region stays locked until marking is done, so no root is added or removed part way
+     */
+    int candidates = 0;
     pthread_mutex_lock(&region->mutex);
+    if (region->options.serial) {
+        // every allocation waits until the collection is complete
+        pthread_mutex_lock(&region->heaps->mutex);
+    }
+    for (EyGCHeap *heap = region->heaps; heap; heap = heap->next_heap) {
+        gc_lock_heap(region, heap);
+        PageHeader *ph = heap->young;
+        while (ph) {
+            PageHeader *this_page = ph;
+            ph = ph->next;
 
-    // unmark all pages
-    PageHeader *ph = region->root_page;
-    while (ph) {
+            __atomic_store_n(&this_page->heap, 0, __ATOMIC_RELEASE);
+            gc_link_page(&region->collecting_pages, this_page);
+            candidates += 1;
+        }
+        heap->young = 0;
+        gc_unlock_heap(region, heap);
+    }
+
+    // index and unmark everything this collection could free
+    if (!young_only) {
+        for (PageHeader *ph = region->old_pages; ph; ph = ph->next) {
+            candidates += 1;
+        }
+    }
+    GCPageSet set;
+    gc_page_set_init(&set, candidates);
+    for (PageHeader *ph = region->collecting_pages; ph; ph = ph->next) {
         ph->marked = k_false;
-        ph = ph->next;
+        gc_page_set_add(&set, ph);
+    }
+    if (!young_only) {
+        for (PageHeader *ph = region->old_pages; ph; ph = ph->next) {
+            ph->marked = k_false;
+            gc_page_set_add(&set, ph);
+        }
     }
 
-    // mark all roots
-    ph = region->root_page;
-    while (ph) {
+    GCMarkStack stack = {
+        .pages = 0,
+        .count = 0,
+        .allocated = 0,
+    };
+
+    // mark all roots and stack roots
+    for (PageHeader *ph = region->collecting_pages; ph; ph = ph->next) {
         if (ph->root_count) {
-            gc_mark_page(region, ph);
+            gc_mark_ptr(&set, &stack, gc_ptr_from_page(ph));
+        }
+    }
+    for (PageHeader *ph = region->old_pages; ph; ph = ph->next) {
+        if (young_only) {
+            // without a write barrier any old page could point to a young one, so scan them all
+            gc_scan_page(&set, &stack, ph);
+        } else if (ph->root_count) {
+            gc_mark_ptr(&set, &stack, gc_ptr_from_page(ph));
         }
-        ph = ph->next;
     }
-
-    // mark all stack roots
     for (int i = 0; i < region->pointers_allocated; i += 1) {
         EyStackPointer *p = region->pointers + i;
-        if (!p->in_use) {
-            continue;
+        if (p->in_use) {
+            gc_mark_ptr(&set, &stack, *(void **)p->pointer_to_pointer);
         }
+    }
+
+    gc_mark(&set, &stack);
 
-        void *ptr = *(void **)p->pointer_to_pointer;
-        if (gc_owns_ptr(region, ptr)) {
-            gc_mark_page(region, gc_page_from_ptr(ptr));
+    // pages allocated since the collection started may hold the only reference to a page
+    for (EyGCHeap *heap = region->heaps; heap; heap = heap->next_heap) {
+        gc_lock_heap(region, heap);
+        for (PageHeader *ph = heap->young; ph; ph = ph->next) {
+            gc_scan_page(&set, &stack, ph);
         }
+        gc_mark(&set, &stack);
+        gc_unlock_heap(region, heap);
     }
+    pthread_mutex_unlock(&region->mutex);
+
+    ey_runtime_manual_free(stack.pages);
+    gc_page_set_free(&set);
 
-    // sweep unmarked pages
-    ph = region->root_page;
+    // sweep unmarked pages, and promote the surviving young ones
+    PageHeader *ph = region->collecting_pages;
     while (ph) {
         PageHeader *this_page = ph;
         ph = ph->next;
 
-        if (!this_page->marked) {
-            // unmarked, do delete
-            gc_free_page(region, this_page);
+        if (this_page->marked) {
+            gc_unlink_page(&region->collecting_pages, this_page);
+            this_page->old = k_true;
+            gc_link_page(&region->old_pages, this_page);
+        } else {
+            gc_free_page(region, &region->collecting_pages, this_page);
         }
     }
+    if (!young_only) {
+        ph = region->old_pages;
+        while (ph) {
+            PageHeader *this_page = ph;
+            ph = ph->next;
+
+            if (!this_page->marked) {
+                gc_free_page(region, &region->old_pages, this_page);
+            }
+        }
+    }
+
+    if (region->options.serial) {
+        pthread_mutex_unlock(&region->heaps->mutex);
+    }
+
+    const EyGCStats stats_after = gc_read_stats(region);
+    pthread_mutex_unlock(&region->old_mutex);
+
+    // NB the statistics are only written whilst collect_mutex is held
+    const int64_t pause = (int64_t)(ey_trace_now() - start);
+    EyGCStats *stats = &region->stats;
+    __atomic_store_n(&stats->live_bytes, stats_after.bytes_allocated, __ATOMIC_RELAXED);
+    __atomic_store_n(&stats->collections, stats->collections + 1, __ATOMIC_RELAXED);
+    if (young_only) {
+        __atomic_store_n(&stats->young_collections, stats->young_collections + 1,
+                         __ATOMIC_RELAXED);
+    } else {
+        region->live_after_full_collection = stats_after.bytes_allocated;
+    }
+    __atomic_store_n(&stats->total_pause_us, stats->total_pause_us + pause, __ATOMIC_RELAXED);
+    __atomic_store_n(&stats->last_pause_us, pause, __ATOMIC_RELAXED);
+    if (pause > stats->max_pause_us) {
+        __atomic_store_n(&stats->max_pause_us, pause, __ATOMIC_RELAXED);
+    }
+    pthread_mutex_unlock(&region->collect_mutex);
+
+    if (ey_trace_enabled()) {
+        char args[128];
+        snprintf(args, sizeof(args),
+                 "{\"bytes_before\":%lld,\"bytes_after\":%lld,\"pages_freed\":%lld}",
+                 (long long)stats_before.bytes_allocated, (long long)stats_after.bytes_allocated,
+                 (long long)(stats_before.pages_allocated - stats_after.pages_allocated));
+        ey_trace_event("gc", young_only ? "collect young" : "collect", start, start + pause,
+                       args);
+    }
+}
 
+void ey_runtime_gc_collect(EyGCRegion *region) {
+    gc_collect(region, k_false);
+}
+
+void ey_runtime_gc_collect_young(EyGCRegion *region) {
+    gc_collect(region, k_true);
+}
+
+void ey_runtime_gc_collect_if_needed(EyGCRegion *region) {
+    pthread_mutex_lock(&region->collect_mutex);
+    const EyGCOptions options = region->options;
+    const int64_t live = region->live_after_full_collection;
+    pthread_mutex_unlock(&region->collect_mutex);
+
+    int64_t full_target = (int64_t)((double)live * options.growth_factor);
+    if (full_target < options.threshold_bytes) {
+        full_target = options.threshold_bytes;
+    }
+
+    if (__atomic_load_n(&region->stats.bytes_allocated, __ATOMIC_RELAXED) >= full_target) {
+        gc_collect(region, k_false);
+    } else if (__atomic_load_n(&region->allocated_since_collection, __ATOMIC_RELAXED) >=
+               options.threshold_bytes) {
+        gc_collect(region, k_true);
+    }
+}
+
+/*
+  Totals for one tag in the heap dump
+ */
+typedef struct {
+    const char *tag;
+    int64_t pages, bytes;
+
+    // of those, how many could be reached from a root
+    int64_t reachable_pages, reachable_bytes;
+} GCDumpGroup;
+
+static int gc_dump_group_compare(const void *lhs, const void *rhs) {
+    const int64_t l = ((const GCDumpGroup *)lhs)->bytes, r = ((const GCDumpGroup *)rhs)->bytes;
+    return l < r ? 1 : (l > r ? -1 : 0);
+}
+
+/*
+  The groups are few, so a linear search is fine
+ */
+static GCDumpGroup *gc_dump_group(GCDumpGroup **groups, int *count, const char *tag) {
+    for (int i = 0; i < *count; i += 1) {
+        if ((*groups)[i].tag == tag || strcmp((*groups)[i].tag, tag) == 0) {
+            return &(*groups)[i];
+        }
+    }
+
+    *groups = ey_runtime_manual_realloc(*groups, sizeof(GCDumpGroup) * (*count + 1));
+    if (!*groups) {
+        ey_runtime_panic("ey_runtime_gc_dump", "Failed to grow the group list");
+    }
+    GCDumpGroup *group = &(*groups)[*count];
+    *group = (GCDumpGroup){
+        .tag = tag,
+    };
+    *count += 1;
+    return group;
+}
+
+/*
+  Mark from a single root, writing what it retains that no earlier root did
+ */
+static void gc_dump_root(FILE *f, const GCPageSet *set, GCMarkStack *stack, const char *kind,
+                         PageHeader *root, EyBoolean *first) {
+    int64_t pages = 0, bytes = 0;
+    gc_mark_ptr(set, stack, gc_ptr_from_page(root));
+    while (stack->count > 0) {
+        stack->count -= 1;
+        PageHeader *ph = stack->pages[stack->count];
+        pages += 1;
+        bytes += ph->size;
+        gc_scan_page(set, stack, ph);
+    }
+
+    fprintf(f,
+            "%s\n    {\"kind\":\"%s\",\"tag\":\"%s\",\"size\":%d,\"root_count\":%d,"
+            "\"retained_pages\":%lld,\"retained_bytes\":%lld}",
+            *first ? "" : ",", kind, root->tag, root->size, root->root_count, (long long)pages,
+            (long long)bytes);
+    *first = k_false;
+}
+
+EyBoolean ey_runtime_gc_dump(EyGCRegion *region, const char *path) {
+    FILE *f = fopen(path, "w");
+    if (!f) {
+        return k_false;
+    }
+
+    // nothing can allocate or collect whilst the heap is walked
+    pthread_mutex_lock(&region->collect_mutex);
+    pthread_mutex_lock(&region->old_mutex);
+    pthread_mutex_lock(&region->mutex);
+    for (EyGCHeap *heap = region->heaps; heap; heap = heap->next_heap) {
+        pthread_mutex_lock(&heap->mutex);
+    }
+
+    int count = 0;
+    for (PageHeader *ph = region->old_pages; ph; ph = ph->next) {
+        count += 1;
+    }
+    for (EyGCHeap *heap = region->heaps; heap; heap = heap->next_heap) {
+        for (PageHeader *ph = heap->young; ph; ph = ph->next) {
+            count += 1;
+        }
+    }
+
+    GCPageSet set;
+    gc_page_set_init(&set, count);
+    for (PageHeader *ph = region->old_pages; ph; ph = ph->next) {
+        ph->marked = k_false;
+        gc_page_set_add(&set, ph);
+    }
+    for (EyGCHeap *heap = region->heaps; heap; heap = heap->next_heap) {
+        for (PageHeader *ph = heap->young; ph; ph = ph->next) {
+            ph->marked = k_false;
+            gc_page_set_add(&set, ph);
+        }
+    }
+
+    const EyGCStats stats = gc_read_stats(region);
+    fprintf(f,
+            "{\n  \"stats\": {\"bytes_allocated\":%lld,\"pages_allocated\":%lld,"
+            "\"peak_bytes\":%lld,\"live_bytes\":%lld,\"collections\":%lld,"
+            "\"young_collections\":%lld,\"total_pause_us\":%lld,\"max_pause_us\":%lld,"
+            "\"last_pause_us\":%lld,\"roots\":%lld},\n",
+            (long long)stats.bytes_allocated, (long long)stats.pages_allocated,
+            (long long)stats.peak_bytes, (long long)stats.live_bytes,
+            (long long)stats.collections, (long long)stats.young_collections,
+            (long long)stats.total_pause_us, (long long)stats.max_pause_us,
+            (long long)stats.last_pause_us, (long long)stats.roots);
+
+    // roots first, so the groups know what is reachable
+    GCMarkStack stack = {
+        .pages = 0,
+        .count = 0,
+        .allocated = 0,
+    };
+    EyBoolean first = k_true;
+    fputs("  \"roots\": [", f);
+    for (PageHeader *ph = region->old_pages; ph; ph = ph->next) {
+        if (ph->root_count) {
+            gc_dump_root(f, &set, &stack, "object", ph, &first);
+        }
+    }
+    for (EyGCHeap *heap = region->heaps; heap; heap = heap->next_heap) {
+        for (PageHeader *ph = heap->young; ph; ph = ph->next) {
+            if (ph->root_count) {
+                gc_dump_root(f, &set, &stack, "object", ph, &first);
+            }
+        }
+    }
+    for (int i = 0; i < region->pointers_allocated; i += 1) {
+        EyStackPointer *p = region->pointers + i;
+        void *ptr = p->in_use ? *(void **)p->pointer_to_pointer : 0;
+        if (ptr && gc_page_set_owns_ptr(&set, ptr)) {
+            gc_dump_root(f, &set, &stack, "stack pointer", gc_page_from_ptr(ptr), &first);
+        }
+    }
+    fputs("\n  ],\n", f);
+
+    GCDumpGroup *groups = 0;
+    int group_count = 0;
+    for (uint64_t i = 0; i <= set.mask; i += 1) {
+        PageHeader *ph = set.slots[i];
+        if (!ph) {
+            continue;
+        }
+
+        GCDumpGroup *group = gc_dump_group(&groups, &group_count, ph->tag);
+        group->pages += 1;
+        group->bytes += ph->size;
+        if (ph->marked) {
+            group->reachable_pages += 1;
+            group->reachable_bytes += ph->size;
+        }
+    }
+    if (group_count > 0) {
+        qsort(groups, group_count, sizeof(GCDumpGroup), gc_dump_group_compare);
+    }
+
+    fputs("  \"groups\": [", f);
+    for (int i = 0; i < group_count; i += 1) {
+        const GCDumpGroup *group = &groups[i];
+        fprintf(f,
+                "%s\n    {\"tag\":\"%s\",\"pages\":%lld,\"bytes\":%lld,\"reachable_pages\":%lld,"
+                "\"reachable_bytes\":%lld}",
+                i == 0 ? "" : ",", group->tag, (long long)group->pages, (long long)group->bytes,
+                (long long)group->reachable_pages, (long long)group->reachable_bytes);
+    }
+    fputs("\n  ]\n}\n", f);
+
+    ey_runtime_manual_free(groups);
+    ey_runtime_manual_free(stack.pages);
+    gc_page_set_free(&set);
+
+    for (EyGCHeap *heap = region->heaps; heap; heap = heap->next_heap) {
+        pthread_mutex_unlock(&heap->mutex);
+    }
     pthread_mutex_unlock(&region->mutex);
+    pthread_mutex_unlock(&region->old_mutex);
+    pthread_mutex_unlock(&region->collect_mutex);
+
+    return fclose(f) == 0;
 }
 
 void ey_runtime_gc_free(EyGCRegion *region) {
     ey_runtime_gc_collect(region);
+
+    // NB heaps are not reachable from threads once the key is gone
+    pthread_key_delete(region->heap_key);
+    EyGCHeap *heap = region->heaps;
+    while (heap) {
+        EyGCHeap *next = heap->next_heap;
+        pthread_mutex_destroy(&heap->mutex);
+        ey_runtime_manual_free(heap);
+        heap = next;
+    }
+
     pthread_mutex_destroy(&region->mutex);
+    pthread_mutex_destroy(&region->old_mutex);
+    pthread_mutex_destroy(&region->collect_mutex);
     ey_runtime_manual_free(region->pointers);
     ey_runtime_manual_free(region);
 }
@@ -363,6 +1081,7 @@ void *ey_runtime_manual_realloc(void *ptr, EyInteger size) {
 void ey_runtime_gc_remember_root_object(EyGCRegion *region __attribute__((unused)), void *ptr) {
     pthread_mutex_lock(&region->mutex);
     gc_page_from_ptr(ptr)->root_count += 1;
+    __atomic_add_fetch(&region->stats.roots, 1, __ATOMIC_RELAXED);
     pthread_mutex_unlock(&region->mutex);
 }
 
@@ -387,6 +1106,7 @@ void ey_runtime_gc_remember_root_pointer(EyGCRegion *region, const void *ptr) {
     EyStackPointer *p = region->pointers + pi;
     p->in_use = k_true;
     p->pointer_to_pointer = ptr;
+    __atomic_add_fetch(&region->stats.roots, 1, __ATOMIC_RELAXED);
 
     pthread_mutex_unlock(&region->mutex);
 }
@@ -398,6 +1118,7 @@ void ey_runtime_gc_forget_root_pointer(EyGCRegion *region, const void *ptr) {
         EyStackPointer *p = region->pointers + i;
         if (p->pointer_to_pointer == ptr) {
             p->in_use = k_false;
+            __atomic_sub_fetch(&region->stats.roots, 1, __ATOMIC_RELAXED);
             pthread_mutex_unlock(&region->mutex);
             return;
         }
@@ -8,9 +8,17 @@
 
 #if defined(EYOT_OPENCL_INCLUDED)
 
+#include <errno.h>
 #include <pthread.h>
+#include <stdint.h>
 #include <string.h>
 #include <unistd.h>
+#ifdef _WIN32
+#include <direct.h>
+#include <process.h>
+#else
+#include <sys/stat.h>
+#endif
 #include <stdio.h>
 #include <stdlib.h>
 
@@ -34,8 +42,15 @@ static const int k_always_show_log =
 #include <CL/cl.h>
 #endif
 
+/*
+  A single device, along with the context and program built for it
+ */
 typedef struct {
+    cl_platform_id platform_id;
     cl_device_id device_id;
+    cl_device_type type;
+
+    // these are only created when the device is first used
     cl_context context;
 
     /*
@@ -44,6 +59,25 @@ typedef struct {
     */
     cl_program program;
 
+    char name[128];
+    cl_ulong memory;
+    cl_uint compute_units;
+} ClDevice;
+
+typedef struct {
+    // every device on every platform, in platform order
+    ClDevice *devices;
+    int device_count;
+
+    // the device used when a worker does not ask for a specific one
+    int default_device;
+
+    // the program source, retained so that devices can be built on demand
+    const char *src;
+
+    // held while building a device
+    pthread_mutex_t mutex;
+
     EyBoolean verbose;
 } ClDriver;
 
@@ -71,14 +105,415 @@ static void print_with_line_numbers(const char *src) {
 
 static void cldriver_finalise(void *obj) {
     ClDriver *driver = obj;
-    if (driver->program) {
-        clReleaseProgram(driver->program);
+    for (int i = 0; i < driver->device_count; i += 1) {
+        ClDevice *device = &driver->devices[i];
+        if (device->program) {
+            clReleaseProgram(device->program);
+        }
+        if (device->context) {
+            clReleaseContext(device->context);
+        }
+    }
+    pthread_mutex_destroy(&driver->mutex);
+}
+
+/*
+  Program binary cache
+
+  Building the program from source happens on every start, and can be slow for larger programs, so
+  the built binary is stored on disk and reloaded with clCreateProgramWithBinary where possible.
+  The key covers the source, the device and the driver version so any change there causes a rebuild.
+
+  EyotClCache controls the location, 'n' disables it, anything else is used as the directory
+ */
+#define EY_CLCACHE_PATH_MAX 4096
+
+static const char k_clcache_magic[8] = {'E', 'Y', 'O', 'T', 'C', 'L', 'B', '1'};
+
+typedef struct {
+    char magic[8];
+    uint64_t key;
+    uint64_t size;
+} ClCacheHeader;
+
+static uint64_t clcache_hash(uint64_t hash, const void *data, size_t len) {
+    // FNV-1a, which is more than adequate to key a cache
+    const unsigned char *bytes = data;
+    for (size_t i = 0; i < len; i += 1) {
+        hash ^= bytes[i];
+        hash *= 1099511628211ULL;
+    }
+    return hash;
+}
+
+static uint64_t clcache_hash_device_string(uint64_t hash, cl_device_id device,
+                                           cl_device_info param) {
+    char value[256] = {0};
+    clGetDeviceInfo(device, param, sizeof(value) - 1, value, NULL);
+    return clcache_hash(hash, value, strlen(value) + 1);
+}
+
+static uint64_t clcache_key(ClDevice *device, const char *src) {
+    uint64_t hash = 14695981039346656037ULL;
+    hash = clcache_hash(hash, src, strlen(src) + 1);
+    hash = clcache_hash_device_string(hash, device->device_id, CL_DEVICE_VENDOR);
+    hash = clcache_hash_device_string(hash, device->device_id, CL_DEVICE_NAME);
+    hash = clcache_hash_device_string(hash, device->device_id, CL_DEVICE_VERSION);
+    hash = clcache_hash_device_string(hash, device->device_id, CL_DRIVER_VERSION);
+    return hash;
+}
+
+/*
+  Fill path with the cache directory, returning false if the cache is disabled
+ */
+static EyBoolean clcache_directory(char *path, size_t len) {
+    const char *configured = getenv("EyotClCache");
+    if (configured && strcmp(configured, "n") == 0) {
+        return k_false;
+    }
+
+    int written;
+    if (configured && strlen(configured) > 0) {
+        written = snprintf(path, len, "%s", configured);
+    } else {
+        const char *xdg = getenv("XDG_CACHE_HOME");
+        const char *home = getenv("HOME");
+#ifdef _WIN32
+        const char *local = getenv("LOCALAPPDATA");
+#else
+        const char *local = NULL;
+#endif
+        if (xdg && strlen(xdg) > 0) {
+            written = snprintf(path, len, "%s/eyot/opencl", xdg);
+        } else if (local && strlen(local) > 0) {
+            written = snprintf(path, len, "%s/eyot/opencl", local);
+        } else if (home && strlen(home) > 0) {
+            written = snprintf(path, len, "%s/.cache/eyot/opencl", home);
+        } else {
+            return k_false;
+        }
+    }
+
+    return written > 0 && (size_t)written < len;
+}
+
+/*
+  Create one directory, succeeding if it is already there
+ */
+static EyBoolean clcache_make_one_directory(const char *path) {
+#ifdef _WIN32
+    const int rc = _mkdir(path);
+#else
+    const int rc = mkdir(path, 0755);
+#endif
+    return rc == 0 || errno == EEXIST;
+}
+
+static int clcache_process_id(void) {
+#ifdef _WIN32
+    return _getpid();
+#else
+    return (int)getpid();
+#endif
+}
+
+/*
+  Create the directory, and any missing parents
+ */
+static EyBoolean clcache_make_directory(const char *dir) {
+    char path[EY_CLCACHE_PATH_MAX];
+    const size_t len = strlen(dir);
+    if (len >= sizeof(path)) {
+        return k_false;
+    }
+    memcpy(path, dir, len + 1);
+
+    for (size_t i = 1; i <= len; i += 1) {
+#ifdef _WIN32
+        const EyBoolean separator = path[i] == '/' || path[i] == '\\';
+#else
+        const EyBoolean separator = path[i] == '/';
+#endif
+        // a drive such as C: is there already, and cannot be made
+        if ((separator || path[i] == 0) && path[i - 1] != ':') {
+            const char saved = path[i];
+            path[i] = 0;
+            if (!clcache_make_one_directory(path)) {
+                return k_false;
+            }
+            path[i] = saved;
+        }
+    }
+
+    return k_true;
+}
+
+/*
+  Attempt to load the program from the cache, this returns 0 on any failure
+ */
+static cl_program clcache_load(ClDevice *device, const char *path, uint64_t key) {
+    FILE *f = fopen(path, "rb");
+    if (!f) {
+        return 0;
+    }
+
+    ClCacheHeader header;
+    if (fread(&header, sizeof(header), 1, f) != 1 ||
+        memcmp(header.magic, k_clcache_magic, sizeof(k_clcache_magic)) != 0 ||
+        header.key != key || header.size == 0) {
+        fclose(f);
+        return 0;
+    }
+
+    unsigned char *binary = ey_runtime_manual_alloc(header.size);
+    const size_t read = fread(binary, 1, header.size, f);
+    fclose(f);
+    if (read != header.size) {
+        ey_runtime_manual_free(binary);
+        return 0;
     }
-    if (driver->context) {
-        clReleaseContext(driver->context);
+
+    const size_t size = header.size;
+    const unsigned char *binaries[] = {binary};
+    cl_int binary_status, err;
+    cl_program program = clCreateProgramWithBinary(device->context, 1, &device->device_id, &size,
+                                                   binaries, &binary_status, &err);
+    ey_runtime_manual_free(binary);
+    if (!program || err != CL_SUCCESS || binary_status != CL_SUCCESS) {
+        if (program) {
+            clReleaseProgram(program);
+        }
+        return 0;
+    }
+
+    err = clBuildProgram(program, 0, NULL, NULL, NULL, NULL);
+    if (err != CL_SUCCESS) {
+        clReleaseProgram(program);
+        return 0;
+    }
+
+    return program;
+}
+
+/*
+  Write the built program to the cache
+
+  Failure is not an error, the next run will just build from source again
+ */
+static void clcache_store(ClDriver *driver, ClDevice *device, const char *dir, const char *path,
+                          uint64_t key) {
+    size_t size = 0;
+    cl_int err = clGetProgramInfo(device->program, CL_PROGRAM_BINARY_SIZES, sizeof(size), &size,
+                                  NULL);
+    if (err != CL_SUCCESS || size == 0) {
+        return;
+    }
+
+    unsigned char *binary = ey_runtime_manual_alloc(size);
+    unsigned char *binaries[] = {binary};
+    err = clGetProgramInfo(device->program, CL_PROGRAM_BINARIES, sizeof(binaries), binaries, NULL);
+    if (err != CL_SUCCESS || !clcache_make_directory(dir)) {
+        ey_runtime_manual_free(binary);
+        return;
+    }
+
+    // write to a temporary then move into place, so concurrent processes never see a partial file
+    char temp_path[EY_CLCACHE_PATH_MAX];
+    const int written = snprintf(temp_path, sizeof(temp_path), "%s.%d.tmp", path, clcache_process_id());
+    if (written <= 0 || (size_t)written >= sizeof(temp_path)) {
+        ey_runtime_manual_free(binary);
+        return;
+    }
+
+    FILE *f = fopen(temp_path, "wb");
+    if (!f) {
+        ey_runtime_manual_free(binary);
+        return;
+    }
+
+    ClCacheHeader header = {.key = key, .size = size};
+    memcpy(header.magic, k_clcache_magic, sizeof(k_clcache_magic));
+    const EyBoolean ok = fwrite(&header, sizeof(header), 1, f) == 1 &&
+                         fwrite(binary, 1, size, f) == size;
+    ey_runtime_manual_free(binary);
+
+    if (fclose(f) != 0 || !ok) {
+        remove(temp_path);
+        return;
+    }
+
+#ifdef _WIN32
+    // rename will not replace a file on Windows, and one left here did not load
+    remove(path);
+#endif
+    if (rename(temp_path, path) != 0) {
+        remove(temp_path);
+        return;
+    }
+
+    if (driver->verbose) {
+        ey_print("cldevice_build: stored program binary in %s\n", path);
     }
 }
 
+/*
+  Create the context and build the program for this device, panicing if the program does not compile
+
+  NB this assumes the driver is locked
+ */
+static EyBoolean cldevice_build(ClDriver *driver, ClDevice *device) {
+    if (device->program) {
+        return k_true;
+    }
+
+    cl_int err;
+    device->context = clCreateContext(0, 1, &device->device_id, NULL, NULL, &err);
+    if (!device->context) {
+        ey_print("cldevice_build: clCreateContext failed with %i\n", err);
+        return k_false;
+    }
+
+    const char *src = driver->src;
+
+    // the build log is only available when compiling from source, so skip the cache if it is wanted
+    char cache_dir[EY_CLCACHE_PATH_MAX], cache_path[EY_CLCACHE_PATH_MAX];
+    EyBoolean use_cache = !k_always_show_log && clcache_directory(cache_dir, sizeof(cache_dir));
+    const uint64_t cache_key = use_cache ? clcache_key(device, src) : 0;
+    if (use_cache) {
+        const int written = snprintf(cache_path, sizeof(cache_path), "%s/%016llx.clbin", cache_dir,
+                                     (unsigned long long)cache_key);
+        use_cache = written > 0 && (size_t)written < sizeof(cache_path);
+    }
+
+    if (use_cache) {
+        device->program = clcache_load(device, cache_path, cache_key);
+        if (device->program) {
+            if (driver->verbose) {
+                ey_print("cldevice_build: loaded program binary from %s\n", cache_path);
+            }
+            return k_true;
+        }
+    }
+
+    // compile the single source
+    device->program = clCreateProgramWithSource(device->context, 1, &src, 0, &err);
+    if (!device->program) {
+        ey_runtime_panic("cldevice_build", "failed to create program");
+    }
+
+    if (driver->verbose) {
+        ey_print(src);
+    }
+    err = clBuildProgram(device->program, 0, NULL, NULL, NULL, NULL);
+
+    const int compile_failed = err != CL_SUCCESS;
+    if (compile_failed || k_always_show_log) {
+        size_t len;
+
+        print_with_line_numbers(src);
+        if (compile_failed) {
+            ey_print("cldevice_build: Failed to build program executable!\n");
+        }
+
+        err = clGetProgramBuildInfo(device->program, device->device_id, CL_PROGRAM_BUILD_LOG, 0, 0,
+                                    &len);
+        if (err != CL_SUCCESS) {
+            ey_runtime_panic("cldevice_build",
+                             "failed to compile program and got error when checking build length");
+        }
+        void *build_log = ey_runtime_manual_alloc(len + 1);
+        err = clGetProgramBuildInfo(device->program, device->device_id, CL_PROGRAM_BUILD_LOG, len,
+                                    build_log, 0);
+        if (err != CL_SUCCESS) {
+            ey_runtime_panic("cldevice_build",
+                             "failed to compile program and got error when reading build log");
+        }
+        ey_print("%s\n", build_log);
+
+        if (compile_failed) {
+            ey_runtime_panic("cldevice_build", "failed to compile program");
+        }
+        ey_runtime_manual_free(build_log);
+    }
+
+    if (use_cache) {
+        clcache_store(driver, device, cache_dir, cache_path, cache_key);
+    }
+
+    return k_true;
+}
+
+/*
+  Append all devices on the platform to the driver's list
+ */
+static void cldriver_add_platform_devices(ClDriver *driver, cl_platform_id platform) {
+    cl_uint ndevices = 0;
+    cl_int err = clGetDeviceIDs(platform, CL_DEVICE_TYPE_ALL, 0, NULL, &ndevices);
+    if (err != CL_SUCCESS || ndevices == 0) {
+        if (driver->verbose) {
+            // a platform with no devices is not unusual, so stay silent by default
+            ey_print("cldriver_create: clGetDeviceIDs failed with %i\n", err);
+        }
+        return;
+    }
+
+    cl_device_id *ids = ey_runtime_manual_alloc(sizeof(cl_device_id) * ndevices);
+    err = clGetDeviceIDs(platform, CL_DEVICE_TYPE_ALL, ndevices, ids, NULL);
+    if (err != CL_SUCCESS) {
+        ey_runtime_manual_free(ids);
+        return;
+    }
+
+    driver->devices = ey_runtime_gc_realloc(ey_runtime_gc(0), driver->devices,
+                                            sizeof(ClDevice) * (driver->device_count + ndevices));
+    for (cl_uint i = 0; i < ndevices; i += 1) {
+        ClDevice *device = &driver->devices[driver->device_count];
+        *device = (ClDevice){
+            .platform_id = platform,
+            .device_id = ids[i],
+        };
+
+        clGetDeviceInfo(ids[i], CL_DEVICE_TYPE, sizeof(device->type), &device->type, NULL);
+        clGetDeviceInfo(ids[i], CL_DEVICE_NAME, sizeof(device->name) - 1, device->name, NULL);
+        clGetDeviceInfo(ids[i], CL_DEVICE_GLOBAL_MEM_SIZE, sizeof(device->memory), &device->memory,
+                        NULL);
+        clGetDeviceInfo(ids[i], CL_DEVICE_MAX_COMPUTE_UNITS, sizeof(device->compute_units),
+                        &device->compute_units, NULL);
+
+        driver->device_count += 1;
+    }
+
+    ey_runtime_manual_free(ids);
+}
+
+/*
+  Pick the default device, either from EyotClDevice, or the first GPU
+
+  Without a GPU this falls back to the first device of any type, e.g. a CPU OpenCL driver, so gpu
+  workers still run. -1 means there are no devices at all, or EyotClDevice is not one of them
+ */
+static int cldriver_default_device(ClDriver *driver) {
+    const char *configured = getenv("EyotClDevice");
+    if (configured && strlen(configured) > 0) {
+        char *end;
+        const long index = strtol(configured, &end, 10);
+        if (*end != 0 || index < 0 || index >= driver->device_count) {
+            ey_print("cldriver_create: EyotClDevice '%s' is not a valid device index (%d found)\n",
+                     configured, driver->device_count);
+            return -1;
+        }
+        return (int)index;
+    }
+
+    for (int i = 0; i < driver->device_count; i += 1) {
+        if (driver->devices[i].type & CL_DEVICE_TYPE_GPU) {
+            return i;
+        }
+    }
+
+    return driver->device_count > 0 ? 0 : -1;
+}
+
 static ClDriver *cldriver_create(const char *src) {
     const char *disable_sentinel = getenv("EyotDisableCl");
     if (disable_sentinel && strcmp(disable_sentinel, "y") == 0) {
@@ -119,17 +554,29 @@ static ClDriver *cldriver_create(const char *src) {
     err = clGetPlatformIDs(nplatforms, platforms, NULL);
     if (err != CL_SUCCESS) {
         ey_print("cldriver_create: clGetPlatformIDs (2) failed with %i\n", err);
+        free(platforms);
         return 0;
     }
 
-    ClDriver *driver = ey_runtime_gc_alloc(ey_runtime_gc(0), sizeof(ClDriver), cldriver_finalise);
+    ClDriver *driver = ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), sizeof(ClDriver),
+                                                  cldriver_finalise, "opencl driver");
     if (!driver) {
         ey_runtime_panic("cldriver_create", "failed to allocate driver structure\n");
     }
-    driver->verbose = verbose;
+    *driver = (ClDriver){
+        .devices = 0,
+        .device_count = 0,
+        .default_device = -1,
+        .src = src,
+        .verbose = verbose,
+    };
+    pthread_mutex_init(&driver->mutex, 0);
+
+    // the driver lives as long as the program
+    ey_runtime_gc_remember_root_object(ey_runtime_gc(0), driver);
 
     if (driver->verbose) {
-        ey_print("OpenCL driver initialising. %d platforms found (will choose 0)\n", nplatforms);
+        ey_print("OpenCL driver initialising. %d platforms found\n", nplatforms);
         for (cl_uint i = 0; i < nplatforms; i++) {
             char name[128], vendor[128], version[128];
 
@@ -141,63 +588,35 @@ static ClDriver *cldriver_create(const char *src) {
         }
     }
 
-    err = clGetDeviceIDs(platforms[0], CL_DEVICE_TYPE_GPU, 1, &driver->device_id, NULL);
-    if (err != CL_SUCCESS) {
+    for (cl_uint i = 0; i < nplatforms; i += 1) {
+        cldriver_add_platform_devices(driver, platforms[i]);
+    }
+    free(platforms);
+
+    if (driver->verbose) {
+        ey_print("%d devices found\n", driver->device_count);
+        for (int i = 0; i < driver->device_count; i += 1) {
+            const ClDevice *device = &driver->devices[i];
+            ey_print("  %d: %s (%u compute units, %llu bytes)\n", i, device->name,
+                     device->compute_units, (unsigned long long)device->memory);
+        }
+    }
+
+    driver->default_device = cldriver_default_device(driver);
+    if (driver->default_device < 0) {
         if (driver->verbose) {
             // this (an) expected failure case when cl is installed, but there are no viable
             // devices, so stay silent by default
-            ey_print("cldriver_create: clGetDeviceIDs failed with %i\n", err);
+            ey_print("cldriver_create: no default device found\n");
         }
         return 0;
     }
 
-    driver->context = clCreateContext(0, 1, &driver->device_id, NULL, NULL, &err);
-    if (!driver->context) {
-        ey_print("cldriver_create: clCreateContext failed with %i\n", err);
+    // build the default eagerly so compilation errors show up at start
+    if (!cldevice_build(driver, &driver->devices[driver->default_device])) {
         return 0;
     }
 
-    // compile the single source
-    driver->program = clCreateProgramWithSource(driver->context, 1, &src, 0, &err);
-    if (!driver->program) {
-        ey_runtime_panic("cldriver_create", "failed to create program");
-    }
-
-    if (driver->verbose) {
-        ey_print(src);
-    }
-    err = clBuildProgram(driver->program, 0, NULL, NULL, NULL, NULL);
-
-    const int compile_failed = err != CL_SUCCESS;
-    if (compile_failed || k_always_show_log) {
-        size_t len;
-
-        print_with_line_numbers(src);
-        if (compile_failed) {
-            ey_print("cldriver_create: Failed to build program executable!\n");
-        }
-
-        err = clGetProgramBuildInfo(driver->program, driver->device_id, CL_PROGRAM_BUILD_LOG, 0, 0,
-                                    &len);
-        if (err != CL_SUCCESS) {
-            ey_runtime_panic("cldriver_create",
-                             "failed to compile program and got error when checking build length");
-        }
-        void *build_log = ey_runtime_manual_alloc(len + 1);
-        err = clGetProgramBuildInfo(driver->program, driver->device_id, CL_PROGRAM_BUILD_LOG, len,
-                                    build_log, 0);
-        if (err != CL_SUCCESS) {
-            ey_runtime_panic("cldriver_create",
-                             "failed to compile program and got error when reading build log");
-        }
-        ey_print("%s\n", build_log);
-
-        if (compile_failed) {
-            ey_runtime_panic("cldriver_create", "failed to compile program");
-        }
-        ey_runtime_manual_free(build_log);
-    }
-
     return driver;
 }
 
@@ -210,6 +629,33 @@ void ey_init_opencl(const char *src) {
     }
 }
 
+/*
+  Find the device, building it if it has not been used before
+ */
+static ClDevice *cldriver_get_device(ClDriver *driver, EyInteger index) {
+    if (index < 0) {
+        index = driver->default_device;
+    }
+
+    if (index >= driver->device_count) {
+        ey_print("OpenCL device %lld requested, but only %d found\n", (long long)index,
+                 driver->device_count);
+        ey_runtime_panic("cldriver_get_device", "no such device");
+    }
+
+    ClDevice *device = &driver->devices[index];
+
+    pthread_mutex_lock(&driver->mutex);
+    const EyBoolean built = cldevice_build(driver, device);
+    pthread_mutex_unlock(&driver->mutex);
+
+    if (!built) {
+        ey_runtime_panic("cldriver_get_device", "failed to initialise device");
+    }
+
+    return device;
+}
+
 typedef struct {
     cl_mem input, output;
     EyVector *output_vector;
@@ -227,6 +673,10 @@ typedef struct {
       After that, it is the first index in the batch that is new
      */
     int read_index;
+
+    // these are only used for tracing, when the queue has profiling enabled
+    cl_event evt_write, evt_kernel, evt_read;
+    uint64_t enqueued_at;
 } WorkBatch;
 
 typedef struct {
@@ -248,6 +698,9 @@ typedef struct {
     // core driver
     ClDriver *driver;
 
+    // the device this worker runs on
+    ClDevice *device;
+
     // a (possibly null) pointer to a closure
     void *closure;
     cl_mem closure_buffer;
@@ -279,6 +732,11 @@ typedef struct {
 
     // number of awaited results
     int activity_count;
+
+    // when true the queue was created with profiling, and batches are written to the trace
+    EyBoolean profiling;
+    int trace_track;
+    const char *kernel_name;
 } EyClWorker;
 
 /*
@@ -314,6 +772,52 @@ static void clworker_pop_batch(EyClWorker *clw) {
     clw->batches_used -= 1;
 }
 
+/*
+  The trace track for a device, kept clear of the thread ids
+ */
+static const int k_trace_device_track_base = 1000;
+
+static uint64_t cl_event_time(cl_event evt, cl_profiling_info param) {
+    cl_ulong t = 0;
+    clGetEventProfilingInfo(evt, param, sizeof(t), &t, NULL);
+    return t;
+}
+
+/*
+  Write the device side timings of a finished batch to the trace
+
+  Device timestamps are in their own timebase, so they are placed relative to the host time the
+  batch was enqueued
+ */
+static void clworker_trace_batch(EyClWorker *w, WorkBatch *batch) {
+    if (!w->profiling || !ey_trace_enabled()) {
+        return;
+    }
+
+    const uint64_t base = cl_event_time(batch->evt_write, CL_PROFILING_COMMAND_QUEUED);
+    const struct {
+        cl_event evt;
+        const char *name;
+    } stages[] = {
+        {batch->evt_write, "gpu write"},
+        {batch->evt_kernel, w->kernel_name},
+        {batch->evt_read, "gpu read"},
+    };
+
+    char args[64];
+    snprintf(args, sizeof(args), "{\"count\":%d}", (int)batch->count);
+    for (size_t i = 0; i < sizeof(stages) / sizeof(stages[0]); i += 1) {
+        const uint64_t start = cl_event_time(stages[i].evt, CL_PROFILING_COMMAND_START);
+        const uint64_t end = cl_event_time(stages[i].evt, CL_PROFILING_COMMAND_END);
+        if (start < base || end < start) {
+            continue;
+        }
+        ey_trace_event_on_track(w->trace_track, "gpu", stages[i].name,
+                                batch->enqueued_at + (start - base) / 1000,
+                                batch->enqueued_at + (end - base) / 1000, args);
+    }
+}
+
 static int round_up(int value, int divisor) {
     const int div = value / divisor, remainder = value % divisor;
 
@@ -332,6 +836,7 @@ static void ey_cl_send(EyWorker *wrkr, EyVector *values) {
     EyClWorker *w = wrkr->ctx;
     pthread_mutex_lock(&w->mutex);
 
+    const uint64_t trace_start = ey_trace_enabled() ? ey_trace_now() : 0;
     cl_int err;
     cl_event computation_finished_event;
 
@@ -340,15 +845,16 @@ static void ey_cl_send(EyWorker *wrkr, EyVector *values) {
         .read_index = -1,
         .count = ey_vector_length(0, values),
         .output_vector = ey_vector_create(0, w->output_size),
+        .enqueued_at = trace_start,
     };
     ey_vector_resize(0, batch->output_vector, batch->count);
 
     w->activity_count += batch->count;
 
     // TODO array these, we only support a single read/write pair RN
-    batch->input = clCreateBuffer(w->driver->context, CL_MEM_READ_ONLY,
+    batch->input = clCreateBuffer(w->device->context, CL_MEM_READ_ONLY,
                                   w->input_size * batch->count, NULL, NULL);
-    batch->output = clCreateBuffer(w->driver->context, CL_MEM_WRITE_ONLY,
+    batch->output = clCreateBuffer(w->device->context, CL_MEM_WRITE_ONLY,
                                    w->output_size * batch->count, NULL, NULL);
     if (!batch->input || !batch->output) {
         ey_runtime_panic("ey_cl_send", "failed to allocate io memory");
@@ -438,7 +944,17 @@ static void ey_cl_send(EyWorker *wrkr, EyVector *values) {
         ey_runtime_panic("ey_cl_send", "failed to read log buffer");
     }
 
+    batch->evt_write = input_written_event;
+    batch->evt_kernel = computation_finished_event;
+    batch->evt_read = output_read_event;
+
     pthread_mutex_unlock(&w->mutex);
+
+    if (trace_start) {
+        char args[64];
+        snprintf(args, sizeof(args), "{\"count\":%d}", (int)batch->count);
+        ey_trace_event("worker", "gpu send", trace_start, ey_trace_now(), args);
+    }
 }
 
 /*
@@ -541,7 +1057,12 @@ static void ey_cl_receive(EyWorker *wrkr, void *value) {
 
     WorkBatch *batch = &w->batches[0];
     if (batch->read_index < 0) {
+        const uint64_t trace_start = ey_trace_enabled() ? ey_trace_now() : 0;
         clWaitForEvents(1, &batch->evt_done);
+        if (trace_start) {
+            ey_trace_event("worker", "gpu receive wait", trace_start, ey_trace_now(), 0);
+        }
+        clworker_trace_batch(w, batch);
         _ey_cl_pump_logs(0, w);
         batch->read_index = 0;
 
@@ -565,10 +1086,21 @@ static EyVector *ey_cl_drain(EyWorker *wrkr) {
 
     WorkBatch *last_batch = &w->batches[w->batches_used - 1];
     if (last_batch->read_index < 0) {
+        const uint64_t trace_start = ey_trace_enabled() ? ey_trace_now() : 0;
         clWaitForEvents(1, &last_batch->evt_done);
+        if (trace_start) {
+            ey_trace_event("worker", "gpu drain wait", trace_start, ey_trace_now(), 0);
+        }
         _ey_cl_pump_logs(0, w);
     }
 
+    // the queue is in order, so every batch is complete once the last one is
+    for (int i = 0; i < w->batches_used; i += 1) {
+        if (w->batches[i].read_index < 0) {
+            clworker_trace_batch(w, &w->batches[i]);
+        }
+    }
+
     EyVector *vec = ey_vector_create(0, w->output_size);
 
     for (int i = 0; i < w->batches_used; i += 1) {
@@ -609,8 +1141,8 @@ static void ey_cl_worker_finalise(void *obj) {
     }
 }
 
-EyWorker *ey_worker_create_opencl(const char *kernel_name, int input_size, int output_size,
-                                  void *closure_ptr, int closure_size) {
+EyWorker *ey_worker_create_opencl(EyInteger device, const char *kernel_name, int input_size,
+                                  int output_size, void *closure_ptr, int closure_size) {
     if (!_singleton_driver) {
         ey_runtime_panic("ey_worker_create_opencl", "CL has not been initialised");
         return 0;
@@ -618,7 +1150,8 @@ EyWorker *ey_worker_create_opencl(const char *kernel_name, int input_size, int o
 
     cl_int err;
     EyClWorker *wrkr =
-        ey_runtime_gc_alloc(ey_runtime_gc(0), sizeof(EyClWorker), ey_cl_worker_finalise);
+        ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), sizeof(EyClWorker), ey_cl_worker_finalise,
+                                   "gpu worker");
     if (!wrkr) {
         ey_runtime_panic("ey_worker_create_opencl", "failed to allocate cl worker structure");
     }
@@ -628,7 +1161,8 @@ EyWorker *ey_worker_create_opencl(const char *kernel_name, int input_size, int o
     *wrkr = (EyClWorker){
         .activity_count = 0,
         .batches =
-            ey_runtime_gc_alloc(ey_runtime_gc(0), sizeof(WorkBatch) * initial_batch_count, 0),
+            ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), sizeof(WorkBatch) * initial_batch_count,
+                                       0, "gpu batches"),
         .batches_used = 0,
         .batches_allocated = initial_batch_count,
         .input_size = input_size,
@@ -644,20 +1178,34 @@ EyWorker *ey_worker_create_opencl(const char *kernel_name, int input_size, int o
         // from this point on there are no elegant failure options, so just panic
         ey_runtime_panic("ey_worker_create_opencl", "no cl driver found");
     }
+    wrkr->device = cldriver_get_device(wrkr->driver, device);
+
+    // profiling has a cost, so it is only enabled if a trace is underway when the worker starts
+    wrkr->profiling = ey_trace_enabled();
+    wrkr->kernel_name = kernel_name;
+    wrkr->trace_track = k_trace_device_track_base + (int)(wrkr->device - wrkr->driver->devices);
+    if (wrkr->profiling) {
+        char track_name[160];
+        snprintf(track_name, sizeof(track_name), "gpu %d: %s",
+                 wrkr->trace_track - k_trace_device_track_base, wrkr->device->name);
+        ey_trace_name_track(wrkr->trace_track, track_name);
+    }
 
-    wrkr->command_queue =
-        clCreateCommandQueue(wrkr->driver->context, wrkr->driver->device_id, 0, &err);
+    const cl_command_queue_properties queue_properties =
+        wrkr->profiling ? CL_QUEUE_PROFILING_ENABLE : 0;
+    wrkr->command_queue = clCreateCommandQueue(wrkr->device->context, wrkr->device->device_id,
+                                               queue_properties, &err);
     if (!wrkr->command_queue) {
         ey_runtime_panic("ey_worker_create_opencl", "failed to create command queue");
     }
 
-    wrkr->kernel = clCreateKernel(wrkr->driver->program, kernel_name, &err);
+    wrkr->kernel = clCreateKernel(wrkr->device->program, kernel_name, &err);
     if (!wrkr->kernel || err != CL_SUCCESS) {
         ey_runtime_panic("ey_worker_create_opencl", "Failed to create compute kernel!");
     }
 
     // we dont have a custom deallocator for the worker as the real complexity is in the ctx
-    EyWorker *w = ey_runtime_gc_alloc(ey_runtime_gc(0), sizeof(EyWorker), 0);
+    EyWorker *w = ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), sizeof(EyWorker), 0, "worker");
     if (!w) {
         ey_runtime_panic("ey_worker_create_opencl", "failed to allocate worker structure");
     }
@@ -676,13 +1224,13 @@ EyWorker *ey_worker_create_opencl(const char *kernel_name, int input_size, int o
         ey_runtime_gc_alloc(ey_runtime_gc(0), sizeof(int) * wrkr->local_workgroup_size, 0);
     wrkr->shared_buffers_host = ey_runtime_gc_alloc(ey_runtime_gc(0), shared_buffer_size, 0);
     wrkr->shared_buffers_gpu =
-        clCreateBuffer(wrkr->driver->context, CL_MEM_READ_WRITE, shared_buffer_size, NULL, NULL);
+        clCreateBuffer(wrkr->device->context, CL_MEM_READ_WRITE, shared_buffer_size, NULL, NULL);
 
     _ey_clear_logs(wrkr, k_false);
 
     if (closure_ptr) {
         wrkr->closure_buffer =
-            clCreateBuffer(wrkr->driver->context, CL_MEM_READ_ONLY, closure_size, NULL, NULL);
+            clCreateBuffer(wrkr->device->context, CL_MEM_READ_ONLY, closure_size, NULL, NULL);
 
         cl_event wait_event = wrkr->ready_event;
 
@@ -701,9 +1249,46 @@ EyBoolean ey_runtime_check_cl(EyExecutionContext *ey_execution_context __attribu
     return _singleton_driver != 0;
 }
 
+/*
+  Look up a device for the std::runtime device queries, returning 0 when out of range
+ */
+static const ClDevice *cl_lookup_device(EyInteger index) {
+    if (!_singleton_driver || index < 0 || index >= _singleton_driver->device_count) {
+        return 0;
+    }
+    return &_singleton_driver->devices[index];
+}
+
+EyInteger ey_runtime_cl_device_count(EyExecutionContext *ctx __attribute__((unused))) {
+    return _singleton_driver ? _singleton_driver->device_count : 0;
+}
+
+EyInteger ey_runtime_cl_default_device(EyExecutionContext *ctx __attribute__((unused))) {
+    return _singleton_driver ? _singleton_driver->default_device : -1;
+}
+
+EyString ey_runtime_cl_device_name(EyExecutionContext *ctx, EyInteger index) {
+    const ClDevice *device = cl_lookup_device(index);
+    return ey_runtime_string_create_literal(ctx, device ? device->name : "");
+}
+
+// NB in megabytes as EyInteger is too narrow for the byte count of most devices
+EyInteger ey_runtime_cl_device_memory_mb(EyExecutionContext *ctx __attribute__((unused)),
+                                         EyInteger index) {
+    const ClDevice *device = cl_lookup_device(index);
+    return device ? (EyInteger)(device->memory / (1024 * 1024)) : 0;
+}
+
+EyInteger ey_runtime_cl_device_compute_units(EyExecutionContext *ctx __attribute__((unused)),
+                                             EyInteger index) {
+    const ClDevice *device = cl_lookup_device(index);
+    return device ? (EyInteger)device->compute_units : 0;
+}
+
 #else  // EYOT_OPENCL_INCLUDED
 
-EyWorker *ey_worker_create_opencl(const char *kernel __attribute__((unused)),
+EyWorker *ey_worker_create_opencl(EyInteger device __attribute__((unused)),
+                                  const char *kernel __attribute__((unused)),
                                   int input_size __attribute__((unused)),
                                   int output_size __attribute__((unused)),
                                   void *closure_ptr __attribute__((unused)),
@@ -716,4 +1301,27 @@ EyBoolean ey_runtime_check_cl(EyExecutionContext *ey_execution_context __attribu
     return k_false;
 }
 
+EyInteger ey_runtime_cl_device_count(EyExecutionContext *ctx __attribute__((unused))) {
+    return 0;
+}
+
+EyInteger ey_runtime_cl_default_device(EyExecutionContext *ctx __attribute__((unused))) {
+    return -1;
+}
+
+EyString ey_runtime_cl_device_name(EyExecutionContext *ctx,
+                                   EyInteger index __attribute__((unused))) {
+    return ey_runtime_string_create_literal(ctx, "");
+}
+
+EyInteger ey_runtime_cl_device_memory_mb(EyExecutionContext *ctx __attribute__((unused)),
+                                         EyInteger index __attribute__((unused))) {
+    return 0;
+}
+
+EyInteger ey_runtime_cl_device_compute_units(EyExecutionContext *ctx __attribute__((unused)),
+                                             EyInteger index __attribute__((unused))) {
+    return 0;
+}
+
 #endif  // EYOT_OPENCL_INCLUDED
@@ -61,14 +61,15 @@ typedef struct EyPipe {
 } EyPipe;
 
 EyPipe *ey_pipe_create(int value_size) {
-    EyPipe *p = ey_runtime_gc_alloc(ey_runtime_gc(0), sizeof(EyPipe), 0);
+    EyPipe *p = ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), sizeof(EyPipe), 0, "pipe");
     if (p == 0) {
         ey_runtime_panic("ey_pipe_create", "unable to allocate");
     }
     *p = (EyPipe){
         .value_size = value_size,
         .allocated_size = k_pipe_allocated_size,
-        .values = ey_runtime_gc_alloc(ey_runtime_gc(0), value_size * k_pipe_allocated_size, 0),
+        .values = ey_runtime_gc_alloc_tagged(ey_runtime_gc(0), value_size * k_pipe_allocated_size,
+                                             0, "pipe values"),
         .used_size = 0,
         .closed = k_false,
     };
@@ -117,14 +118,41 @@ void ey_pipe_send(EyPipe *p, const void *value) {
 #endif
 }
 
-EyBoolean ey_pipe_receive(EyPipe *p, void *value) {
-    EyBoolean rv;
+/*
+  Wait for a value to be available
+
+  When tracing, this first tries without blocking so that only real waits are recorded
+ */
+static void ey_pipe_wait(EyPipe *p) {
+    uint64_t trace_start = 0;
+    if (ey_trace_enabled()) {
+#ifdef __APPLE__
+        if (dispatch_semaphore_wait(p->semaphore, DISPATCH_TIME_NOW) == 0) {
+            return;
+        }
+#else
+        if (sem_trywait(&p->semaphore) == 0) {
+            return;
+        }
+#endif
+        trace_start = ey_trace_now();
+    }
+
 #ifdef __APPLE__
     dispatch_semaphore_wait(p->semaphore, DISPATCH_TIME_FOREVER);
 #else
     sem_wait(&p->semaphore);
 #endif
 
+    if (trace_start) {
+        ey_trace_event("pipe", "pipe blocked", trace_start, ey_trace_now(), 0);
+    }
+}
+
+EyBoolean ey_pipe_receive(EyPipe *p, void *value) {
+    EyBoolean rv;
+    ey_pipe_wait(p);
+
     pthread_mutex_lock(&p->mutex);
     if (p->closed && p->used_size == 0) {
         rv = k_false;
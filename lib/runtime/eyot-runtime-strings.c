@@ -22,7 +22,8 @@ static void finalise_allocated_string(void *ptr) {
 
 static EyString ey_runtime_string_create_blank(EyExecutionContext *ctx) {
     EyStringS *s =
-        ey_runtime_gc_alloc(ey_runtime_gc(ctx), sizeof(EyStringS), finalise_allocated_string);
+        ey_runtime_gc_alloc_tagged(ey_runtime_gc(ctx), sizeof(EyStringS),
+                                   finalise_allocated_string, "string");
 
     *s = (EyStringS){
         .length = 0,
@@ -35,7 +36,8 @@ static EyString ey_runtime_string_create_blank(EyExecutionContext *ctx) {
 
 EyString ey_runtime_string_copy(EyExecutionContext *ctx, EyString s) {
     EyStringS *ns =
-        ey_runtime_gc_alloc(ey_runtime_gc(ctx), sizeof(EyStringS), finalise_allocated_string);
+        ey_runtime_gc_alloc_tagged(ey_runtime_gc(ctx), sizeof(EyStringS),
+                                   finalise_allocated_string, "string");
     *ns = (EyStringS){
         .length = s->length,
         .ptr = ey_runtime_manual_alloc(s->length),
@@ -56,7 +58,8 @@ EyString ey_runtime_string_assign(EyExecutionContext *ctx, EyString s) {
 EyString ey_runtime_string_join(EyExecutionContext *ctx, EyString lhs, EyString rhs) {
     const int length = lhs->length + rhs->length;
     EyStringS *s =
-        ey_runtime_gc_alloc(ey_runtime_gc(ctx), sizeof(EyStringS), finalise_allocated_string);
+        ey_runtime_gc_alloc_tagged(ey_runtime_gc(ctx), sizeof(EyStringS),
+                                   finalise_allocated_string, "string");
     *s = (EyStringS){
         .length = length,
         .ptr = ey_runtime_manual_alloc(length),
@@ -109,7 +112,8 @@ EyString ey_runtime_string_create_literal(EyExecutionContext *ctx, const char *l
     const int usv_count = get_utf8_length(literal);
 
     EyStringS *s =
-        ey_runtime_gc_alloc(ey_runtime_gc(ctx), sizeof(EyStringS), finalise_allocated_string);
+        ey_runtime_gc_alloc_tagged(ey_runtime_gc(ctx), sizeof(EyStringS),
+                                   finalise_allocated_string, "string");
     *s = (EyStringS){
         .length = usv_count * 4,
         .ptr = ey_runtime_manual_alloc(4 * usv_count),
@@ -0,0 +1,200 @@
+/*
+  Eyot runtime tracing
+
+  This writes Chrome trace_event JSON, which can be opened in Perfetto or chrome://tracing
+  Tracing is started by setting EyotTrace to a path, or from std::runtime::trace_start
+ */
+
+// for clock_gettime under -std=c99
+#define _POSIX_C_SOURCE 200809L
+
+#include "eyot-runtime-cpu.h"
+
+#include <pthread.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+
+static pthread_mutex_t trace_mutex = PTHREAD_MUTEX_INITIALIZER;
+
+/*
+  Non-zero whilst a trace is being written
+
+  This is read atomically without the lock, as a fast check. It is only a hint, writers check
+  trace_file again under the lock, so an event racing ey_trace_stop is dropped
+ */
+static int trace_enabled = 0;
+
+static FILE *trace_file = 0;
+static int trace_event_count = 0;
+
+// each thread is given a small id on first use, which is more readable than pthread_t
+static __thread int trace_thread_id = 0;
+static int trace_thread_count = 0;
+
+static EyBoolean trace_registered_atexit = k_false;
+
+EyBoolean ey_trace_enabled(void) {
+    return __atomic_load_n(&trace_enabled, __ATOMIC_ACQUIRE) != 0;
+}
+
+uint64_t ey_trace_now(void) {
+    struct timespec ts;
+    clock_gettime(CLOCK_MONOTONIC, &ts);
+    return (uint64_t)ts.tv_sec * 1000000 + (uint64_t)ts.tv_nsec / 1000;
+}
+
+/*
+  Start an event record
+
+  NB this assumes the lock is held
+ */
+static void trace_begin_record(void) {
+    if (trace_event_count > 0) {
+        fputs(",\n", trace_file);
+    }
+    trace_event_count += 1;
+}
+
+// NB called locked
+static int trace_current_thread(void) {
+    if (trace_thread_id == 0) {
+        trace_thread_count += 1;
+        trace_thread_id = trace_thread_count;
+    }
+    return trace_thread_id;
+}
+
+/*
+  Write a JSON string, quoted, escaping what JSON needs escaped
+
+  Names can come from outside eyot, e.g. a device name from the OpenCL driver
+  NB called locked
+ */
+static void trace_write_string(const char *s) {
+    fputc('"', trace_file);
+    for (const unsigned char *c = (const unsigned char *)s; *c; c += 1) {
+        if (*c == '"' || *c == '\\') {
+            fputc('\\', trace_file);
+            fputc(*c, trace_file);
+        } else if (*c < 0x20) {
+            fprintf(trace_file, "\\u%04x", *c);
+        } else {
+            fputc(*c, trace_file);
+        }
+    }
+    fputc('"', trace_file);
+}
+
+static void trace_write_event(int tid, const char *category, const char *name, uint64_t start,
+                              uint64_t end, const char *args) {
+    pthread_mutex_lock(&trace_mutex);
+    if (trace_file) {
+        if (tid < 0) {
+            tid = trace_current_thread();
+        }
+
+        trace_begin_record();
+        fputs("{\"name\":", trace_file);
+        trace_write_string(name);
+        fputs(",\"cat\":", trace_file);
+        trace_write_string(category);
+        fprintf(trace_file, ",\"ph\":\"X\",\"pid\":1,\"tid\":%d,\"ts\":%llu,\"dur\":%llu", tid,
+                (unsigned long long)start, (unsigned long long)(end > start ? end - start : 0));
+        if (args) {
+            fprintf(trace_file, ",\"args\":%s", args);
+        }
+        fputs("}", trace_file);
+    }
+    pthread_mutex_unlock(&trace_mutex);
+}
+
+void ey_trace_event(const char *category, const char *name, uint64_t start, uint64_t end,
+                    const char *args) {
+    if (!ey_trace_enabled()) {
+        return;
+    }
+    trace_write_event(-1, category, name, start, end, args);
+}
+
+void ey_trace_event_on_track(int track, const char *category, const char *name, uint64_t start,
+                             uint64_t end, const char *args) {
+    if (!ey_trace_enabled()) {
+        return;
+    }
+    trace_write_event(track, category, name, start, end, args);
+}
+
+void ey_trace_name_track(int track, const char *name) {
+    if (!ey_trace_enabled()) {
+        return;
+    }
+
+    pthread_mutex_lock(&trace_mutex);
+    if (trace_file) {
+        trace_begin_record();
+        fprintf(trace_file,
+                "{\"name\":\"thread_name\",\"ph\":\"M\",\"pid\":1,\"tid\":%d,\"args\":{\"name\":",
+                track);
+        trace_write_string(name);
+        fputs("}}", trace_file);
+    }
+    pthread_mutex_unlock(&trace_mutex);
+}
+
+void ey_trace_stop(void) {
+    pthread_mutex_lock(&trace_mutex);
+    __atomic_store_n(&trace_enabled, 0, __ATOMIC_RELEASE);
+    if (trace_file) {
+        fputs("\n]}\n", trace_file);
+        fclose(trace_file);
+        trace_file = 0;
+    }
+    pthread_mutex_unlock(&trace_mutex);
+}
+
+void ey_trace_start(const char *path) {
+    // only one trace is written at a time
+    ey_trace_stop();
+
+    pthread_mutex_lock(&trace_mutex);
+    trace_file = fopen(path, "w");
+    if (!trace_file) {
+        pthread_mutex_unlock(&trace_mutex);
+        ey_print("ey_trace_start: unable to open '%s' for writing\n", path);
+        return;
+    }
+
+    // make sure the file is valid json if the program exits without stopping the trace
+    if (!trace_registered_atexit) {
+        atexit(ey_trace_stop);
+        trace_registered_atexit = k_true;
+    }
+
+    trace_event_count = 0;
+    fputs("{\"displayTimeUnit\":\"ms\",\"traceEvents\":[\n", trace_file);
+    trace_begin_record();
+    fputs("{\"name\":\"process_name\",\"ph\":\"M\",\"pid\":1,\"args\":{\"name\":\"eyot\"}}",
+          trace_file);
+    __atomic_store_n(&trace_enabled, 1, __ATOMIC_RELEASE);
+    pthread_mutex_unlock(&trace_mutex);
+}
+
+void ey_trace_start_from_environment(void) {
+    const char *path = getenv("EyotTrace");
+    if (path && strlen(path) > 0) {
+        ey_trace_start(path);
+    }
+}
+
+void ey_runtime_trace_start(EyExecutionContext *ctx __attribute__((unused)), EyString path) {
+    const char *cpath = ey_runtime_string_create_c_string(path);
+    ey_trace_start(cpath);
+    ey_runtime_manual_free((void *)cpath);
+}
+
+void ey_runtime_trace_stop(EyExecutionContext *ctx __attribute__((unused))) {
+    ey_trace_stop();
+}
@@ -13,7 +13,8 @@ typedef struct EyVector {
 } EyVector;
 
 EyVector *ey_vector_create(EyExecutionContext *ey_execution_context, int unit_size) {
-    EyVector *vec = ey_runtime_gc_alloc(ey_runtime_gc(ey_execution_context), sizeof(EyVector), 0);
+    EyVector *vec = ey_runtime_gc_alloc_tagged(ey_runtime_gc(ey_execution_context),
+                                               sizeof(EyVector), 0, "vector");
     if (vec == 0) {
         ey_runtime_panic("ey_vector_create", "unable to allocate");
     }
@@ -37,8 +38,9 @@ void ey_vector_resize(EyExecutionContext *ey_execution_context, EyVector *vec, i
                 ey_runtime_panic("ey_vector_resize", "reallocation failed");
             }
         } else {
-            vec->ptr = ey_runtime_gc_alloc(ey_runtime_gc(ey_execution_context),
-                                           vec->unit_size * vec->length, 0);
+            vec->ptr = ey_runtime_gc_alloc_tagged(ey_runtime_gc(ey_execution_context),
+                                                  vec->unit_size * vec->length, 0,
+                                                  "vector elements");
             if (!vec->ptr) {
                 ey_runtime_panic("ey_vector_resize", "allocation failed");
             }
@@ -243,7 +243,7 @@ static const char *cl_src =
     "}\n";
 
 static void test_gpu_worker(EyExecutionContext *ctx) {
-    EyWorker *w = ey_worker_create_opencl("kernel1", 4, 4, 0, 0);
+    EyWorker *w = ey_worker_create_opencl(-1, "kernel1", 4, 4, 0, 0);
     if (!w) {
         ey_runtime_panic("test", "no worker");
     }
@@ -295,7 +295,7 @@ static void test_gpu_worker(EyExecutionContext *ctx) {
 static void test_gpu_worker_with_parameter(EyExecutionContext *ctx) {
     int closure = 2;
 
-    EyWorker *w = ey_worker_create_opencl("kernel3", 4, 4, &closure, sizeof(closure));
+    EyWorker *w = ey_worker_create_opencl(-1, "kernel3", 4, 4, &closure, sizeof(closure));
     if (!w) {
         ey_runtime_panic("test", "no worker");
     }
@@ -478,6 +478,310 @@ static void test_gc_recursive(EyExecutionContext *ctx __attribute__((unused))) {
     ey_runtime_gc_free(gc);
 }
 
+static void test_gc_young(EyExecutionContext *ctx __attribute__((unused))) {
+    finalised = 0;
+    EyGCRegion *gc = ey_runtime_gc_create();
+
+    XY *xy = ey_runtime_gc_alloc(gc, sizeof(XY), finaliser);
+    ey_runtime_gc_remember_root_object(gc, xy);
+    xy->a = 1;
+
+    // promotes xy
+    ey_runtime_gc_collect_young(gc);
+
+    // only referenced from an old page
+    xy->b = ey_runtime_gc_alloc(gc, sizeof(int), finaliser);
+    *xy->b = 2;
+
+    ey_runtime_gc_collect_young(gc);
+    if (finalised != 0) {
+        ey_runtime_panic("test_gc_young", "bad finaliser 1");
+    }
+
+    // now b is also old, so a young collection will not free it
+    xy->b = 0;
+    ey_runtime_gc_collect_young(gc);
+    if (finalised != 0) {
+        ey_runtime_panic("test_gc_young", "bad finaliser 2");
+    }
+
+    ey_runtime_gc_collect(gc);
+    if (finalised != 2) {
+        ey_runtime_panic("test_gc_young", "bad finaliser 3");
+    }
+
+    int *c = ey_runtime_gc_alloc(gc, sizeof(int), finaliser);
+    *c = 4;
+    ey_runtime_gc_collect_young(gc);
+    if (finalised != 6) {
+        ey_runtime_panic("test_gc_young", "bad finaliser 4");
+    }
+
+    ey_runtime_gc_forget_root_object(gc, xy);
+    ey_runtime_gc_collect_young(gc);
+    if (finalised != 6) {
+        ey_runtime_panic("test_gc_young", "old page freed by a young collection");
+    }
+
+    ey_runtime_gc_collect(gc);
+    if (finalised != 7) {
+        ey_runtime_panic("test_gc_young", "bad finaliser 5");
+    }
+
+    if (ey_runtime_gc_get_stats(gc).bytes_allocated != 0) {
+        ey_runtime_panic("test_gc_young", "bad alloc 1");
+    }
+
+    ey_runtime_gc_free(gc);
+}
+
+static void test_gc_stats(EyExecutionContext *ctx __attribute__((unused))) {
+    finalised = 0;
+    EyGCOptions options = ey_runtime_gc_default_options();
+    options.threshold_bytes = 64;
+    options.growth_factor = 10.0;
+    EyGCRegion *gc = ey_runtime_gc_create_with_options(options);
+
+    XY *xy = ey_runtime_gc_alloc_tagged(gc, sizeof(XY), finaliser, "xy");
+    ey_runtime_gc_remember_root_object(gc, xy);
+    xy->a = 1;
+    xy->b = ey_runtime_gc_alloc(gc, sizeof(int), finaliser);
+    *xy->b = 2;
+
+    int *c = ey_runtime_gc_alloc(gc, sizeof(int), finaliser);
+    ey_runtime_gc_remember_root_pointer(gc, &c);
+    *c = 4;
+
+    EyGCStats stats = ey_runtime_gc_get_stats(gc);
+    if (stats.roots != 2 || stats.collections != 0) {
+        ey_runtime_panic("test_gc_stats", "bad initial stats");
+    }
+
+    // under the threshold
+    ey_runtime_gc_collect_if_needed(gc);
+    if (ey_runtime_gc_get_stats(gc).collections != 0) {
+        ey_runtime_panic("test_gc_stats", "collected under the threshold");
+    }
+
+    ey_runtime_gc_forget_root_pointer(gc, &c);
+    for (int i = 0; i < 8; i += 1) {
+        ey_runtime_gc_alloc(gc, 16, 0);
+    }
+    const int64_t peak = ey_runtime_gc_get_stats(gc).bytes_allocated;
+
+    // nothing has been collected yet, so the threshold also starts a full collection
+    ey_runtime_gc_collect_if_needed(gc);
+    stats = ey_runtime_gc_get_stats(gc);
+    if (stats.collections != 1 || stats.young_collections != 0 || finalised != 4) {
+        ey_runtime_panic("test_gc_stats", "expected a full collection");
+    }
+    if (stats.peak_bytes != peak || stats.live_bytes != sizeof(XY) + sizeof(int) ||
+        stats.roots != 1) {
+        ey_runtime_panic("test_gc_stats", "bad stats after collection");
+    }
+    if (stats.max_pause_us < stats.last_pause_us || stats.total_pause_us < stats.max_pause_us) {
+        ey_runtime_panic("test_gc_stats", "bad pause times");
+    }
+
+    // over the young threshold, but the heap has not grown enough for a full collection
+    for (int i = 0; i < 5; i += 1) {
+        ey_runtime_gc_alloc(gc, 16, 0);
+    }
+    ey_runtime_gc_collect_if_needed(gc);
+    stats = ey_runtime_gc_get_stats(gc);
+    if (stats.collections != 2 || stats.young_collections != 1) {
+        ey_runtime_panic("test_gc_stats", "expected a young collection");
+    }
+
+    const char *path = "/tmp/eyot-runtime-test-heap.json";
+    if (!ey_runtime_gc_dump(gc, path)) {
+        ey_runtime_panic("test_gc_stats", "failed to write the heap dump");
+    }
+    FILE *f = fopen(path, "r");
+    char dump[4096];
+    const size_t read = f ? fread(dump, 1, sizeof(dump) - 1, f) : 0;
+    if (f) {
+        fclose(f);
+    }
+    dump[read] = 0;
+    if (!strstr(dump, "{\"kind\":\"object\",\"tag\":\"xy\",\"size\":16,\"root_count\":1,"
+                      "\"retained_pages\":2,\"retained_bytes\":20}")) {
+        printf("%s", dump);
+        ey_runtime_panic("test_gc_stats", "root not in the heap dump");
+    }
+    remove(path);
+
+    ey_runtime_gc_forget_root_object(gc, xy);
+    ey_runtime_gc_collect(gc);
+    if (ey_runtime_gc_get_stats(gc).bytes_allocated != 0) {
+        ey_runtime_panic("test_gc_stats", "bad alloc 1");
+    }
+
+    ey_runtime_gc_free(gc);
+}
+
+/*
+  Throughput of allocation heavy threads whilst the main thread collects
+ */
+enum {
+    k_throughput_threads = 4,
+    k_throughput_allocations = 200000,
+    k_throughput_live = 20000,
+};
+
+typedef struct {
+    EyGCRegion *gc;
+    int finished;
+} ThroughputState;
+
+static int throughput_finalised = 0;
+
+static void throughput_finaliser(void *ptr __attribute__((unused))) {
+    __atomic_add_fetch(&throughput_finalised, 1, __ATOMIC_RELAXED);
+}
+
+static void *throughput_allocator(void *arg) {
+    ThroughputState *state = arg;
+
+    // these are garbage as soon as they are allocated, so are never touched
+    for (int i = 0; i < k_throughput_allocations; i += 1) {
+        ey_runtime_gc_alloc(state->gc, 48, 0);
+    }
+
+    __atomic_add_fetch(&state->finished, 1, __ATOMIC_RELEASE);
+    return 0;
+}
+
+static int64_t *throughput_live_value(EyGCRegion *gc, int64_t value) {
+    int64_t *v = ey_runtime_gc_alloc(gc, sizeof(int64_t), throughput_finaliser);
+    *v = value;
+    return v;
+}
+
+// every live value is still allocated and holds its index
+static void throughput_check(const char *label, int64_t **live) {
+    for (int i = 0; i < k_throughput_live; i += 1) {
+        if (*live[i] != i) {
+            ey_print("%s: live value %d is %lld\n", label, i, (long long)*live[i]);
+            ey_runtime_panic("test_gc_throughput", "live value overwritten");
+        }
+    }
+}
+
+static void test_gc_throughput_run(const char *label, EyGCOptions options, EyBoolean young) {
+    EyGCRegion *gc = ey_runtime_gc_create_with_options(options);
+    throughput_finalised = 0;
+
+    // a live old generation that every full collection has to mark
+    int64_t **live = ey_runtime_gc_alloc(gc, sizeof(int64_t *) * k_throughput_live, 0);
+    ey_runtime_gc_remember_root_object(gc, live);
+    for (int i = 0; i < k_throughput_live; i += 1) {
+        live[i] = throughput_live_value(gc, i);
+    }
+    ey_runtime_gc_collect(gc);
+
+    ThroughputState state = {
+        .gc = gc,
+        .finished = 0,
+    };
+
+    const uint64_t start = ey_trace_now();
+
+    pthread_t threads[k_throughput_threads];
+    for (int i = 0; i < k_throughput_threads; i += 1) {
+        pthread_create(&threads[i], 0, throughput_allocator, &state);
+    }
+
+    int collections = 0;
+    while (__atomic_load_n(&state.finished, __ATOMIC_ACQUIRE) < k_throughput_threads) {
+        if (young) {
+            ey_runtime_gc_collect_young(gc);
+        } else {
+            ey_runtime_gc_collect(gc);
+        }
+
+        // replace a value, so the old array holds the only reference to a young page
+        const int replaced = collections % k_throughput_live;
+        live[replaced] = throughput_live_value(gc, replaced);
+        collections += 1;
+
+        throughput_check(label, live);
+    }
+
+    for (int i = 0; i < k_throughput_threads; i += 1) {
+        pthread_join(threads[i], 0);
+    }
+
+    const uint64_t elapsed = ey_trace_now() - start;
+    const double allocations = (double)k_throughput_threads * k_throughput_allocations;
+    printf("  %s: %.0f allocations/ms, %d collections\n", label,
+           allocations / (double)(elapsed > 0 ? elapsed : 1) * 1000.0, collections);
+
+    // a young collection keeps the replacements, and only ever frees replaced values
+    ey_runtime_gc_collect_young(gc);
+    throughput_check(label, live);
+    if (__atomic_load_n(&throughput_finalised, __ATOMIC_RELAXED) > collections) {
+        ey_runtime_panic("test_gc_throughput", "young collection freed a live value");
+    }
+
+    // a full collection frees every replaced value and all the garbage, and nothing else
+    ey_runtime_gc_collect(gc);
+    throughput_check(label, live);
+    if (throughput_finalised != collections) {
+        ey_runtime_panic("test_gc_throughput", "full collection missed a replaced value");
+    }
+    const int64_t live_bytes = sizeof(int64_t *) * k_throughput_live +
+                               sizeof(int64_t) * k_throughput_live;
+    if (ey_runtime_gc_get_stats(gc).bytes_allocated != live_bytes) {
+        ey_runtime_panic("test_gc_throughput", "garbage survived a full collection");
+    }
+
+    ey_runtime_gc_forget_root_object(gc, live);
+    ey_runtime_gc_collect(gc);
+    if (ey_runtime_gc_get_stats(gc).bytes_allocated != 0 ||
+        throughput_finalised != collections + k_throughput_live) {
+        ey_runtime_panic("test_gc_throughput", "bad alloc 1");
+    }
+
+    ey_runtime_gc_free(gc);
+}
+
+static void test_gc_throughput(EyExecutionContext *ctx __attribute__((unused))) {
+    EyGCOptions serial = ey_runtime_gc_default_options();
+    serial.serial = k_true;
+    const EyGCOptions heaps = ey_runtime_gc_default_options();
+
+    test_gc_throughput_run("serial, full collections", serial, k_false);
+    test_gc_throughput_run("thread heaps, full collections", heaps, k_false);
+    test_gc_throughput_run("thread heaps, young collections", heaps, k_true);
+}
+
+/*
+  Names in the trace are escaped, as some come from outside eyot
+ */
+static void test_trace_escaping(EyExecutionContext *ctx __attribute__((unused))) {
+    const char *path = "/tmp/eyot-runtime-test-trace.json";
+    ey_trace_start(path);
+    ey_trace_name_track(7, "device \"A\" \\ 1");
+    ey_trace_event("test", "a\tb", 1, 3, 0);
+    ey_trace_stop();
+
+    FILE *f = fopen(path, "r");
+    char trace[4096];
+    const size_t read = f ? fread(trace, 1, sizeof(trace) - 1, f) : 0;
+    if (f) {
+        fclose(f);
+    }
+    trace[read] = 0;
+    if (!strstr(trace, "\"args\":{\"name\":\"device \\\"A\\\" \\\\ 1\"}}") ||
+        !strstr(trace, "{\"name\":\"a\\u0009b\",\"cat\":\"test\",\"ph\":\"X\",\"pid\":1,\"tid\":1,"
+                       "\"ts\":1,\"dur\":2}")) {
+        printf("%s", trace);
+        ey_runtime_panic("test_trace_escaping", "names not escaped");
+    }
+    remove(path);
+}
+
 void ey_generated_main(EyExecutionContext *ctx) {
     printf("test_vector\n");
     test_vector(ctx);
@@ -494,6 +798,18 @@ void ey_generated_main(EyExecutionContext *ctx) {
     printf("test_gc_recursive\n");
     test_gc_recursive(ctx);
 
+    printf("test_gc_young\n");
+    test_gc_young(ctx);
+
+    printf("test_gc_stats\n");
+    test_gc_stats(ctx);
+
+    printf("test_gc_throughput\n");
+    test_gc_throughput(ctx);
+
+    printf("test_trace_escaping\n");
+    test_trace_escaping(ctx);
+
     printf("test_basic_worker\n");
     test_basic_worker(ctx);
 
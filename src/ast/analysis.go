@@ -0,0 +1,165 @@
+package ast
+
+import (
+	"strings"
+)
+
+// The warnings found by KPassAnalyse, the names are also how they are disabled
+const (
+	KWarningUnusedVariable  = "unused-variable"
+	KWarningUnusedImport    = "unused-import"
+	KWarningUnusedFunction  = "unused-function"
+	KWarningUnreachableCode = "unreachable-code"
+	KWarningShadow          = "shadow"
+)
+
+var Warnings = []string{
+	KWarningUnusedVariable,
+	KWarningUnusedImport,
+	KWarningUnusedFunction,
+	KWarningUnreachableCode,
+	KWarningShadow,
+}
+
+/*
+What the analysis pass knows about the module
+
+Uses and shadowing are noted during KPassSetTypes, when the tree is still as it was written and
+names are resolved in the order they appear. KPassAnalyse then reports on them
+*/
+type analysis struct {
+	// names that were read, by the scope that declares them
+	used map[*Scope]map[string]bool
+
+	// declarations hiding a variable of the same name from an enclosing scope
+	shadows map[*IdentifierLValue]bool
+
+	// functions already warned about, as gpu and cpu versions share a name
+	reportedFunctions map[string]bool
+}
+
+// Leading underscores mark a name as deliberately unused
+func ignoredName(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+// Note that name was used from scope
+func (ctx *CheckContext) noteUse(scope *Scope, name string) {
+	declaring := scope.declaringScope(name)
+	if declaring == nil {
+		return
+	}
+
+	if ctx.analysis.used == nil {
+		ctx.analysis.used = map[*Scope]map[string]bool{}
+	}
+	if ctx.analysis.used[declaring] == nil {
+		ctx.analysis.used[declaring] = map[string]bool{}
+	}
+	ctx.analysis.used[declaring][name] = true
+}
+
+// Assigning through a.b, a[i] or *a uses a
+func (ctx *CheckContext) noteLValueUse(scope *Scope, lv LValue) {
+	switch l := lv.(type) {
+	case *IdentifierLValue:
+		ctx.noteUse(scope, l.Name)
+
+	case *AccessorLValue:
+		ctx.noteLValueUse(scope, l.Inner)
+
+	case *IndexLValue:
+		ctx.noteLValueUse(scope, l.Indexed)
+
+	case *DerefLValue:
+		ctx.noteLValueUse(scope, l.Inner)
+	}
+}
+
+// Note any variables a let hides, this must be called as it is declared
+func (ctx *CheckContext) noteShadows(scope *Scope, lv LValue) {
+	if scope.Parent == nil {
+		return
+	}
+
+	for _, ilv := range DeclaredLValues(lv) {
+		if _, _, fnd := scope.Parent.LookupVariableType(ilv.Name); fnd {
+			if ctx.analysis.shadows == nil {
+				ctx.analysis.shadows = map[*IdentifierLValue]bool{}
+			}
+			ctx.analysis.shadows[ilv] = true
+		}
+	}
+}
+
+func (ctx *CheckContext) isUsed(scope *Scope, name string) bool {
+	return ctx.analysis.used[scope.declaringScope(name)][name]
+}
+
+// Warn about a node, nodes the compiler generated are never warned about
+func (ctx *CheckContext) warnAt(n interface{}, code string, format string, args ...interface{}) {
+	sl := LocationOf(n)
+	if sl.Line == 0 {
+		return
+	}
+	ctx.Errors.Warnf(sl, code, format, args...)
+}
+
+// The identifiers a let declares
+func DeclaredLValues(lv LValue) []*IdentifierLValue {
+	switch l := lv.(type) {
+	case *IdentifierLValue:
+		return []*IdentifierLValue{l}
+
+	case *MultipleLValue:
+		ilvs := []*IdentifierLValue{}
+		for _, inner := range l.LValues {
+			ilvs = append(ilvs, DeclaredLValues(inner)...)
+		}
+		return ilvs
+	}
+
+	return nil
+}
+
+// Warn about declared variables that are never read, or that hide another
+func (ctx *CheckContext) analyseDeclaration(as *AssignStatement, scope *Scope) {
+	for _, ilv := range DeclaredLValues(as.Lhs) {
+		if ignoredName(ilv.Name) {
+			continue
+		}
+
+		if ctx.analysis.shadows[ilv] {
+			ctx.warnAt(ilv, KWarningShadow, "Declaration of %v shadows an earlier declaration", ilv.Name)
+		}
+		if !ctx.isUsed(scope, ilv.Name) {
+			ctx.warnAt(ilv, KWarningUnusedVariable, "Variable %v is never used", ilv.Name)
+		}
+	}
+}
+
+// Warn about a function nothing can call
+func (ctx *CheckContext) analyseFunction(fd *FunctionDefinition, scope *Scope) {
+	if fd.Exported || !fd.Id.Struct.Blank() || fd.Id.Name == "main" || ignoredName(fd.Id.Name) {
+		return
+	}
+
+	if ctx.analysis.reportedFunctions[fd.Id.Name] || ctx.isUsed(scope, fd.Id.Name) {
+		return
+	}
+
+	if ctx.analysis.reportedFunctions == nil {
+		ctx.analysis.reportedFunctions = map[string]bool{}
+	}
+	ctx.analysis.reportedFunctions[fd.Id.Name] = true
+	ctx.warnAt(fd, KWarningUnusedFunction, "Function %v is never used", fd.Id.Name)
+}
+
+// Statements after a return or break never run
+func endsFlow(s Statement) bool {
+	switch s.(type) {
+	case *ReturnStatement, *BreakStatement:
+		return true
+	}
+	return false
+}
@@ -40,8 +40,27 @@ const (
 
 	// Check types (including from mutated code)
 	KPassCheckTypes
+
+	// Warn about likely mistakes, see analysis.go. This does not change the tree
+	KPassAnalyse
 )
 
+func (cp CheckPass) String() string {
+	switch cp {
+	case KPassSetTleTypes:
+		return "set-tle-types"
+	case KPassSetTypes:
+		return "set-types"
+	case KPassMutate:
+		return "mutate"
+	case KPassCheckTypes:
+		return "check-types"
+	case KPassAnalyse:
+		return "analyse"
+	}
+	return fmt.Sprintf("CheckPass(%d)", int(cp))
+}
+
 /*
    A Check context is the overall record of typechecking a module
  */
@@ -70,6 +89,15 @@ type CheckContext struct {
 	Strings map[string]int
 
 	currentModule *Module
+
+	// variables whose declarations failed to check, by the scope they were declared in
+	brokenVariables map[*Scope]map[string]bool
+
+	// structs whose definitions failed to check, by StructId.Key
+	brokenStructs map[string]bool
+
+	// what KPassAnalyse needs from the earlier passes
+	analysis analysis
 }
 
 func NewCheckContext(es *errors.Errors, sp map[string]int) *CheckContext {
@@ -110,8 +138,72 @@ func (ctx *CheckContext) RequireClosureSize(size int) {
 
 func (ctx *CheckContext) AssertType(e Expression, ty TypeSelector) {
 	if e.Type().Selector != ty {
-		ctx.Errors.Errorf("Mismatched types: expecting %v, got %v", RoughTypeName(ty), e.Type())
+		ctx.ErrorfAt(e, "Mismatched types: expecting %v, got %v", RoughTypeName(ty), e.Type())
+	}
+}
+
+// Log an error pointing at the source of node n, or the current statement if it has no location
+func (ctx *CheckContext) ErrorfAt(n interface{}, format string, args ...interface{}) {
+	ctx.Errors.ErrorfAt(LocationOf(n), format, args...)
+}
+
+// Note that a statement declaring these variables failed to check, see ErrorfUnlessBroken
+func (ctx *CheckContext) MarkVariablesBroken(scope *Scope, names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	if ctx.brokenVariables == nil {
+		ctx.brokenVariables = map[*Scope]map[string]bool{}
+	}
+	if ctx.brokenVariables[scope] == nil {
+		ctx.brokenVariables[scope] = map[string]bool{}
+	}
+	for _, name := range names {
+		ctx.brokenVariables[scope][name] = true
+	}
+}
+
+// Note that a top level element failed to check, so the function, const or struct it declares is broken
+func (ctx *CheckContext) MarkTleBroken(tlec TopLevelElementContainer) {
+	switch tle := tlec.TopLevelElement.(type) {
+	case *FunctionDefinitionTle:
+		// methods are not in the module's scope, uses of them go through the struct
+		if tle.Definition.Id.Struct.Blank() {
+			ctx.MarkVariablesBroken(tlec.Context, []string{tle.Definition.Id.Name})
+		}
+
+	case *ConstTle:
+		ctx.MarkVariablesBroken(tlec.Context, declaredNames(tle.Assign))
+
+	case *StructDefinitionStatement:
+		if ctx.brokenStructs == nil {
+			ctx.brokenStructs = map[string]bool{}
+		}
+		ctx.brokenStructs[tle.Id.Key()] = true
+	}
+}
+
+// Report a missing variable or function, unless it is missing because its declaration already failed
+func (ctx *CheckContext) ErrorfUnlessBroken(n interface{}, scope *Scope, name string, format string, args ...interface{}) {
+	for s := scope; s != nil; s = s.Parent {
+		if ctx.brokenVariables[s][name] {
+			ctx.Errors.KnockOnf(LocationOf(n), format, args...)
+			return
+		}
+	}
+
+	ctx.ErrorfAt(n, format, args...)
+}
+
+// Report a missing struct, unless it is missing because its definition already failed
+func (ctx *CheckContext) ErrorfUnlessStructBroken(n interface{}, id StructId, format string, args ...interface{}) {
+	if ctx.brokenStructs[id.Key()] {
+		ctx.Errors.KnockOnf(LocationOf(n), format, args...)
+		return
 	}
+
+	ctx.ErrorfAt(n, format, args...)
 }
 
 func (ctx *CheckContext) MaximumClosureSize() int {
@@ -182,6 +274,17 @@ func (cc *CheckContext) NoteCpuRequired(msg string) {
 	}
 }
 
+/*
+Call this in any pass where a statement can't run on the gpu
+
+Unlike NoteCpuRequired, a function that can run on either is fine
+*/
+func (cc *CheckContext) NoteGpuForbidden(msg string) {
+	if cc.inGpuMethodCount > 0 {
+		cc.Errors.Errorf("Not available on the GPU: %v", msg)
+	}
+}
+
 /*
 Call this in any pass where Gpu is required
 
@@ -340,8 +443,9 @@ func (cc *CheckContext) RequireType(ty Type, scope *Scope) {
 
 			for i, ty := range ty.Types {
 				defn.Fields = append(defn.Fields, StructField{
-					Name: TupleFieldName(i),
-					Type: ty,
+					Name:   TupleFieldName(i),
+					Type:   ty,
+					Public: true,
 				})
 			}
 
@@ -358,7 +462,7 @@ func (cc *CheckContext) RequireType(ty Type, scope *Scope) {
 		case KTypeStruct:
 			sd, fnd := scope.LookupStructDefinition(ty.StructId)
 			if !fnd {
-				cc.Errors.Errorf("Failed to find struct definition for '%v'", ty.StructId)
+				cc.ErrorfUnlessStructBroken(nil, ty.StructId, "Failed to find struct definition for '%v'", ty.StructId)
 			}
 			cc.Structs = append(cc.Structs, &RequiredStruct{
 				GeneratedForTuple: false,
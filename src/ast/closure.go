@@ -7,6 +7,8 @@ import (
 
 // Represent the creation of a closure
 type ClosureExpression struct {
+	Located
+
 	// The root function this builds upon
 	CalledExpression Expression
 
@@ -75,7 +77,7 @@ func (ce *ClosureExpression) Check(ctx *CheckContext, scope *Scope) {
 	case KPassSetTypes:
 		// do this check early, it will cause crashes if false and unchecked
 		if len(cet.Types) != len(ce.SuppliedArguments) {
-			ctx.Errors.Errorf("Cannot partially apply %v arguments to a function of %v arguments", len(ce.SuppliedArguments), len(cet.Types))
+			ctx.ErrorfAt(ce, "Cannot partially apply %v arguments to a function of %v arguments", len(ce.SuppliedArguments), len(cet.Types))
 			return
 		}
 
@@ -116,7 +118,7 @@ func (ce *ClosureExpression) Check(ctx *CheckContext, scope *Scope) {
 		ctx.RequireClosureSize(sizeEstimate)
 
 		if !cet.IsCallable() {
-			ctx.Errors.Errorf("Called expression in partial is not callable")
+			ctx.ErrorfAt(ce, "Called expression in partial is not callable")
 			return
 		}
 
@@ -124,13 +126,13 @@ func (ce *ClosureExpression) Check(ctx *CheckContext, scope *Scope) {
 			// this is creating from a raw function (ok)
 			it, ok := ce.CalledExpression.(*IdentifierTerminal)
 			if !ok {
-				ctx.Errors.Errorf(".CalledExpression not an identifier")
+				ctx.ErrorfAt(ce, ".CalledExpression not an identifier")
 				return
 			}
 
 			ce.CalledFunctionId = *it.Fid
 		} else {
-			ctx.Errors.Errorf("Cannot create from closure yet")
+			ctx.ErrorfAt(ce, "Cannot create from closure yet")
 			return
 		}
 	}
@@ -0,0 +1,246 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"eyot/errors"
+)
+
+var (
+	functionIdType = reflect.TypeOf(FunctionId{})
+	structIdType   = reflect.TypeOf(StructId{})
+	moduleIdType   = reflect.TypeOf(ModuleId{})
+	spanType       = reflect.TypeOf(errors.SourceLocation{})
+)
+
+// A node of the tree as Dump gives it, with its fields in the order they are declared
+type DumpNode struct {
+	// the name of the node's Go type, e.g. FunctionDefinitionTle
+	Node string
+
+	// where it was parsed from, empty for nodes the compiler generated
+	Span string
+
+	Fields []DumpField
+}
+
+// A field of a dumped node, whose value is a string, number, bool, *DumpNode or []interface{} of these
+type DumpField struct {
+	Name  string
+	Value interface{}
+}
+
+// Written with the node and span first, then the fields in order, which a map would lose
+func (dn *DumpNode) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBufferString("{")
+	write := func(name string, value interface{}) error {
+		if buf.Len() > 1 {
+			buf.WriteString(",")
+		}
+		blob, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(fmt.Sprintf("%q:", name))
+		buf.Write(blob)
+		return nil
+	}
+
+	if dn.Node != "" {
+		write("node", dn.Node)
+	}
+	if dn.Span != "" {
+		write("span", dn.Span)
+	}
+	for _, f := range dn.Fields {
+		if err := write(f.Name, f.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+func dumpSpan(sl errors.SourceLocation) string {
+	if sl.Line <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v:%v-%v:%v", sl.Line, sl.Column, sl.EndLine, sl.EndColumn)
+}
+
+/*
+Turn the tree below root into DumpNodes, for eyot ast to print
+
+Types, ids, spans and enums are given as the strings they print as, and imported modules by their id.
+Scopes are left out, as are the containers of statements and top level elements, and fields holding
+nothing (nil, empty, false or ""), to keep the output readable
+*/
+func Dump(root interface{}) interface{} {
+	// nodes being dumped, so a cycle back up the tree is cut rather than followed forever
+	active := map[uintptr]bool{}
+
+	var dump func(v reflect.Value) (interface{}, bool)
+	dump = func(v reflect.Value) (interface{}, bool) {
+		switch v.Type() {
+		case typeType:
+			return v.Interface().(Type).String(), true
+		case functionIdType:
+			return v.Interface().(FunctionId).String(), true
+		case structIdType:
+			return v.Interface().(StructId).String(), true
+		case moduleIdType:
+			mid := v.Interface().(ModuleId)
+			return mid.Key(), !mid.Blank()
+		case modulePointerType:
+			if v.IsNil() {
+				return nil, false
+			}
+			return v.Interface().(*Module).Id.Key(), true
+		case spanType:
+			span := dumpSpan(v.Interface().(errors.SourceLocation))
+			return span, span != ""
+		case scopePointerType:
+			return nil, false
+		}
+
+		switch v.Kind() {
+		case reflect.Interface:
+			if v.IsNil() {
+				return nil, false
+			}
+			return dump(v.Elem())
+
+		case reflect.Ptr:
+			if v.IsNil() {
+				return nil, false
+			}
+			if active[v.Pointer()] {
+				return "(cycle)", true
+			}
+			active[v.Pointer()] = true
+			defer delete(active, v.Pointer())
+			return dump(v.Elem())
+
+		case reflect.Struct:
+			dn := &DumpNode{Node: v.Type().Name()}
+			for i := 0; i < v.NumField(); i += 1 {
+				field := v.Type().Field(i)
+				if !field.IsExported() {
+					continue
+				}
+				if field.Type == locatedType {
+					dn.Span = dumpSpan(v.Field(i).Interface().(Located).Span)
+					continue
+				}
+
+				if value, shown := dump(v.Field(i)); shown {
+					dn.Fields = append(dn.Fields, DumpField{Name: field.Name, Value: value})
+				}
+			}
+
+			// the statement and top level element containers only add a level to the tree
+			if strings.HasSuffix(dn.Node, "Container") && len(dn.Fields) == 1 {
+				return dn.Fields[0].Value, true
+			}
+			return dn, true
+
+		case reflect.Slice, reflect.Array:
+			values := []interface{}{}
+			for i := 0; i < v.Len(); i += 1 {
+				if value, shown := dump(v.Index(i)); shown {
+					values = append(values, value)
+				}
+			}
+			return values, len(values) > 0
+
+		case reflect.Map:
+			dn := &DumpNode{}
+			keys := v.MapKeys()
+			sort.Slice(keys, func(i, j int) bool {
+				return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+			})
+			for _, key := range keys {
+				if value, shown := dump(v.MapIndex(key)); shown {
+					dn.Fields = append(dn.Fields, DumpField{Name: fmt.Sprint(key.Interface()), Value: value})
+				}
+			}
+			return dn, len(dn.Fields) > 0
+
+		case reflect.Bool:
+			return v.Bool(), v.Bool()
+
+		case reflect.String:
+			return v.String(), v.String() != ""
+
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			// enums, such as operators and locations, are given by name
+			if s, ok := v.Interface().(fmt.Stringer); ok {
+				return s.String(), true
+			}
+			return v.Int(), true
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return v.Uint(), true
+
+		case reflect.Float32, reflect.Float64:
+			return v.Float(), true
+		}
+
+		return nil, false
+	}
+
+	// the module itself is dumped, rather than given by its id as the modules it imports are
+	v := reflect.ValueOf(root)
+	if m, ok := root.(*Module); ok {
+		v = reflect.ValueOf(*m)
+	}
+	value, _ := dump(v)
+	return value
+}
+
+// Write a dumped tree as indented JSON
+func WriteDumpJSON(w io.Writer, dumped interface{}) error {
+	blob, err := json.MarshalIndent(dumped, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(blob, '\n'))
+	return err
+}
+
+/*
+Write a dumped tree as an indented tree of text, a node per line with its span
+
+	FunctionDefinitionTle 1:1-3:2
+	  Definition: FunctionDefinition 1:1-3:2
+	    Id: main::add
+*/
+func WriteDumpTree(w io.Writer, dumped interface{}) {
+	var write func(indent, label string, value interface{})
+	write = func(indent, label string, value interface{}) {
+		switch value := value.(type) {
+		case *DumpNode:
+			fmt.Fprintln(w, strings.TrimRight(indent+label+value.Node+" "+value.Span, " "))
+			for _, f := range value.Fields {
+				write(indent+"  ", f.Name+": ", f.Value)
+			}
+
+		case []interface{}:
+			fmt.Fprintln(w, strings.TrimRight(indent+label, " "))
+			for _, element := range value {
+				write(indent+"  ", "- ", element)
+			}
+
+		default:
+			fmt.Fprintf(w, "%v%v%v\n", indent, label, value)
+		}
+	}
+	write("", "", dumped)
+}
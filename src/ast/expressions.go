@@ -12,6 +12,7 @@ type Expression interface {
 }
 
 type NullLiteral struct {
+	Located
 }
 
 var _ Expression = &NullLiteral{}
@@ -29,6 +30,8 @@ func (st *NullLiteral) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type CastExpression struct {
+	Located
+
 	NewType Type
 	Casted  Expression
 	CheckCastable bool
@@ -56,13 +59,15 @@ func (ce *CastExpression) Check(ctx *CheckContext, scope *Scope) {
 
 	case KPassCheckTypes:
 		if ce.CheckCastable && !ce.Casted.Type().CanAssignTo(ce.NewType) {
-			ctx.Errors.Errorf("cannot cast %v to %v", ce.Casted.Type().String(), ce.NewType.String())
+			ctx.ErrorfAt(ce.Casted, "cannot cast %v to %v", ce.Casted.Type().String(), ce.NewType.String())
 			return
 		}
 	}
 }
 
 type SelfTerminal struct {
+	Located
+
 	cachedType Type
 }
 
@@ -81,13 +86,15 @@ func (st *SelfTerminal) Check(ctx *CheckContext, scope *Scope) {
 		var ok bool
 		st.cachedType, _, ok = scope.LookupVariableType("__self__")
 		if !ok {
-			ctx.Errors.Errorf("SelfTerminal: Could not find a type for self")
+			ctx.ErrorfAt(st, "SelfTerminal: Could not find a type for self")
 		}
 		ctx.RequireType(st.cachedType, scope)
 	}
 }
 
 type BooleanTerminal struct {
+	Located
+
 	Value bool
 }
 
@@ -109,6 +116,8 @@ func (bt *BooleanTerminal) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type CharacterTerminal struct {
+	Located
+
 	CodePoint int64
 }
 
@@ -130,6 +139,8 @@ func (bt *CharacterTerminal) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type StringTerminal struct {
+	Located
+
 	// The raw value of the terminal
 	Value string
 
@@ -158,6 +169,8 @@ func (st *StringTerminal) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type GpuBuiltinTerminal struct {
+	Located
+
 	Name string
 }
 var _ Expression = &GpuBuiltinTerminal{}
@@ -191,16 +204,21 @@ func (gt GpuBuiltinTerminal) Type() Type {
 func (gt GpuBuiltinTerminal) Check(ctx *CheckContext, scope *Scope) {
 	_, fnd := gt.calculateType()
 	if !fnd {
-		ctx.Errors.Errorf("No such gpu builtin %v", gt.Name)
+		ctx.ErrorfAt(gt, "No such gpu builtin %v", gt.Name)
 	}
 	ctx.NoteGpuRequired("gpu builtin")
 }
 
 // A literal identifier
 type IdentifierTerminal struct {
+	Located
+
 	// The symbol of this identifier
 	Name string
 
+	// The import this was qualified with, e.g. geo in geo::double, which is empty for local names
+	Qualifier string
+
 	// Set true when it shouldn't be namespaced on output
 	DontNamespace bool
 
@@ -229,9 +247,10 @@ func (it *IdentifierTerminal) Check(ctx *CheckContext, scope *Scope) {
 		var ok bool
 		it.CachedType, _, ok = scope.LookupVariableType(it.Name)
 		if !ok {
-			ctx.Errors.Errorf("Failed to find variable type %v", it.Name)
+			ctx.ErrorfUnlessBroken(it, scope, it.Name, "Failed to find variable type %v", it.Name)
 		}
 		ctx.RequireType(it.CachedType, scope)
+		ctx.noteUse(scope, it.Name)
 
 	case KPassMutate:
 		if it.Type().Selector == KTypeFunction {
@@ -241,7 +260,12 @@ func (it *IdentifierTerminal) Check(ctx *CheckContext, scope *Scope) {
 				// we can't do this in pass 1 in case the fn is below us
 				def, ok := ctx.CurrentModule().LookupFunction(it.Name)
 				if !ok {
-					ctx.Errors.Errorf("Failed to find function %v in current module", it.Name)
+					fid, imported := scope.LookupImportedFunction(it.Name)
+					if !imported {
+						ctx.ErrorfAt(it, "Failed to find function %v in current module", it.Name)
+						return
+					}
+					it.Fid = &fid
 					return
 				}
 				fid := def.Id
@@ -257,8 +281,13 @@ type StructLiteralPair struct {
 }
 
 type StructLiteralExpression struct {
+	Located
+
 	Id    StructId
 	Pairs []StructLiteralPair
+
+	// The import the struct name was qualified with, as in IdentifierTerminal
+	Qualifier string
 }
 
 var _ Expression = &StructLiteralExpression{}
@@ -300,7 +329,7 @@ func (sle *StructLiteralExpression) Check(ctx *CheckContext, scope *Scope) {
 				if _, ok := existingKeys[field.Name]; !ok {
 					dv, ok := field.Type.DefaultValueExpression(scope)
 					if !ok {
-						ctx.Errors.Errorf("No default value for '%v' on type %v", field.Name, sle.Type())
+						ctx.ErrorfAt(sle, "No default value for '%v' on type %v", field.Name, sle.Type())
 						return
 					}
 
@@ -314,10 +343,19 @@ func (sle *StructLiteralExpression) Check(ctx *CheckContext, scope *Scope) {
 
 	case KPassSetTypes:
 		ctx.RequireType(sle.Type(), scope)
+
+		sd, fnd := scope.LookupStructDefinition(sle.Id)
+		if fnd && sle.Id.Module.Key() != ctx.CurrentModule().Id.Key() {
+			if field, private := sd.privateField(); private {
+				ctx.ErrorfAt(sle, "Cannot create struct %v outside module %v, as its field %v is not pub", sle.Id.Name, sle.Id.Module.DisplayName(), field.Name)
+			}
+		}
 	}
 }
 
 type TupleExpression struct {
+	Located
+
 	Expressions []Expression
 }
 
@@ -365,6 +403,8 @@ func (te *TupleExpression) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type AccessExpression struct {
+	Located
+
 	Accessed   Expression
 	Identifier string
 
@@ -394,14 +434,14 @@ func (ae *AccessExpression) Check(ctx *CheckContext, scope *Scope) {
 	switch ctx.CurrentPass() {
 	case KPassSetTypes:
 		logNotFound := func() {
-			ctx.Errors.Errorf("Do not recognise field '%v' on type %v", ae.Identifier, ae.Accessed.Type())
+			ctx.ErrorfAt(ae, "Do not recognise field '%v' on type %v", ae.Identifier, ae.Accessed.Type())
 		}
 
 		switch ty.Selector {
 		case KTypeStruct:
 			sd, fnd := scope.LookupStructDefinition(ty.StructId)
 			if !fnd {
-				ctx.Errors.Errorf("Could not find struct named %v", ty.StructId)
+				ctx.ErrorfUnlessStructBroken(ae, ty.StructId, "Could not find struct named %v", ty.StructId)
 				return
 			}
 
@@ -410,6 +450,9 @@ func (ae *AccessExpression) Check(ctx *CheckContext, scope *Scope) {
 				logNotFound()
 				return
 			}
+			if !checkFieldVisible(ctx, ae, ty.StructId, field) {
+				return
+			}
 
 			ae.cachedType = field.Type
 			ctx.RequireType(ae.cachedType, scope)
@@ -441,7 +484,7 @@ func (ae *AccessExpression) Check(ctx *CheckContext, scope *Scope) {
 			}
 
 		default:
-			ctx.Errors.Errorf("Tried to take a field value of a non-struct type in access expression: " + ty.String())
+			ctx.ErrorfAt(ae, "Tried to take a field value of a non-struct type in access expression: %v", ty.String())
 			return
 		}
 
@@ -471,7 +514,41 @@ const (
 	KOperatorMod
 )
 
+func (be BinaryOperator) String() string {
+	switch be {
+	case KOperatorAdd:
+		return "add"
+	case KOperatorSubtract:
+		return "subtract"
+	case KOperatorMultiply:
+		return "multiply"
+	case KOperatorDivide:
+		return "divide"
+	case KOperatorEquality:
+		return "equality"
+	case KOperatorInequality:
+		return "inequality"
+	case KOperatorLT:
+		return "lt"
+	case KOperatorLTE:
+		return "lte"
+	case KOperatorGT:
+		return "gt"
+	case KOperatorGTE:
+		return "gte"
+	case KOperatorAnd:
+		return "and"
+	case KOperatorOr:
+		return "or"
+	case KOperatorMod:
+		return "mod"
+	}
+	return fmt.Sprintf("BinaryOperator(%d)", int(be))
+}
+
 type BinaryExpression struct {
+	Located
+
 	Operator   BinaryOperator
 	Lhs, Rhs   Expression
 	cachedType Type
@@ -511,23 +588,23 @@ func (be *BinaryExpression) Check(ctx *CheckContext, scope *Scope) {
 		lt := be.Lhs.Type()
 		rt := be.Rhs.Type()
 
-		emsg := fmt.Sprintf("Mismatched types in binary operator '%v' vs '%v'", lt, rt)
+		emsg := "Mismatched types in binary operator '%v' vs '%v'"
 
 		switch be.Operator {
 		case KOperatorAdd, KOperatorSubtract, KOperatorMultiply, KOperatorDivide:
 			if !lt.NumericallyCompatible(rt) {
-				ctx.Errors.Errorf(emsg)
+				ctx.ErrorfAt(be, emsg, lt, rt)
 				return
 			}
 			be.cachedType = arithmeticTypeCombine(lt, rt)
 
 		case KOperatorMod:
 			if lt.Selector != KTypeInteger {
-				ctx.Errors.Errorf("Left hand side of '%%' must be integer")
+				ctx.ErrorfAt(be.Lhs, "Left hand side of '%%' must be integer")
 				return
 			}
 			if rt.Selector != KTypeInteger {
-				ctx.Errors.Errorf("Right hand side of '%%' must be integer")
+				ctx.ErrorfAt(be.Rhs, "Right hand side of '%%' must be integer")
 				return
 			}
 			be.cachedType = lt
@@ -538,14 +615,14 @@ func (be *BinaryExpression) Check(ctx *CheckContext, scope *Scope) {
 			} else if lt.Selector == KTypeNull && rt.Selector == KTypePointer {
 				// always ok to compare pointer and null
 			} else if !lt.Equal(rt) {
-				ctx.Errors.Errorf(emsg)
+				ctx.ErrorfAt(be, emsg, lt, rt)
 				return
 			}
 			be.cachedType = Type{Selector: KTypeBoolean}
 
 		case KOperatorLT, KOperatorLTE, KOperatorGT, KOperatorGTE, KOperatorAnd, KOperatorOr:
 			if !lt.Equal(rt) {
-				ctx.Errors.Errorf(emsg)
+				ctx.ErrorfAt(be, emsg, lt, rt)
 				return
 			}
 			be.cachedType = Type{Selector: KTypeBoolean}
@@ -565,7 +642,21 @@ const (
 	KOperatorNegate
 )
 
+func (uo UnaryOperator) String() string {
+	switch uo {
+	case KOperatorNot:
+		return "not"
+	case KOperatorAddressOf:
+		return "address-of"
+	case KOperatorNegate:
+		return "negate"
+	}
+	return fmt.Sprintf("UnaryOperator(%d)", int(uo))
+}
+
 type UnaryExpression struct {
+	Located
+
 	Operator   UnaryOperator
 	Rhs        Expression
 	cachedType Type
@@ -591,7 +682,7 @@ func (ue *UnaryExpression) Check(ctx *CheckContext, scope *Scope) {
 		switch ue.Operator {
 		case KOperatorNot:
 			if ty.Selector != KTypeBoolean {
-				ctx.Errors.Errorf("Not operator cannot be applied to non-boolean type")
+				ctx.ErrorfAt(ue.Rhs, "Not operator cannot be applied to non-boolean type")
 				return
 			}
 			ue.cachedType = Type{Selector: KTypeBoolean}
@@ -601,7 +692,7 @@ func (ue *UnaryExpression) Check(ctx *CheckContext, scope *Scope) {
 
 		case KOperatorNegate:
 			if !ty.IsNumeric() {
-				ctx.Errors.Errorf("Negation operator cannot be applied to non-numeric type")
+				ctx.ErrorfAt(ue.Rhs, "Negation operator cannot be applied to non-numeric type")
 				return
 			}
 			ue.cachedType = ty
@@ -616,6 +707,8 @@ func (ue *UnaryExpression) Check(ctx *CheckContext, scope *Scope) {
 
 // access a vector
 type IndexExpression struct {
+	Located
+
 	Indexed      Expression
 	Index        Expression
 	cachedType   Type
@@ -655,13 +748,13 @@ func (ae *IndexExpression) Check(ctx *CheckContext, scope *Scope) {
 			ae.AccessedType = KTypeString
 
 		default:
-			ctx.Errors.Errorf("Attempting to access a non-vector type %v", at)
+			ctx.ErrorfAt(ae, "Attempting to access a non-vector type %v", at)
 			return
 		}
 
 		it := ae.Index.Type()
 		if it.Selector != KTypeInteger {
-			ctx.Errors.Errorf("Attempting to access a vector using non-integer %v", it)
+			ctx.ErrorfAt(ae.Index, "Attempting to access a vector using non-integer %v", it)
 			return
 		}
 
@@ -670,6 +763,8 @@ func (ae *IndexExpression) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type IntegerTerminal struct {
+	Located
+
 	Value int64
 }
 
@@ -690,6 +785,8 @@ func (it *IntegerTerminal) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type FloatTerminal struct {
+	Located
+
 	LValue, Zeros, RValue int64
 	Width                 int
 }
@@ -712,6 +809,8 @@ func (ft *FloatTerminal) String() string {
 }
 
 type CallExpression struct {
+	Located
+
 	// The expression being called
 	CalledExpression Expression
 
@@ -859,11 +958,11 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 						CachedType:    voidFunction(),
 					}
 					if len(ce.Arguments) != 1 {
-						ctx.Errors.Errorf("Currently string resize only supports a single argument")
+						ctx.ErrorfAt(ce, "Currently string resize only supports a single argument")
 						return
 					}
 					if ce.Arguments[0].Type().Selector != KTypeInteger {
-						ctx.Errors.Errorf("String.resize() takes a single integer argument")
+						ctx.ErrorfAt(ce, "String.resize() takes a single integer argument")
 						return
 					}
 					ce.Arguments = append([]Expression{ae.Accessed, ce.Arguments[0]})
@@ -879,11 +978,11 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 						CachedType:    voidFunction(),
 					}
 					if len(ce.Arguments) < 1 || len(ce.Arguments) > 2 {
-						ctx.Errors.Errorf("Vector erase takes one or two arguments")
+						ctx.ErrorfAt(ce, "Vector erase takes one or two arguments")
 						return
 					}
 					if ce.Arguments[0].Type().Selector != KTypeInteger {
-						ctx.Errors.Errorf("Vector.erase's first argument should be an integer")
+						ctx.ErrorfAt(ce, "Vector.erase's first argument should be an integer")
 						return
 					}
 
@@ -897,7 +996,7 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 
 					if len(ce.Arguments) > 1 {
 						if ce.Arguments[1].Type().Selector != KTypeInteger {
-							ctx.Errors.Errorf("Vector.erase's second argument should be an integer")
+							ctx.ErrorfAt(ce, "Vector.erase's second argument should be an integer")
 							return
 						}
 
@@ -950,11 +1049,11 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 						CachedType:    voidFunction(),
 					}
 					if len(ce.Arguments) != 1 {
-						ctx.Errors.Errorf("Currently vector append only supports a single argument")
+						ctx.ErrorfAt(ce, "Currently vector append only supports a single argument")
 						return
 					}
 					if ce.Arguments[0].Type().Selector != KTypeInteger {
-						ctx.Errors.Errorf("Vector.resize() takes a single integer argument")
+						ctx.ErrorfAt(ce, "Vector.resize() takes a single integer argument")
 						return
 					}
 
@@ -1035,7 +1134,7 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 					name = "ey_print_character"
 
 				default:
-					ctx.Errors.Errorf("print_ln can't handle type '%v' (yet)", ty)
+					ctx.ErrorfAt(ce, "print_ln can't handle type '%v' (yet)", ty)
 				}
 
 				called := &CallExpression{
@@ -1162,12 +1261,12 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 		if !ce.IgnoreTypeChecks {
 			ty := ce.CalledExpression.Type()
 			if !ty.IsCallable() {
-				ctx.Errors.Errorf("Expression of type '%v' not callable", ty)
+				ctx.ErrorfAt(ce.CalledExpression, "Expression of type '%v' not callable", ty)
 				return
 			}
 
 			if len(ty.Types) != len(ce.Arguments) {
-				ctx.Errors.Errorf("Wrong number of arguments in call expression, have %v, expecting %v", len(ce.Arguments), len(ty.Types))
+				ctx.ErrorfAt(ce, "Wrong number of arguments in call expression, have %v, expecting %v", len(ce.Arguments), len(ty.Types))
 				return
 			}
 
@@ -1175,7 +1274,7 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 				rhsTy := ce.Arguments[i].Type()
 
 				if !lhsTy.CanAssignTo(rhsTy) {
-					ctx.Errors.Errorf("Wrong argument type in call expression, have %v, expecting %v", rhsTy, lhsTy)
+					ctx.ErrorfAt(ce.Arguments[i], "Wrong argument type in call expression, have %v, expecting %v", rhsTy, lhsTy)
 				}
 			}
 		}
@@ -1197,7 +1296,7 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 		ty := ce.CalledExpression.Type()
 		// this is duped above
 		if !ty.IsCallable() {
-			ctx.Errors.Errorf("Expression not callable: is of type '%v'", ty)
+			ctx.ErrorfAt(ce, "Expression not callable: is of type '%v'", ty)
 			return
 		}
 
@@ -1209,6 +1308,8 @@ func (ce *CallExpression) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type NewExpression struct {
+	Located
+
 	// The initialiser, set during the original creation of this
 	Initialiser Expression
 
@@ -1266,7 +1367,7 @@ func (be *NewExpression) Check(ctx *CheckContext, scope *Scope) {
 			Rhs: &CallExpression{
 				IgnoreTypeChecks: true,
 				CalledExpression: &IdentifierTerminal{
-					Name:          "ey_runtime_gc_alloc",
+					Name:          "ey_runtime_gc_alloc_tagged",
 					DontNamespace: true,
 				},
 				SkipExecutionContext: true,
@@ -1281,6 +1382,8 @@ func (be *NewExpression) Check(ctx *CheckContext, scope *Scope) {
 					},
 					&SizeofExpression{SizedType: ty},
 					&IntegerTerminal{Value: 0},
+					// groups the allocation in heap dumps
+					&CStringTerminal{Value: ty.String()},
 				},
 				cachedType: ct,
 			},
@@ -1317,6 +1420,8 @@ func (be *NewExpression) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type SizeofExpression struct {
+	Located
+
 	SizedType Type
 }
 
@@ -1333,7 +1438,30 @@ func (se *SizeofExpression) Check(ctx *CheckContext, scope *Scope) {
 
 }
 
+// A C string literal, only created by the compiler to pass labels to the runtime
+type CStringTerminal struct {
+	Located
+
+	Value string
+}
+
+var _ Expression = &CStringTerminal{}
+
+func (ct CStringTerminal) Type() Type {
+	// this never reaches the type checker, so it doesn't matter what this is
+	return Type{Selector: KTypeVoid}
+}
+func (ct CStringTerminal) String() string {
+	return fmt.Sprintf("CStringTerminal(%v)", ct.Value)
+}
+
+func (ct *CStringTerminal) Check(ctx *CheckContext, scope *Scope) {
+
+}
+
 type DereferenceExpression struct {
+	Located
+
 	Pointer Expression
 }
 
@@ -1355,12 +1483,14 @@ func (de *DereferenceExpression) Check(ctx *CheckContext, scope *Scope) {
 	switch ctx.CurrentPass() {
 	case KPassCheckTypes:
 		if de.Pointer.Type().Selector != KTypePointer {
-			ctx.Errors.Errorf("Attempting to deference %v, only pointers can be deferenced", de.Pointer.Type())
+			ctx.ErrorfAt(de, "Attempting to deference %v, only pointers can be deferenced", de.Pointer.Type())
 		}
 	}
 }
 
 type VectorLiteralExpression struct {
+	Located
+
 	ElementType  Type
 	Initialisers []Expression
 
@@ -1401,7 +1531,7 @@ func (vl *VectorLiteralExpression) Check(ctx *CheckContext, scope *Scope) {
 		// ideally for the check types phase, but maybe better to do pre mutation
 		for _, e := range vl.Initialisers {
 			if !e.Type().CanAssignTo(vl.ElementType) {
-				ctx.Errors.Errorf("Bad type in vector literal. Have %v, expecting %v", e.Type(), vl.ElementType)
+				ctx.ErrorfAt(e, "Bad type in vector literal. Have %v, expecting %v", e.Type(), vl.ElementType)
 				return
 			}
 		}
@@ -1482,6 +1612,8 @@ func (vl *VectorLiteralExpression) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type RangeExpression struct {
+	Located
+
 	Count, Start, Step Expression
 }
 
@@ -65,6 +65,18 @@ const (
 	KLocationGpu
 )
 
+func (fl FunctionLocation) String() string {
+	switch fl {
+	case KLocationCpu:
+		return "cpu"
+	case KLocationAnywhere:
+		return "anywhere"
+	case KLocationGpu:
+		return "gpu"
+	}
+	return fmt.Sprintf("FunctionLocation(%d)", int(fl))
+}
+
 func FunctionLocations() []FunctionLocation {
 	return []FunctionLocation {
 		KLocationCpu,
@@ -183,6 +195,9 @@ type FunctionEntry struct {
 
 	// Where this function entry is located
 	Location FunctionLocation
+
+	// The signature of the set the function is in
+	Signature FunctionSignature
 }
 
 func (fg *FunctionGroup) String() string {
@@ -197,6 +212,11 @@ func (fg *FunctionGroup) String() string {
 	return buf.String()
 }
 
+/*
+Every function in the group, in the same order from one build to the next
+
+This is by signature, then location, then function id, so the C and the OpenCL C agree on the ids
+*/
 func (fg *FunctionGroup) FunctionEntries() []FunctionEntry {
 	tlKeys := []string { }
 	for k, _ := range fg.Functions {
@@ -211,12 +231,16 @@ func (fg *FunctionGroup) FunctionEntries() []FunctionEntry {
 		fs := fg.Functions[k]
 
 		for _, loc := range FunctionLocations() {
-			ids := fs.AllIds[loc]
+			ids := append([]FunctionId {}, fs.AllIds[loc]...)
+			sort.Slice(ids, func(i, j int) bool {
+				return ids[i].String() < ids[j].String()
+			})
 			for _, fid := range ids {
 				fes = append(fes, FunctionEntry {
 					Id: runningId,
 					Location: loc,
 					Fid: fid,
+					Signature: fs.Signature,
 				})
 				runningId += 1
 			}
@@ -275,11 +299,11 @@ func (fg *FunctionGroup) FindSignature(id FunctionId) (FunctionSignature, bool)
 }
 
 // Add a single function to the function group
-// If a string is returned, it is an error
-func (fg *FunctionGroup) Add(id FunctionId, fs FunctionSignature, loc FunctionLocation) string {
+// If false is returned, the function is already in the group with the other signature that is returned
+func (fg *FunctionGroup) Add(id FunctionId, fs FunctionSignature, loc FunctionLocation) (FunctionSignature, bool) {
 	sig, fnd := fg.FindSignature(id)
 	if fnd && !sig.IsEqual(fs) {
-		return fmt.Sprintf("Inconsistent signature for %v. Got %v, expected %v", id, fs, sig)
+		return sig, false
 	}
 
 	key := fs.MapKey()
@@ -304,15 +328,43 @@ func (fg *FunctionGroup) Add(id FunctionId, fs FunctionSignature, loc FunctionLo
 	}
 
 	fg.Functions[key] = fsv
-	return ""
+	return fs, true
 }
 
 type FunctionParameter struct {
+	// the span of the name
+	Located
+
 	Name string
 	Type Type
 }
 
+/*
+A bench block, which becomes a function for each destination written after its name
+
+Each function is parsed from the same source, with its worker expressions sent to its destination
+*/
+type Bench struct {
+	Name string
+
+	// the destinations written after for, or empty
+	Destinations []PipeDestination
+
+	// which of Destinations this function runs its workers on
+	Variant int
+}
+
+// The destination this function runs its workers on, when the bench has destinations
+func (b *Bench) Destination() (PipeDestination, bool) {
+	if len(b.Destinations) == 0 {
+		return KDestinationCpu, false
+	}
+	return b.Destinations[b.Variant], true
+}
+
 type FunctionDefinition struct {
+	Located
+
 	Id              FunctionId
 	Return          Type
 	AvoidCheckPhase bool
@@ -321,6 +373,18 @@ type FunctionDefinition struct {
 	// is this exported from its module
 	Exported        bool
 
+	// for a method, can it be called outside the module defining its struct
+	Public bool
+
+	// the /// comment written above it
+	Doc string
+
+	// the name of the test block this was written as, these are only called by eyot test
+	Test string
+
+	// the bench block this was written as, these are only called by eyot bench
+	Bench *Bench
+
 	// scope including function parameters
 	Scope *Scope
 
@@ -391,9 +455,8 @@ func CheckStatementBlockEndsWithReturn(sb *StatementBlock) bool {
 }
 
 func (fd *FunctionDefinition) AddToContext(ctx *CheckContext) {
-	err := ctx.Functions.Add(fd.Id, fd.Signature(), fd.Location)
-	if err != "" {
-		ctx.Errors.Errorf(err)
+	if sig, ok := ctx.Functions.Add(fd.Id, fd.Signature(), fd.Location); !ok {
+		ctx.ErrorfAt(fd, "Inconsistent signature for %v. Got %v, expected %v", fd.Id, fd.Signature(), sig)
 	}
 }
 
@@ -416,7 +479,7 @@ func (fd *FunctionDefinition) Check(ctx *CheckContext, externalScope *Scope) {
 	case KPassSetTleTypes:
 		if fd.Return.Selector != KTypeVoid {
 			if !CheckStatementBlockEndsWithReturn(fd.Block) {
-				ctx.Errors.Errorf("A non-void function must end with a return")
+				ctx.ErrorfAt(fd, "A non-void function must end with a return")
 				return
 			}
 		}
@@ -441,6 +504,12 @@ func (fd *FunctionDefinition) Check(ctx *CheckContext, externalScope *Scope) {
 		if fd.AvoidCheckPhase {
 			return
 		}
+
+	case KPassAnalyse:
+		if fd.AvoidCheckPhase {
+			return
+		}
+		ctx.analyseFunction(fd, externalScope)
 	}
 
 	ctx.PushReturnType(fd.Return)
@@ -0,0 +1,49 @@
+package ast
+
+import (
+	"eyot/errors"
+	"reflect"
+)
+
+/*
+The span of source a node was parsed from, embedded in every node
+
+Nodes created by the compiler itself have no location
+*/
+type Located struct {
+	Span errors.SourceLocation
+
+	// True when the node was written in brackets, which only matters to the formatter
+	Bracketed bool
+}
+
+func (l *Located) SourceLocation() errors.SourceLocation {
+	return l.Span
+}
+
+func (l *Located) SetSourceLocation(sl errors.SourceLocation) {
+	l.Span = sl
+}
+
+func (l *Located) IsBracketed() bool {
+	return l.Bracketed
+}
+
+func (l *Located) SetBracketed() {
+	l.Bracketed = true
+}
+
+type Locatable interface {
+	SourceLocation() errors.SourceLocation
+	SetSourceLocation(errors.SourceLocation)
+	IsBracketed() bool
+	SetBracketed()
+}
+
+// The location of a node, which is empty if it has none
+func LocationOf(n interface{}) errors.SourceLocation {
+	if l, ok := n.(Locatable); ok && !reflect.ValueOf(l).IsNil() {
+		return l.SourceLocation()
+	}
+	return errors.SourceLocation{}
+}
@@ -24,6 +24,8 @@ type LValue interface {
 
 // Simple identifier on lhs
 type IdentifierLValue struct {
+	Located
+
 	Name       string
 	cachedType Type
 }
@@ -37,7 +39,7 @@ func (ilv *IdentifierLValue) String() string {
 func (ilv *IdentifierLValue) CheckAssignable(ctx *CheckContext, scope *Scope) bool {
 	ty, assignable, ok := scope.LookupVariableType(ilv.Name)
 	if !ok {
-		ctx.Errors.Errorf("IdentifierLValue.Type Could not find type for '%v': %v", ilv.Name, ilv)
+		ctx.ErrorfUnlessBroken(ilv, scope, ilv.Name, "IdentifierLValue.Type Could not find type for '%v': %v", ilv.Name, ilv)
 		return false
 	}
 	ilv.cachedType = ty
@@ -52,6 +54,8 @@ func (ilv *IdentifierLValue) Type() Type {
 LValue representing the object this is called inside
 */
 type SelfLValue struct {
+	Located
+
 	cachedType Type
 }
 
@@ -66,7 +70,7 @@ func (slv *SelfLValue) Type() Type {
 func (slv *SelfLValue) CheckAssignable(ctx *CheckContext, scope *Scope) bool {
 	ty, _, ok := scope.LookupVariableType("__self__")
 	if !ok {
-		ctx.Errors.Errorf("SelfLValue.Type Could not find type")
+		ctx.ErrorfAt(slv, "SelfLValue.Type Could not find type")
 		return false
 	}
 	slv.cachedType = ty
@@ -75,6 +79,8 @@ func (slv *SelfLValue) CheckAssignable(ctx *CheckContext, scope *Scope) bool {
 
 // dereference, ie *a
 type DerefLValue struct {
+	Located
+
 	// The pointer to dereference
 	Inner LValue
 }
@@ -86,7 +92,7 @@ func (dl *DerefLValue) CheckAssignable(ctx *CheckContext, scope *Scope) bool {
 
 	if dl.Inner.Type().Selector != KTypePointer {
 		// this is never user generated, so no need for an error
-		ctx.Errors.Errorf("DerefLValue is not dereferencing a pointer")
+		ctx.ErrorfAt(dl, "DerefLValue is not dereferencing a pointer")
 	}
 
 	return assignable
@@ -113,6 +119,8 @@ func (dl DerefLValue) UpdateScope(scope *Scope, ty Type, assignable bool) error
 
 // a.b
 type AccessorLValue struct {
+	Located
+
 	Inner      LValue
 	FieldName  string
 	cachedType Type
@@ -134,19 +142,22 @@ func (alv *AccessorLValue) CheckAssignable(ctx *CheckContext, scope *Scope) bool
 
 	if it.Selector != KTypeStruct {
 		// vectors have fields too, but they are not assignable
-		ctx.Errors.Errorf("Cannot assign to a field of a non-struct type: %v", it.String())
+		ctx.ErrorfAt(alv, "Cannot assign to a field of a non-struct type: %v", it.String())
 		return false
 	}
 
 	sdef, fnd := scope.LookupStructDefinition(it.StructId)
 	if !fnd {
-		ctx.Errors.Errorf("Could not find struct of type %v", it.StructId)
+		ctx.ErrorfUnlessStructBroken(alv, it.StructId, "Could not find struct of type %v", it.StructId)
 		return false
 	}
 
 	field, fnd := sdef.GetField(alv.FieldName)
 	if !fnd {
-		ctx.Errors.Errorf("Could not find field named %v", alv.FieldName)
+		ctx.ErrorfAt(alv, "Could not find field named %v", alv.FieldName)
+		return false
+	}
+	if !checkFieldVisible(ctx, alv, it.StructId, field) {
 		return false
 	}
 
@@ -166,6 +177,8 @@ func (al AccessorLValue) UpdateScope(scope *Scope, ty Type, assignable bool) err
 
 // a[b]
 type IndexLValue struct {
+	Located
+
 	Indexed    LValue
 	Index      Expression
 	cachedType Type
@@ -193,7 +206,7 @@ func (ilv *IndexLValue) CheckAssignable(ctx *CheckContext, scope *Scope) bool {
 		ilv.cachedType = Type{Selector: KTypeCharacter}
 
 	default:
-		ctx.Errors.Errorf("Can only index lvalue vectors (%v, %v)", ity, ilv.Indexed)
+		ctx.ErrorfAt(ilv, "Can only index lvalue vectors (%v, %v)", ity, ilv.Indexed)
 	}
 
 	return assignable
@@ -233,6 +246,8 @@ func (sl SelfLValue) UpdateScope(scope *Scope, ty Type, assignable bool) error {
 
 // a, b, c
 type MultipleLValue struct {
+	Located
+
 	LValues []LValue
 }
 
@@ -41,6 +41,39 @@ func (f *Module) LookupFunction(name string) (*FunctionDefinition, bool) {
 	return &def, fnd
 }
 
+// The test blocks in the module, in the order they were written, which is how eyot test numbers them
+func (f *Module) Tests() []*FunctionDefinition {
+	tests := []*FunctionDefinition{}
+	for _, tlec := range f.TopLevelElements {
+		if fdtle, ok := tlec.TopLevelElement.(*FunctionDefinitionTle); ok && fdtle.Definition.Test != "" {
+			tests = append(tests, fdtle.Definition)
+		}
+	}
+	return tests
+}
+
+// The functions made from bench blocks, in the order they were written, which is how eyot bench numbers them
+func (f *Module) Benches() []*FunctionDefinition {
+	benches := []*FunctionDefinition{}
+	for _, tlec := range f.TopLevelElements {
+		if fdtle, ok := tlec.TopLevelElement.(*FunctionDefinitionTle); ok && fdtle.Definition.Bench != nil {
+			benches = append(benches, fdtle.Definition)
+		}
+	}
+	return benches
+}
+
+// The exported functions that are not bound to a struct, in the order they were written
+func (f *Module) Exports() []*FunctionDefinition {
+	exports := []*FunctionDefinition{}
+	for _, tlec := range f.TopLevelElements {
+		if fdtle, ok := tlec.TopLevelElement.(*FunctionDefinitionTle); ok && fdtle.Definition.Exported {
+			exports = append(exports, fdtle.Definition)
+		}
+	}
+	return exports
+}
+
 func (f *Module) LookupStruct(name string) (*StructDefinitionStatement, bool) {
 	for _, tlec := range f.TopLevelElements {
 		sd, ok := tlec.TopLevelElement.(*StructDefinitionStatement)
@@ -56,12 +89,22 @@ func (f *Module) Check(ctx *CheckContext) {
 	newTlecs := []TopLevelElementContainer{}
 
 	for _, tlec := range f.TopLevelElements {
-		tlec.Check(ctx)
-		if !ctx.Errors.Clean() {
-			return
+		if tlec.Broken {
+			newTlecs = append(newTlecs, tlec)
+			continue
 		}
 
+		checkpoint := ctx.Errors.Checkpoint()
+		tlec.Check(ctx)
+		tlec.Broken = !ctx.Errors.Clean()
+		ctx.Errors.Restore(checkpoint)
+
 		els := ctx.GetElementsForInsert()
+		if tlec.Broken {
+			ctx.MarkTleBroken(tlec)
+			newTlecs = append(newTlecs, tlec)
+			continue
+		}
 
 		if els != nil {
 			for _, el := range els {
@@ -33,6 +33,9 @@ type Scope struct {
 
 	// a map from struct names to definitions
 	StructBindings map[string]StructDefinition
+
+	// the functions imported by name from other modules
+	ImportedFunctions map[string]FunctionId
 }
 
 func (s *Scope) log(level int) {
@@ -58,10 +61,11 @@ func (s *Scope) Log() {
 
 func NewScope(parent *Scope) *Scope {
 	return &Scope{
-		Parent:           parent,
-		VariableBindings: map[string]*VariableBinding{},
-		ModuleBindings:   map[string]*Module{},
-		StructBindings:   map[string]StructDefinition{},
+		Parent:            parent,
+		VariableBindings:  map[string]*VariableBinding{},
+		ModuleBindings:    map[string]*Module{},
+		StructBindings:    map[string]StructDefinition{},
+		ImportedFunctions: map[string]FunctionId{},
 	}
 }
 
@@ -148,6 +152,16 @@ func (s *Scope) LookupVariableType(ident string) (Type, bool, bool) {
 	return s.Parent.LookupVariableType(ident)
 }
 
+// The scope a variable is bound in, or nil if it is not bound
+func (s *Scope) declaringScope(ident string) *Scope {
+	for sc := s; sc != nil; sc = sc.Parent {
+		if _, fnd := sc.VariableBindings[ident]; fnd {
+			return sc
+		}
+	}
+	return nil
+}
+
 func (s *Scope) LookupStructDefinition(ident StructId) (StructDefinition, bool) {
 	sd, fnd := s.StructBindings[ident.Key()]
 	if fnd {
@@ -204,6 +218,24 @@ func (s *Scope) SetModule(ident string, mod *Module) {
 	s.ModuleBindings[ident] = mod
 }
 
+func (s *Scope) SetImportedFunction(ident string, fid FunctionId) {
+	s.ImportedFunctions[ident] = fid
+}
+
+// The function imported under this name, as in from geo import area
+func (s *Scope) LookupImportedFunction(ident string) (FunctionId, bool) {
+	fid, fnd := s.ImportedFunctions[ident]
+	if fnd {
+		return fid, true
+	}
+
+	if s.Parent == nil {
+		return FunctionId{}, false
+	}
+
+	return s.Parent.LookupImportedFunction(ident)
+}
+
 func (s *Scope) SetStruct(id StructId, sd StructDefinition) {
 	s.StructBindings[id.Key()] = sd
 }
@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"fmt"
+
 	"eyot/errors"
 )
 
@@ -18,6 +20,8 @@ func (sc *StatementContainer) Check(ctx *CheckContext) {
 }
 
 type StatementBlock struct {
+	Located
+
 	Statements []StatementContainer
 	Context    *Scope
 }
@@ -25,18 +29,36 @@ type StatementBlock struct {
 func (sb *StatementBlock) Check(ctx *CheckContext) {
 	i := 0
 	newStatements := []StatementContainer{}
+	flowEnded, warnedUnreachable := false, false
 
 	for i < len(sb.Statements) {
+		if ctx.CurrentPass() == KPassAnalyse {
+			// only the first unreachable statement is worth a warning
+			st := sb.Statements[i].Statement
+			if flowEnded && !warnedUnreachable && LocationOf(st).Line != 0 {
+				ctx.warnAt(st, KWarningUnreachableCode, "Unreachable code")
+				warnedUnreachable = true
+			}
+			flowEnded = flowEnded || endsFlow(st)
+		}
+
 		ctx.StartStatementCollectionBlock()
 
+		// a broken statement still lets the ones after it be checked
+		checkpoint := ctx.Errors.Checkpoint()
 		sc := sb.Statements[i]
 		sc.Check(ctx)
-
-		if !ctx.Errors.Clean() {
-			return
-		}
+		failed := !ctx.Errors.Clean()
+		ctx.Errors.Restore(checkpoint)
 
 		stmts := ctx.StopStatementCollectionBlock()
+		if failed {
+			ctx.ShouldRemoveStatement()
+			ctx.MarkVariablesBroken(sc.Context, declaredNames(sc.Statement))
+			newStatements = append(newStatements, sc)
+			i += 1
+			continue
+		}
 
 		if stmts != nil {
 			for _, st := range stmts {
@@ -57,6 +79,30 @@ func (sb *StatementBlock) Check(ctx *CheckContext) {
 	sb.Statements = newStatements
 }
 
+// The names of the variables a statement declares
+func declaredNames(s Statement) []string {
+	as, ok := s.(*AssignStatement)
+	if !ok || as.Type == KAssignNormal {
+		return nil
+	}
+
+	names := []string{}
+	var collect func(lv LValue)
+	collect = func(lv LValue) {
+		switch l := lv.(type) {
+		case *IdentifierLValue:
+			names = append(names, l.Name)
+		case *MultipleLValue:
+			for _, inner := range l.LValues {
+				collect(inner)
+			}
+		}
+	}
+	collect(as.Lhs)
+
+	return names
+}
+
 type AssignType int
 
 const (
@@ -70,7 +116,21 @@ const (
 	KAssignNormal
 )
 
+func (at AssignType) String() string {
+	switch at {
+	case KAssignLet:
+		return "let"
+	case KAssignConst:
+		return "const"
+	case KAssignNormal:
+		return "normal"
+	}
+	return fmt.Sprintf("AssignType(%d)", int(at))
+}
+
 type AssignStatement struct {
+	Located
+
 	// The identifier we are assigning
 	Lhs LValue
 
@@ -107,13 +167,17 @@ func (as *AssignStatement) Check(ctx *CheckContext, scope *Scope) {
 
 		switch as.Type {
 		case KAssignLet, KAssignConst:
+			ctx.noteShadows(scope, as.Lhs)
 			err := as.Lhs.UpdateScope(scope, as.NewType, as.Type == KAssignLet)
 			if err != nil {
-				ctx.Errors.Errorf("Unable to update scope: %v", err)
+				ctx.ErrorfAt(as, "Unable to update scope: %v", err)
 			}
 
 		case KAssignNormal:
-
+			// assigning to a variable is not a use, but assigning to part of one is
+			if _, whole := as.Lhs.(*IdentifierLValue); !whole {
+				ctx.noteLValueUse(scope, as.Lhs)
+			}
 		}
 
 		as.Lhs.CheckAssignable(ctx, scope)
@@ -131,7 +195,7 @@ func (as *AssignStatement) Check(ctx *CheckContext, scope *Scope) {
 
 			if ctx.CurrentPass() == KPassCheckTypes {
 				if !assignable {
-					ctx.Errors.Errorf("Unable to reassign %v", as.Lhs)
+					ctx.ErrorfAt(as, "Unable to reassign %v", as.Lhs)
 					return
 				}
 			}
@@ -141,7 +205,7 @@ func (as *AssignStatement) Check(ctx *CheckContext, scope *Scope) {
 		}
 
 		if as.NewType.Selector == KTypeVoid {
-			ctx.Errors.Errorf("Cannot assign to void")
+			ctx.ErrorfAt(as, "Cannot assign to void")
 			return
 		}
 
@@ -151,13 +215,20 @@ func (as *AssignStatement) Check(ctx *CheckContext, scope *Scope) {
 			lt := as.Lhs.Type()
 
 			if !lt.CanAssignTo(as.NewType) {
-				ctx.Errors.Errorf("Cannot assign a variable of type '%v' to type '%v'", lt, as.NewType)
+				ctx.ErrorfAt(as, "Cannot assign a variable of type '%v' to type '%v'", lt, as.NewType)
 			}
 		}
+
+	case KPassAnalyse:
+		if as.Type != KAssignNormal {
+			ctx.analyseDeclaration(as, scope)
+		}
 	}
 }
 
 type ExpressionStatement struct {
+	Located
+
 	Expression Expression
 }
 
@@ -168,9 +239,14 @@ func (es *ExpressionStatement) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type StructDefinitionStatement struct {
+	Located
+
 	Exported   bool
 	Id         StructId
 	Definition StructDefinition
+
+	// the /// comment written above it
+	Doc string
 }
 
 func (sds *StructDefinitionStatement) Check(ctx *CheckContext, scope *Scope) {
@@ -200,6 +276,8 @@ type IfStatementSegment struct {
 }
 
 type IfStatement struct {
+	Located
+
 	Segments []IfStatementSegment
 }
 
@@ -216,7 +294,7 @@ func (is *IfStatement) Check(ctx *CheckContext, scope *Scope) {
 			if ctx.CurrentPass() == KPassSetTypes {
 				ty := seg.Condition.Type()
 				if ty.Selector != KTypeBoolean {
-					ctx.Errors.Errorf("IfStatement condition not of boolean type")
+					ctx.ErrorfAt(seg.Condition, "IfStatement condition not of boolean type")
 					return
 				}
 			}
@@ -243,6 +321,7 @@ func (ds *DummyStatement) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type BreakStatement struct {
+	Located
 }
 
 var _ Statement = &BreakStatement{}
@@ -252,6 +331,8 @@ func (bs *BreakStatement) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type WhileStatement struct {
+	Located
+
 	Condition Expression
 	Block     *StatementBlock
 }
@@ -264,6 +345,8 @@ func (ws *WhileStatement) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type ReturnStatement struct {
+	Located
+
 	// If nil, this is a void return
 	ReturnedValue Expression
 }
@@ -277,12 +360,12 @@ func (rs *ReturnStatement) Check(ctx *CheckContext, scope *Scope) {
 
 	functionReturnType, inFunction := ctx.CurrentReturnType()
 	if !inFunction {
-		ctx.Errors.Errorf("Trying to return when not in a function")
+		ctx.ErrorfAt(rs, "Trying to return when not in a function")
 	}
 
 	if rs.ReturnedValue == nil {
 		if functionReturnType.Selector != KTypeVoid {
-			ctx.Errors.Errorf("Mismatched return types cannot return void in a function returning '%v'", functionReturnType.String())
+			ctx.ErrorfAt(rs, "Mismatched return types cannot return void in a function returning '%v'", functionReturnType.String())
 		}
 	} else {
 		returnedType := rs.ReturnedValue.Type()
@@ -290,7 +373,7 @@ func (rs *ReturnStatement) Check(ctx *CheckContext, scope *Scope) {
 		switch ctx.CurrentPass() {
 		case KPassSetTypes:
 			if !returnedType.CanAssignTo(functionReturnType) {
-				ctx.Errors.Errorf("Mismatched return types '%v' != '%v'", returnedType.String(), functionReturnType.String())
+				ctx.ErrorfAt(rs.ReturnedValue, "Mismatched return types '%v' != '%v'", returnedType.String(), functionReturnType.String())
 			}
 
 		case KPassMutate:
@@ -316,6 +399,8 @@ func (rs *ReturnStatement) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type SendPipeStatement struct {
+	Located
+
 	Pipe  Expression
 	Value Expression
 }
@@ -334,7 +419,7 @@ func (sps *SendPipeStatement) Check(ctx *CheckContext, scope *Scope) {
 	switch ctx.CurrentPass() {
 	case KPassCheckTypes:
 		if sps.Pipe.Type().Selector != KTypeWorker {
-			ctx.Errors.Errorf("Trying to send to non-worker type: %v", sps.Pipe.Type().String())
+			ctx.ErrorfAt(sps.Pipe, "Trying to send to non-worker type: %v", sps.Pipe.Type().String())
 			return
 		}
 
@@ -348,21 +433,50 @@ func (sps *SendPipeStatement) Check(ctx *CheckContext, scope *Scope) {
 			if sentValueType.Selector == KTypeVector {
 				sentValueType = sentValueType.Types[0]
 			} else {
-				ctx.Errors.Errorf("Sent value type %v was not a vector", sentValueType.String())
+				ctx.ErrorfAt(sps.Value, "Sent value type %v was not a vector", sentValueType.String())
 				return
 			}
 		} else {
-			ctx.Errors.Errorf("Sent value type %v was not a pointer", sentValueType.String())
+			ctx.ErrorfAt(sps.Value, "Sent value type %v was not a pointer", sentValueType.String())
 			return
 		}
 
 		if !sentValueType.CanAssignTo(pipeSendType) {
-			ctx.Errors.Errorf("Sent value type %v does not match the send type of the pipe: %v", sentValueType.String(), pipeSendType.String())
+			ctx.ErrorfAt(sps.Value, "Sent value type %v does not match the send type of the pipe: %v", sentValueType.String(), pipeSendType.String())
 			return
 		}
 	}
 }
 
+// assert(condition, message) stops the program with the message and where it is when the condition is false
+type AssertStatement struct {
+	Located
+
+	Condition Expression
+	Message   Expression
+}
+
+var _ Statement = &AssertStatement{}
+
+func (as *AssertStatement) Check(ctx *CheckContext, scope *Scope) {
+	ctx.NoteGpuForbidden("assert")
+
+	as.Condition.Check(ctx, scope)
+	as.Message.Check(ctx, scope)
+	if !ctx.Errors.Clean() {
+		return
+	}
+
+	if ctx.CurrentPass() == KPassCheckTypes {
+		if as.Condition.Type().Selector != KTypeBoolean {
+			ctx.ErrorfAt(as.Condition, "Assert condition of type %v, not bool", as.Condition.Type().String())
+		}
+		if as.Message.Type().Selector != KTypeString {
+			ctx.ErrorfAt(as.Message, "Assert message of type %v, not string", as.Message.Type().String())
+		}
+	}
+}
+
 type ForType int
 
 const (
@@ -373,7 +487,19 @@ const (
 	KForRange
 )
 
+func (ft ForType) String() string {
+	switch ft {
+	case KForEach:
+		return "each"
+	case KForRange:
+		return "range"
+	}
+	return fmt.Sprintf("ForType(%d)", int(ft))
+}
+
 type ForeachStatement struct {
+	Located
+
 	// The name of the temporary variable
 	TemporaryVariableName string
 
@@ -404,7 +530,7 @@ func (fs *ForeachStatement) Check(ctx *CheckContext, scope *Scope) {
 
 			fst := fs.Iterable.Type().Unwrapped()
 			if fst.Selector != KTypeVector {
-				ctx.Errors.Errorf("Attempting to iterate over something that is not a vector: %v", fst)
+				ctx.ErrorfAt(fs.Iterable, "Attempting to iterate over something that is not a vector: %v", fst)
 			}
 			fs.IteratedType = fst.Types[0]
 
@@ -468,7 +594,23 @@ const (
 	KModifyDivide
 )
 
+func (mo ModifyOperator) String() string {
+	switch mo {
+	case KModifyPlus:
+		return "plus"
+	case KModifyMinus:
+		return "minus"
+	case KModifyTimes:
+		return "times"
+	case KModifyDivide:
+		return "divide"
+	}
+	return fmt.Sprintf("ModifyOperator(%d)", int(mo))
+}
+
 type ModifyInPlaceStatement struct {
+	Located
+
 	Operator   ModifyOperator
 	Modified   LValue
 	Expression Expression
@@ -477,10 +619,14 @@ type ModifyInPlaceStatement struct {
 var _ Statement = &ModifyInPlaceStatement{}
 
 func (ms *ModifyInPlaceStatement) Check(ctx *CheckContext, scope *Scope) {
+	if ctx.CurrentPass() == KPassSetTypes {
+		ctx.noteLValueUse(scope, ms.Modified)
+	}
+
 	switch ctx.CurrentPass()  {
 	case KPassSetTypes, KPassMutate, KPassCheckTypes:
 		if !ms.Modified.CheckAssignable(ctx, scope) {
-			ctx.Errors.Errorf("Unable to reassign %v", ms.Modified)
+			ctx.ErrorfAt(ms, "Unable to reassign %v", ms.Modified)
 			if !ctx.Errors.Clean() {
 				return
 			}
@@ -491,6 +637,8 @@ func (ms *ModifyInPlaceStatement) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type ClosureArgDeclarationStatement struct {
+	Located
+
 	Name string
 
 	// the various args to be written in
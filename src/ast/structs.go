@@ -1,11 +1,22 @@
 package ast
 
 type StructField struct {
+	// the span of the name
+	Located
+
 	Name string
 	Type Type
+
+	// can it be used outside the module defining the struct, as when written pub
+	Public bool
+
+	// the /// comment written above it
+	Doc string
 }
 
 type StructDefinition struct {
+	Located
+
 	// Variables in the struct
 	Fields []StructField
 
@@ -36,7 +47,8 @@ func (sd *StructDefinition) GetField(name string) (StructField, bool) {
 			}
 
 			return StructField{
-				Name: name,
+				Name:   name,
+				Public: fn.Public,
 				Type: Type{
 					Selector:        KTypeFunction,
 					BoundStructName: name,
@@ -50,3 +62,27 @@ func (sd *StructDefinition) GetField(name string) (StructField, bool) {
 
 	return StructField{}, false
 }
+
+/*
+Can the field or method be used in the module being checked, reporting an error if not
+
+Only those written pub can be used outside the module defining the struct
+*/
+func checkFieldVisible(ctx *CheckContext, at interface{}, id StructId, field StructField) bool {
+	if field.Public || id.Module.Key() == ctx.CurrentModule().Id.Key() {
+		return true
+	}
+
+	ctx.ErrorfAt(at, "%v of struct %v in module %v is not pub", field.Name, id.Name, id.Module.DisplayName())
+	return false
+}
+
+// The first field that can only be used in the module defining the struct, if any
+func (sd *StructDefinition) privateField() (StructField, bool) {
+	for _, f := range sd.Fields {
+		if !f.Public {
+			return f, true
+		}
+	}
+	return StructField{}, false
+}
@@ -14,6 +14,9 @@ type TopLevelElement interface {
 type TopLevelElementContainer struct {
 	TopLevelElement TopLevelElement
 	Context         *Scope
+
+	// True once this has failed a check pass, later passes skip it rather than report knock on errors
+	Broken bool
 }
 
 func (tlec *TopLevelElementContainer) Check(ctx *CheckContext) {
@@ -21,6 +24,8 @@ func (tlec *TopLevelElementContainer) Check(ctx *CheckContext) {
 }
 
 type FunctionDefinitionTle struct {
+	Located
+
 	Definition *FunctionDefinition
 }
 
@@ -35,6 +40,8 @@ func (ftle *FunctionDefinitionTle) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type GpuKernelTle struct {
+	Located
+
 	// the name of the kernel itself
 	KernelId FunctionId
 
@@ -77,9 +84,20 @@ func (dt *DummyTle) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type ImportElement struct {
-	Names    []string
+	Located
+
+	Names []string
+
+	// The name the module is qualified with, which is empty when only some of its symbols are imported
 	ImportAs string
-	Mod      *Module
+
+	// The functions and structs imported by name, as in from geo::shapes import area, Square
+	Symbols []string
+
+	Mod *Module
+
+	// Set by the parser once something is looked up in the module
+	Used bool
 }
 
 var _ TopLevelElement = &ImportElement{}
@@ -105,12 +123,47 @@ func (ie *ImportElement) String() string {
 }
 
 func (ie *ImportElement) Check(ctx *CheckContext, scope *Scope) {
-	ident := ie.Names[len(ie.Names)-1]
-	scope.SetModule(ident, ie.Mod)
+	if ie.ImportAs != "" {
+		scope.SetModule(ie.ImportAs, ie.Mod)
+	}
+
+	switch ctx.CurrentPass() {
+	case KPassSetTleTypes:
+		for _, name := range ie.Symbols {
+			if _, fnd := ctx.CurrentModule().LookupFunction(name); fnd {
+				ctx.ErrorfAt(ie, "%v is imported from %v, but is also defined in this module", name, ie.ImportedId().DisplayName())
+				continue
+			}
+			if _, fnd := ctx.CurrentModule().LookupStruct(name); fnd {
+				ctx.ErrorfAt(ie, "%v is imported from %v, but is also defined in this module", name, ie.ImportedId().DisplayName())
+				continue
+			}
+
+			// structs are resolved by the parser, functions are bound like those defined here
+			if def, fnd := ie.Mod.LookupFunction(name); fnd {
+				scope.SetVariable(name, def.OurType(), false)
+				scope.SetImportedFunction(name, def.Id)
+			}
+		}
+
+	case KPassAnalyse:
+		if !ie.Used {
+			name := ie.ImportAs
+			if name == "" {
+				name = ie.ImportedId().DisplayName()
+			}
+			ctx.warnAt(ie, KWarningUnusedImport, "Module %v is imported but never used", name)
+		}
+	}
 }
 
 type ConstTle struct {
+	Located
+
 	Assign *AssignStatement
+
+	// the /// comment written above it
+	Doc string
 }
 
 var _ TopLevelElement = &ConstTle{}
@@ -120,6 +173,11 @@ func (ce *ConstTle) String() string {
 }
 
 func (ce *ConstTle) Check(ctx *CheckContext, scope *Scope) {
+	if ctx.CurrentPass() == KPassAnalyse {
+		// other modules can use it, so it is never unused
+		return
+	}
+
 	// seems the easiest way to do this for now
 	ce.Assign.Check(ctx, scope)
 }
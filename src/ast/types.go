@@ -38,6 +38,40 @@ const (
 	KTypeWorker
 )
 
+func (ts TypeSelector) String() string {
+	switch ts {
+	case KTypeInteger:
+		return "integer"
+	case KTypeString:
+		return "string"
+	case KTypeBoolean:
+		return "boolean"
+	case KTypeCharacter:
+		return "character"
+	case KTypeFloat:
+		return "float"
+	case KTypeVoid:
+		return "void"
+	case KTypeNull:
+		return "null"
+	case KTypeFunction:
+		return "function"
+	case KTypeClosure:
+		return "closure"
+	case KTypeTuple:
+		return "tuple"
+	case KTypeStruct:
+		return "struct"
+	case KTypePointer:
+		return "pointer"
+	case KTypeVector:
+		return "vector"
+	case KTypeWorker:
+		return "worker"
+	}
+	return fmt.Sprintf("TypeSelector(%d)", int(ts))
+}
+
 type Type struct {
 	// Base type
 	Selector TypeSelector
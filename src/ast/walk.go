@@ -0,0 +1,94 @@
+package ast
+
+import (
+	"reflect"
+	"sort"
+)
+
+var (
+	scopePointerType  = reflect.TypeOf(&Scope{})
+	modulePointerType = reflect.TypeOf(&Module{})
+	typeType          = reflect.TypeOf(Type{})
+	locatedType       = reflect.TypeOf(Located{})
+)
+
+/*
+Call visit on every node with a source location in root, parents before their children
+
+Scopes and imported modules are not followed, as they lead out of the tree, nor are types, which
+hold no nodes. Nodes the compiler generated have no location, but their children are still visited
+*/
+func Walk(root interface{}, visit func(Locatable)) {
+	seen := map[uintptr]bool{}
+
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+
+		case reflect.Ptr:
+			if v.IsNil() || v.Type() == scopePointerType || v.Type() == modulePointerType || seen[v.Pointer()] {
+				return
+			}
+			seen[v.Pointer()] = true
+			walk(v.Elem())
+
+		case reflect.Struct:
+			if v.Type() == typeType || v.Type() == locatedType {
+				return
+			}
+
+			// some nodes are held by value, so this checks the struct rather than the pointer to it
+			if v.CanAddr() {
+				if l, ok := v.Addr().Interface().(Locatable); ok && l.SourceLocation().Line > 0 {
+					visit(l)
+				}
+			}
+			for i := 0; i < v.NumField(); i += 1 {
+				if v.Type().Field(i).IsExported() {
+					walk(v.Field(i))
+				}
+			}
+
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i += 1 {
+				walk(v.Index(i))
+			}
+		}
+	}
+
+	if m, ok := root.(*Module); ok {
+		root = m.TopLevelElements
+	}
+	walk(reflect.ValueOf(root))
+}
+
+/*
+The nodes whose span holds the position, outermost first
+
+This is by span rather than the order of the tree, as the mutate pass moves some expressions into
+statements of their own
+*/
+func NodesAt(root interface{}, line, column int) []Locatable {
+	nodes := []Locatable{}
+	Walk(root, func(l Locatable) {
+		if sl := l.SourceLocation(); sl.Contains(line, column) {
+			nodes = append(nodes, l)
+		}
+	})
+
+	before := func(l1, c1, l2, c2 int) bool {
+		return l1 < l2 || (l1 == l2 && c1 < c2)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i].SourceLocation(), nodes[j].SourceLocation()
+		if a.Line != b.Line || a.Column != b.Column {
+			return before(a.Line, a.Column, b.Line, b.Column)
+		}
+		return before(b.EndLine, b.EndColumn, a.EndLine, a.EndColumn)
+	})
+	return nodes
+}
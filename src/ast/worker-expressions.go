@@ -11,14 +11,32 @@ const (
 	KDestinationGpu
 )
 
+func (pd PipeDestination) String() string {
+	switch pd {
+	case KDestinationCpu:
+		return "cpu"
+	case KDestinationGpu:
+		return "gpu"
+	}
+	return fmt.Sprintf("PipeDestination(%d)", int(pd))
+}
+
 type CreateWorkerExpression struct {
+	Located
+
 	Worker                Expression
 	SendType, ReceiveType Type
 	Destination           PipeDestination
 
+	// The device index for gpu workers, or nil for the default device
+	Device Expression
+
 	// In the case of creating a closure, the variable name, or blank
 	ClosureVariable string
 
+	// Written as `worker f` in a bench, taking its destination from the bench
+	FromBench bool
+
 	// name of the wrapper function (and kernel)
 	WrapperId, KernelId FunctionId
 }
@@ -49,14 +67,26 @@ func (cce *CreateWorkerExpression) Check(ctx *CheckContext, scope *Scope) {
 			ctx.SetGpuRequired()
 		}
 
+		if cce.Device != nil {
+			cce.Device.Check(ctx, scope)
+			if !ctx.Errors.Clean() {
+				return
+			}
+
+			if cce.Device.Type().Selector != KTypeInteger {
+				ctx.ErrorfAt(cce.Device, "The device of a gpu worker must be an integer, not '%v'", cce.Device.Type().String())
+				return
+			}
+		}
+
 		// need early checks
 		lty := cce.Worker.Type()
 		if !lty.IsCallable() {
-			ctx.Errors.Errorf("A create channel expression must be passed something callable")
+			ctx.ErrorfAt(cce, "A create channel expression must be passed something callable")
 			return
 		}
 		if len(lty.Types) != 1 {
-			ctx.Errors.Errorf("A create channel expression must be passed a function with a single parameter")
+			ctx.ErrorfAt(cce, "A create channel expression must be passed a function with a single parameter")
 			return
 		}
 		if lty.Selector == KTypeFunction {
@@ -70,6 +100,9 @@ func (cce *CreateWorkerExpression) Check(ctx *CheckContext, scope *Scope) {
 
 	case KPassMutate:
 		cce.Worker.Check(ctx, scope)
+		if cce.Device != nil {
+			cce.Device.Check(ctx, scope)
+		}
 		cce.WrapperId = FunctionId{
 			Module: ctx.CurrentModule().Id,
 			Struct: BlankStructId(),
@@ -81,9 +114,9 @@ func (cce *CreateWorkerExpression) Check(ctx *CheckContext, scope *Scope) {
 			for _, ty := range cce.Worker.Type().Types {
 				if ok, problemType := scope.CanPassToGpu(ty); !ok {
 					if ty.Equal(problemType) {
-						ctx.Errors.Errorf("Worker creation uses type that cannot be passed to GPU '" + ty.String() + "'")
+						ctx.ErrorfAt(cce, "Worker creation uses type that cannot be passed to GPU '%v'", ty.String())
 					} else {
-						ctx.Errors.Errorf("Worker creation uses type that cannot be passed to GPU '" + problemType.String() + "' embedded in '" + ty.String() + "'")
+						ctx.ErrorfAt(cce, "Worker creation uses type that cannot be passed to GPU '%v' embedded in '%v'", problemType.String(), ty.String())
 					}
 				}
 			}
@@ -145,7 +178,7 @@ func (cce *CreateWorkerExpression) Check(ctx *CheckContext, scope *Scope) {
 				} else {
 					// Eventually it would be nice if this were more flexible
 					// However we need to understand this at C generation time
-					ctx.Errors.Errorf("A create worker expression must be passed a function name (for now)")
+					ctx.ErrorfAt(cce, "A create worker expression must be passed a function name (for now)")
 					return
 				}
 			}
@@ -329,6 +362,8 @@ func (cce *CreateWorkerExpression) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type ReceiveWorkerExpression struct {
+	Located
+
 	// The worker in question
 	Worker Expression
 
@@ -375,7 +410,7 @@ func (rpe *ReceiveWorkerExpression) Check(ctx *CheckContext, scope *Scope) {
 	case KPassSetTypes:
 		pty := rpe.Worker.Type()
 		if pty.Selector != KTypeWorker {
-			ctx.Errors.Errorf("Expected a pipe after 'receive'")
+			ctx.ErrorfAt(rpe, "Expected a pipe after 'receive'")
 		}
 
 	case KPassMutate:
@@ -444,6 +479,8 @@ func (rpe *ReceiveWorkerExpression) Check(ctx *CheckContext, scope *Scope) {
 }
 
 type CreatePipelineExpression struct {
+	Located
+
 	// the input and output types of this as a whole
 	SendType, ReceiveType Type
 
@@ -480,13 +517,13 @@ func (cpe *CreatePipelineExpression) Check(ctx *CheckContext, scope *Scope) {
 	case KPassSetTypes:
 		lty := cpe.LhsWorker.Type()
 		if lty.Selector != KTypeWorker {
-			ctx.Errors.Errorf("First argument to pipeline keyword must be a worker expression")
+			ctx.ErrorfAt(cpe, "First argument to pipeline keyword must be a worker expression")
 			return
 		}
 
 		rty := cpe.RhsWorker.Type()
 		if rty.Selector != KTypeWorker {
-			ctx.Errors.Errorf("Second argument to pipeline keyword must be a worker expression")
+			ctx.ErrorfAt(cpe, "Second argument to pipeline keyword must be a worker expression")
 			return
 		}
 
@@ -495,7 +532,7 @@ func (cpe *CreatePipelineExpression) Check(ctx *CheckContext, scope *Scope) {
 		cpe.ReceiveType = rty.Types[1]
 
 		if !rty.Types[0].Equal(cpe.IntermediateType) {
-			ctx.Errors.Errorf("Output from first argument to pipeline must be the same as the input to the second")
+			ctx.ErrorfAt(cpe, "Output from first argument to pipeline must be the same as the input to the second")
 			return
 		}
 
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"eyot/ast"
+	"eyot/errors"
+)
+
+// What one bench function measured, as printed by ey_runtime_bench
+type benchResult struct {
+	iterations, ns, allocations, bytes, elements int64
+
+	// set when the bench was not run, as when there is no gpu
+	skipped string
+}
+
+/*
+Build and run the benches in a file, or in each file below a folder
+
+Each bench is run in a process of its own, for -benchtime each (a second by default). A bench with
+destinations is run once for each, and these are printed together and compared
+*/
+func runBenches(target string, flags map[string]bool, options map[string]string, rep *reporter, es *errors.Errors) *errors.Errors {
+	filter, ok := runFilter(options, es)
+	if !ok {
+		return es
+	}
+
+	bo, ok := buildOptions(flags, options, es)
+	if !ok {
+		return es
+	}
+
+	benchTime := time.Second
+	if bt, fnd := options["benchtime"]; fnd {
+		var err error
+		if benchTime, err = time.ParseDuration(bt); err != nil || benchTime <= 0 {
+			es.LogInternalError(fmt.Errorf("Bad -benchtime value: %v", bt))
+			return es
+		}
+	}
+	benchTimeEnv := fmt.Sprintf("EyotBenchTime=%v", benchTime.Nanoseconds())
+
+	files, ok := blockFiles(target, "bench", es)
+	if !ok {
+		return es
+	}
+
+	for _, file := range files {
+		fnd := withBlocks(file, true, flags["showlog"], bo, es, func(m *ast.Module, binary string) {
+			fmt.Printf("=== %v\n", file)
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "BENCH\tON\tITERATIONS\tNS/OP\tALLOCS/OP\tB/OP\tNS/ELEMENT\n")
+
+			comparisons := []string{}
+			results := map[ast.PipeDestination]benchResult{}
+			for i, fd := range m.Benches() {
+				if !filter.MatchString(fd.Bench.Name) {
+					continue
+				}
+
+				on := "-"
+				dest, hasDest := fd.Bench.Destination()
+				if hasDest {
+					on = destinationName(dest)
+				}
+
+				output, err := runBlock(binary, i, benchTimeEnv)
+				result, fnd := parseBenchResult(output)
+				if err != nil || !fnd {
+					tw.Flush()
+					fmt.Printf("--- FAIL: %v\n", fd.Bench.Name)
+					for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+						fmt.Printf("    %v\n", line)
+					}
+
+					if err == nil {
+						err = fmt.Errorf("no result")
+					}
+					es.ErrorfAt(fd.Span, "Bench \"%v\" failed: %v", fd.Bench.Name, err)
+					continue
+				}
+
+				if result.skipped != "" {
+					fmt.Fprintf(tw, "%v\t%v\tskipped, %v\t\t\t\t\n", fd.Bench.Name, on, result.skipped)
+				} else {
+					fmt.Fprintf(tw, "%v\t%v\t%v\t%.1f\t%v\t%v\t%v\n",
+						fd.Bench.Name, on, result.iterations,
+						float64(result.ns)/float64(result.iterations),
+						result.allocations/result.iterations,
+						result.bytes/result.iterations,
+						perElement(result))
+					results[dest] = result
+				}
+
+				if hasDest && fd.Bench.Variant == len(fd.Bench.Destinations)-1 {
+					if comparison, fnd := compareDestinations(fd.Bench.Name, results); fnd {
+						comparisons = append(comparisons, comparison)
+					}
+					results = map[ast.PipeDestination]benchResult{}
+				}
+			}
+
+			tw.Flush()
+			for _, comparison := range comparisons {
+				fmt.Println(comparison)
+			}
+		})
+		rep.warn(es)
+		if !fnd {
+			return es
+		}
+	}
+
+	return es
+}
+
+func destinationName(dest ast.PipeDestination) string {
+	if dest == ast.KDestinationGpu {
+		return "gpu"
+	}
+	return "cpu"
+}
+
+// Find the line ey_runtime_bench printed amongst whatever the bench printed itself
+func parseBenchResult(output string) (benchResult, bool) {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i -= 1 {
+		if skipped, fnd := cutPrefix(lines[i], "ey-bench-skip "); fnd {
+			return benchResult{skipped: skipped}, true
+		}
+
+		if fields, fnd := cutPrefix(lines[i], "ey-bench "); fnd {
+			r := benchResult{}
+			n, err := fmt.Sscanf(fields, "%d %d %d %d %d", &r.iterations, &r.ns, &r.allocations, &r.bytes, &r.elements)
+			return r, err == nil && n == 5 && r.iterations > 0
+		}
+	}
+	return benchResult{}, false
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// The time per element sent to a worker, for benches that send any
+func perElement(r benchResult) string {
+	if r.elements == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", float64(r.ns)/float64(r.elements))
+}
+
+// How a bench ran on the gpu against the cpu, when it ran on both
+func compareDestinations(name string, results map[ast.PipeDestination]benchResult) (string, bool) {
+	cpu, cpuFnd := results[ast.KDestinationCpu]
+	gpu, gpuFnd := results[ast.KDestinationGpu]
+	if !cpuFnd || !gpuFnd {
+		return "", false
+	}
+
+	cpuNs := float64(cpu.ns) / float64(cpu.iterations)
+	gpuNs := float64(gpu.ns) / float64(gpu.iterations)
+	if gpuNs < cpuNs {
+		return fmt.Sprintf("%v: gpu is %.2fx faster than cpu", name, cpuNs/gpuNs), true
+	}
+	return fmt.Sprintf("%v: cpu is %.2fx faster than gpu", name, gpuNs/cpuNs), true
+}
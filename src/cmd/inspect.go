@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"eyot/ast"
+	"eyot/errors"
+	"eyot/output/cwriter"
+	"eyot/output/textwriter"
+	"eyot/program"
+)
+
+/*
+Print the root module's tree as it is after a pass of the checker, for debugging the checker
+
+The pass is given by -pass, and defaults to the last that changes the tree. The tree is printed as
+indented text, or as JSON with -json. It is printed even when checking fails, as far as it got
+*/
+func dumpAst(env *program.Environment, filePath string, flags map[string]bool, options map[string]string, es *errors.Errors) *errors.Errors {
+	last := ast.KPassCheckTypes
+	if name, fnd := options["pass"]; fnd {
+		passes := []string{}
+		known := false
+		for pass := ast.KPassSetTleTypes; pass <= ast.KPassCheckTypes; pass += 1 {
+			passes = append(passes, pass.String())
+			if pass.String() == name {
+				last, known = pass, true
+			}
+		}
+		if !known {
+			es.LogInternalError(fmt.Errorf("Bad -pass value: %v, it should be one of %v", name, strings.Join(passes, ", ")))
+			return es
+		}
+	}
+
+	p := program.NewProgram(env, es)
+	m := p.ParseRootModuleUntil(strings.TrimSuffix(filepath.Base(filePath), ".ey"), last)
+	if m == nil {
+		return es
+	}
+
+	dumped := ast.Dump(m)
+	if flags["json"] {
+		if err := ast.WriteDumpJSON(os.Stdout, dumped); err != nil {
+			es.LogInternalError(fmt.Errorf("Failed to write the tree: %v", err))
+		}
+	} else {
+		ast.WriteDumpTree(os.Stdout, dumped)
+	}
+	return es
+}
+
+// Print the OpenCL C the program's gpu code is built from at run time
+func printKernels(env *program.Environment, filePath string, rep *reporter, es *errors.Errors) *errors.Errors {
+	p := program.NewProgram(env, es)
+	p.ParseRoot(strings.TrimSuffix(filepath.Base(filePath), ".ey"))
+	rep.warn(es)
+	if !es.Clean() {
+		return es
+	}
+
+	cwriter.NewCWriter(textwriter.NewWriter(os.Stdout)).WriteKernels(p)
+	return es
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const inspectSource = `fn square(v i64) i64 {
+    return -v * v
+}
+
+cpu fn main() {
+    let w = gpu square
+    send(w, [i64] { 1, 2 })
+    for v: drain(w) {
+        print_ln(v)
+    }
+}
+`
+
+// Write inspectSource to a new directory, returning the directory
+func inspectDir(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "square.ey"), []byte(inspectSource), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	return dir
+}
+
+func expectContains(t *testing.T, out string, expected ...string) {
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Fatalf("Missing '%v' in:\n%v", e, out)
+		}
+	}
+}
+
+func TestAst(t *testing.T) {
+	out := runEyot(t, inspectDir(t), "ast", "square.ey")
+
+	// enums are printed by name
+	expectContains(t, out,
+		"- FunctionDefinitionTle 1:1-3:2",
+		"Id: FunctionId(square, square)",
+		"Location: anywhere",
+		"Location: cpu",
+		"ReturnedValue: BinaryExpression 2:12-2:18",
+		"Operator: multiply",
+		"Operator: negate",
+		"Type: let",
+		"- GpuKernelTle",
+	)
+}
+
+func TestAstPass(t *testing.T) {
+	// the gpu kernel is added by the mutate pass
+	out := runEyot(t, inspectDir(t), "ast", "-pass=set-types", "square.ey")
+	expectContains(t, out, "NewType: worker(i64)i64")
+	if strings.Contains(out, "GpuKernelTle") {
+		t.Fatalf("The tree was mutated before the mutate pass:\n%v", out)
+	}
+
+	out = runEyot(t, inspectDir(t), "ast", "-pass=mutate", "square.ey")
+	expectContains(t, out, "- GpuKernelTle")
+}
+
+func TestAstJson(t *testing.T) {
+	out := runEyot(t, inspectDir(t), "ast", "-json", "square.ey")
+
+	var module struct {
+		Node             string `json:"node"`
+		TopLevelElements []map[string]interface{}
+	}
+	if err := json.Unmarshal([]byte(out), &module); err != nil {
+		t.Fatalf("Bad JSON %v:\n%v", err, out)
+	}
+	if module.Node != "Module" {
+		t.Fatalf("Expecting a Module, have %v", module.Node)
+	}
+
+	var square map[string]interface{}
+	for _, tle := range module.TopLevelElements {
+		if tle["node"] == "FunctionDefinitionTle" {
+			square = tle["Definition"].(map[string]interface{})
+			break
+		}
+	}
+	if square == nil || square["Id"] != "FunctionId(square, square)" || square["Location"] != "anywhere" || square["span"] != "1:1-1:21" {
+		t.Fatalf("Wrong first function: %v", square)
+	}
+}
+
+func TestKernels(t *testing.T) {
+	out := runEyot(t, inspectDir(t), "kernels", "square.ey")
+	expectContains(t, out,
+		"#define EYOT_RUNTIME_GPU",
+		"__kernel void ey_function_square___unbound___ey_generated_kernel_",
+		"ey_function_square___unbound___square (",
+	)
+}
@@ -1,28 +1,40 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 
+	"eyot/ast"
+	"eyot/doc"
 	"eyot/errors"
+	"eyot/format"
+	"eyot/lsp"
 	"eyot/output/crunner"
 	"eyot/output/cwriter"
+	"eyot/output/interp"
 	"eyot/output/textwriter"
 	"eyot/program"
 )
 
 func usage() *errors.Errors {
 	fmt.Print(`eyot <command> <file>
+  Inside a project (a folder with an eyot.toml), the file can be left out to use the project's main,
+  or with test and bench the whole project
+
   Commands:
     env
-      Print environment
+      Print environment, and the project's configuration
 
     build
-      Build the program to an executable file
+      Build the program to an executable file, named after the project when building its main
 
     run
       Run directly
@@ -36,14 +48,113 @@ func usage() *errors.Errors {
     c
       Output the C code (one file)
 
+    ast
+      Print the tree of the file after the checker's passes, with the types it inferred and the code it generated
+
+    kernels
+      Print the OpenCL C the file's gpu code is built from when it runs
+
+    fmt
+      Print the file in the canonical layout, or with -write rewrite it in place
+
+    test
+      Build and run the test blocks in the file, or in each file in the folder, every test in a process of its own
+
+    bench
+      Build and run the bench blocks in the file, or in each file in the folder, and print how long each took
+
+    doc
+      Print the documentation of a module (e.g. std::math) or file, and the modules below it
+
+    mod vendor
+      Copy the project's dependencies into its vendor folder, and write eyot.lock with a hash of each that builds check
+
+    lsp
+      Run a language server on stdin and stdout, for editors (no file is given)
+
   Flags:
     -showlog
       Show the compiler output (error or no error)
 
+    -o PATH
+      With build, the executable to write (default ./out.exe). With run, keep the executable there
+
+    -release
+      With build, run, test or bench, optimise the C and leave out debug info
+
+    -debug
+      With build, run, test or bench, keep debug info and turn off optimisation
+
+    -sanitize=address,undefined,thread
+      With build, run, test or bench, compile with these sanitizers
+
+    -cflags=FLAGS
+      Extra flags for the C compiler, as one argument separated by spaces
+
+    -ldflags=FLAGS
+      Extra flags for the linker, as one argument separated by spaces
+
+    -lib
+      With build, build a library instead of an executable, along with a C header for the functions the file exports. It is shared (default lib<name>.so) unless -o ends in .a. With lint, check the file as a library would be, without building it
+
+    -pass=set-tle-types|set-types|mutate|check-types
+      With ast, stop checking after this pass (default check-types)
+
+    -json
+      With ast, print the tree as JSON
+
+    -nolines
+      Leave out the #line directives that point the C back at the .ey files, to read the C as it is
+
+    -interp
+      With run, interpret the program instead of compiling it, for fast iteration. Gpu workers are emulated on the cpu, and ffi is not available
+
+    -max-errors=N
+      Print at most N errors, 0 prints them all (default 20)
+
+    -format=text|json|sarif
+      How errors are printed. json and sarif (2.1.0) are for tools, and are always printed by build and lint
+
+    -w
+      Disable all warnings
+
+    -write
+      With fmt, write the formatted file back instead of printing it
+
+    -check
+      With fmt, fail if the file is not already formatted, and leave it alone
+
+    -run=REGEXP
+      With test or bench, only run those whose names match
+
+    -benchtime=DURATION
+      With bench, how long to run each bench for, as in 500ms. Defaults to 1s
+
+    -markdown=DIR
+      With doc, write a Markdown page per module and an index.md to DIR instead of printing
+
+    -html=DIR
+      With doc, write an HTML page per module and an index.html to DIR instead of printing
+
+    -Werror
+      Treat warnings as errors
+
+    -Wno-<warning>
+      Disable a warning, one of unused-variable, unused-import, unused-function, unreachable-code or shadow
+
   EnvironmentVariables:
     EyotRoot: the root of the eyot runtime libraries
     EyotTestOclGrind: if 'y' it will use oclgrind
+    EyotTestInterp: if 'y' the golden tests use run -interp
+    EyotTrace: path to write a Chrome trace_event file of runtime activity to
+    EyotGcThreshold: bytes allocated before runtime::collect_if_needed does a young collection (default 4MB)
+    EyotGcGrowth: heap growth before runtime::collect_if_needed does a full collection (default 2)
+    EyotClDevice: index of the default OpenCL device for gpu workers (default is the first GPU, or the first device if there is none)
+    EyotClCache: directory for cached OpenCL program binaries, 'n' to disable (default ~/.cache/eyot/opencl)
+    EyotBuildCache: directory for the compiled runtime and ffi objects, 'n' to disable (default ~/.cache/eyot/build)
     CC: the C compiler to use for the backend code generation (Linux and macOS)
+    AR: the archiver used for the compiled runtime (Linux and macOS, default ar)
+    NO_COLOR: if set, errors and warnings are never coloured (they are only coloured on a terminal anyway)
 `)
 	return nil
 }
@@ -66,39 +177,407 @@ const (
 
 	// print env
 	kEnv
+
+	// reprint the file in the canonical layout
+	kFormat
+
+	// document the module
+	kDoc
+
+	// build and run the tests
+	kTest
+
+	// build and run the benches
+	kBench
+
+	// print the checked tree
+	kAst
+
+	// print the OpenCL C
+	kKernels
 )
 
+// how errors are reported
+type reporter struct {
+	format string
+
+	// when true the report is written even without errors, so there is always something to parse
+	always bool
+}
+
+func (r *reporter) report(es *errors.Errors) {
+	failed := es != nil && !es.Clean()
+	if es == nil {
+		es = errors.NewErrors()
+	}
+
+	switch r.format {
+	case "json":
+		if failed || r.always {
+			es.WriteJSON(os.Stdout)
+		}
+
+	case "sarif":
+		if failed || r.always {
+			es.WriteSARIF(os.Stdout)
+		}
+
+	default:
+		if !failed {
+			return
+		}
+
+		if es.InternalError() != nil {
+			fmt.Println("internal error: ", es.InternalError())
+		} else {
+			es.LogErrors(os.Stdout)
+		}
+	}
+}
+
+// Text warnings are printed as soon as they are known, and to stderr to keep them apart from the program's output
+func (r *reporter) warn(es *errors.Errors) {
+	if r.format == "text" {
+		es.LogWarnings(os.Stderr)
+	}
+}
+
+// Run a language server on stdin and stdout until the editor closes it
+func serveLsp() *errors.Errors {
+	env := program.CreateEnvironment(".")
+	es := errors.NewErrors()
+
+	// nothing else can be printed to stdout, or it would corrupt the protocol
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	if err := lsp.NewServer(env.LibraryRoot()).Serve(os.Stdin, out); err != nil {
+		es.LogInternalError(err)
+	}
+	return es
+}
+
+// Format a file, printing it, checking it (-check) or writing it back (-write)
+func formatFile(env *program.Environment, filePath string, flags map[string]bool, es *errors.Errors) *errors.Errors {
+	p := program.NewProgram(env, es)
+	m := p.ParseRootUnchecked(strings.TrimSuffix(filepath.Base(filePath), ".ey"))
+	if m == nil {
+		return es
+	}
+
+	original, err := os.ReadFile(filePath)
+	if err != nil {
+		es.LogInternalError(fmt.Errorf("Failed to read file %v", filePath))
+		return es
+	}
+	formatted := format.Format(m, p.Comments[p.RootModuleId.Key()])
+
+	switch {
+	case flags["check"]:
+		if !bytes.Equal(original, formatted) {
+			// point at the first line that would change
+			originalLines := strings.Split(string(original), "\n")
+			formattedLines := strings.Split(string(formatted), "\n")
+			line := 0
+			for line < len(originalLines) && line < len(formattedLines) && originalLines[line] == formattedLines[line] {
+				line += 1
+			}
+
+			es.ErrorfAt(errors.SourceLocation{Filename: p.RootModuleId.Key(), Line: line + 1}, "File is not formatted, eyot fmt -write would change it from here")
+		}
+
+	case flags["write"]:
+		if !bytes.Equal(original, formatted) {
+			if err := os.WriteFile(filePath, formatted, 0666); err != nil {
+				es.LogInternalError(fmt.Errorf("Failed to write file %v: %v", filePath, err))
+			}
+		}
+
+	default:
+		os.Stdout.Write(formatted)
+	}
+
+	return es
+}
+
+// Run a file with the interpreter rather than compiling it (-interp)
+func interpretFile(env *program.Environment, filePath string, rep *reporter, es *errors.Errors) *errors.Errors {
+	p := program.NewProgram(env, es)
+	p.ParseRoot(strings.TrimSuffix(filepath.Base(filePath), ".ey"))
+	rep.warn(es)
+	if !es.Clean() {
+		return es
+	}
+
+	if err := interp.Run(p, []string{filePath}, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		es.LogInternalError(fmt.Errorf("Error running: %v", err))
+	}
+	return es
+}
+
+// The profile, sanitizers and C flags to build with, from -release, -debug, -sanitize, -cflags and -ldflags
+func buildOptions(flags map[string]bool, options map[string]string, es *errors.Errors) (crunner.BuildOptions, bool) {
+	bo := crunner.BuildOptions{
+		CFlags:  strings.Fields(options["cflags"]),
+		LdFlags: strings.Fields(options["ldflags"]),
+	}
+
+	switch {
+	case flags["release"] && flags["debug"]:
+		es.LogInternalError(fmt.Errorf("Only one of -release and -debug can be given"))
+		return bo, false
+	case flags["release"]:
+		bo.Profile = crunner.KProfileRelease
+	case flags["debug"]:
+		bo.Profile = crunner.KProfileDebug
+	}
+
+	if sanitize, fnd := options["sanitize"]; fnd {
+		bo.Sanitize = strings.Split(sanitize, ",")
+	}
+	if err := bo.Validate(); err != nil {
+		es.LogInternalError(fmt.Errorf("Bad -sanitize value: %v", err))
+		return bo, false
+	}
+
+	return bo, true
+}
+
+// The build options with the project's C flags added first, so those given to the command can override them
+func projectBuildOptions(bo crunner.BuildOptions, m *program.Manifest) crunner.BuildOptions {
+	if m == nil {
+		return bo
+	}
+
+	bo.CFlags = append(append([]string{}, m.CFlags...), bo.CFlags...)
+	bo.LdFlags = append(append([]string{}, m.LdFlags...), bo.LdFlags...)
+	return bo
+}
+
+// Print where modules are found, and the project the current folder is in
+func printEnvironment(env *program.Environment) {
+	fmt.Println("EyotRoot")
+	for _, root := range env.Roots {
+		fmt.Println("  " + root)
+	}
+
+	m := env.Manifest
+	if m == nil {
+		return
+	}
+
+	fmt.Println("Project")
+	fmt.Println("  name: " + m.Name)
+	fmt.Println("  manifest: " + m.Path)
+	if m.Main != "" {
+		fmt.Println("  main: " + m.MainPath())
+	}
+	if len(m.CFlags) > 0 {
+		fmt.Println("  cflags: " + strings.Join(m.CFlags, " "))
+	}
+	if len(m.LdFlags) > 0 {
+		fmt.Println("  ldflags: " + strings.Join(m.LdFlags, " "))
+	}
+
+	// those vendored include what the dependencies need themselves
+	names := []string{}
+	for name := range env.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) > 0 {
+		fmt.Println("Dependencies")
+	}
+	for _, name := range names {
+		source := "needed by a dependency"
+		for _, d := range m.Dependencies {
+			if d.Name == name && d.Git != "" {
+				source = strings.TrimSpace(d.Git + " " + d.Rev)
+			} else if d.Name == name {
+				source = d.Path
+			}
+		}
+		fmt.Printf("  %v: %v (%v)\n", name, env.Packages[name], source)
+	}
+}
+
+/*
+Manage the dependencies of the project the current folder is in
+
+vendor copies them into the project's vendor folder and writes the lockfile, which builds then check
+*/
+func runMod(command string, es *errors.Errors) *errors.Errors {
+	if command != "vendor" {
+		es.LogInternalError(fmt.Errorf("Unknown mod command %v, it should be vendor", command))
+		return es
+	}
+
+	m, err := program.FindManifest(".")
+	if err != nil {
+		es.LogInternalError(err)
+		return es
+	}
+	if m == nil {
+		es.LogInternalError(fmt.Errorf("No %v was found in this folder or those above it", program.ManifestName))
+		return es
+	}
+
+	locked, err := m.Vendor()
+	if err != nil {
+		es.LogInternalError(err)
+		return es
+	}
+	for _, ld := range locked {
+		fmt.Printf("vendored %v %v\n", ld.Name, ld.Hash)
+	}
+	return es
+}
+
+// Finish the C written for the program, compiling it when compile is set, and return the compiler's log
+func closeRunner(cr crunner.CRunner, p *program.Program, compile bool) (string, error) {
+	defs := map[string]string{
+		"EYOT_RUNTIME_MAX_ARGS": fmt.Sprintf("%v", p.Functions.MaxArgCount()),
+	}
+
+	ffiFiles := []string{}
+	for _, mod := range p.Modules {
+		if mod.Ffid != nil {
+			ffiFiles = append(ffiFiles, mod.Ffid.Src)
+		}
+	}
+
+	return cr.Close(compile, p.GpuRequired, defs, ffiFiles, p.FfiFlags())
+}
+
+// The file name of a shared library, as the platform's linker looks for it
+func sharedLibraryName(name string) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "lib" + name + ".dylib"
+	case "windows":
+		return name + ".dll"
+	default:
+		return "lib" + name + ".so"
+	}
+}
+
+// Write the C header of a library, noting what else linking it statically needs
+func writeLibraryHeader(p *program.Program, path string) error {
+	linkWith := []string{}
+	for flag := range p.FfiFlags() {
+		linkWith = append(linkWith, flag)
+	}
+	sort.Strings(linkWith)
+	if p.GpuRequired {
+		linkWith = append(linkWith, "OpenCL")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cwriter.NewCWriter(textwriter.NewWriter(f)).WriteLibraryHeader(p, linkWith)
+	return nil
+}
+
+/*
+Document a module, given by id (std::math) or path, along with the modules in the folder below it
+
+The documentation is printed, or written as pages to the folder given by -markdown or -html
+*/
+func documentModules(env *program.Environment, target string, options map[string]string, es *errors.Errors) *errors.Errors {
+	id := ast.ModuleId(strings.Split(target, "::"))
+	if filepath.Ext(target) == ".ey" {
+		id = ast.ModuleId{strings.TrimSuffix(filepath.Base(target), ".ey")}
+	}
+
+	ids := env.ModulesUnder(id)
+	if len(ids) == 0 {
+		es.Errorf("Module %v not found", target)
+		return es
+	}
+
+	mds := []doc.Module{}
+	for _, id := range ids {
+		// each is parsed alone, as the modules it imports are checked
+		m := program.NewProgram(env, es).ParseUnchecked(id)
+		if m == nil {
+			return es
+		}
+		mds = append(mds, doc.Collect(m))
+	}
+
+	var err error
+	if dir, fnd := options["markdown"]; fnd {
+		err = doc.WriteMarkdown(dir, mds)
+	} else if dir, fnd := options["html"]; fnd {
+		err = doc.WriteHTML(dir, mds)
+	} else {
+		doc.WriteText(os.Stdout, mds)
+	}
+	if err != nil {
+		es.LogInternalError(fmt.Errorf("Failed to write documentation: %v", err))
+	}
+
+	return es
+}
+
 // parse options and invoke doCompile
-func errMain() *errors.Errors {
+func errMain(rep *reporter) *errors.Errors {
 	action := kCompile
 	outFile := "./out.exe"
 	filePath := ""
 
 	flags := map[string]bool{}
+	options := map[string]string{}
 	args := []string{}
 
 	useOclGrind := os.Getenv("EyotTestOclGrind") == "y"
 
-	for _, arg := range os.Args {
+	// these options can also be given as -name value
+	spaced := map[string]bool{"o": true, "cflags": true, "ldflags": true}
+
+	for ai := 0; ai < len(os.Args); ai += 1 {
+		arg := os.Args[ai]
 		if len(arg) == 0 {
 			continue
 		}
 
 		if strings.HasPrefix(arg, "-") {
-			flags[arg[1:]] = true
+			if name, value, fnd := strings.Cut(arg[1:], "="); fnd {
+				options[name] = value
+			} else if spaced[arg[1:]] && ai+1 < len(os.Args) {
+				ai += 1
+				options[arg[1:]] = os.Args[ai]
+			} else {
+				flags[arg[1:]] = true
+			}
 		} else {
 			args = append(args, arg)
 		}
 	}
 
+	if len(args) == 2 && args[1] == "lsp" {
+		return serveLsp()
+	}
+
+	if len(args) == 3 && args[1] == "mod" {
+		return runMod(args[2], errors.NewErrors())
+	}
+
 	if len(args) == 2 && args[1] == "env" {
 		action = kEnv
 	} else {
-		if len(args) != 3 {
+		// without a file, the project's is used
+		if len(args) == 3 {
+			filePath = args[2]
+		} else if len(args) != 2 {
 			return usage()
 		}
 
-		filePath = args[2]
 		switch args[1] {
 		case "build":
 			action = kCompile
@@ -114,23 +593,155 @@ func errMain() *errors.Errors {
 
 		case "run":
 			action = kRun
+
+		case "fmt":
+			action = kFormat
+
+		case "doc":
+			action = kDoc
+
+		case "test":
+			action = kTest
+
+		case "bench":
+			action = kBench
+
+		case "ast":
+			action = kAst
+
+		case "kernels":
+			action = kKernels
 		}
 	}
 
-	env := program.CreateEnvironment(filepath.Dir(filePath))
 	es := errors.NewErrors()
-	if action == kEnv {
-		fmt.Println("EyotRoot")
-		for _, root := range env.Roots {
-			fmt.Println("  " + root)
+
+	projectBuild := false
+	if filePath == "" && action != kEnv {
+		m, err := program.FindManifest(".")
+		if err != nil {
+			es.LogInternalError(err)
+			return es
+		}
+		if m == nil {
+			return usage()
+		}
+
+		switch {
+		case action == kTest || action == kBench:
+			filePath = m.Dir
+		case m.Main == "":
+			es.LogInternalError(fmt.Errorf("No file was given, and %v has no main", m.Path))
+			return es
+		default:
+			filePath = m.MainPath()
+			projectBuild = true
+		}
+	}
+
+	env, err := program.CreateProjectEnvironment(filepath.Dir(filePath))
+	if err != nil {
+		es.LogInternalError(err)
+		return es
+	}
+
+	// lint can check a library without building it
+	if flags["lib"] && action != kCompile && action != kSilent {
+		es.LogInternalError(fmt.Errorf("-lib can only be used with build or lint"))
+		return es
+	}
+
+	if format, fnd := options["format"]; fnd {
+		switch format {
+		case "text", "json", "sarif":
+			rep.format = format
+		default:
+			es.LogInternalError(fmt.Errorf("Bad -format value: %v", format))
+			return es
+		}
+	}
+	rep.always = action == kCompile || action == kSilent
+
+	if maxErrors, fnd := options["max-errors"]; fnd {
+		limit, err := strconv.Atoi(maxErrors)
+		if err != nil || limit < 0 {
+			es.LogInternalError(fmt.Errorf("Bad -max-errors value: %v", maxErrors))
+			return es
+		}
+		es.SetLimit(limit)
+	}
+
+	es.SetWarningsAsErrors(flags["Werror"])
+	if flags["w"] {
+		for _, code := range ast.Warnings {
+			es.DisableWarning(code)
+		}
+	}
+	for flag := range flags {
+		if !strings.HasPrefix(flag, "Wno-") {
+			continue
+		}
+
+		code := flag[len("Wno-"):]
+		known := false
+		for _, warning := range ast.Warnings {
+			known = known || warning == code
 		}
+		if !known {
+			es.LogInternalError(fmt.Errorf("Unknown warning: %v", code))
+			return es
+		}
+		es.DisableWarning(code)
+	}
+	if action == kEnv {
+		printEnvironment(env)
 		return es
 	}
 
+	if action == kDoc {
+		return documentModules(env, filePath, options, es)
+	}
+
+	if action == kTest {
+		return runTests(filePath, flags, options, rep, es)
+	}
+
+	if action == kBench {
+		return runBenches(filePath, flags, options, rep, es)
+	}
+
 	if filepath.Ext(filePath) != ".ey" {
 		es.LogInternalError(fmt.Errorf("Bad extension: %v", filePath))
 	}
 
+	if action == kFormat {
+		if !es.Clean() {
+			return es
+		}
+		return formatFile(env, filePath, flags, es)
+	}
+
+	if action == kAst {
+		if !es.Clean() {
+			return es
+		}
+		return dumpAst(env, filePath, flags, options, es)
+	}
+
+	if action == kKernels {
+		if !es.Clean() {
+			return es
+		}
+		return printKernels(env, filePath, rep, es)
+	}
+
+	if action == kRun && flags["interp"] {
+		if !es.Clean() {
+			return es
+		}
+		return interpretFile(env, filePath, rep, es)
+	}
+
 	var outStream io.Writer = os.Stdout
 
 	pid := os.Getpid()
@@ -142,15 +753,38 @@ func errMain() *errors.Errors {
 
 	var cr crunner.CRunner = nil
 
-	if action == kRun {
+	fname := strings.TrimSuffix(filepath.Base(filePath), ".ey")
+
+	if o, fnd := options["o"]; fnd {
+		outFile = o
+	} else if projectBuild && action == kCompile && !flags["lib"] {
+		outFile = filepath.Join(env.Manifest.Dir, env.Manifest.Name)
+		if runtime.GOOS == "windows" {
+			outFile += ".exe"
+		}
+	} else if flags["lib"] {
+		outFile = "./" + sharedLibraryName(fname)
+	} else if action == kRun {
 		outFile = filepath.Join(buildRootDir, fmt.Sprintf("temp-binary-%v.exe", os.Getpid()))
 		defer os.Remove(outFile)
 	}
 
 	if !(action == kPrint || action == kSilent) {
+		bo, ok := buildOptions(flags, options, es)
+		if !ok {
+			return es
+		}
+		bo = projectBuildOptions(bo, env.Manifest)
+		if flags["lib"] {
+			bo.Output = crunner.KOutputShared
+			if filepath.Ext(outFile) == ".a" {
+				bo.Output = crunner.KOutputStatic
+			}
+		}
+
 		os.MkdirAll(buildRootDir, 0777)
 		files := cwriter.DumpRuntime(buildRootDir, env)
-		cr = crunner.NewRunner(buildRootDir, files, flags["showlog"])
+		cr = crunner.NewRunner(buildRootDir, files, flags["showlog"], bo)
 
 		cr.Open(outFile)
 		outStream = cr.WriteStream()
@@ -160,10 +794,10 @@ func errMain() *errors.Errors {
 	}
 
 	p := program.NewProgram(env, es)
-	fname := filepath.Base(filePath)
-	fname = fname[:len(fname)-3]
+	p.Library = flags["lib"]
 
 	p.ParseRoot(fname)
+	rep.warn(es)
 	if !es.Clean() {
 		return es
 	}
@@ -171,21 +805,16 @@ func errMain() *errors.Errors {
 	// output phase
 	w := textwriter.NewWriter(outStream)
 	cw := cwriter.NewCWriter(w)
-	cw.WriteProgram(p)
+	cw.SetLineDirectives(!flags["nolines"])
+	if cr == nil {
+		cw.WriteProgram(p)
+	} else if err := cw.WriteProgramUnits(p, cr.AddFile); err != nil {
+		es.LogInternalError(fmt.Errorf("Failed to write the C: %v", err))
+		return es
+	}
 
 	if !(action == kPrint || action == kSilent) {
-		defs := map[string]string{
-			"EYOT_RUNTIME_MAX_ARGS": fmt.Sprintf("%v", p.Functions.MaxArgCount()),
-		}
-
-		ffiFiles := []string{}
-		for _, mod := range p.Modules {
-			if mod.Ffid != nil {
-				ffiFiles = append(ffiFiles, mod.Ffid.Src)
-			}
-		}
-
-		log, err := cr.Close(action != kPrepare, p.GpuRequired, defs, ffiFiles, p.FfiFlags())
+		log, err := closeRunner(cr, p, action != kPrepare)
 		if action == kPrepare {
 			if err != nil {
 				es.LogInternalError(fmt.Errorf("Code preparation error: %v", err))
@@ -196,27 +825,42 @@ func errMain() *errors.Errors {
 			fmt.Println(buildRootDir)
 		} else {
 			if err != nil {
-				fmt.Println(log)
-				es.LogInternalError(fmt.Errorf("CC error: %v", err))
+				// the compiler's log goes in the error, so it is reported in whichever format was asked for
+				es.LogInternalError(fmt.Errorf("%v\n%v", err, strings.TrimSpace(log)))
 				return es
 			}
 		}
 	}
 
+	if p.Library && action == kCompile {
+		headerPath := filepath.Join(filepath.Dir(outFile), fname+".h")
+		if err := writeLibraryHeader(p, headerPath); err != nil {
+			es.LogInternalError(fmt.Errorf("Failed to write the library header: %v", err))
+			return es
+		}
+	}
+
 	if action == kRun {
 		var stdOutStream io.Writer = os.Stdout
 
+		// a bare name, as -o prog gives, would be looked up on the PATH
+		runPath, err := filepath.Abs(outFile)
+		if err != nil {
+			es.LogInternalError(fmt.Errorf("Failed to find the binary %v: %v", outFile, err))
+			return es
+		}
+
 		var ocmd *exec.Cmd = nil
 		if useOclGrind {
-			ocmd = exec.Command("oclgrind", outFile)
+			ocmd = exec.Command("oclgrind", runPath)
 		} else {
-			ocmd = exec.Command(outFile)
+			ocmd = exec.Command(runPath)
 		}
 
 		ocmd.Stdin = os.Stdin
 		ocmd.Stdout = stdOutStream
 		ocmd.Stderr = os.Stderr
-		err := ocmd.Run()
+		err = ocmd.Run()
 
 		if err != nil {
 			// not obvious this is an error once we've added exit codes
@@ -225,21 +869,18 @@ func errMain() *errors.Errors {
 		}
 	}
 
-	return nil
+	// this is clean, but may hold warnings
+	return es
 }
 
 func main() {
-	es := errMain()
-	if es == nil {
-		os.Exit(0)
-	} else if es.Clean() {
+	rep := &reporter{format: "text"}
+	es := errMain(rep)
+	rep.report(es)
+
+	if es == nil || es.Clean() {
 		os.Exit(0)
 	} else {
-		if es.InternalError() != nil {
-			fmt.Println("internal error: ", es.InternalError())
-		} else {
-			es.LogErrors(os.Stdout)
-		}
 		os.Exit(1)
 	}
 }
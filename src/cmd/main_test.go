@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Run eyot with args in dir, returning what was printed to stdout
+func runEyot(t *testing.T, dir string, args ...string) string {
+	root, err := filepath.Abs(filepath.Join("..", "..", "lib"))
+	if err != nil {
+		t.Fatalf("No library root: %v", err)
+	}
+	t.Setenv("EyotRoot", root)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("No working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create a pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printed := make(chan string)
+	go func() {
+		buf := bytes.NewBuffer([]byte{})
+		io.Copy(buf, r)
+		printed <- buf.String()
+	}()
+
+	savedArgs := os.Args
+	os.Args = append([]string{"eyot"}, args...)
+	es := errMain(&reporter{format: "text"})
+	os.Args = savedArgs
+
+	os.Stdout = stdout
+	w.Close()
+	out := <-printed
+
+	if es != nil && !es.Clean() {
+		buf := bytes.NewBuffer([]byte{})
+		es.LogErrors(buf)
+		t.Fatalf("eyot %v failed:\n%v%v", args, buf.String(), es.InternalError())
+	}
+	return out
+}
+
+// -o with a bare name builds to the working directory, and that binary is what runs
+func TestRunWithOutput(t *testing.T) {
+	dir := t.TempDir()
+	src := "cpu fn main() {\n    print_ln(\"hello\")\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "hello.ey"), []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	out := runEyot(t, dir, "run", "-o", "prog", "hello.ey")
+	if out != "hello\n" {
+		t.Fatalf("Wrong output: '%v'", out)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "prog")); err != nil {
+		t.Fatalf("The binary was not kept: %v", err)
+	}
+}
+
+// fmt writes the file back with -write, while -w only turns warnings off as it does for every command
+func TestFmtWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "messy.ey")
+	messy := "cpu fn main() {\nprint_ln(\"hello\")\n}\n"
+	formatted := "cpu fn main() {\n\tprint_ln(\"hello\")\n}\n"
+	if err := os.WriteFile(path, []byte(messy), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	if out := runEyot(t, dir, "fmt", "-w", "messy.ey"); out != formatted {
+		t.Fatalf("Wrong output: '%v'", out)
+	}
+	if blob, _ := os.ReadFile(path); string(blob) != messy {
+		t.Fatalf("-w rewrote the file:\n%v", string(blob))
+	}
+
+	if out := runEyot(t, dir, "fmt", "-write", "messy.ey"); out != "" {
+		t.Fatalf("-write printed '%v'", out)
+	}
+	if blob, _ := os.ReadFile(path); string(blob) != formatted {
+		t.Fatalf("-write left the file as:\n%v", string(blob))
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"eyot/ast"
+	"eyot/errors"
+	"eyot/output/crunner"
+	"eyot/output/cwriter"
+	"eyot/output/textwriter"
+	"eyot/program"
+	"eyot/token"
+)
+
+/*
+Build and run the tests in a file, or in each file below a folder
+
+Each test is run in a process of its own, so one that fails an assert or crashes leaves the rest to
+run. A failed test is an error at the test, after what it printed
+*/
+func runTests(target string, flags map[string]bool, options map[string]string, rep *reporter, es *errors.Errors) *errors.Errors {
+	filter, ok := runFilter(options, es)
+	if !ok {
+		return es
+	}
+
+	bo, ok := buildOptions(flags, options, es)
+	if !ok {
+		return es
+	}
+
+	files, ok := blockFiles(target, "test", es)
+	if !ok {
+		return es
+	}
+
+	passed, failed := 0, 0
+	for _, file := range files {
+		fnd := withBlocks(file, false, flags["showlog"], bo, es, func(m *ast.Module, binary string) {
+			fmt.Printf("=== %v\n", file)
+
+			for i, fd := range m.Tests() {
+				if !filter.MatchString(fd.Test) {
+					continue
+				}
+
+				output, err := runBlock(binary, i)
+				if err != nil {
+					fmt.Printf("--- FAIL: %v\n", fd.Test)
+					for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+						fmt.Printf("    %v\n", line)
+					}
+
+					es.ErrorfAt(fd.Span, "Test \"%v\" failed: %v", fd.Test, err)
+					failed += 1
+				} else {
+					fmt.Printf("--- PASS: %v\n", fd.Test)
+					passed += 1
+				}
+			}
+		})
+		rep.warn(es)
+		if !fnd {
+			return es
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL: %v passed, %v failed\n", passed, failed)
+	} else {
+		fmt.Printf("ok: %v passed\n", passed)
+	}
+	return es
+}
+
+// The -run option, matching every name when it is not given
+func runFilter(options map[string]string, es *errors.Errors) (*regexp.Regexp, bool) {
+	run, fnd := options["run"]
+	if !fnd {
+		return regexp.MustCompile(""), true
+	}
+
+	filter, err := regexp.Compile(run)
+	if err != nil {
+		es.LogInternalError(fmt.Errorf("Bad -run value: %v", err))
+		return nil, false
+	}
+	return filter, true
+}
+
+// The file target, or the files below the folder target that have blocks such as `test "name"`
+func blockFiles(target, keyword string, es *errors.Errors) ([]string, bool) {
+	info, err := os.Stat(target)
+	if err != nil || !info.IsDir() {
+		if filepath.Ext(target) != ".ey" {
+			es.LogInternalError(fmt.Errorf("Bad extension: %v", target))
+			return nil, false
+		}
+		return []string{target}, true
+	}
+
+	files := []string{}
+	filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && filepath.Ext(path) == ".ey" && hasBlocks(path, keyword) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, true
+}
+
+// A quick look for blocks such as `test "name"`, so the files in a folder without any are not built
+func hasBlocks(path, keyword string) bool {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	tkns, err := token.Tokenise(string(blob))
+	if err != nil {
+		return false
+	}
+
+	for i := 0; i+1 < len(tkns); i += 1 {
+		if tkns[i].Type == token.Identifier && tkns[i].Tval == keyword && tkns[i+1].Type == token.String {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Build a file's tests, or its benches when benching, then call run with its root module and binary
+
+The binary is removed once run returns. This returns false if the file didn't build
+*/
+func withBlocks(filePath string, benching, showLog bool, bo crunner.BuildOptions, es *errors.Errors, run func(*ast.Module, string)) bool {
+	env, err := program.CreateProjectEnvironment(filepath.Dir(filePath))
+	if err != nil {
+		es.LogInternalError(err)
+		return false
+	}
+	bo = projectBuildOptions(bo, env.Manifest)
+
+	p := program.NewProgram(env, es)
+	p.Testing = !benching
+	p.Benching = benching
+
+	m := p.ParseRootModule(strings.TrimSuffix(filepath.Base(filePath), ".ey"))
+	if m == nil || !es.Clean() {
+		return false
+	}
+
+	buildRootDir := filepath.Join(os.TempDir(), fmt.Sprintf("eyot-test-root-%v", os.Getpid()))
+	defer os.RemoveAll(buildRootDir)
+	os.MkdirAll(buildRootDir, 0777)
+
+	outFile := filepath.Join(buildRootDir, fmt.Sprintf("temp-binary-%v.exe", os.Getpid()))
+	cr := crunner.NewRunner(buildRootDir, cwriter.DumpRuntime(buildRootDir, env), showLog, bo)
+	cr.Open(outFile)
+	if err := cwriter.NewCWriter(textwriter.NewWriter(cr.WriteStream())).WriteProgramUnits(p, cr.AddFile); err != nil {
+		es.LogInternalError(fmt.Errorf("Failed to write the C: %v", err))
+		return false
+	}
+
+	log, err := closeRunner(cr, p, true)
+	if err != nil {
+		// the runner's error already says it came from the C compiler
+		es.LogInternalError(fmt.Errorf("%v\n%v", err, strings.TrimSpace(log)))
+		return false
+	}
+
+	run(m, outFile)
+	return true
+}
+
+// Run the test or bench with this index in binary, returning what it printed
+func runBlock(binary string, index int, env ...string) (string, error) {
+	var cmd *exec.Cmd
+	if os.Getenv("EyotTestOclGrind") == "y" {
+		cmd = exec.Command("oclgrind", binary)
+	} else {
+		cmd = exec.Command(binary)
+	}
+
+	output := bytes.NewBuffer([]byte{})
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = append(append(os.Environ(), fmt.Sprintf("EyotTestIndex=%v", index)), env...)
+
+	err := cmd.Run()
+	return output.String(), err
+}
@@ -0,0 +1,173 @@
+package doc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"eyot/ast"
+	"eyot/format"
+)
+
+// What a module exports, in the order it was written
+type Module struct {
+	Id    ast.ModuleId
+	Items []Item
+}
+
+type ItemKind int
+
+const (
+	KItemFunction ItemKind = iota
+	KItemStruct
+	KItemConst
+	KItemField
+)
+
+// A documented function, struct, const, field or method
+type Item struct {
+	Kind ItemKind
+	Name string
+
+	// as they are written, a cpu and a gpu function of the same name share an item so have two
+	Signatures []string
+
+	// the /// comment, without the slashes
+	Doc string
+
+	// the fields and then the functions of a struct
+	Members []Item
+}
+
+/*
+Gather the documentation of a module, which must be as it was parsed
+
+Exported functions and structs are included, along with every const as other modules can use them.
+Of an exported struct, the fields and functions written pub are included
+*/
+func Collect(m *ast.Module) Module {
+	md := Module{Id: m.Id, Items: []Item{}}
+
+	for _, tlec := range m.TopLevelElements {
+		switch tle := tlec.TopLevelElement.(type) {
+		case *ast.FunctionDefinitionTle:
+			if tle.Definition.Exported {
+				md.Items = addFunction(md.Items, tle.Definition)
+			}
+
+		case *ast.StructDefinitionStatement:
+			if tle.Exported {
+				md.Items = append(md.Items, structItem(tle))
+			}
+
+		case *ast.ConstTle:
+			md.Items = append(md.Items, Item{
+				Kind:       KItemConst,
+				Name:       constName(tle.Assign.Lhs),
+				Signatures: []string{format.Const(tle.Assign)},
+				Doc:        tle.Doc,
+			})
+		}
+	}
+
+	return md
+}
+
+// Add a function, to an item of the same name if there is one
+func addFunction(items []Item, fd *ast.FunctionDefinition) []Item {
+	for i := range items {
+		if items[i].Kind == KItemFunction && items[i].Name == fd.Id.Name {
+			items[i].Signatures = append(items[i].Signatures, format.Signature(fd))
+			if items[i].Doc == "" {
+				items[i].Doc = fd.Doc
+			}
+			return items
+		}
+	}
+
+	return append(items, Item{
+		Kind:       KItemFunction,
+		Name:       fd.Id.Name,
+		Signatures: []string{format.Signature(fd)},
+		Doc:        fd.Doc,
+	})
+}
+
+func structItem(sds *ast.StructDefinitionStatement) Item {
+	item := Item{
+		Kind:       KItemStruct,
+		Name:       sds.Id.Name,
+		Signatures: []string{"struct " + sds.Id.Name},
+		Doc:        sds.Doc,
+		Members:    []Item{},
+	}
+
+	for _, field := range sds.Definition.Fields {
+		if !field.Public {
+			continue
+		}
+		item.Members = append(item.Members, Item{
+			Kind:       KItemField,
+			Name:       field.Name,
+			Signatures: []string{field.Name + " " + format.Type(field.Type)},
+			Doc:        field.Doc,
+		})
+	}
+
+	functions := []Item{}
+	for _, fd := range sds.Definition.Functions {
+		if fd.Public {
+			functions = addFunction(functions, fd)
+		}
+	}
+	item.Members = append(item.Members, functions...)
+
+	return item
+}
+
+func constName(lv ast.LValue) string {
+	names := []string{}
+	for _, ilv := range ast.DeclaredLValues(lv) {
+		names = append(names, ilv.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Print the documentation of modules as plain text
+func WriteText(w io.Writer, mds []Module) {
+	for i, md := range mds {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "module %v\n", md.Id.Key())
+
+		for _, item := range md.Items {
+			fmt.Fprintln(w)
+			writeTextItem(w, item, "")
+		}
+	}
+}
+
+func writeTextItem(w io.Writer, item Item, indent string) {
+	for _, signature := range item.Signatures {
+		fmt.Fprintf(w, "%v%v\n", indent, signature)
+	}
+	for _, line := range docLines(item.Doc) {
+		fmt.Fprintf(w, "%v    %v\n", indent, line)
+	}
+
+	for i, member := range item.Members {
+		// fields are listed together
+		if i == 0 || member.Kind != KItemField {
+			fmt.Fprintln(w)
+		}
+		writeTextItem(w, member, indent+"    ")
+	}
+}
+
+func docLines(doc string) []string {
+	if doc == "" {
+		return nil
+	}
+	return strings.Split(doc, "\n")
+}
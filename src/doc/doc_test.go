@@ -0,0 +1,34 @@
+package doc
+
+import (
+	"bytes"
+	"testing"
+
+	"eyot/errors"
+	"eyot/parser"
+	"eyot/token"
+)
+
+func TestCollect(t *testing.T) {
+	src := "/// the size\nconst size = 2\n\n/// hidden\nfn f() {}\n\n/// on the cpu\nexport cpu fn g(a, b i64) i64 { return a }\n\n/// or the gpu\nexport gpu fn g(a, b i64) i64 { return b }\n\n// not a doc\nexport struct P {\n\t/// across\n\tpub x f64\n\n\t/// private\n\ty f64\n\n\t/// reset it\n\tpub cpu fn clear() {}\n\n\tfn tidy() {}\n}"
+
+	tkns, err := token.Tokenise(src)
+	if err != nil {
+		t.Fatalf("Tokenise failed with error: %v", err)
+	}
+
+	es := errors.NewErrors()
+	m := parser.NewParser(nil, []string{"m"}, tkns, es, map[string]bool{}, nil).Module()
+	if m == nil || !es.Clean() {
+		t.Fatalf("Parse failed: %v", es.Shown())
+	}
+	m.Id = []string{"m"}
+
+	buf := bytes.NewBuffer([]byte{})
+	WriteText(buf, []Module{Collect(m)})
+
+	expected := "module m\n\nconst size = 2\n    the size\n\ncpu fn g(a, b i64) i64\ngpu fn g(a, b i64) i64\n    on the cpu\n\nstruct P\n\n    x f64\n        across\n\n    cpu fn clear()\n        reset it\n"
+	if buf.String() != expected {
+		t.Fatalf("Documentation is %q, expecting %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,176 @@
+package doc
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where a module's page goes in the output folder, as in std/math.md
+func pagePath(md Module, extension string) string {
+	return filepath.Join(md.Id...) + extension
+}
+
+// The relative path from a module's page back to the top of the output folder
+func toTop(md Module) string {
+	return strings.Repeat("../", len(md.Id)-1)
+}
+
+func writePage(dir, path string, contents []byte) error {
+	path = filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0666)
+}
+
+/*
+Write a Markdown page for each module and an index.md linking them into dir
+
+The pages are laid out by module, as in std/math.md, so dir can be inside an mkdocs docs folder
+*/
+func WriteMarkdown(dir string, mds []Module) error {
+	index := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(index, "# Modules\n\n")
+
+	for _, md := range mds {
+		path := pagePath(md, ".md")
+		fmt.Fprintf(index, "- [%v](%v)\n", md.Id.Key(), filepath.ToSlash(path))
+
+		page := bytes.NewBuffer([]byte{})
+		fmt.Fprintf(page, "# %v\n", md.Id.Key())
+		if len(md.Items) == 0 {
+			fmt.Fprintf(page, "\nNothing is exported from this module\n")
+		}
+		for _, item := range md.Items {
+			writeMarkdownItem(page, item, "##", "")
+		}
+
+		if err := writePage(dir, path, page.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return writePage(dir, "index.md", index.Bytes())
+}
+
+func writeMarkdownItem(w *bytes.Buffer, item Item, heading, prefix string) {
+	fmt.Fprintf(w, "\n%v %v%v\n\n", heading, prefix, item.Name)
+	fmt.Fprintf(w, "```eyot\n%v\n```\n", strings.Join(item.Signatures, "\n"))
+	if item.Doc != "" {
+		fmt.Fprintf(w, "\n%v\n", item.Doc)
+	}
+
+	fields := false
+	for _, member := range item.Members {
+		if member.Kind != KItemField {
+			continue
+		}
+
+		if !fields {
+			fmt.Fprintf(w, "\n")
+			fields = true
+		}
+		fmt.Fprintf(w, "- `%v`", member.Signatures[0])
+		if member.Doc != "" {
+			// a list item can't hold separate lines
+			fmt.Fprintf(w, " - %v", strings.Join(docLines(member.Doc), " "))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	for _, member := range item.Members {
+		if member.Kind != KItemField {
+			writeMarkdownItem(w, member, heading+"#", item.Name+".")
+		}
+	}
+}
+
+// Write an HTML page for each module and an index.html linking them into dir
+func WriteHTML(dir string, mds []Module) error {
+	index := bytes.NewBuffer([]byte{})
+	writeHTMLStart(index, "Modules")
+	fmt.Fprintf(index, "<h1>Modules</h1>\n")
+	fmt.Fprintf(index, "<ul>\n")
+
+	for _, md := range mds {
+		path := pagePath(md, ".html")
+		fmt.Fprintf(index, "<li><a href=\"%v\">%v</a></li>\n", html.EscapeString(filepath.ToSlash(path)), html.EscapeString(md.Id.Key()))
+
+		page := bytes.NewBuffer([]byte{})
+		writeHTMLStart(page, md.Id.Key())
+		fmt.Fprintf(page, "<p><a href=\"%vindex.html\">Modules</a></p>\n", toTop(md))
+		fmt.Fprintf(page, "<h1>%v</h1>\n", html.EscapeString(md.Id.Key()))
+		if len(md.Items) == 0 {
+			fmt.Fprintf(page, "<p>Nothing is exported from this module</p>\n")
+		}
+		for _, item := range md.Items {
+			writeHTMLItem(page, item, 2, "")
+		}
+		writeHTMLEnd(page)
+
+		if err := writePage(dir, path, page.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(index, "</ul>\n")
+	writeHTMLEnd(index)
+	return writePage(dir, "index.html", index.Bytes())
+}
+
+func writeHTMLStart(w *bytes.Buffer, title string) {
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%v</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; }
+pre { background: #f4f4f4; padding: 0.5em; }
+.doc { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+`, html.EscapeString(title))
+}
+
+func writeHTMLEnd(w *bytes.Buffer) {
+	fmt.Fprintf(w, "</body>\n</html>\n")
+}
+
+func writeHTMLItem(w *bytes.Buffer, item Item, level int, prefix string) {
+	name := html.EscapeString(prefix + item.Name)
+	fmt.Fprintf(w, "<h%v id=\"%v\">%v</h%v>\n", level, name, name, level)
+	fmt.Fprintf(w, "<pre><code>%v</code></pre>\n", html.EscapeString(strings.Join(item.Signatures, "\n")))
+	if item.Doc != "" {
+		fmt.Fprintf(w, "<p class=\"doc\">%v</p>\n", html.EscapeString(item.Doc))
+	}
+
+	fields := []Item{}
+	for _, member := range item.Members {
+		if member.Kind == KItemField {
+			fields = append(fields, member)
+		}
+	}
+	if len(fields) > 0 {
+		fmt.Fprintf(w, "<ul>\n")
+		for _, field := range fields {
+			fmt.Fprintf(w, "<li><code>%v</code>", html.EscapeString(field.Signatures[0]))
+			if field.Doc != "" {
+				fmt.Fprintf(w, " - %v", html.EscapeString(field.Doc))
+			}
+			fmt.Fprintf(w, "</li>\n")
+		}
+		fmt.Fprintf(w, "</ul>\n")
+	}
+
+	for _, member := range item.Members {
+		if member.Kind != KItemField {
+			writeHTMLItem(w, member, level+1, item.Name+".")
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package errors
+
+/*
+The code for every error format, so tools can match on a code that does not change with the wording
+
+When the wording of a message changes its format changes here too, but its code stays the same. Each
+code names exactly one format, which codes_test checks along with every format logged having a code.
+A code names the mistake in a few words, e.g. missing-return, rather than echoing the message
+*/
+var codes = map[string]string{
+	// ast
+	"Mismatched types: expecting %v, got %v":                                             "mismatched-types",
+	"CPU is required for this statement: %v":                                             "cpu-required",
+	"Not available on the GPU: %v":                                                       "not-on-gpu",
+	"GPU is required for this statement: %v":                                             "gpu-required",
+	"Failed to find struct definition for '%v'":                                          "missing-struct-definition",
+	"Cannot partially apply %v arguments to a function of %v arguments":                  "partial-too-many-arguments",
+	"Called expression in partial is not callable":                                       "partial-not-callable",
+	".CalledExpression not an identifier":                                                "called-expression-not-identifier",
+	"Cannot create from closure yet":                                                     "create-from-closure",
+	"cannot cast %v to %v":                                                               "invalid-cast",
+	"SelfTerminal: Could not find a type for self":                                       "self-without-type",
+	"No such gpu builtin %v":                                                             "unknown-gpu-builtin",
+	"Failed to find variable type %v":                                                    "unknown-variable",
+	"Failed to find function %v in current module":                                       "unknown-function",
+	"No default value for '%v' on type %v":                                               "no-default-value",
+	"Cannot create struct %v outside module %v, as its field %v is not pub":              "struct-field-not-pub",
+	"Do not recognise field '%v' on type %v":                                             "unknown-field",
+	"Could not find struct named %v":                                                     "unknown-struct",
+	"Tried to take a field value of a non-struct type in access expression: %v":          "field-of-non-struct",
+	"Mismatched types in binary operator '%v' vs '%v'":                                   "binary-operand-types",
+	"Left hand side of '%%' must be integer":                                             "modulo-left-not-integer",
+	"Right hand side of '%%' must be integer":                                            "modulo-right-not-integer",
+	"Not operator cannot be applied to non-boolean type":                                 "not-operand-not-boolean",
+	"Negation operator cannot be applied to non-numeric type":                            "negate-operand-not-numeric",
+	"Attempting to access a non-vector type %v":                                          "index-non-vector",
+	"Attempting to access a vector using non-integer %v":                                 "index-not-integer",
+	"Currently string resize only supports a single argument":                            "string-resize-argument-count",
+	"String.resize() takes a single integer argument":                                    "string-resize-argument-type",
+	"Vector erase takes one or two arguments":                                            "vector-erase-argument-count",
+	"Vector.erase's first argument should be an integer":                                 "vector-erase-start-type",
+	"Vector.erase's second argument should be an integer":                                "vector-erase-end-type",
+	"Currently vector append only supports a single argument":                            "vector-append-argument-count",
+	"Vector.resize() takes a single integer argument":                                    "vector-resize-argument-type",
+	"print_ln can't handle type '%v' (yet)":                                              "print-unsupported-type",
+	"Expression of type '%v' not callable":                                               "not-callable",
+	"Wrong number of arguments in call expression, have %v, expecting %v":                "wrong-argument-count",
+	"Wrong argument type in call expression, have %v, expecting %v":                      "wrong-argument-type",
+	"Expression not callable: is of type '%v'":                                           "called-type-not-callable",
+	"Attempting to deference %v, only pointers can be deferenced":                        "deref-non-pointer",
+	"Bad type in vector literal. Have %v, expecting %v":                                  "vector-literal-type",
+	"Inconsistent signature for %v. Got %v, expected %v":                                 "inconsistent-signature",
+	"A non-void function must end with a return":                                         "missing-return",
+	"IdentifierLValue.Type Could not find type for '%v': %v":                             "unknown-assigned-variable",
+	"SelfLValue.Type Could not find type":                                                "self-assign-without-type",
+	"DerefLValue is not dereferencing a pointer":                                         "deref-assign-non-pointer",
+	"Cannot assign to a field of a non-struct type: %v":                                  "assign-field-of-non-struct",
+	"Could not find struct of type %v":                                                   "unknown-struct-type",
+	"Could not find field named %v":                                                      "unknown-assigned-field",
+	"Can only index lvalue vectors (%v, %v)":                                             "assign-index-non-vector",
+	"Unable to update scope: %v":                                                         "invalid-declaration",
+	"Unable to reassign %v":                                                              "not-assignable",
+	"Cannot assign to void":                                                              "assign-void",
+	"Cannot assign a variable of type '%v' to type '%v'":                                 "assign-type-mismatch",
+	"IfStatement condition not of boolean type":                                          "if-condition-not-boolean",
+	"Trying to return when not in a function":                                            "return-outside-function",
+	"Mismatched return types cannot return void in a function returning '%v'":            "missing-return-value",
+	"Mismatched return types '%v' != '%v'":                                               "return-type-mismatch",
+	"Trying to send to non-worker type: %v":                                              "send-to-non-worker",
+	"Sent value type %v was not a vector":                                                "send-not-vector",
+	"Sent value type %v was not a pointer":                                               "send-not-pointer",
+	"Sent value type %v does not match the send type of the pipe: %v":                    "send-type-mismatch",
+	"Assert condition of type %v, not bool":                                              "assert-condition-not-bool",
+	"Assert message of type %v, not string":                                              "assert-message-not-string",
+	"Attempting to iterate over something that is not a vector: %v":                      "for-over-non-vector",
+	"%v of struct %v in module %v is not pub":                                            "struct-member-not-pub",
+	"%v is imported from %v, but is also defined in this module":                         "import-redefined",
+	"The device of a gpu worker must be an integer, not '%v'":                            "gpu-device-not-integer",
+	"A create channel expression must be passed something callable":                      "channel-not-callable",
+	"A create channel expression must be passed a function with a single parameter":      "channel-parameter-count",
+	"Worker creation uses type that cannot be passed to GPU '%v'":                        "gpu-worker-type",
+	"Worker creation uses type that cannot be passed to GPU '%v' embedded in '%v'":       "gpu-worker-embedded-type",
+	"A create worker expression must be passed a function name (for now)":                "worker-not-function-name",
+	"Expected a pipe after 'receive'":                                                    "receive-not-pipe",
+	"First argument to pipeline keyword must be a worker expression":                     "pipeline-first-not-worker",
+	"Second argument to pipeline keyword must be a worker expression":                    "pipeline-second-not-worker",
+	"Output from first argument to pipeline must be the same as the input to the second": "pipeline-type-mismatch",
+
+	// cmd
+	"Bench \"%v\" failed: %v": "bench-failed",
+	"File is not formatted, eyot fmt -write would change it from here": "not-formatted",
+	"Module %v not found":    "module-not-found",
+	"Test \"%v\" failed: %v": "test-failed",
+
+	// parser
+	"Expecting '%v' in %v, have %v":                       "unexpected-token",
+	"No open type found after '['":                        "vector-type-missing-element",
+	"No close found for vector type":                      "vector-type-unclosed",
+	"Expecting '(' after worker":                          "worker-type-missing-open",
+	"Expecting ')' after type":                            "worker-type-unclosed",
+	"Did not find type in tuple":                          "tuple-missing-type",
+	"Expecting close curved at the end of a tuple":        "tuple-unclosed",
+	"No identifier found after scope resolution operator": "scope-missing-identifier",
+	"Parser failed to find module %v":                     "unknown-module",
+	"Function %v in module %v is not exported":            "function-not-exported",
+	"Cannot parse a struct literal in this context (not a function, so interpreted that way)": "struct-literal-context",
+	"struct %v in module %v is not exported":                                                  "struct-not-exported",
+	"Do not recognise the scoped identifier in this context":                                  "unknown-scoped-identifier",
+	"No type found after '['":                                                                 "vector-literal-missing-type",
+	"No close square found for vector type":                                                   "vector-literal-type-unclosed",
+	"No open curly found for vector type":                                                     "vector-literal-missing-open",
+	"Expecting expression list in vector literal":                                             "vector-literal-missing-elements",
+	"Expecting closed curly after vector literal":                                             "vector-literal-unclosed",
+	"Expecting an expression after the curved brackets":                                       "parenthesis-missing-expression",
+	"Missing closing curved bracket":                                                          "parenthesis-unclosed",
+	"Expecting expression after command in expression list":                                   "expression-list-missing-expression",
+	"Expecting colon after identifier in struct (%v)":                                         "struct-literal-missing-colon",
+	"Expecting expression after colon in struct":                                              "struct-literal-missing-value",
+	"Expecting ']' in access statement, have %v":                                              "index-unclosed",
+	"Expected an identifier after '.'":                                                        "access-missing-field",
+	"Expecting expression after %v":                                                           "unary-missing-operand",
+	"Expecting RHS expression":                                                                "binary-missing-operand",
+	"Expecting '(' after 'range'":                                                             "range-missing-open",
+	"Expecting expression after 'range('":                                                     "range-missing-expression",
+	"Expecting expression after ','":                                                          "range-missing-argument",
+	"Expecting ')' after 'range'":                                                             "range-unclosed",
+	"Expecting '(' after 'drain'":                                                             "drain-missing-open",
+	"Expecting ')' after expression in 'drain'":                                               "drain-unclosed",
+	"There is no reason to partially apply a function of 0 arguments":                         "partial-no-arguments",
+	"Expecting '(' after 'receive'":                                                           "receive-missing-open",
+	"Expecting ')' after expression in 'receive'":                                             "receive-unclosed",
+	"A worker without cpu or gpu can only be created in a bench with destinations":            "worker-without-location",
+	"Expecting a function after 'worker'":                                                     "worker-missing-function",
+	"Expecting expression after comma in expression list":                                     "argument-list-missing-expression",
+	"No identifier found after dot in LValue":                                                 "assign-missing-field",
+	"Expected an expression after '[' in accessor lvalue":                                     "assign-index-missing-expression",
+	"Expected an ']' in accessor lvalue":                                                      "assign-index-unclosed",
+	"Expecting l-values after comma":                                                          "assign-missing-lvalue",
+	"%v is already imported as %v, use import ... as to give one another name":                "import-name-clash",
+	"%v is not found in module %v":                                                            "import-not-found",
+	"%v is already imported from %v":                                                          "import-duplicate",
+	"Expecting identifer after %v":                                                            "import-missing-module",
+	"Expecting identifer after dot in import":                                                 "import-path-missing-module",
+	"Import cycle found when importing '%v' from '%v'":                                        "import-cycle",
+	"Expecting identifier after struct keyword":                                               "struct-missing-name",
+	"Expecting '{' after struct name":                                                         "struct-missing-open",
+	"Expecting a field or function after pub":                                                 "pub-missing-member",
+	"Expecting '}' after struct":                                                              "struct-unclosed",
+	"Expecting ( after 'send' first expression":                                               "send-missing-open",
+	"Expecting expression after 'send'":                                                       "send-missing-worker",
+	"Expecting comma after 'send' first expression":                                           "send-missing-comma",
+	"Expecting second values after 'send'":                                                    "send-missing-values",
+	"Expecting ) after 'send' first expression":                                               "send-unclosed",
+	"Expecting condition after 'assert'":                                                      "assert-missing-condition",
+	"Expecting comma after 'assert' condition":                                                "assert-missing-comma",
+	"Expecting message after 'assert' condition":                                              "assert-missing-message",
+	"Expecting ) after 'assert' message":                                                      "assert-unclosed",
+	"Expecting identifier after 'for'":                                                        "for-missing-variable",
+	"Expecting ':' after identifier in 'for'":                                                 "for-missing-colon",
+	"Expecting expression after ':' in 'for'":                                                 "for-missing-expression",
+	"Statement block expected after for statement":                                            "for-missing-block",
+	"No lvalue found after let or const":                                                      "let-missing-lvalue",
+	"No equals found in let statement":                                                        "let-missing-equals",
+	"No expression found in let statement":                                                    "let-missing-expression",
+	"Expression expected after modify in place operator":                                      "modify-missing-expression",
+	"Expression expected after assignment":                                                    "assign-missing-expression",
+	"Cannot break outside of a breakable block (e.g. for or while)":                           "break-outside-loop",
+	"Expression expected after while statement":                                               "while-missing-condition",
+	"Statement block expected after while statement":                                          "while-missing-block",
+	"Expression expected after if statement":                                                  "if-missing-condition",
+	"Statement block expected after if statement":                                             "if-missing-block",
+	"Expression expected after elseif statement":                                              "elseif-missing-condition",
+	"Statement block expected after elseif statement":                                         "elseif-missing-block",
+	"Statement block expected after else statement":                                           "else-missing-block",
+	"StatementBlock(): No close curly found":                                                  "block-unclosed",
+	"Expecting identifier after command in parameter list":                                    "parameter-missing-name",
+	"Expecting type after parameters in parameter list":                                       "parameter-missing-type",
+	"Expecting parameters after command in parameter list":                                    "parameter-list-missing-parameter",
+	"FunctionDefinition(): No identifier found":                                               "function-missing-name",
+	"FunctionDefinition(): No open paren found":                                               "function-missing-parameters",
+	"FunctionDefinition(): No close curved found":                                             "function-parameters-unclosed",
+	"FunctionDefinition(): No statement block found following definition":                     "function-missing-body",
+	"Expecting a block after test name":                                                       "test-missing-block",
+	"Expecting cpu or gpu in the destinations of a bench":                                     "bench-destination",
+	"Expecting a block after bench name":                                                      "bench-missing-block",
+	"Expecting EOF, got %v":                                                                   "unexpected-top-level-token",
+	"Expecting EOF":                                                                           "trailing-tokens",
+
+	// program
+	"file not found":                                                   "file-not-found",
+	"No main function found":                                           "no-main",
+	"Main function (%v) should not take arguments %v":                  "main-arguments",
+	"%v is exported from a library, but returns %v which C can't take": "library-return-type",
+	"%v is exported from a library, but takes %v which C can't pass":   "library-parameter-type",
+	"A library should export at least one cpu function":                "library-no-exports",
+}
@@ -0,0 +1,117 @@
+package errors
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCodesUnique(t *testing.T) {
+	formats := map[string]string{}
+	for format, code := range codes {
+		if other, found := formats[code]; found {
+			t.Errorf("Code %v is used by both %q and %q", code, format, other)
+		}
+		formats[code] = format
+
+		if code == KCodeUnlisted || code == KCodeInternal || code == "" {
+			t.Errorf("Format %q has the reserved code %q", format, code)
+		}
+	}
+}
+
+// the argument holding the format, for each way of logging an error
+var formatArgument = map[string]int{
+	"Errorf":                   0,
+	"LogError":                 0,
+	"ErrorfAt":                 1,
+	"KnockOnf":                 1,
+	"ErrorfUnlessStructBroken": 2,
+	"ErrorfUnlessBroken":       3,
+}
+
+// The format an error is logged with, or false when it is passed on from a caller
+func loggedFormat(t *testing.T, fset *token.FileSet, arg ast.Expr) (string, bool) {
+	if id, ok := arg.(*ast.Ident); ok && id.Obj != nil {
+		switch decl := id.Obj.Decl.(type) {
+		case *ast.Field:
+			return "", false
+		case *ast.AssignStmt:
+			if len(decl.Rhs) == 1 {
+				arg = decl.Rhs[0]
+			}
+		}
+	}
+
+	lit, ok := arg.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		t.Errorf("%v: an error's format should be a string literal", fset.Position(arg.Pos()))
+		return "", false
+	}
+
+	format, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		t.Fatalf("%v: %v", fset.Position(arg.Pos()), err)
+	}
+	return format, true
+}
+
+// Every error logged in the compiler has a code, and every code is for an error that is logged
+func TestCodesCoverFormats(t *testing.T) {
+	used := map[string]bool{}
+
+	fset := token.NewFileSet()
+	err := filepath.Walk("..", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			index, ok := formatArgument[sel.Sel.Name]
+			if !ok || index >= len(call.Args) {
+				return true
+			}
+			if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "fmt" {
+				return true
+			}
+
+			format, ok := loggedFormat(t, fset, call.Args[index])
+			if !ok {
+				return true
+			}
+			if _, found := codes[format]; !found {
+				t.Errorf("%v: %q has no code in codes.go", fset.Position(call.Pos()), format)
+			}
+			used[format] = true
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read the source: %v", err)
+	}
+
+	for format := range codes {
+		if !used[format] {
+			t.Errorf("%q has a code in codes.go, but is never logged", format)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package errors
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+The code for messages logged with this format
+
+The codes are listed in codes.go, rather than derived from the wording, so they stay the same when the
+wording changes. A format that is not listed, which codes_test should catch, has the code "error"
+*/
+func CodeFor(format string) string {
+	if code, ok := codes[format]; ok {
+		return code
+	}
+	return KCodeUnlisted
+}
+
+// The code for an error whose format is not listed in codes.go
+const KCodeUnlisted = "error"
+
+// An error in a form for tools rather than people
+type Diagnostic struct {
+	// As it appears in the text output, which is the module name
+	File string `json:"file"`
+
+	// The file on disk, if it is known
+	Path string `json:"path,omitempty"`
+
+	// Line is 0 when there is no location. Columns are 0 when they are not known
+	Line      int `json:"line"`
+	Column    int `json:"column,omitempty"`
+	EndLine   int `json:"endLine,omitempty"`
+	EndColumn int `json:"endColumn,omitempty"`
+
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Activity string   `json:"activity,omitempty"`
+}
+
+// The code used for internal errors, which are bugs or problems with the environment rather than the source
+const KCodeInternal = "internal"
+
+// All errors worth showing as diagnostics, followed by the warnings
+func (es *Errors) Diagnostics() []Diagnostic {
+	ds := []Diagnostic{}
+
+	for _, emsg := range append(es.Shown(), es.warnings...) {
+		sl := emsg.Location
+		d := Diagnostic{
+			File:     sl.Filename,
+			Path:     es.paths[sl.Filename],
+			Line:     sl.Line,
+			Severity: emsg.Severity,
+			Code:     emsg.Code,
+			Message:  emsg.Message,
+			Activity: emsg.Activity,
+		}
+
+		if sl.Line < 0 {
+			// the end of the file is reported on its last line
+			d.Line = len(es.sources[sl.Filename])
+		} else if sl.HasColumn() {
+			d.Column = sl.Column
+			d.EndLine = sl.EndLine
+			d.EndColumn = sl.EndColumn
+		}
+
+		ds = append(ds, d)
+	}
+
+	if es.internalError != nil {
+		ds = append(ds, Diagnostic{
+			Severity: KSeverityError,
+			Code:     KCodeInternal,
+			Message:  es.internalError.Error(),
+		})
+	}
+
+	return ds
+}
+
+// Write the diagnostics as a JSON object, with a "diagnostics" list
+func (es *Errors) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"diagnostics": es.Diagnostics(),
+	})
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	Uri string `json:"uri"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifResult struct {
+	RuleId     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifRule struct {
+	Id string `json:"id"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationUri string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// A path as a SARIF artifact uri, relative to the working directory when that is possible
+func sarifUri(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+
+	return "file://" + filepath.ToSlash(abs)
+}
+
+// Write the diagnostics as a SARIF 2.1.0 log, which code scanning tools accept
+func (es *Errors) WriteSARIF(w io.Writer) error {
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:           "eyot",
+				InformationUri: "https://github.com/steeleduncan/eyot",
+				Rules:          []sarifRule{},
+			},
+		},
+		// columns count runes, as SARIF would otherwise assume UTF-16
+		ColumnKind: "unicodeCodePoints",
+		Results:    []sarifResult{},
+	}
+
+	ruleSeen := map[string]bool{}
+	for _, d := range es.Diagnostics() {
+		if !ruleSeen[d.Code] {
+			ruleSeen[d.Code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{Id: d.Code})
+		}
+
+		r := sarifResult{
+			RuleId:  d.Code,
+			Level:   string(d.Severity),
+			Message: sarifMessage{Text: d.Message},
+		}
+		if d.Activity != "" {
+			r.Properties = map[string]string{"activity": d.Activity}
+		}
+
+		if d.Path != "" {
+			pl := sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{Uri: sarifUri(d.Path)},
+			}
+			if d.Line > 0 {
+				pl.Region = &sarifRegion{
+					StartLine:   d.Line,
+					StartColumn: d.Column,
+					EndLine:     d.EndLine,
+					EndColumn:   d.EndColumn,
+				}
+			}
+			r.Locations = []sarifLocation{{PhysicalLocation: pl}}
+		}
+
+		run.Results = append(run.Results, r)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
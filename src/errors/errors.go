@@ -3,11 +3,19 @@ package errors
 import (
 	"fmt"
 	"io"
+	"os"
+	"strings"
 )
 
+// A span of source, columns count runes from 1 and the end is exclusive
+//
+// A zero column means only the line is known
 type SourceLocation struct {
-	Filename string
-	Line     int
+	Filename  string
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
 }
 
 func (sl *SourceLocation) String() string {
@@ -18,38 +26,180 @@ func (sl *SourceLocation) String() string {
 	}
 }
 
+// True when this has a column, rather than just a line
+func (sl *SourceLocation) HasColumn() bool {
+	return sl.Line > 0 && sl.Column > 0
+}
+
+// True when the position is in the span, or just after it, as a cursor after a name is still on it
+func (sl *SourceLocation) Contains(line, column int) bool {
+	if !sl.HasColumn() {
+		return false
+	}
+
+	if line < sl.Line || (line == sl.Line && column < sl.Column) {
+		return false
+	}
+	if line > sl.EndLine || (line == sl.EndLine && column > sl.EndColumn) {
+		return false
+	}
+	return true
+}
+
+type Severity string
+
+const (
+	KSeverityError   Severity = "error"
+	KSeverityWarning Severity = "warning"
+)
+
 type ErrorMessage struct {
 	Location SourceLocation
 	Message  string
 	Activity string
+	Severity Severity
+
+	// Identifies the kind of message, see CodeFor
+	Code string
+
+	// The text of the first line of the location, empty when it is not known
+	SourceLine string
+
+	// When true this was caused by an earlier error, so it fails the build but is not shown
+	KnockOn bool
 }
 
-func (em *ErrorMessage) String() string {
-	s := fmt.Sprintf("%v: %v", em.Location.String(), em.Message)
-	if em.Activity != "" {
-		s += fmt.Sprintf(" (%v)", em.Activity)
+const (
+	colourReset   = "\x1b[0m"
+	colourError   = "\x1b[1;31m"
+	colourWarning = "\x1b[1;33m"
+	colourFrame   = "\x1b[1;34m"
+)
+
+// Render the error, with a code frame underneath when the column is known
+func (em *ErrorMessage) Render(colour bool) string {
+	paint := func(c, s string) string {
+		if colour {
+			return c + s + colourReset
+		}
+		return s
 	}
+
+	highlight := colourError
+	s := ""
+	if em.Severity == KSeverityWarning {
+		// the code is what -Wno- takes to turn this off
+		highlight = colourWarning
+		s = paint(highlight, fmt.Sprintf("%v: warning: %v [%v]", em.Location.String(), em.Message, em.Code))
+	} else {
+		s = paint(highlight, fmt.Sprintf("%v: %v", em.Location.String(), em.Message))
+		if em.Activity != "" {
+			s += fmt.Sprintf(" (%v)", em.Activity)
+		}
+	}
+
+	if !em.Location.HasColumn() || em.SourceLine == "" {
+		return s
+	}
+
+	/*
+	   In the style of rustc
+
+	     |
+	   3 | let x = foo(1, "a")
+	     |                ^^^
+	*/
+	number := fmt.Sprintf("%v", em.Location.Line)
+	gutter := strings.Repeat(" ", len(number))
+	line := []rune(em.SourceLine)
+
+	start := em.Location.Column - 1
+	if start > len(line) {
+		start = len(line)
+	}
+	end := len(line)
+	if em.Location.EndLine == em.Location.Line && em.Location.EndColumn-1 < end {
+		end = em.Location.EndColumn - 1
+	}
+	if end <= start {
+		end = start + 1
+	}
+
+	// keep tabs so the carets line up with the source however tabs are displayed
+	padding := []rune{}
+	for _, r := range line[:start] {
+		if r == '\t' {
+			padding = append(padding, '\t')
+		} else {
+			padding = append(padding, ' ')
+		}
+	}
+
+	s += "\n" + paint(colourFrame, gutter+" |")
+	s += "\n" + paint(colourFrame, number+" |") + " " + em.SourceLine
+	s += "\n" + paint(colourFrame, gutter+" |") + " " + string(padding) + paint(highlight, strings.Repeat("^", end-start))
 	return s
 }
 
+func (em *ErrorMessage) String() string {
+	return em.Render(false)
+}
+
 type Errors struct {
 	errorMessages     []ErrorMessage
 	lastKnownLocation SourceLocation
 	internalError     error
 	activity          string
+
+	// the lines of each file, so errors can show the source
+	sources map[string][]string
+
+	// where each file is on disk
+	paths map[string]string
+
+	// errors before this are ignored by Clean, see Checkpoint
+	cleanFrom int
+
+	// the most errors LogErrors will print, 0 for no limit
+	limit int
+
+	// warnings do not fail the build, unless warningsAsErrors is set
+	warnings         []ErrorMessage
+	warningsAsErrors bool
+	disabledWarnings map[string]bool
 }
 
 func NewErrors() *Errors {
 	return &Errors{
 		errorMessages: []ErrorMessage{},
 		internalError: nil,
+		sources:       map[string][]string{},
+		paths:         map[string]string{},
+		limit:         DefaultLimit,
+
+		warnings:         []ErrorMessage{},
+		disabledWarnings: map[string]bool{},
 	}
 }
 
+// How many errors are printed unless SetLimit is called
+const DefaultLimit = 20
+
+// Set the most errors LogErrors will print, 0 prints them all
+func (es *Errors) SetLimit(limit int) {
+	es.limit = limit
+}
+
 func (es *Errors) SetActivity(a string) {
 	es.activity = a
 }
 
+// Remember the path and text of a file, so errors in it can show a code frame
+func (es *Errors) AddSource(filename, path, text string) {
+	es.sources[filename] = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	es.paths[filename] = path
+}
+
 // return any internal error
 func (es *Errors) InternalError() error {
 	return es.internalError
@@ -64,21 +214,170 @@ func (es *Errors) SetCurrentLocation(sl SourceLocation) {
 	es.lastKnownLocation = sl
 }
 
+func (es *Errors) sourceLine(sl SourceLocation) string {
+	lines, fnd := es.sources[sl.Filename]
+	if !fnd || sl.Line < 1 || sl.Line > len(lines) {
+		return ""
+	}
+	return lines[sl.Line-1]
+}
+
+// True when the same message was already logged at sl, as when one block of source is checked more than once
+func repeated(ems []ErrorMessage, sl SourceLocation, message string) bool {
+	for _, em := range ems {
+		if em.Location == sl && em.Message == message {
+			return true
+		}
+	}
+	return false
+}
+
 func (es *Errors) Errorf(format string, args ...interface{}) {
+	es.ErrorfAt(es.lastKnownLocation, format, args...)
+}
+
+// Log an error at a specific location, falling back to the last known location if it has no line
+func (es *Errors) ErrorfAt(sl SourceLocation, format string, args ...interface{}) {
+	if sl.Line == 0 {
+		sl = es.lastKnownLocation
+	}
+
 	em := ErrorMessage{
-		Location: es.lastKnownLocation,
-		Message:  fmt.Sprintf(format, args...),
-		Activity: es.activity,
+		Location:   sl,
+		Message:    fmt.Sprintf(format, args...),
+		Activity:   es.activity,
+		Severity:   KSeverityError,
+		Code:       CodeFor(format),
+		SourceLine: es.sourceLine(sl),
+	}
+	if repeated(es.errorMessages, sl, em.Message) {
+		return
 	}
 	es.errorMessages = append(es.errorMessages, em)
 }
 
+/*
+Log a warning, which is a likely mistake that does not stop the program building
+
+Unlike errors, warnings have a code chosen by the caller, as that is the name used to disable them
+*/
+func (es *Errors) Warnf(sl SourceLocation, code string, format string, args ...interface{}) {
+	if es.disabledWarnings[code] {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+	if repeated(es.warnings, sl, message) || repeated(es.errorMessages, sl, message) {
+		return
+	}
+
+	if es.warningsAsErrors {
+		es.ErrorfAt(sl, format, args...)
+		es.errorMessages[len(es.errorMessages)-1].Code = code
+		return
+	}
+
+	es.warnings = append(es.warnings, ErrorMessage{
+		Location:   sl,
+		Message:    message,
+		Severity:   KSeverityWarning,
+		Code:       code,
+		SourceLine: es.sourceLine(sl),
+	})
+}
+
+// Stop warnings with this code being logged
+func (es *Errors) DisableWarning(code string) {
+	es.disabledWarnings[code] = true
+}
+
+// When true warnings are logged as errors, so they fail the build
+func (es *Errors) SetWarningsAsErrors(werror bool) {
+	es.warningsAsErrors = werror
+}
+
+// All warnings logged so far
+func (es *Errors) Warnings() []ErrorMessage {
+	return es.warnings
+}
+
+// Log an error that only happened because of an earlier one
+//
+// This keeps the build failing without burying the real mistake under messages about its consequences
+func (es *Errors) KnockOnf(sl SourceLocation, format string, args ...interface{}) {
+	count := len(es.errorMessages)
+	es.ErrorfAt(sl, format, args...)
+
+	// a repeated message is not logged again, and the one before it is not a knock on
+	if len(es.errorMessages) > count {
+		es.errorMessages[count].KnockOn = true
+	}
+}
+
 func (es *Errors) Clean() bool {
 	if es.internalError != nil {
 		return false
 	}
 
-	return len(es.errorMessages) == 0
+	return len(es.errorMessages) == es.cleanFrom
+}
+
+// The number of errors logged so far
+func (es *Errors) Count() int {
+	return len(es.errorMessages)
+}
+
+/*
+Start a fresh section, where Clean only considers errors logged after this
+
+This lets a caller check one statement and carry on to the next if that fails.
+Pass the result to Restore at the end of the section
+*/
+func (es *Errors) Checkpoint() int {
+	previous := es.cleanFrom
+	es.cleanFrom = len(es.errorMessages)
+	return previous
+}
+
+func (es *Errors) Restore(checkpoint int) {
+	es.cleanFrom = checkpoint
+}
+
+// The errors worth showing, which leaves out knock on errors
+func (es *Errors) Shown() []ErrorMessage {
+	shown := []ErrorMessage{}
+	for _, emsg := range es.errorMessages {
+		if !emsg.KnockOn {
+			shown = append(shown, emsg)
+		}
+	}
+	return shown
+}
+
+// colour is only used on a terminal, and never when NO_COLOR is set
+func wantsColour(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// print human readable warnings
+func (es *Errors) LogWarnings(w io.Writer) {
+	colour := wantsColour(w)
+	for _, wmsg := range es.warnings {
+		fmt.Fprintln(w, wmsg.Render(colour))
+	}
 }
 
 // print human readable errors
@@ -89,8 +388,14 @@ func (es *Errors) LogErrors(w io.Writer) bool {
 		return true
 	}
 
-	for _, emsg := range es.errorMessages {
-		fmt.Fprintln(w, emsg.String())
+	colour := wantsColour(w)
+	shown := es.Shown()
+	for i, emsg := range shown {
+		if es.limit > 0 && i == es.limit {
+			fmt.Fprintf(w, "... and %v more errors (use -max-errors to see more)\n", len(shown)-i)
+			break
+		}
+		fmt.Fprintln(w, emsg.Render(colour))
 	}
 
 	return false
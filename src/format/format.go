@@ -0,0 +1,841 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"eyot/ast"
+	"eyot/errors"
+	"eyot/token"
+)
+
+/*
+Print a module as it was written, in the canonical layout
+
+The module must be as it was parsed, as checking rewrites the tree. Comments are placed by their
+lines, either on a line of their own before what follows them, or after what they were written
+after. Single blank lines between statements and top level elements are kept
+*/
+func Format(m *ast.Module, comments []token.Comment) []byte {
+	p := &printer{
+		comments:    comments,
+		atLineStart: true,
+		blockStart:  true,
+	}
+
+	for _, tlec := range m.TopLevelElements {
+		p.topLevelElement(tlec.TopLevelElement)
+	}
+	p.finish()
+
+	return p.buf.Bytes()
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+
+	// true when nothing has been written to the current line, so it needs indenting
+	atLineStart bool
+
+	comments []token.Comment
+
+	// the first comment not yet printed
+	nextComment int
+
+	// the source line the last thing printed ended on, to see where blank lines were
+	lastLine int
+
+	// true before the first thing in a block, where blank lines are dropped
+	blockStart bool
+}
+
+func (p *printer) write(s string) {
+	if p.atLineStart {
+		p.buf.WriteString(strings.Repeat("\t", p.indent))
+		p.atLineStart = false
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) writef(format string, args ...interface{}) {
+	p.write(fmt.Sprintf(format, args...))
+}
+
+func (p *printer) endLine() {
+	p.buf.WriteString("\n")
+	p.atLineStart = true
+}
+
+// Keep a blank line before something starting at line, if there was one in the source
+func (p *printer) separate(line int) {
+	if !p.blockStart && p.lastLine > 0 && line > p.lastLine+1 {
+		p.endLine()
+	}
+	p.blockStart = false
+}
+
+// Print the comments that come before a position
+func (p *printer) commentsBefore(line, column int) {
+	for p.nextComment < len(p.comments) {
+		c := p.comments[p.nextComment]
+		if c.Line > line || (c.Line == line && c.Column >= column) {
+			break
+		}
+		p.nextComment += 1
+
+		p.separate(c.Line)
+		p.write(c.Text)
+		if c.EndLine < line {
+			p.endLine()
+		} else {
+			// a block comment on the same line as what follows it
+			p.write(" ")
+		}
+		p.lastLine = c.EndLine
+	}
+}
+
+// Start something that is on a line of its own
+func (p *printer) leading(sl errors.SourceLocation) {
+	if sl.Line == 0 {
+		return
+	}
+
+	p.commentsBefore(sl.Line, sl.Column)
+	p.separate(sl.Line)
+}
+
+/*
+End the line of something that ended on line
+
+Comments up to the end of line follow it, which includes any that were inside it, as expressions
+are always printed on one line
+*/
+func (p *printer) trailing(line int) {
+	afterLineComment := false
+	for p.nextComment < len(p.comments) {
+		c := p.comments[p.nextComment]
+		if c.Line > line {
+			break
+		}
+		p.nextComment += 1
+
+		if afterLineComment {
+			p.endLine()
+			p.write(c.Text)
+		} else {
+			p.write(" " + c.Text)
+		}
+		afterLineComment = strings.HasPrefix(c.Text, "//")
+
+		if c.EndLine > line {
+			line = c.EndLine
+		}
+	}
+
+	p.endLine()
+	if line > p.lastLine {
+		p.lastLine = line
+	}
+}
+
+// Print the comments left at the end of the file
+func (p *printer) finish() {
+	for ; p.nextComment < len(p.comments); p.nextComment += 1 {
+		c := p.comments[p.nextComment]
+		p.separate(c.Line)
+		p.write(c.Text)
+		p.endLine()
+		p.lastLine = c.EndLine
+	}
+}
+
+// Print a block, from its opening brace up to its closing one
+func (p *printer) block(b *ast.StatementBlock) {
+	p.write("{")
+	p.trailing(b.Span.Line)
+
+	p.indent += 1
+	p.blockStart = true
+	for _, sc := range b.Statements {
+		p.statement(sc.Statement)
+	}
+
+	// the comments before the closing brace are still inside the block
+	p.commentsBefore(b.Span.EndLine, b.Span.EndColumn-1)
+	p.indent -= 1
+	p.write("}")
+	p.blockStart = false
+}
+
+func (p *printer) topLevelElement(rtle ast.TopLevelElement) {
+	if _, ok := rtle.(*ast.DummyTle); ok {
+		return
+	}
+	if ftle, ok := rtle.(*ast.FunctionDefinitionTle); ok && ftle.Definition.Bench != nil && ftle.Definition.Bench.Variant > 0 {
+		// the same source as the bench's first function
+		return
+	}
+
+	sl := ast.LocationOf(rtle)
+	p.leading(sl)
+
+	switch tle := rtle.(type) {
+	case *ast.ImportElement:
+		if len(tle.Symbols) > 0 {
+			p.writef("from %v import %v", strings.Join(tle.Names, "::"), strings.Join(tle.Symbols, ", "))
+			break
+		}
+		p.writef("import %v", strings.Join(tle.Names, "::"))
+		if tle.ImportAs != tle.Names[len(tle.Names)-1] {
+			p.writef(" as %v", tle.ImportAs)
+		}
+
+	case *ast.ConstTle:
+		p.assign(tle.Assign)
+
+	case *ast.FunctionDefinitionTle:
+		p.functionDefinition(tle.Definition)
+
+	case *ast.StructDefinitionStatement:
+		p.structDefinition(tle)
+
+	default:
+		panic(fmt.Sprintf("Cannot format top level element %v", rtle))
+	}
+
+	p.trailing(sl.EndLine)
+}
+
+func (p *printer) functionDefinition(fd *ast.FunctionDefinition) {
+	if fd.Test != "" {
+		p.writef("test \"%v\" ", fd.Test)
+		p.block(fd.Block)
+		return
+	}
+
+	if fd.Bench != nil {
+		p.writef("bench \"%v\" ", fd.Bench.Name)
+		for i, dest := range fd.Bench.Destinations {
+			if i == 0 {
+				p.write("for ")
+			} else {
+				p.write(", ")
+			}
+
+			if dest == ast.KDestinationCpu {
+				p.write("cpu")
+			} else {
+				p.write("gpu")
+			}
+			if i == len(fd.Bench.Destinations)-1 {
+				p.write(" ")
+			}
+		}
+		p.block(fd.Block)
+		return
+	}
+
+	if fd.Public {
+		p.write("pub ")
+	}
+	if fd.Exported {
+		p.write("export ")
+	}
+
+	p.write(Signature(fd) + " ")
+	p.block(fd.Block)
+}
+
+// A function's signature as it is written, from where it runs up to the return type, as in cpu fn add(a, b i64) i64
+func Signature(fd *ast.FunctionDefinition) string {
+	location := ""
+	switch fd.Location {
+	case ast.KLocationCpu:
+		location = "cpu "
+
+	case ast.KLocationGpu:
+		location = "gpu "
+	}
+
+	signature := fmt.Sprintf("%vfn %v(%v)", location, fd.Id.Name, parameterList(fd.Parameters))
+	if fd.Return.Selector != ast.KTypeVoid {
+		signature += " " + typeString(fd.Return)
+	}
+	return signature
+}
+
+// A const as it is written, which must be as it was parsed
+func Const(as *ast.AssignStatement) string {
+	p := &printer{}
+	p.assign(as)
+	return p.buf.String()
+}
+
+// A type as it is written
+func Type(ty ast.Type) string {
+	return typeString(ty)
+}
+
+// Parameters that share a type are written together, as a, b i64
+func parameterList(ps []ast.FunctionParameter) string {
+	segments := []string{}
+	for i, param := range ps {
+		ty := typeString(param.Type)
+		if i+1 < len(ps) && typeString(ps[i+1].Type) == ty {
+			segments = append(segments, param.Name)
+		} else {
+			segments = append(segments, param.Name+" "+ty)
+		}
+	}
+	return strings.Join(segments, ", ")
+}
+
+// Fields and functions are printed in the order they were written, so each needs to know where it was
+type structMember struct {
+	sl errors.SourceLocation
+
+	// fields written on one line with a shared type, as in x, y f64
+	fields []ast.StructField
+
+	function *ast.FunctionDefinition
+}
+
+func (p *printer) structDefinition(sds *ast.StructDefinitionStatement) {
+	if sds.Exported {
+		p.write("export ")
+	}
+	p.writef("struct %v {", sds.Id.Name)
+	p.trailing(sds.Span.Line)
+
+	members := []structMember{}
+	for _, field := range sds.Definition.Fields {
+		last := len(members) - 1
+		if last >= 0 && members[last].fields != nil {
+			previous := members[last].fields[len(members[last].fields)-1]
+			if previous.Span.Line == field.Span.Line && typeString(previous.Type) == typeString(field.Type) && previous.Public == field.Public {
+				members[last].fields = append(members[last].fields, field)
+				continue
+			}
+		}
+
+		members = append(members, structMember{sl: field.Span, fields: []ast.StructField{field}})
+	}
+	for _, fd := range sds.Definition.Functions {
+		members = append(members, structMember{sl: fd.Span, function: fd})
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		a, b := members[i].sl, members[j].sl
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+
+	p.indent += 1
+	p.blockStart = true
+	for _, member := range members {
+		p.leading(member.sl)
+		if member.function != nil {
+			p.functionDefinition(member.function)
+			p.trailing(member.function.Block.Span.EndLine)
+			continue
+		}
+
+		names := []string{}
+		for _, field := range member.fields {
+			names = append(names, field.Name)
+		}
+		if member.fields[0].Public {
+			p.write("pub ")
+		}
+		p.writef("%v %v", strings.Join(names, ", "), typeString(member.fields[0].Type))
+		p.trailing(member.sl.EndLine)
+	}
+
+	p.commentsBefore(sds.Span.EndLine, sds.Span.EndColumn-1)
+	p.indent -= 1
+	p.write("}")
+	p.blockStart = false
+}
+
+func (p *printer) statement(rs ast.Statement) {
+	if _, ok := rs.(*ast.DummyStatement); ok {
+		return
+	}
+
+	sl := ast.LocationOf(rs)
+	p.leading(sl)
+
+	switch s := rs.(type) {
+	case *ast.AssignStatement:
+		p.assign(s)
+
+	case *ast.ModifyInPlaceStatement:
+		p.lvalue(s.Modified)
+		p.writef(" %v ", modifyOperators[s.Operator])
+		p.expression(s.Expression)
+
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression)
+
+	case *ast.ReturnStatement:
+		p.write("return")
+		if s.ReturnedValue != nil {
+			p.write(" ")
+			p.expression(s.ReturnedValue)
+		}
+
+	case *ast.BreakStatement:
+		p.write("break")
+
+	case *ast.AssertStatement:
+		p.write("assert(")
+		p.expression(s.Condition)
+		p.write(", ")
+		p.expression(s.Message)
+		p.write(")")
+
+	case *ast.SendPipeStatement:
+		p.write("send(")
+		p.expression(s.Pipe)
+		p.write(", ")
+		p.expression(s.Value)
+		p.write(")")
+
+	case *ast.WhileStatement:
+		p.write("while ")
+		p.expression(s.Condition)
+		p.write(" ")
+		p.block(s.Block)
+
+	case *ast.ForeachStatement:
+		p.writef("for %v: ", s.TemporaryVariableName)
+		p.expression(s.Iterable)
+		p.write(" ")
+		p.block(s.Body)
+
+	case *ast.IfStatement:
+		for i, segment := range s.Segments {
+			switch {
+			case i == 0:
+				p.write("if ")
+
+			case segment.Condition != nil:
+				p.write(" elseif ")
+
+			default:
+				p.write(" else ")
+			}
+
+			if segment.Condition != nil {
+				p.expression(segment.Condition)
+				p.write(" ")
+			}
+			p.block(segment.Block)
+		}
+
+	default:
+		panic(fmt.Sprintf("Cannot format statement %v", rs))
+	}
+
+	p.trailing(sl.EndLine)
+}
+
+func (p *printer) assign(as *ast.AssignStatement) {
+	switch as.Type {
+	case ast.KAssignLet:
+		p.write("let ")
+
+	case ast.KAssignConst:
+		p.write("const ")
+	}
+
+	p.lvalue(as.Lhs)
+	p.write(" = ")
+	p.expression(as.Rhs)
+}
+
+var modifyOperators = map[ast.ModifyOperator]string{
+	ast.KModifyPlus:   "+=",
+	ast.KModifyMinus:  "-=",
+	ast.KModifyTimes:  "*=",
+	ast.KModifyDivide: "/=",
+}
+
+var binaryOperators = map[ast.BinaryOperator]string{
+	ast.KOperatorAdd:        "+",
+	ast.KOperatorSubtract:   "-",
+	ast.KOperatorMultiply:   "*",
+	ast.KOperatorDivide:     "/",
+	ast.KOperatorMod:        "%",
+	ast.KOperatorEquality:   "==",
+	ast.KOperatorInequality: "!=",
+	ast.KOperatorLT:         "<",
+	ast.KOperatorLTE:        "<=",
+	ast.KOperatorGT:         ">",
+	ast.KOperatorGTE:        ">=",
+	ast.KOperatorAnd:        "and",
+	ast.KOperatorOr:         "or",
+}
+
+func (p *printer) lvalue(rlv ast.LValue) {
+	switch lv := rlv.(type) {
+	case *ast.IdentifierLValue:
+		p.write(lv.Name)
+
+	case *ast.SelfLValue:
+		p.write("self")
+
+	case *ast.AccessorLValue:
+		p.lvalue(lv.Inner)
+		p.write("." + lv.FieldName)
+
+	case *ast.IndexLValue:
+		p.lvalue(lv.Indexed)
+		p.write("[")
+		p.expression(lv.Index)
+		p.write("]")
+
+	case *ast.DerefLValue:
+		p.write("*")
+		p.lvalue(lv.Inner)
+
+	case *ast.MultipleLValue:
+		for i, inner := range lv.LValues {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.lvalue(inner)
+		}
+
+	default:
+		panic(fmt.Sprintf("Cannot format lvalue %v", rlv))
+	}
+}
+
+/*
+An expression, which is printed on one line
+
+Brackets are kept where they were written, and never added, so the expression parses back the same
+way. Struct and vector literals that were written over several lines are the exception, and keep a
+line per element
+*/
+func (p *printer) expression(e ast.Expression) {
+	bracketed := false
+	if l, ok := e.(ast.Locatable); ok {
+		bracketed = l.IsBracketed()
+	}
+
+	if bracketed {
+		p.write("(")
+	}
+	p.unbracketedExpression(e)
+	if bracketed {
+		p.write(")")
+	}
+}
+
+func (p *printer) expressionList(es []ast.Expression) {
+	for i, e := range es {
+		if i > 0 {
+			p.write(", ")
+		}
+
+		if e == nil {
+			// a placeholder in a partial application
+			p.write("_")
+		} else {
+			p.expression(e)
+		}
+	}
+}
+
+func (p *printer) unbracketedExpression(re ast.Expression) {
+	switch e := re.(type) {
+	case *ast.IntegerTerminal:
+		p.writef("%v", e.Value)
+
+	case *ast.FloatTerminal:
+		fraction := strings.Repeat("0", int(e.Zeros))
+		if e.RValue != 0 {
+			fraction += fmt.Sprint(e.RValue)
+		}
+		if fraction == "" {
+			fraction = "0"
+		}
+
+		p.writef("%v.%v", e.LValue, fraction)
+		if e.Width == 32 {
+			p.write("f")
+		}
+
+	case *ast.StringTerminal:
+		p.write("\"" + e.Value + "\"")
+
+	case *ast.CharacterTerminal:
+		p.write("'" + characterEscapes(e.CodePoint) + "'")
+
+	case *ast.BooleanTerminal:
+		p.writef("%v", e.Value)
+
+	case *ast.NullLiteral:
+		p.write("null")
+
+	case *ast.SelfTerminal:
+		p.write("self")
+
+	case *ast.GpuBuiltinTerminal:
+		p.write("gpubuiltin::" + e.Name)
+
+	case *ast.IdentifierTerminal:
+		if e.Qualifier != "" {
+			p.write(e.Qualifier + "::")
+		}
+		p.write(e.Name)
+
+	case *ast.StructLiteralExpression:
+		if e.Qualifier != "" {
+			p.write(e.Qualifier + "::")
+		}
+		p.write(e.Id.Name + " ")
+
+		elements := []element{}
+		for _, pair := range e.Pairs {
+			pair := pair
+			elements = append(elements, element{
+				sl: ast.LocationOf(pair.Value),
+				print: func() {
+					p.write(pair.FieldName + ": ")
+					p.expression(pair.Value)
+				},
+			})
+		}
+		p.elements(e.Span, elements)
+
+	case *ast.VectorLiteralExpression:
+		p.write("[" + typeString(e.ElementType) + "]")
+
+		elements := []element{}
+		for _, init := range e.Initialisers {
+			init := init
+			elements = append(elements, element{
+				sl: ast.LocationOf(init),
+				print: func() {
+					p.expression(init)
+				},
+			})
+		}
+		p.elements(e.Span, elements)
+
+	case *ast.TupleExpression:
+		p.expressionList(e.Expressions)
+
+	case *ast.BinaryExpression:
+		p.expression(e.Lhs)
+		p.writef(" %v ", binaryOperators[e.Operator])
+		p.expression(e.Rhs)
+
+	case *ast.UnaryExpression:
+		if e.Operator == ast.KOperatorNot {
+			p.write("not ")
+		} else {
+			p.write("-")
+		}
+		p.expression(e.Rhs)
+
+	case *ast.CastExpression:
+		p.expression(e.Casted)
+		p.write(" as " + typeString(e.NewType))
+
+	case *ast.CallExpression:
+		p.expression(e.CalledExpression)
+		p.write("(")
+		p.expressionList(e.Arguments)
+		p.write(")")
+
+	case *ast.IndexExpression:
+		p.expression(e.Indexed)
+		p.write("[")
+		p.expression(e.Index)
+		p.write("]")
+
+	case *ast.AccessExpression:
+		p.expression(e.Accessed)
+		p.write("." + e.Identifier)
+
+	case *ast.NewExpression:
+		p.write("new ")
+		p.expression(e.Initialiser)
+
+	case *ast.DereferenceExpression:
+		p.write("*")
+		p.expression(e.Pointer)
+
+	case *ast.RangeExpression:
+		// the parser fills in the start and step when they are left out, and those have no location
+		p.write("range(")
+		switch {
+		case ast.LocationOf(e.Start).Line == 0:
+			p.expression(e.Count)
+
+		case ast.LocationOf(e.Step).Line == 0:
+			p.expressionList([]ast.Expression{e.Start, e.Count})
+
+		default:
+			p.expressionList([]ast.Expression{e.Start, e.Count, e.Step})
+		}
+		p.write(")")
+
+	case *ast.ClosureExpression:
+		p.write("partial ")
+		p.expression(e.CalledExpression)
+		p.write("(")
+		p.expressionList(e.SuppliedArguments)
+		p.write(")")
+
+	case *ast.CreateWorkerExpression:
+		if e.FromBench {
+			p.write("worker ")
+		} else if e.Destination == ast.KDestinationCpu {
+			p.write("cpu ")
+		} else if e.Device != nil {
+			p.write("gpu(")
+			p.expression(e.Device)
+			p.write(") ")
+		} else {
+			p.write("gpu ")
+		}
+		p.expression(e.Worker)
+
+	case *ast.CreatePipelineExpression:
+		p.write("pipeline ")
+		p.expression(e.LhsWorker)
+		p.write(" ")
+		p.expression(e.RhsWorker)
+
+	case *ast.ReceiveWorkerExpression:
+		if e.All {
+			p.write("drain(")
+		} else {
+			p.write("receive(")
+		}
+		p.expression(e.Worker)
+		p.write(")")
+
+	default:
+		panic(fmt.Sprintf("Cannot format expression %v", re))
+	}
+}
+
+// Characters that need escaping in a character literal
+func characterEscapes(codePoint int64) string {
+	switch codePoint {
+	case 9:
+		return "\\t"
+
+	case 10:
+		return "\\n"
+
+	case 13:
+		return "\\r"
+	}
+
+	return string(rune(codePoint))
+}
+
+// An element of a struct or vector literal
+type element struct {
+	sl    errors.SourceLocation
+	print func()
+}
+
+// The braced elements of a literal, where each gets its own line if they were written that way
+func (p *printer) elements(sl errors.SourceLocation, elements []element) {
+	if len(elements) == 0 {
+		p.write("{}")
+		return
+	}
+
+	if sl.Line == sl.EndLine {
+		p.write("{ ")
+		for i, element := range elements {
+			if i > 0 {
+				p.write(", ")
+			}
+			element.print()
+		}
+		p.write(" }")
+		return
+	}
+
+	p.write("{")
+	p.trailing(sl.Line)
+
+	p.indent += 1
+	p.blockStart = true
+	for _, element := range elements {
+		p.leading(element.sl)
+		element.print()
+		p.write(",")
+		p.trailing(element.sl.EndLine)
+	}
+	p.commentsBefore(sl.EndLine, sl.EndColumn-1)
+	p.indent -= 1
+	p.write("}")
+	p.blockStart = false
+}
+
+// A type as it is written
+func typeString(ty ast.Type) string {
+	switch ty.Selector {
+	case ast.KTypeInteger:
+		return "i64"
+
+	case ast.KTypeFloat:
+		return fmt.Sprintf("f%v", ty.Width)
+
+	case ast.KTypeBoolean:
+		return "bool"
+
+	case ast.KTypeCharacter:
+		return "char"
+
+	case ast.KTypeString:
+		return "string"
+
+	case ast.KTypeStruct:
+		return ty.StructId.Name
+
+	case ast.KTypeVector:
+		return "[" + typeString(ty.Types[0]) + "]"
+
+	case ast.KTypePointer:
+		// vectors are always pointers, so [i64] is a pointer to a vector
+		if ty.Types[0].Selector == ast.KTypeVector {
+			return typeString(ty.Types[0])
+		}
+		return "*" + typeString(ty.Types[0])
+
+	case ast.KTypeTuple:
+		types := []string{}
+		for _, inner := range ty.Types {
+			types = append(types, typeString(inner))
+		}
+		return "(" + strings.Join(types, ", ") + ")"
+
+	case ast.KTypeWorker:
+		// the type sent is left out when there is only the type received
+		sent := ""
+		if len(ty.Types) == 2 {
+			sent = typeString(ty.Types[0])
+		}
+		received := ty.Types[len(ty.Types)-1]
+		if received.Selector == ast.KTypeVoid {
+			return "worker(" + sent + ")"
+		}
+		return "worker(" + sent + ") " + typeString(received)
+	}
+
+	panic(fmt.Sprintf("Cannot format type %v", ty))
+}
@@ -0,0 +1,74 @@
+package format
+
+import (
+	"testing"
+
+	"eyot/errors"
+	"eyot/parser"
+	"eyot/token"
+)
+
+func formatSource(t *testing.T, src string) string {
+	tkns, comments, err := token.TokeniseWithComments(src)
+	if err != nil {
+		t.Fatalf("Tokenise failed with error: %v", err)
+	}
+
+	es := errors.NewErrors()
+	m := parser.NewParser(nil, []string{"<unittest>"}, tkns, es, map[string]bool{}, nil).Module()
+	if m == nil || !es.Clean() {
+		t.Fatalf("Parse failed: %v", es.Shown())
+	}
+
+	return string(Format(m, comments))
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		src, expected string
+	}{
+		{
+			"fn add(a i64, b i64) i64 {\n    return (a+b)*2\n}",
+			"fn add(a, b i64) i64 {\n\treturn (a + b) * 2\n}\n",
+		},
+		{
+			"// lead\n\n\nstruct P {\n  x, y f64 // shared\n  z f64\n}",
+			"// lead\n\nstruct P {\n\tx, y f64 // shared\n\tz f64\n}\n",
+		},
+		{
+			"cpu fn main() {\n\n  let v = [i64]{1,2}\n  for i: range(0, 3) { print_ln(i) }\n  /* end */\n}",
+			"cpu fn main() {\n\tlet v = [i64]{ 1, 2 }\n\tfor i: range(0, 3) {\n\t\tprint_ln(i)\n\t}\n\t/* end */\n}\n",
+		},
+		{
+			"fn f() {\n  if not (true) { return } elseif 1 > 2 { } else { send(p, 1.50f) }\n}",
+			"fn f() {\n\tif not (true) {\n\t\treturn\n\t} elseif 1 > 2 {\n\t} else {\n\t\tsend(p, 1.50f)\n\t}\n}\n",
+		},
+		{
+			"fn f() {\n  let c = pipeline cpu g gpu(1) partial h(_, 'x')\n  let p = P {\n    a: 1, // one\n    b: drain(c) }\n}",
+			"fn f() {\n\tlet c = pipeline cpu g gpu(1) partial h(_, 'x')\n\tlet p = P {\n\t\ta: 1, // one\n\t\tb: drain(c),\n\t}\n}\n",
+		},
+		{
+			"test  \"adds up\"{\n  assert(1+1 == 2, \"maths\")\n}",
+			"test \"adds up\" {\n\tassert(1 + 1 == 2, \"maths\")\n}\n",
+		},
+		{
+			"bench \"squares\" for cpu ,gpu {\nlet w = worker square\n}",
+			"bench \"squares\" for cpu, gpu {\n\tlet w = worker square\n}\n",
+		},
+		{
+			"export struct V {\n  pub x, y f64\n  count i64\n  pub  cpu fn norm() f64 { return self.x }\n}",
+			"export struct V {\n\tpub x, y f64\n\tcount i64\n\tpub cpu fn norm() f64 {\n\t\treturn self.x\n\t}\n}\n",
+		},
+	}
+
+	for _, c := range cases {
+		formatted := formatSource(t, c.src)
+		if formatted != c.expected {
+			t.Fatalf("Formatting %q gave %q, expecting %q", c.src, formatted, c.expected)
+		}
+
+		if again := formatSource(t, formatted); again != formatted {
+			t.Fatalf("Formatting %q again gave %q", formatted, again)
+		}
+	}
+}
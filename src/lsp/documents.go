@@ -0,0 +1,235 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"eyot/ast"
+	"eyot/errors"
+	"eyot/program"
+)
+
+// An open document
+type document struct {
+	uri  string
+	path string
+	text string
+
+	// true when the text has changed since it was last checked
+	dirty bool
+
+	// the last check that parsed, which is kept while the text does not parse
+	last *checked
+}
+
+// A module that parsed, and the program it was checked as the root of
+type checked struct {
+	program *program.Program
+	module  *ast.Module
+}
+
+func newDocument(uri, text string) *document {
+	return &document{
+		uri:   uri,
+		path:  uriToPath(uri),
+		text:  text,
+		dirty: true,
+	}
+}
+
+func (d *document) setText(text string) {
+	d.text = text
+	d.dirty = true
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	path := u.Path
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		// file:///C:/x is /C:/x
+		path = path[1:]
+	}
+	return filepath.Clean(filepath.FromSlash(path))
+}
+
+func pathToUri(path string) string {
+	slashed := filepath.ToSlash(path)
+	if !strings.HasPrefix(slashed, "/") {
+		slashed = "/" + slashed
+	}
+	return (&url.URL{Scheme: "file", Path: slashed}).String()
+}
+
+func splitLines(text string) []string {
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+/*
+Convert a line and rune column, both counting from 1 as in errors.SourceLocation, to a position
+
+Positions count UTF-16 code units, so the line is needed to convert the column
+*/
+func toPosition(lines []string, line, column int) Position {
+	pos := Position{Line: line - 1, Character: column - 1}
+	if line < 1 || line > len(lines) {
+		return pos
+	}
+
+	pos.Character = 0
+	runes := []rune(lines[line-1])
+	for i := 0; i < column-1 && i < len(runes); i += 1 {
+		pos.Character += utf16Length(runes[i])
+	}
+	return pos
+}
+
+// The reverse of toPosition
+func fromPosition(lines []string, pos Position) (int, int) {
+	line := pos.Line + 1
+	if line < 1 || line > len(lines) {
+		return line, pos.Character + 1
+	}
+
+	units, column := 0, 1
+	for _, r := range lines[line-1] {
+		if units >= pos.Character {
+			break
+		}
+		units += utf16Length(r)
+		column += 1
+	}
+	return line, column
+}
+
+func utf16Length(r rune) int {
+	if r >= 0x10000 && utf8.ValidRune(r) {
+		return 2
+	}
+	return 1
+}
+
+func spanToRange(lines []string, sl errors.SourceLocation) Range {
+	if !sl.HasColumn() {
+		// the whole line
+		start := Position{Line: sl.Line - 1}
+		end := toPosition(lines, sl.Line, len([]rune(lineOf(lines, sl.Line)))+1)
+		return Range{Start: start, End: end}
+	}
+
+	return Range{
+		Start: toPosition(lines, sl.Line, sl.Column),
+		End:   toPosition(lines, sl.EndLine, sl.EndColumn),
+	}
+}
+
+func lineOf(lines []string, line int) string {
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return lines[line-1]
+}
+
+// The lines of a file, from its open document if there is one
+func (s *Server) linesOf(path string) []string {
+	for _, doc := range s.documents {
+		if doc.path == path {
+			return splitLines(doc.text)
+		}
+	}
+
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return []string{}
+	}
+	return splitLines(string(blob))
+}
+
+/*
+Check a document as the root of a program, returning the diagnostics by uri
+
+The document always has an entry, so its old diagnostics are replaced even when it is now clean
+*/
+func (s *Server) check(doc *document) map[string][]Diagnostic {
+	env := &program.Environment{
+		Roots:   []string{filepath.Dir(doc.path), s.libraryRoot},
+		Overlay: map[string]string{},
+	}
+	for _, open := range s.documents {
+		env.Overlay[open.path] = open.text
+	}
+
+	es := errors.NewErrors()
+	p := program.NewProgram(env, es)
+	m := parseRoot(p, es, strings.TrimSuffix(filepath.Base(doc.path), ".ey"))
+
+	doc.dirty = false
+	if m != nil {
+		doc.last = &checked{program: p, module: m}
+	}
+
+	byUri := map[string][]Diagnostic{doc.uri: {}}
+	for _, d := range es.Diagnostics() {
+		// internal errors have no file, so are shown at the top of the document
+		path := d.Path
+		if path == "" {
+			path = doc.path
+		}
+
+		uri := pathToUri(path)
+		byUri[uri] = append(byUri[uri], toDiagnostic(d, s.linesOf(path)))
+	}
+
+	return byUri
+}
+
+// Parse and check a module, a crash in the compiler is reported rather than taking down the server
+func parseRoot(p *program.Program, es *errors.Errors, name string) (m *ast.Module) {
+	defer func() {
+		if r := recover(); r != nil {
+			es.LogInternalError(fmt.Errorf("Compiler panic: %v", r))
+			m = nil
+		}
+	}()
+
+	return p.ParseRootModule(name)
+}
+
+func toDiagnostic(d errors.Diagnostic, lines []string) Diagnostic {
+	ld := Diagnostic{
+		Severity: kDiagnosticError,
+		Code:     d.Code,
+		Source:   "eyot",
+		Message:  d.Message,
+	}
+	if d.Severity == errors.KSeverityWarning {
+		ld.Severity = kDiagnosticWarning
+	}
+
+	if d.Line > 0 {
+		ld.Range = spanToRange(lines, errors.SourceLocation{
+			Line:      d.Line,
+			Column:    d.Column,
+			EndLine:   d.EndLine,
+			EndColumn: d.EndColumn,
+		})
+	}
+
+	return ld
+}
+
+// The latest check of a document, checking it again if it has changed
+func (s *Server) current(doc *document) *checked {
+	if doc.dirty {
+		s.check(doc)
+	}
+	return doc.last
+}
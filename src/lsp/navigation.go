@@ -0,0 +1,571 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"eyot/ast"
+	"eyot/errors"
+	"eyot/token"
+)
+
+// Something a name refers to
+type symbol struct {
+	// The module it is declared in
+	module *ast.Module
+
+	// Where it is declared, the whole of the first line when this is a module
+	span errors.SourceLocation
+
+	// The type of a variable, or nil if it is not a variable
+	ty *ast.Type
+}
+
+// The start of a module, which is where a module name leads
+var moduleStart = errors.SourceLocation{Line: 1, Column: 1, EndLine: 1, EndColumn: 1}
+
+/*
+The tokens of the text, and the index of the one at the position
+
+A token under the position is preferred to one that ends at it, so in "f(x" the position of the
+"(" is on it rather than the end of "f"
+*/
+func tokenAt(text string, line, column int) ([]token.Token, int, bool) {
+	tkns, err := token.Tokenise(text)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	ending := -1
+	for i, tkn := range tkns {
+		if tkn.Line != line || tkn.Column == tkn.EndColumn {
+			// inserted semicolons have no width
+			continue
+		}
+
+		if column >= tkn.Column && column < tkn.EndColumn {
+			return tkns, i, true
+		}
+		if column == tkn.EndColumn {
+			ending = i
+		}
+	}
+
+	return tkns, ending, ending >= 0
+}
+
+// The module imported under this name
+func importNamed(m *ast.Module, name string) (*ast.Module, bool) {
+	for _, tlec := range m.TopLevelElements {
+		if ie, ok := tlec.TopLevelElement.(*ast.ImportElement); ok && ie.ImportAs == name {
+			return ie.Mod, ie.Mod != nil
+		}
+	}
+	return nil, false
+}
+
+// A function or struct imported by name from another module, as in from geo import area
+func importedSymbol(m *ast.Module, name string) (symbol, bool) {
+	for _, tlec := range m.TopLevelElements {
+		if ie, ok := tlec.TopLevelElement.(*ast.ImportElement); ok && ie.Mod != nil {
+			for _, imported := range ie.Symbols {
+				if imported == name {
+					return memberOf(ie.Mod, name)
+				}
+			}
+		}
+	}
+	return symbol{}, false
+}
+
+// A function, struct or const declared at the top level of a module
+func memberOf(m *ast.Module, name string) (symbol, bool) {
+	for _, tlec := range m.TopLevelElements {
+		switch tle := tlec.TopLevelElement.(type) {
+		case *ast.FunctionDefinitionTle:
+			if tle.Definition.Id.Name == name {
+				return symbol{module: m, span: tle.Definition.Span}, true
+			}
+
+		case *ast.StructDefinitionStatement:
+			if tle.Id.Name == name {
+				return symbol{module: m, span: tle.Span}, true
+			}
+
+		case *ast.ConstTle:
+			for _, ilv := range ast.DeclaredLValues(tle.Assign.Lhs) {
+				if ilv.Name == name {
+					ty := ilv.Type()
+					return symbol{module: m, span: ilv.Span, ty: &ty}, true
+				}
+			}
+		}
+	}
+
+	return symbol{}, false
+}
+
+/*
+The function whose body holds the position, which is the last to start before it
+
+While text is being typed it often won't parse, and the module is from before the edit. Taking the
+last function to start means a position just past the end of the old body is still in it
+*/
+func enclosingFunction(m *ast.Module, line, column int) (*ast.FunctionDefinition, bool) {
+	var found *ast.FunctionDefinition
+	ast.Walk(m, func(l ast.Locatable) {
+		fd, ok := l.(*ast.FunctionDefinition)
+		if !ok || fd.Block == nil || !fd.Block.Span.HasColumn() {
+			return
+		}
+
+		start := fd.Block.Span
+		if start.Line > line || (start.Line == line && start.Column > column) {
+			return
+		}
+		if found == nil || start.Line > found.Block.Span.Line || (start.Line == found.Block.Span.Line && start.Column > found.Block.Span.Column) {
+			found = fd
+		}
+	})
+	return found, found != nil
+}
+
+// The variable or parameter named from inside a function body
+func localDeclaration(m *ast.Module, name string, line, column int) (symbol, bool) {
+	fd, fnd := enclosingFunction(m, line, column)
+	if !fnd {
+		return symbol{}, false
+	}
+
+	before := func(sl errors.SourceLocation) bool {
+		return sl.HasColumn() && (sl.EndLine < line || (sl.EndLine == line && sl.EndColumn <= column))
+	}
+
+	// blocks are outermost first, so a later match is declared closer to the position
+	// the body is always searched, as it may be from before the text was edited
+	var best *symbol
+	blocks := append([]ast.Locatable{fd.Block}, ast.NodesAt(fd.Block, line, column)...)
+	for _, n := range blocks {
+		switch node := n.(type) {
+		case *ast.StatementBlock:
+			for _, sc := range node.Statements {
+				as, ok := sc.Statement.(*ast.AssignStatement)
+				if !ok || as.Type == ast.KAssignNormal || !before(as.Span) {
+					continue
+				}
+
+				for _, ilv := range ast.DeclaredLValues(as.Lhs) {
+					if ilv.Name == name {
+						ty := ilv.Type()
+						best = &symbol{module: m, span: ilv.Span, ty: &ty}
+					}
+				}
+			}
+
+		case *ast.ForeachStatement:
+			if node.TemporaryVariableName == name && node.Body.Span.Contains(line, column) {
+				ty := node.IteratedType
+				best = &symbol{module: m, span: node.Span, ty: &ty}
+			}
+		}
+	}
+	if best != nil {
+		return *best, true
+	}
+
+	for _, param := range fd.Parameters {
+		if param.Name == name {
+			ty := param.Type
+			return symbol{module: m, span: fd.Span, ty: &ty}, true
+		}
+	}
+
+	return symbol{}, false
+}
+
+// What a name refers to at a position, looking at local declarations first
+func resolveName(m *ast.Module, name string, line, column int) (symbol, bool) {
+	if sym, fnd := localDeclaration(m, name, line, column); fnd {
+		return sym, true
+	}
+	if sym, fnd := memberOf(m, name); fnd {
+		return sym, true
+	}
+	if sym, fnd := importedSymbol(m, name); fnd {
+		return sym, true
+	}
+	if mod, fnd := importNamed(m, name); fnd {
+		return symbol{module: mod, span: moduleStart}, true
+	}
+	return symbol{}, false
+}
+
+// The module a struct is declared in
+func (c *checked) moduleOf(id ast.StructId) (*ast.Module, bool) {
+	if id.Module.Key() == c.module.Id.Key() {
+		return c.module, true
+	}
+	m, fnd := c.program.Modules[id.Module.Key()]
+	return m, fnd
+}
+
+// The declaration of a struct type, looking through a pointer to it
+func (c *checked) structOf(ty ast.Type) (*ast.StructDefinitionStatement, *ast.Module, bool) {
+	ty = ty.Unwrapped()
+	if ty.Selector != ast.KTypeStruct {
+		return nil, nil, false
+	}
+
+	m, fnd := c.moduleOf(ty.StructId)
+	if !fnd {
+		return nil, nil, false
+	}
+
+	sds, fnd := m.LookupStruct(ty.StructId.Name)
+	return sds, m, fnd
+}
+
+// The type of what is before a '.', found from the name before it
+func (c *checked) receiverType(tkns []token.Token, dot int, line, column int) (ast.Type, bool) {
+	if dot < 1 {
+		return ast.Type{}, false
+	}
+
+	receiver := tkns[dot-1]
+	switch receiver.Type {
+	case token.Self:
+		fd, fnd := enclosingFunction(c.module, line, column)
+		if !fnd || fd.Id.Struct.Blank() {
+			return ast.Type{}, false
+		}
+		return ast.Type{Selector: ast.KTypeStruct, StructId: fd.Id.Struct}, true
+
+	case token.Identifier:
+		sym, fnd := resolveName(c.module, receiver.Tval, line, column)
+		if !fnd || sym.ty == nil {
+			return ast.Type{}, false
+		}
+		return *sym.ty, true
+	}
+
+	return ast.Type{}, false
+}
+
+// What the name at a position refers to
+func (c *checked) resolve(text string, line, column int) (symbol, bool) {
+	tkns, i, fnd := tokenAt(text, line, column)
+	if !fnd || tkns[i].Type != token.Identifier {
+		return symbol{}, false
+	}
+	name := tkns[i].Tval
+
+	// a module name in mod::member
+	if i+1 < len(tkns) && tkns[i+1].Type == token.ScopeResolution {
+		if mod, fnd := importNamed(c.module, name); fnd {
+			return symbol{module: mod, span: moduleStart}, true
+		}
+		return symbol{}, false
+	}
+
+	// the member in mod::member
+	if i >= 2 && tkns[i-1].Type == token.ScopeResolution && tkns[i-2].Type == token.Identifier {
+		if mod, fnd := importNamed(c.module, tkns[i-2].Tval); fnd {
+			return memberOf(mod, name)
+		}
+		return symbol{}, false
+	}
+
+	// a field or method
+	if i >= 1 && tkns[i-1].Type == token.Dot {
+		ty, fnd := c.receiverType(tkns, i-1, line, column)
+		if !fnd {
+			return symbol{}, false
+		}
+
+		sds, m, fnd := c.structOf(ty)
+		if !fnd {
+			return symbol{}, false
+		}
+
+		for _, fd := range sds.Definition.Functions {
+			if fd.Id.Name == name {
+				return symbol{module: m, span: fd.Span}, true
+			}
+		}
+		for _, field := range sds.Definition.Fields {
+			if field.Name == name {
+				fty := field.Type
+				return symbol{module: m, span: field.Span, ty: &fty}, true
+			}
+		}
+		return symbol{}, false
+	}
+
+	return resolveName(c.module, name, line, column)
+}
+
+func (s *Server) location(c *checked, doc *document, sym symbol) Location {
+	path := doc.path
+	if sym.module != c.module {
+		path = c.program.Env.FindModule(sym.module.Id)
+	}
+
+	return Location{
+		Uri:   pathToUri(path),
+		Range: spanToRange(s.linesOf(path), sym.span),
+	}
+}
+
+func (s *Server) definition(doc *document, pos Position) (Location, bool) {
+	c := s.current(doc)
+	if c == nil {
+		return Location{}, false
+	}
+
+	line, column := fromPosition(splitLines(doc.text), pos)
+	sym, fnd := c.resolve(doc.text, line, column)
+	if !fnd {
+		return Location{}, false
+	}
+
+	return s.location(c, doc, sym), true
+}
+
+// A function as it would be written
+func signature(fd *ast.FunctionDefinition) string {
+	params := []string{}
+	for _, param := range fd.Parameters {
+		params = append(params, fmt.Sprintf("%v %v", param.Name, param.Type.String()))
+	}
+
+	sig := fmt.Sprintf("fn %v(%v)", fd.Id.Name, strings.Join(params, ", "))
+	if fd.Return.Selector != ast.KTypeVoid {
+		sig += " " + fd.Return.String()
+	}
+	return sig
+}
+
+// The text shown when hovering over a node, if it has anything worth showing
+func describe(n ast.Locatable) (desc string, fnd bool) {
+	// types are not always complete when a module failed to check
+	defer func() {
+		if recover() != nil {
+			desc, fnd = "", false
+		}
+	}()
+
+	switch node := n.(type) {
+	case *ast.FunctionDefinition:
+		return signature(node), true
+
+	case *ast.IdentifierLValue:
+		return fmt.Sprintf("%v %v", node.Name, node.Type().String()), true
+
+	case *ast.IdentifierTerminal:
+		return fmt.Sprintf("%v %v", node.Name, node.Type().String()), true
+
+	case ast.Expression:
+		return node.Type().String(), true
+	}
+
+	return "", false
+}
+
+func (s *Server) hover(doc *document, pos Position) (Hover, bool) {
+	c := s.current(doc)
+	if c == nil {
+		return Hover{}, false
+	}
+
+	lines := splitLines(doc.text)
+	line, column := fromPosition(lines, pos)
+
+	for _, tlec := range c.module.TopLevelElements {
+		nodes := ast.NodesAt(tlec.TopLevelElement, line, column)
+		if len(nodes) == 0 {
+			continue
+		}
+
+		// the types in an element that failed to check can't be trusted
+		if tlec.Broken {
+			return Hover{}, false
+		}
+
+		// the innermost node that has something to say
+		for i := len(nodes) - 1; i >= 0; i -= 1 {
+			if desc, fnd := describe(nodes[i]); fnd {
+				value := "```eyot\n" + desc + "\n```"
+				if fd, ok := nodes[i].(*ast.FunctionDefinition); ok && fd.Doc != "" {
+					value += "\n\n" + fd.Doc
+				}
+
+				rng := spanToRange(lines, nodes[i].SourceLocation())
+				return Hover{
+					Contents: MarkupContent{Kind: "markdown", Value: value},
+					Range:    &rng,
+				}, true
+			}
+		}
+	}
+
+	return Hover{}, false
+}
+
+/*
+Complete the members of a module after mod::, or the fields and methods of a struct after a '.'
+
+The partial name being typed is left for the editor to filter on
+*/
+func (s *Server) completion(doc *document, pos Position) []CompletionItem {
+	items := []CompletionItem{}
+
+	c := s.current(doc)
+	if c == nil {
+		return items
+	}
+
+	lines := splitLines(doc.text)
+	line, column := fromPosition(lines, pos)
+
+	// back up over the partial name to the token before it
+	prefix := []rune(lineOf(lines, line))
+	if column-1 < len(prefix) {
+		prefix = prefix[:column-1]
+	}
+	start := len(prefix)
+	for start > 0 && token.IsIdentifier(prefix[start-1]) {
+		start -= 1
+	}
+	tkns, err := token.Tokenise(string(prefix[:start]))
+	if err != nil {
+		return items
+	}
+
+	// the last is always Eof, and may follow an inserted semicolon
+	last := len(tkns) - 2
+	for last >= 0 && tkns[last].Type == token.Semicolon && tkns[last].Column == tkns[last].EndColumn {
+		last -= 1
+	}
+	if last < 0 {
+		return items
+	}
+
+	switch tkns[last].Type {
+	case token.ScopeResolution:
+		if last < 1 || tkns[last-1].Type != token.Identifier {
+			return items
+		}
+		mod, fnd := importNamed(c.module, tkns[last-1].Tval)
+		if !fnd {
+			return items
+		}
+
+		for _, tlec := range mod.TopLevelElements {
+			switch tle := tlec.TopLevelElement.(type) {
+			case *ast.FunctionDefinitionTle:
+				if tle.Definition.Exported {
+					items = append(items, CompletionItem{Label: tle.Definition.Id.Name, Kind: kCompletionFunction, Detail: signature(tle.Definition)})
+				}
+
+			case *ast.StructDefinitionStatement:
+				if tle.Exported {
+					items = append(items, CompletionItem{Label: tle.Id.Name, Kind: kCompletionStruct})
+				}
+			}
+		}
+
+	case token.Dot:
+		ty, fnd := c.receiverType(tkns, last, line, column)
+		if !fnd {
+			return items
+		}
+		sds, _, fnd := c.structOf(ty)
+		if !fnd {
+			return items
+		}
+
+		// only what is pub can be used outside the struct's module
+		local := sds.Id.Module.Key() == c.module.Id.Key()
+		for _, field := range sds.Definition.Fields {
+			if local || field.Public {
+				items = append(items, CompletionItem{Label: field.Name, Kind: kCompletionField, Detail: field.Type.String()})
+			}
+		}
+		for _, fd := range sds.Definition.Functions {
+			if local || fd.Public {
+				items = append(items, CompletionItem{Label: fd.Id.Name, Kind: kCompletionMethod, Detail: signature(fd)})
+			}
+		}
+	}
+
+	return items
+}
+
+func (s *Server) documentSymbols(doc *document) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	c := s.current(doc)
+	if c == nil {
+		return symbols
+	}
+	lines := splitLines(doc.text)
+
+	add := func(name, detail string, kind SymbolKind, sl errors.SourceLocation, children []DocumentSymbol) {
+		if !sl.HasColumn() {
+			return
+		}
+		rng := spanToRange(lines, sl)
+		symbols = append(symbols, DocumentSymbol{
+			Name:           name,
+			Detail:         detail,
+			Kind:           kind,
+			Range:          rng,
+			SelectionRange: rng,
+			Children:       children,
+		})
+	}
+
+	for _, tlec := range c.module.TopLevelElements {
+		switch tle := tlec.TopLevelElement.(type) {
+		case *ast.FunctionDefinitionTle:
+			if tle.Definition.Test != "" {
+				add(fmt.Sprintf("test %q", tle.Definition.Test), "", kSymbolFunction, tle.Definition.Span, nil)
+			} else if bench := tle.Definition.Bench; bench != nil {
+				// each destination has a function of its own, but they are one block in the source
+				if bench.Variant == 0 {
+					add(fmt.Sprintf("bench %q", bench.Name), "", kSymbolFunction, tle.Definition.Span, nil)
+				}
+			} else {
+				add(tle.Definition.Id.Name, signature(tle.Definition), kSymbolFunction, tle.Definition.Span, nil)
+			}
+
+		case *ast.StructDefinitionStatement:
+			children := []DocumentSymbol{}
+			for _, field := range tle.Definition.Fields {
+				frng := spanToRange(lines, field.Span)
+				children = append(children, DocumentSymbol{Name: field.Name, Detail: field.Type.String(), Kind: kSymbolField, Range: frng, SelectionRange: frng})
+			}
+			for _, fd := range tle.Definition.Functions {
+				if fd.Span.HasColumn() {
+					frng := spanToRange(lines, fd.Span)
+					children = append(children, DocumentSymbol{Name: fd.Id.Name, Detail: signature(fd), Kind: kSymbolMethod, Range: frng, SelectionRange: frng})
+				}
+			}
+			add(tle.Id.Name, "", kSymbolStruct, tle.Span, children)
+
+		case *ast.ConstTle:
+			for _, ilv := range ast.DeclaredLValues(tle.Assign.Lhs) {
+				add(ilv.Name, ilv.Type().String(), kSymbolConstant, ilv.Span, nil)
+			}
+
+		case *ast.ImportElement:
+			name := tle.ImportAs
+			if name == "" {
+				name = strings.Join(tle.Symbols, ", ")
+			}
+			add(name, strings.Join(tle.Names, "::"), kSymbolModule, tle.Span, nil)
+		}
+	}
+
+	return symbols
+}
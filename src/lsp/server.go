@@ -0,0 +1,255 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+/*
+A language server, speaking LSP over a pair of streams
+
+Documents are checked with the same parser and passes as the compiler. The text of open documents
+is used in place of what is on disk, so unsaved changes to an imported module are seen by the
+modules that import it
+*/
+type Server struct {
+	out io.Writer
+
+	// where the standard library is, see program.Environment
+	libraryRoot string
+
+	// open documents by uri
+	documents map[string]*document
+
+	// the uris each document last published diagnostics to, so they can be cleared
+	published map[string][]string
+
+	shutdown bool
+}
+
+func NewServer(libraryRoot string) *Server {
+	return &Server{
+		libraryRoot: libraryRoot,
+		documents:   map[string]*document{},
+		published:   map[string][]string{},
+	}
+}
+
+/*
+Serve requests until the client exits
+
+This returns nil when the client shut the server down first, as the protocol expects
+*/
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+	reader := textproto.NewReader(bufio.NewReader(in))
+
+	for {
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return fmt.Errorf("Failed to read header: %v", err)
+		}
+
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return fmt.Errorf("Bad Content-Length: %v", header.Get("Content-Length"))
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader.R, body); err != nil {
+			return fmt.Errorf("Failed to read message: %v", err)
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			return fmt.Errorf("Bad message: %v", err)
+		}
+
+		if req.Method == "exit" {
+			if s.shutdown {
+				return nil
+			}
+			return fmt.Errorf("Exit before shutdown")
+		}
+
+		s.handle(&req)
+	}
+}
+
+func (s *Server) send(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(s.out, "Content-Length: %v\r\n\r\n%s", len(body), body)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.send(notification{JsonRpc: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(req *request) {
+	result, rerr := s.dispatch(req)
+
+	// notifications have no id, and are never answered
+	if req.Id == nil {
+		return
+	}
+
+	if rerr != nil {
+		s.send(errorResponse{JsonRpc: "2.0", Id: req.Id, Error: *rerr})
+	} else {
+		s.send(response{JsonRpc: "2.0", Id: req.Id, Result: result})
+	}
+}
+
+func (s *Server) dispatch(req *request) (interface{}, *responseError) {
+	decode := func(params interface{}) *responseError {
+		if err := json.Unmarshal(req.Params, params); err != nil {
+			return &responseError{Code: kErrorInvalidParams, Message: err.Error()}
+		}
+		return nil
+	}
+
+	switch req.Method {
+	case "initialize":
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync: TextDocumentSyncOptions{
+					OpenClose: true,
+					Change:    kSyncFull,
+					Save:      SaveOptions{IncludeText: false},
+				},
+				DefinitionProvider: true,
+				HoverProvider:      true,
+				CompletionProvider: CompletionOptions{
+					TriggerCharacters: []string{".", ":"},
+				},
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: ServerInfo{Name: "eyot"},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if rerr := decode(&params); rerr != nil {
+			return nil, rerr
+		}
+		doc := newDocument(params.TextDocument.Uri, params.TextDocument.Text)
+		s.documents[doc.uri] = doc
+		s.publish(doc)
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if rerr := decode(&params); rerr != nil {
+			return nil, rerr
+		}
+		doc, fnd := s.documents[params.TextDocument.Uri]
+		if fnd && len(params.ContentChanges) > 0 {
+			doc.setText(params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+
+	case "textDocument/didSave":
+		var params DidSaveTextDocumentParams
+		if rerr := decode(&params); rerr != nil {
+			return nil, rerr
+		}
+		if doc, fnd := s.documents[params.TextDocument.Uri]; fnd {
+			if params.Text != nil {
+				doc.setText(*params.Text)
+			}
+			s.publish(doc)
+		}
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if rerr := decode(&params); rerr != nil {
+			return nil, rerr
+		}
+		delete(s.documents, params.TextDocument.Uri)
+		s.clearDiagnostics(params.TextDocument.Uri, nil)
+
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if rerr := decode(&params); rerr != nil {
+			return nil, rerr
+		}
+		if doc, fnd := s.documents[params.TextDocument.Uri]; fnd {
+			if loc, fnd := s.definition(doc, params.Position); fnd {
+				return loc, nil
+			}
+		}
+
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if rerr := decode(&params); rerr != nil {
+			return nil, rerr
+		}
+		if doc, fnd := s.documents[params.TextDocument.Uri]; fnd {
+			if hover, fnd := s.hover(doc, params.Position); fnd {
+				return hover, nil
+			}
+		}
+
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if rerr := decode(&params); rerr != nil {
+			return nil, rerr
+		}
+		if doc, fnd := s.documents[params.TextDocument.Uri]; fnd {
+			return s.completion(doc, params.Position), nil
+		}
+		return []CompletionItem{}, nil
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if rerr := decode(&params); rerr != nil {
+			return nil, rerr
+		}
+		if doc, fnd := s.documents[params.TextDocument.Uri]; fnd {
+			return s.documentSymbols(doc), nil
+		}
+		return []DocumentSymbol{}, nil
+
+	default:
+		// optional notifications, e.g. $/cancelRequest, can be ignored
+		if req.Id != nil && !strings.HasPrefix(req.Method, "$/") {
+			return nil, &responseError{Code: kErrorMethodNotFound, Message: "Unsupported method " + req.Method}
+		}
+	}
+
+	return nil, nil
+}
+
+// Check a document, and publish what was found
+func (s *Server) publish(doc *document) {
+	byUri := s.check(doc)
+
+	uris := []string{}
+	for uri, ds := range byUri {
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{Uri: uri, Diagnostics: ds})
+		uris = append(uris, uri)
+	}
+
+	s.clearDiagnostics(doc.uri, byUri)
+	s.published[doc.uri] = uris
+}
+
+// Clear the diagnostics a document last published, other than those in keep
+func (s *Server) clearDiagnostics(docUri string, keep map[string][]Diagnostic) {
+	for _, uri := range s.published[docUri] {
+		if _, fnd := keep[uri]; !fnd {
+			s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{Uri: uri, Diagnostics: []Diagnostic{}})
+		}
+	}
+	delete(s.published, docUri)
+}
@@ -0,0 +1,232 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A client talking to a Server over in memory pipes
+type testClient struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	nextId int
+	served chan error
+
+	// what the server sent, read as it is sent so the server never blocks on writing
+	messages chan testMessage
+
+	// every notification received so far
+	notifications []notification
+}
+
+type testMessage struct {
+	Id     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+	Result json.RawMessage  `json:"result"`
+	Error  *responseError   `json:"error"`
+}
+
+func newTestClient(t *testing.T) *testClient {
+	root, err := filepath.Abs(filepath.Join("..", "..", "lib"))
+	if err != nil {
+		t.Fatalf("No library root: %v", err)
+	}
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &testClient{
+		t:        t,
+		in:       clientOut,
+		served:   make(chan error, 1),
+		messages: make(chan testMessage, 100),
+	}
+	go func() {
+		c.served <- NewServer(root).Serve(serverIn, serverOut)
+		serverOut.Close()
+	}()
+	go readMessages(textproto.NewReader(bufio.NewReader(clientIn)), c.messages)
+
+	return c
+}
+
+func (c *testClient) write(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatalf("Failed to encode %v: %v", msg, err)
+	}
+	if _, err := io.WriteString(c.in, "Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body)); err != nil {
+		c.t.Fatalf("Failed to send message: %v", err)
+	}
+}
+
+// Read messages until the server closes its output
+func readMessages(out *textproto.Reader, messages chan<- testMessage) {
+	defer close(messages)
+
+	for {
+		header, err := out.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(out.R, body); err != nil {
+			return
+		}
+
+		var msg testMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return
+		}
+		messages <- msg
+	}
+}
+
+func (c *testClient) read() testMessage {
+	msg, ok := <-c.messages
+	if !ok {
+		c.t.Fatalf("The server stopped sending")
+	}
+	return msg
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	c.write(notification{JsonRpc: "2.0", Method: method, Params: params})
+}
+
+// Send a request and decode its result, keeping any notifications sent before it
+func (c *testClient) call(method string, params interface{}, result interface{}) {
+	c.nextId += 1
+	id := json.RawMessage(strconv.Itoa(c.nextId))
+	c.write(map[string]interface{}{"jsonrpc": "2.0", "id": &id, "method": method, "params": params})
+
+	for {
+		msg := c.read()
+		if msg.Id == nil {
+			c.notifications = append(c.notifications, notification{Method: msg.Method, Params: msg.Params})
+			continue
+		}
+
+		if string(*msg.Id) != string(id) {
+			c.t.Fatalf("Response to %s, expecting %s", *msg.Id, id)
+		}
+		if msg.Error != nil {
+			c.t.Fatalf("%v failed: %v", method, msg.Error.Message)
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("Bad %v result %s: %v", method, msg.Result, err)
+			}
+		}
+		return
+	}
+}
+
+// The diagnostics last published for a uri
+func (c *testClient) diagnostics(uri string) []Diagnostic {
+	var ds []Diagnostic
+	for _, n := range c.notifications {
+		if n.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+
+		var params PublishDiagnosticsParams
+		if err := json.Unmarshal(n.Params.(json.RawMessage), &params); err != nil {
+			c.t.Fatalf("Bad diagnostics: %v", err)
+		}
+		if params.Uri == uri {
+			ds = params.Diagnostics
+		}
+	}
+	return ds
+}
+
+func (c *testClient) close() {
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.served; err != nil {
+		c.t.Fatalf("Server did not exit cleanly: %v", err)
+	}
+}
+
+const testSource = `fn double(x i64) i64 {
+    return x * 2
+}
+
+fn main() {
+    let y = double(2)
+    print_ln(y)
+}
+
+fn broken() {
+    let z = 1 + "two"
+}
+`
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.ey")
+	if err := os.WriteFile(path, []byte(testSource), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	uri := pathToUri(path)
+
+	c := newTestClient(t)
+	defer c.close()
+
+	var init InitializeResult
+	c.call("initialize", map[string]interface{}{"rootUri": pathToUri(dir)}, &init)
+	if !init.Capabilities.DefinitionProvider || !init.Capabilities.HoverProvider {
+		t.Fatalf("Missing capabilities: %+v", init.Capabilities)
+	}
+
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{Uri: uri, Version: 1, Text: testSource},
+	})
+
+	// the position of `double(` in main
+	var loc Location
+	c.call("textDocument/definition", TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{Uri: uri},
+		Position:     Position{Line: 5, Character: 13},
+	}, &loc)
+	if loc.Uri != uri || loc.Range.Start.Line != 0 {
+		t.Fatalf("Wrong definition of double: %+v", loc)
+	}
+
+	// didOpen has been handled by now, as requests are answered in order
+	ds := c.diagnostics(uri)
+	if len(ds) != 1 {
+		t.Fatalf("Expecting one diagnostic, have %+v", ds)
+	}
+	d := ds[0]
+	if d.Severity != kDiagnosticError || d.Range.Start.Line != 10 || !strings.Contains(d.Message, "Mismatched types") {
+		t.Fatalf("Wrong diagnostic: %+v", d)
+	}
+
+	// the y in print_ln(y)
+	var hover Hover
+	c.call("textDocument/hover", TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{Uri: uri},
+		Position:     Position{Line: 6, Character: 13},
+	}, &hover)
+	if !strings.Contains(hover.Contents.Value, "y i64") {
+		t.Fatalf("Wrong hover: %+v", hover)
+	}
+	if hover.Range == nil || hover.Range.Start != (Position{Line: 6, Character: 13}) {
+		t.Fatalf("Wrong hover range: %+v", hover.Range)
+	}
+}
@@ -0,0 +1,136 @@
+package crunner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+/*
+The directory compiled runtimes and ffi objects are kept in between builds, or "" when caching is off
+
+EyotBuildCache sets it, or disables it when 'n', otherwise it sits next to the OpenCL cache
+*/
+func BuildCacheDir() string {
+	dir := os.Getenv("EyotBuildCache")
+	if dir == "n" {
+		return ""
+	}
+
+	if dir == "" {
+		if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+			dir = filepath.Join(xdg, "eyot", "build")
+		} else if home, err := os.UserHomeDir(); err == nil && home != "" {
+			dir = filepath.Join(home, ".cache", "eyot", "build")
+		} else {
+			return ""
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return ""
+	}
+	return dir
+}
+
+// A hash of everything that goes into a compiled file, so a change to any of them misses the cache
+type cacheKey struct {
+	h []string
+}
+
+func (k *cacheKey) add(parts ...string) {
+	k.h = append(k.h, parts...)
+}
+
+// Add the contents of the files, failing if any can't be read
+func (k *cacheKey) addFiles(paths ...string) error {
+	for _, path := range paths {
+		blob, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Failed to read %v for the build cache: %v", path, err)
+		}
+		k.add(filepath.Base(path), string(blob))
+	}
+	return nil
+}
+
+func (k *cacheKey) String() string {
+	hash := sha256.New()
+	for _, part := range k.h {
+		// the length keeps the parts from running into each other
+		fmt.Fprintf(hash, "%v:%v", len(part), part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:24]
+}
+
+/*
+The headers of those given that the C source includes by name
+
+A program's unit is keyed only on the headers it includes, so a change to another module's header
+does not miss the cache for it
+*/
+func includedHeaders(src string, headers []string) ([]string, error) {
+	blob, err := os.ReadFile(src)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %v for the build cache: %v", src, err)
+	}
+
+	included := []string{}
+	for _, h := range headers {
+		if bytes.Contains(blob, []byte(`#include "`+filepath.Base(h)+`"`)) {
+			included = append(included, h)
+		}
+	}
+	return included, nil
+}
+
+// Is there a file at path
+func cached(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+/*
+Write a file into the cache through a temporary name, so other builds never see it half written
+
+write creates the file at the path it is given
+*/
+func writeCached(path string, write func(string) error) error {
+	temp := fmt.Sprintf("%v.%v.tmp", path, os.Getpid())
+	defer os.Remove(temp)
+
+	if err := write(temp); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// Run the jobs, as many at a time as there are cpus, returning the first error
+func runParallel(jobs []func() error) error {
+	errs := make([]error, len(jobs))
+	slots := make(chan bool, runtime.NumCPU())
+
+	var wg sync.WaitGroup
+	for ji, job := range jobs {
+		wg.Add(1)
+		go func(ji int, job func() error) {
+			defer wg.Done()
+			slots <- true
+			errs[ji] = job()
+			<-slots
+		}(ji, job)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,7 +1,11 @@
 package crunner
 
 import (
+	"fmt"
 	"io"
+	"os"
+	"sort"
+	"strings"
 )
 
 // A platform agnostic interface for running a C Compiler
@@ -12,9 +16,94 @@ type CRunner interface {
 	// The stream to write the C to
 	WriteStream() io.Writer
 
+	// Create another file next to the main one, which is compiled and linked in with it when it is C source
+	AddFile(name string) (io.Writer, error)
+
 	/*
 	  "Close" the stream for the runner, and guaranteeing it has tried to write C. Return the log on failure
 	  When compiling, if the withOpenCl flag is true, it'll be
 	*/
 	Close(compile bool, withOpenCl bool, defs map[string]string, supportFiles []string, flags map[string]bool) (string, error)
 }
+
+type Profile int
+
+const (
+	// debug info, with the compiler's default optimisation
+	KProfileDefault Profile = iota
+
+	// debug info, and no optimisation
+	KProfileDebug
+
+	// optimised, without debug info
+	KProfileRelease
+)
+
+type Output int
+
+const (
+	// a program, with a main
+	KOutputExecutable Output = iota
+
+	// a library without a main, loaded at run time
+	KOutputShared
+
+	// a library without a main, as an archive to link into another program
+	KOutputStatic
+)
+
+// The sanitizers a program can be built with
+var Sanitizers = []string{"address", "undefined", "thread"}
+
+// How a program is compiled and linked, the same for each runner
+type BuildOptions struct {
+	Profile Profile
+	Output  Output
+
+	// from Sanitizers
+	Sanitize []string
+
+	// passed on to the C compiler and the linker as they are
+	CFlags, LdFlags []string
+}
+
+// Check the sanitizers asked for are known
+func (o BuildOptions) Validate() error {
+	for _, s := range o.Sanitize {
+		known := false
+		for _, sanitizer := range Sanitizers {
+			known = known || s == sanitizer
+		}
+		if !known {
+			return fmt.Errorf("Unknown sanitizer %v, it should be one of %v", s, strings.Join(Sanitizers, ", "))
+		}
+	}
+	return nil
+}
+
+// The sanitizers to build with, where EyotDebug=y asks for address and undefined when none are given
+func (o BuildOptions) sanitizers() []string {
+	if len(o.Sanitize) == 0 && DebugMode() {
+		return []string{"address", "undefined"}
+	}
+	return o.Sanitize
+}
+
+// Is the output a library, and so without a main
+func (o BuildOptions) Library() bool {
+	return o.Output != KOutputExecutable
+}
+
+func DebugMode() bool {
+	return os.Getenv("EyotDebug") == "y"
+}
+
+// The link flags in a fixed order, so the same program always links the same way
+func sortedFlags(flags map[string]bool) []string {
+	sorted := []string{}
+	for flag := range flags {
+		sorted = append(sorted, flag)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
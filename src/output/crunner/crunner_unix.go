@@ -9,27 +9,36 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type CRunnerUnix struct {
 	outBuf    *bytes.Buffer
-	cmd       *exec.Cmd
-	echan     chan error
+	outMutex  sync.Mutex
 	outStream io.WriteCloser
 	ip        string
-	files     []string
 	outFile   string
 	showLog   bool
+	options   BuildOptions
+
+	// the runtime's .c files, and the ffi sources written on close
+	runtimeFiles, ffiFiles []string
+
+	// the files added alongside the main one, and which of them are compiled
+	added []*os.File
+	units []string
 }
 
 var _ CRunner = &CRunnerUnix{}
 
-func NewRunner(includePath string, runtimeFiles []string, showOutput bool) CRunner {
+func NewRunner(includePath string, runtimeFiles []string, showOutput bool, options BuildOptions) CRunner {
 	return &CRunnerUnix{
-		ip:      includePath,
-		files:   runtimeFiles,
-		showLog: showOutput,
+		ip:           includePath,
+		runtimeFiles: runtimeFiles,
+		showLog:      showOutput,
+		options:      options,
 	}
 }
 
@@ -43,8 +52,6 @@ func (cr *CRunnerUnix) Open(outFile string) error {
 		return fmt.Errorf("failed to create eyot main: %v", err)
 	}
 
-	cr.files = append(cr.files, "eyot-main.c")
-
 	return nil
 }
 
@@ -52,12 +59,34 @@ func (cr *CRunnerUnix) WriteStream() io.Writer {
 	return cr.outStream
 }
 
-func DebugMode() bool {
-	return os.Getenv("EyotDebug") == "y"
+func (cr *CRunnerUnix) AddFile(name string) (io.Writer, error) {
+	f, err := os.Create(filepath.Join(cr.ip, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %v: %v", name, err)
+	}
+
+	cr.added = append(cr.added, f)
+	if filepath.Ext(name) == ".c" {
+		cr.units = append(cr.units, name)
+	}
+	return f, nil
+}
+
+// Was the file created by AddFile
+func (cr *CRunnerUnix) wasAdded(name string) bool {
+	for _, f := range cr.added {
+		if filepath.Base(f.Name()) == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (cr *CRunnerUnix) Close(compile bool, withOpenCl bool, defs map[string]string, supportFiles []string, flags map[string]bool) (string, error) {
 	cr.outStream.Close()
+	for _, f := range cr.added {
+		f.Close()
+	}
 
 	for sfi, sf := range supportFiles {
 		fnam := fmt.Sprintf("eyot-ffi-%v.c", sfi)
@@ -66,57 +95,297 @@ func (cr *CRunnerUnix) Close(compile bool, withOpenCl bool, defs map[string]stri
 		if err != nil {
 			return "", fmt.Errorf("Failed to write output file %v: %v", path, err)
 		}
-		cr.files = append(cr.files, fnam)
+		cr.ffiFiles = append(cr.ffiFiles, fnam)
 	}
 
 	if compile {
-		cr.cmd = cr.getCompileCommand(withOpenCl, defs, flags)
-		cr.cmd.Stdout = cr.outBuf
-		cr.cmd.Stderr = cr.outBuf
-
-		err := cr.cmd.Run()
+		err := cr.build(withOpenCl, defs, flags)
 		if err != nil {
 			return cr.outBuf.String(), fmt.Errorf("CC error: %v", err)
 		}
 		if strings.Contains(cr.outBuf.String(), "warning") || cr.showLog {
+			// on stderr, so it doesn't mix with the program's output or a report for tools
 			indent := "  >  "
-			fmt.Println("Build output from C compiler:")
-			fmt.Println(indent + strings.ReplaceAll(cr.outBuf.String(), "\n", "\n"+indent))
-			fmt.Println()
-			fmt.Println()
+			fmt.Fprintln(os.Stderr, "Build output from C compiler:")
+			fmt.Fprintln(os.Stderr, indent+strings.ReplaceAll(cr.outBuf.String(), "\n", "\n"+indent))
+			fmt.Fprintln(os.Stderr)
+			fmt.Fprintln(os.Stderr)
 		}
 	}
 
 	return "", nil
 }
 
-// check for clang
-// NB on macOS 'gcc' actually resolves to clang, but is not compatible with gcc
-func cmdIsClang(cc string) bool {
+/*
+Compile and link the program
+
+The runtime is compiled once into a static archive, and each ffi source and translation unit of the
+program into an object, all kept in the build cache under a hash of their sources, the compiler and
+its flags. Only the main file is compiled every time, and everything that is compiled is compiled in
+parallel.
+*/
+func (cr *CRunnerUnix) build(withOpenCl bool, defs map[string]string, flags map[string]bool) error {
+	cc := compiler()
+	args := cr.compileArgs(cc, withOpenCl)
+
+	cacheDir := BuildCacheDir()
+	if cacheDir == "" {
+		// still build the archive, it just won't outlive this build
+		cacheDir = cr.ip
+	}
+
+	// every compiled file includes the runtime headers, and the program's units some of its own headers too
+	headers, err := filepath.Glob(filepath.Join(cr.ip, "*.h"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(headers)
+
+	runtimeHeaders, programHeaders := []string{}, []string{}
+	for _, h := range headers {
+		if cr.wasAdded(filepath.Base(h)) {
+			programHeaders = append(programHeaders, h)
+		} else {
+			runtimeHeaders = append(runtimeHeaders, h)
+		}
+	}
+
+	keyFor := func(args []string, files ...string) (string, error) {
+		key := &cacheKey{}
+		key.add(cc, compilerVersion(cc))
+		key.add(args...)
+		if err := key.addFiles(append(append([]string{}, runtimeHeaders...), files...)...); err != nil {
+			return "", err
+		}
+		return key.String(), nil
+	}
+
+	jobs := []func() error{}
+
+	// the runtime
+	runtimeSources := []string{}
+	for _, f := range cr.runtimeFiles {
+		runtimeSources = append(runtimeSources, filepath.Join(cr.ip, f))
+	}
+	runtimeKey, err := keyFor(args, runtimeSources...)
+	if err != nil {
+		return err
+	}
+	archive := filepath.Join(cacheDir, "eyot-runtime-"+runtimeKey+".a")
+
+	runtimeObjects := []string{}
+	if !cached(archive) {
+		for _, src := range runtimeSources {
+			src := src
+			obj := strings.TrimSuffix(src, ".c") + ".o"
+			runtimeObjects = append(runtimeObjects, obj)
+			jobs = append(jobs, func() error {
+				return cr.compileObject(cc, args, src, obj)
+			})
+		}
+	}
+
+	// the program's code, which alone needs the definitions
+	programArgs := append([]string{}, args...)
+	defKeys := []string{}
+	for key := range defs {
+		defKeys = append(defKeys, key)
+	}
+	sort.Strings(defKeys)
+	for _, key := range defKeys {
+		programArgs = append(programArgs, "-D"+key+"="+defs[key])
+	}
+
+	// the ffi sources and the program's units, each cached on its own
+	linked := []string{}
+	addObject := func(f string, args []string, headers []string) error {
+		src := filepath.Join(cr.ip, f)
+		key, err := keyFor(args, append(append([]string{}, headers...), src)...)
+		if err != nil {
+			return err
+		}
+
+		obj := filepath.Join(cacheDir, strings.TrimSuffix(f, ".c")+"-"+key+".o")
+		linked = append(linked, obj)
+		if !cached(obj) {
+			jobs = append(jobs, func() error {
+				return writeCached(obj, func(temp string) error {
+					return cr.compileObject(cc, args, src, temp)
+				})
+			})
+		}
+		return nil
+	}
+	for _, f := range cr.ffiFiles {
+		if err := addObject(f, args, nil); err != nil {
+			return err
+		}
+	}
+	for _, f := range cr.units {
+		included, err := includedHeaders(filepath.Join(cr.ip, f), programHeaders)
+		if err != nil {
+			return err
+		}
+		if err := addObject(f, programArgs, included); err != nil {
+			return err
+		}
+	}
+
+	mainObj := filepath.Join(cr.ip, "eyot-main.o")
+	linked = append([]string{mainObj}, linked...)
+	jobs = append(jobs, func() error {
+		return cr.compileObject(cc, programArgs, filepath.Join(cr.ip, "eyot-main.c"), mainObj)
+	})
+
+	if err := runParallel(jobs); err != nil {
+		return err
+	}
+
+	if len(runtimeObjects) > 0 {
+		err := writeCached(archive, func(temp string) error {
+			return cr.run(archiver(), append([]string{"rcs", temp}, runtimeObjects...)...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if cr.options.Output == KOutputStatic {
+		return cr.archive(archive, linked)
+	}
+
+	// objects come before the archive, so the runtime's symbols are pulled in from it as they are needed
+	linkArgs := cr.profileArgs()
+	if cr.options.Output == KOutputShared {
+		linkArgs = append(linkArgs, "-shared")
+	}
+	linkArgs = append(linkArgs, "-o", cr.outFile)
+	linkArgs = append(linkArgs, linked...)
+	linkArgs = append(linkArgs, archive)
+	if withOpenCl {
+		linkArgs = append(linkArgs, openCLArgs()...)
+	}
+	for _, flag := range sortedFlags(flags) {
+		linkArgs = append(linkArgs, flag)
+	}
+	linkArgs = append(linkArgs, cr.options.LdFlags...)
+
+	return cr.run(cc, linkArgs...)
+}
+
+/*
+Put the program's objects and the runtime's into one static archive
+
+The runtime's objects are taken back out of its cached archive, so a program linking the library
+needs nothing else from eyot
+*/
+func (cr *CRunnerUnix) archive(runtimeArchive string, objects []string) error {
+	extractDir := filepath.Join(cr.ip, "eyot-runtime-objects")
+	if err := os.MkdirAll(extractDir, 0777); err != nil {
+		return err
+	}
+
+	extract := exec.Command(archiver(), "x", runtimeArchive)
+	extract.Dir = extractDir
+	if out, err := extract.CombinedOutput(); err != nil {
+		cr.outBuf.Write(out)
+		return err
+	}
+
+	runtimeObjects, err := filepath.Glob(filepath.Join(extractDir, "*.o"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(runtimeObjects)
+
+	// ar adds to an archive that is already there
+	os.Remove(cr.outFile)
+	return cr.run(archiver(), append(append([]string{"rcs", cr.outFile}, objects...), runtimeObjects...)...)
+}
+
+func (cr *CRunnerUnix) compileObject(cc string, args []string, src, obj string) error {
+	return cr.run(cc, append(append([]string{}, args...), "-c", src, "-o", obj)...)
+}
+
+// Run a tool, adding what it prints to the build log
+func (cr *CRunnerUnix) run(name string, args ...string) error {
+	outBuf := bytes.NewBuffer([]byte{})
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = outBuf
+	cmd.Stderr = outBuf
+	err := cmd.Run()
+
+	cr.outMutex.Lock()
+	cr.outBuf.Write(outBuf.Bytes())
+	cr.outMutex.Unlock()
+
+	return err
+}
+
+// should work for gcc, tcc, clang right now
+func compiler() string {
+	if altCC := os.Getenv("CC"); altCC != "" {
+		return altCC
+	}
+	return "gcc"
+}
+
+func archiver() string {
+	if altAR := os.Getenv("AR"); altAR != "" {
+		return altAR
+	}
+	return "ar"
+}
+
+var compilerVersions sync.Map
+
+// What the compiler says its version is, which tells compilers apart for the build cache
+func compilerVersion(cc string) string {
+	if version, fnd := compilerVersions.Load(cc); fnd {
+		return version.(string)
+	}
+
 	outBuf := bytes.NewBuffer([]byte{})
 	cmd := exec.Command(cc, "--version")
 	cmd.Stdout = outBuf
 	if err := cmd.Run(); err != nil {
-		// don't realy
-		return false
+		// no version just means no reuse across compilers that fail to report one
+		return ""
 	}
 
-	return strings.Contains(strings.ToLower(outBuf.String()), "clang")
+	compilerVersions.Store(cc, outBuf.String())
+	return outBuf.String()
 }
 
-func (cr *CRunnerUnix) getCompileCommand(withOpenCl bool, defs map[string]string, flags map[string]bool) *exec.Cmd {
-	// should work for gcc, tcc, clang right now
-	cc := "gcc"
-	if altCC := os.Getenv("CC"); altCC != "" {
-		cc = altCC
-	}
+// check for clang
+// NB on macOS 'gcc' actually resolves to clang, but is not compatible with gcc
+func cmdIsClang(cc string) bool {
+	return strings.Contains(strings.ToLower(compilerVersion(cc)), "clang")
+}
 
+// The flags the profile and sanitizers need, when compiling and when linking
+func (cr *CRunnerUnix) profileArgs() []string {
 	args := []string{}
-	args = append(args, "-g3")
-	if DebugMode() {
-		args = append(args, "-fsanitize=address,undefined")
+	switch cr.options.Profile {
+	case KProfileDefault:
+		args = append(args, "-g3")
+	case KProfileDebug:
+		args = append(args, "-g3", "-O0")
+	case KProfileRelease:
+		args = append(args, "-O2")
+	}
+
+	if sanitizers := cr.options.sanitizers(); len(sanitizers) > 0 {
+		args = append(args, "-fsanitize="+strings.Join(sanitizers, ","))
 	}
 
+	return args
+}
+
+// The flags every file is compiled with
+func (cr *CRunnerUnix) compileArgs(cc string, withOpenCl bool) []string {
+	args := cr.profileArgs()
+
 	if !cr.showLog {
 		if cmdIsClang(cc) {
 			args = append(args, "-Wno-everything")
@@ -125,12 +394,8 @@ func (cr *CRunnerUnix) getCompileCommand(withOpenCl bool, defs map[string]string
 		}
 	}
 
-
 	args = append(args, "-std=c99")
 
-	args = append(args, "-o")
-	args = append(args, cr.outFile)
-
 	if withOpenCl {
 		args = append(args, "-DEYOT_OPENCL_INCLUDED")
 	}
@@ -139,21 +404,13 @@ func (cr *CRunnerUnix) getCompileCommand(withOpenCl bool, defs map[string]string
 		args = append(args, "-DEYOT_SHOW_LOG")
 	}
 
-	for key, value := range defs {
-		args = append(args, "-D"+key+"="+value)
-	}
-
-	for _, f := range cr.files {
-		args = append(args, filepath.Join(cr.ip, f))
+	if cr.options.Library() {
+		args = append(args, "-DEYOT_LIBRARY")
 	}
-
-	if withOpenCl {
-		args = append(args, openCLArgs()...)
-	}
-
-	for flag, _ := range flags {
-		args = append(args, flag)
+	if cr.options.Output == KOutputShared {
+		args = append(args, "-fPIC")
 	}
 
-	return exec.Command(cc, args...)
+	// last, so they can override any of the above
+	return append(args, cr.options.CFlags...)
 }
@@ -7,35 +7,42 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 type CRunnerWindows struct {
-	buildRoot, exePath, tempPath, outPath, runtimePath string
-	outStream                                          io.WriteCloser
-	msvcIncludes, msvcLibs                             []string
-	msvcPath                                           string
+	ip, outFile            string
+	outStream              io.WriteCloser
+	showLog                bool
+	options                BuildOptions
+	msvcIncludes, msvcLibs []string
+	msvcPath               string
+
+	// the runtime's .c files, the ffi sources written on close, and the program's other units
+	runtimeFiles, ffiFiles, units []string
+	added                         []*os.File
 }
 
 var _ CRunner = &CRunnerWindows{}
 
-func NewRunner(buildPath string) CRunner {
+func NewRunner(includePath string, runtimeFiles []string, showOutput bool, options BuildOptions) CRunner {
 	cr := &CRunnerWindows{
+		ip:           includePath,
+		runtimeFiles: runtimeFiles,
+		showLog:      showOutput,
+		options:      options,
 		msvcIncludes: []string{},
 		msvcLibs:     []string{},
-		buildRoot:    buildPath,
-		runtimePath:  filepath.Join(buildPath, "eyot-runtime.c"), // ideally this isn't hard coded
-		tempPath:     filepath.Join(buildPath, fmt.Sprintf("temp-file-%v.c", os.Getpid())),
-		exePath:      filepath.Join(buildPath, fmt.Sprintf("temp-file-%v.exe", os.Getpid())),
 	}
 	cr.setupMsvcPaths()
 	return cr
 }
 
 func (cr *CRunnerWindows) Open(path string) error {
-	cr.outPath = path
-	fh, err := os.Create(cr.tempPath)
+	cr.outFile = path
+	fh, err := os.Create(filepath.Join(cr.ip, "eyot-main.c"))
 	if err != nil {
-		return fmt.Errorf("CRunnerWindows unable to create temporary C file: %v", err)
+		return fmt.Errorf("CRunnerWindows unable to create eyot main: %v", err)
 	}
 	cr.outStream = fh
 
@@ -46,53 +53,139 @@ func (cr *CRunnerWindows) WriteStream() io.Writer {
 	return cr.outStream
 }
 
-func (cr *CRunnerWindows) Close() (string, error) {
+func (cr *CRunnerWindows) AddFile(name string) (io.Writer, error) {
+	f, err := os.Create(filepath.Join(cr.ip, name))
+	if err != nil {
+		return nil, fmt.Errorf("CRunnerWindows unable to create %v: %v", name, err)
+	}
+
+	cr.added = append(cr.added, f)
+	if filepath.Ext(name) == ".c" {
+		cr.units = append(cr.units, name)
+	}
+	return f, nil
+}
+
+func (cr *CRunnerWindows) Close(compile bool, withOpenCl bool, defs map[string]string, supportFiles []string, flags map[string]bool) (string, error) {
 	cr.outStream.Close()
-	//defer os.Remove(cr.tempPath)
+	for _, f := range cr.added {
+		f.Close()
+	}
 
-	outBuf := bytes.NewBuffer([]byte{})
+	for sfi, sf := range supportFiles {
+		fnam := fmt.Sprintf("eyot-ffi-%v.c", sfi)
+		path := filepath.Join(cr.ip, fnam)
+		err := os.WriteFile(path, []byte(sf), 0777)
+		if err != nil {
+			return "", fmt.Errorf("Failed to write output file %v: %v", path, err)
+		}
+		cr.ffiFiles = append(cr.ffiFiles, fnam)
+	}
 
-	os.Chdir(cr.buildRoot)
+	if !compile {
+		return "", nil
+	}
 
-	// source file
-	args := []string{cr.tempPath, cr.runtimePath}
+	if cr.options.Library() {
+		return "", fmt.Errorf("Building libraries is not supported with MSVC yet")
+	}
 
-	// compile options
-	for _, path := range cr.msvcIncludes {
-		args = append(args, "/I", path)
+	args, err := cr.compileArgs(withOpenCl, defs)
+	if err != nil {
+		return "", err
 	}
 
-	// get rid of this file
-	//args = append(args, "/Fo:" + filepath.Join(os.TempDir(), "junk.obj"))
+	// source files, with the objects left in the build folder
+	args = append(args, "/Fo"+cr.ip+`\`)
+	args = append(args, cr.runtimeFiles...)
+	args = append(args, cr.ffiFiles...)
+	args = append(args, cr.units...)
+	args = append(args, "eyot-main.c")
 
 	// swap to linker
 	args = append(args, "/link")
 
 	// linker options
 	for _, path := range cr.msvcLibs {
-		args = append(args, "/LIBPATH", path)
+		args = append(args, "/LIBPATH:"+path)
 	}
-	args = append(args, "/out:"+cr.exePath)
-
-	// TODO linker part needs something to suppress the .obj file (or send it somewhere innocuous)
+	args = append(args, "/out:"+cr.outFile)
+	if withOpenCl {
+		args = append(args, "OpenCL.lib")
+	}
+	for _, flag := range sortedFlags(flags) {
+		if lib, fnd := msvcLibrary(flag); fnd {
+			args = append(args, lib)
+		}
+	}
+	args = append(args, cr.options.LdFlags...)
 
+	outBuf := bytes.NewBuffer([]byte{})
 	cmd := exec.Command(filepath.Join(cr.msvcPath, "cl.exe"), args...)
-
+	cmd.Dir = cr.ip
 	cmd.Stdout = outBuf
 	cmd.Stderr = outBuf
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		return outBuf.String(), fmt.Errorf("CC error: %v", err)
 	}
 
-	os.Rename(cr.exePath, cr.outPath)
+	if cr.showLog {
+		fmt.Fprintln(os.Stderr, "Build output from C compiler:")
+		fmt.Fprintln(os.Stderr, outBuf.String())
+	}
 
 	return "", nil
 }
 
-func (cr *CRunnerWindows) getCompileCommandTcc() *exec.Cmd {
-	return exec.Command("tcc", "-o", cr.outPath, cr.tempPath)
+// The compiler flags, for the profile, sanitizers and definitions
+func (cr *CRunnerWindows) compileArgs(withOpenCl bool, defs map[string]string) ([]string, error) {
+	args := []string{"/nologo"}
+
+	switch cr.options.Profile {
+	case KProfileDefault:
+		args = append(args, "/Zi")
+	case KProfileDebug:
+		args = append(args, "/Zi", "/Od")
+	case KProfileRelease:
+		args = append(args, "/O2")
+	}
+
+	for _, s := range cr.options.sanitizers() {
+		if s != "address" {
+			return nil, fmt.Errorf("The %v sanitizer is not available with MSVC", s)
+		}
+		args = append(args, "/fsanitize=address")
+	}
+
+	if !cr.showLog {
+		args = append(args, "/w")
+	}
+
+	if withOpenCl {
+		args = append(args, "/DEYOT_OPENCL_INCLUDED")
+	}
+	if cr.showLog {
+		args = append(args, "/DEYOT_SHOW_LOG")
+	}
+	for key, value := range defs {
+		args = append(args, "/D"+key+"="+value)
+	}
+
+	for _, path := range cr.msvcIncludes {
+		args = append(args, "/I", path)
+	}
+
+	return append(args, cr.options.CFlags...), nil
+}
+
+// The library to link for an ffi linker flag written for gcc (-lname), if it is not part of the C runtime already
+func msvcLibrary(flag string) (string, bool) {
+	if !strings.HasPrefix(flag, "-l") || flag == "-lm" {
+		return "", false
+	}
+	return flag[len("-l"):] + ".lib", true
 }
 
 func (cr *CRunnerWindows) setupMsvcPaths() {
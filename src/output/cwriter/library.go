@@ -0,0 +1,174 @@
+package cwriter
+
+import (
+	"fmt"
+	"strings"
+
+	"eyot/ast"
+	"eyot/program"
+)
+
+// The prefix of everything in a library's C API, which is its module's name
+func LibraryPrefix(p *program.Program) string {
+	return p.RootModuleId.Namespace()
+}
+
+// The functions a library's C API can call, checked by the program to have C types
+func libraryFunctions(p *program.Program) []*ast.FunctionDefinition {
+	fds := []*ast.FunctionDefinition{}
+	for _, fd := range p.Modules[p.RootModuleId.Key()].Exports() {
+		if fd.Location != ast.KLocationGpu {
+			fds = append(fds, fd)
+		}
+	}
+	return fds
+}
+
+func (cw *CWriter) writeLibraryPrototype(p *program.Program, fd *ast.FunctionDefinition) {
+	cw.WriteType(fd.Return)
+	cw.w().AddComponent(LibraryPrefix(p) + "_" + fd.Id.Name)
+	cw.w().AddComponentNoSpace("(")
+	cw.w().SuppressNextSpace()
+
+	if len(fd.Parameters) == 0 {
+		cw.w().AddComponentNoSpace("void")
+	}
+	for pi, param := range fd.Parameters {
+		if pi > 0 {
+			cw.w().AddComponentNoSpace(",")
+		}
+
+		cw.WriteType(param.Type)
+		cw.w().AddComponent(param.Name)
+	}
+
+	cw.w().AddComponentNoSpace(")")
+}
+
+/*
+Write the C API of a library, in place of main
+
+Each exported function gets a wrapper that passes the library's execution context. init and
+shutdown start and stop the runtime, and strings are made and read with the string functions
+*/
+func (cw *CWriter) writeLibraryApi(p *program.Program) {
+	prefix := LibraryPrefix(p)
+	const ctxName string = "ey_library_context"
+
+	cw.w().AddComponent("// Library API")
+	cw.w().EndLine()
+	cw.w().AddComponents("static", "EyExecutionContext", ctxName, ";")
+	cw.w().EndLine()
+	cw.w().EndLine()
+
+	cw.w().WriteRaw(fmt.Sprintf(`void %[1]v_init(int argc, const char **argv) {
+    ey_runtime_init(&%[2]v, argc, argv);
+}
+
+void %[1]v_shutdown(void) {
+    ey_runtime_shutdown();
+}
+
+EyString %[1]v_string(const char *s) {
+    return ey_runtime_string_create_literal(&%[2]v, s);
+}
+
+char *%[1]v_string_c(EyString s) {
+    return (char *)ey_runtime_string_create_c_string(s);
+}
+
+void %[1]v_free(void *ptr) {
+    ey_runtime_manual_free(ptr);
+}
+
+`, prefix, ctxName))
+
+	for _, fd := range libraryFunctions(p) {
+		cw.writeLibraryPrototype(p, fd)
+		cw.w().AddComponent("{")
+		cw.w().EndLine()
+		cw.w().Indent()
+
+		if fd.Return.Selector != ast.KTypeVoid {
+			cw.w().AddComponent("return")
+		}
+		cw.w().AddComponents(namespaceFunctionId(fd.Id), "(", "&", ctxName)
+		for _, param := range fd.Parameters {
+			cw.w().AddComponents(",", param.Name)
+		}
+		cw.w().AddComponents(")", ";")
+		cw.w().EndLine()
+
+		cw.w().Unindent()
+		cw.w().AddComponent("}")
+		cw.w().EndLine()
+		cw.w().EndLine()
+	}
+}
+
+/*
+Write the header for a library's C API, for C and C++ programs to include
+
+linkWith is what a program linking the library statically has to link too
+*/
+func (cw *CWriter) WriteLibraryHeader(p *program.Program, linkWith []string) {
+	prefix := LibraryPrefix(p)
+	guard := "EYOT_LIBRARY_" + strings.ToUpper(prefix) + "_H"
+
+	cw.w().WriteRaw(fmt.Sprintf(`/*
+  The C API of the eyot library %[1]v, written by eyot build -lib
+
+  Call %[1]v_init before any other function, and %[1]v_shutdown when done with the library. Make
+  strings to pass in with %[1]v_string, and read those returned with %[1]v_string_c, freeing what it
+  returns with %[1]v_free. Strings are kept until the library collects its garbage.
+`, prefix))
+	if len(linkWith) > 0 {
+		cw.w().WriteRaw(fmt.Sprintf("\n  Linked statically, this also needs %v\n", strings.Join(linkWith, " ")))
+	}
+	cw.w().WriteRaw(fmt.Sprintf(` */
+#ifndef %[1]v
+#define %[1]v
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef int EyBoolean;
+typedef int EyInteger;
+typedef float EyFloat32;
+typedef double EyFloat64;
+typedef uint32_t EyCharacter;
+typedef struct EyStringS *EyString;
+
+void %[2]v_init(int argc, const char **argv);
+void %[2]v_shutdown(void);
+
+EyString %[2]v_string(const char *s);
+char *%[2]v_string_c(EyString s);
+void %[2]v_free(void *ptr);
+
+`, guard, prefix))
+
+	for _, fd := range libraryFunctions(p) {
+		if fd.Doc != "" {
+			cw.w().WriteRaw("/*\n")
+			for _, line := range strings.Split(strings.TrimRight(fd.Doc, "\n"), "\n") {
+				cw.w().WriteRaw(strings.TrimRight("  "+line, " ") + "\n")
+			}
+			cw.w().WriteRaw(" */\n")
+		}
+		cw.writeLibraryPrototype(p, fd)
+		cw.w().AddComponentNoSpace(";")
+		cw.w().EndLine()
+	}
+
+	cw.w().WriteRaw(`
+#ifdef __cplusplus
+}
+#endif
+
+#endif
+`)
+}
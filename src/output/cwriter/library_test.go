@@ -0,0 +1,85 @@
+package cwriter
+
+import (
+	"bytes"
+	"testing"
+
+	"eyot/output/textwriter"
+)
+
+const librarySource = `/// Scale a value
+///
+/// by a factor
+export fn scale(v f32, factor i64) f32 {
+    return v * factor as f32
+}
+
+export fn greet(name string, loud bool) string {
+    return "hello " + name
+}
+
+export fn reset() {
+}
+
+fn helper() i64 {
+    return 1
+}
+`
+
+func TestLibraryHeader(t *testing.T) {
+	p := checkedProgram(t, t.TempDir(), librarySource, true)
+
+	buf := bytes.NewBuffer([]byte{})
+	NewCWriter(textwriter.NewWriter(buf)).WriteLibraryHeader(p, []string{"-lm", "-lpthread"})
+	if buf.String() != libraryHeader {
+		t.Fatalf("Wrong header:\n%v", buf.String())
+	}
+}
+
+const libraryHeader = `/*
+  The C API of the eyot library main, written by eyot build -lib
+
+  Call main_init before any other function, and main_shutdown when done with the library. Make
+  strings to pass in with main_string, and read those returned with main_string_c, freeing what it
+  returns with main_free. Strings are kept until the library collects its garbage.
+
+  Linked statically, this also needs -lm -lpthread
+ */
+#ifndef EYOT_LIBRARY_MAIN_H
+#define EYOT_LIBRARY_MAIN_H
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef int EyBoolean;
+typedef int EyInteger;
+typedef float EyFloat32;
+typedef double EyFloat64;
+typedef uint32_t EyCharacter;
+typedef struct EyStringS *EyString;
+
+void main_init(int argc, const char **argv);
+void main_shutdown(void);
+
+EyString main_string(const char *s);
+char *main_string_c(EyString s);
+void main_free(void *ptr);
+
+/*
+  Scale a value
+
+  by a factor
+ */
+EyFloat32 main_scale(EyFloat32 v, EyInteger factor);
+EyString main_greet(EyString name, EyBoolean loud);
+void main_reset(void);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
+`
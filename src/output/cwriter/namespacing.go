@@ -57,6 +57,11 @@ func namespaceFunctionEnumId(fid ast.FunctionId) string {
 	return "k_ey_function_" + namespaceFunctionCore(fid)
 }
 
+// the variable holding a function's enum key, for code that does not include the enum
+func namespaceFunctionIdVariable(fid ast.FunctionId) string {
+	return "ey_fid_" + namespaceFunctionCore(fid)
+}
+
 func namespaceFunctionCount() string {
 	// no underscore to guarantee no collisions
 	return "k_ey_max_arg_count"
@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"eyot/output/textwriter"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"eyot/ast"
+	"eyot/errors"
 	"eyot/program"
 )
 
@@ -22,6 +27,23 @@ type CWriter struct {
 	writingGpu bool
 
 	scopes []*CWriterScope
+
+	// the path of each module by key, so asserts can say where they are
+	sourcePaths map[string]string
+
+	// count the elements sent to workers, for eyot bench
+	benching bool
+
+	// point the C of each module's unit back at its .ey file with #line
+	lineDirectives bool
+
+	// the name of the module unit being written, and whether a #line points away from it
+	unitName   string
+	lineMapped bool
+
+	// where the last #line pointed, and how many lines had been written after it
+	mappedPath           string
+	mappedLine, mappedAt int
 }
 
 const closureIdFieldName string = "fn_id"
@@ -48,6 +70,8 @@ func DumpRuntime(path string, env *program.Environment) []string {
 		"eyot-runtime-pipes.c",
 		"eyot-runtime-gc.c",
 		"eyot-runtime-opencl.c",
+		"eyot-runtime-trace.c",
+		"eyot-runtime-bench.c",
 	}
 	for _, src := range cFiles {
 		runtimeFiles = append(runtimeFiles, src)
@@ -75,11 +99,66 @@ func NewCWriter(w *textwriter.W) *CWriter {
 		tempCount:  0,
 		writingGpu: false,
 		scopes:     []*CWriterScope{},
+
+		lineDirectives: true,
 	}
 
 	return cw
 }
 
+// Turn the #line directives on or off, as they get in the way of reading the C
+func (cw *CWriter) SetLineDirectives(on bool) {
+	cw.lineDirectives = on
+}
+
+/*
+Point the lines that follow at the eyot source they were written from
+
+This is only done in module units, as they have a name to be pointed back at. The OpenCL compiler's
+reports are not shown, so the gpu code has none
+*/
+func (cw *CWriter) writeLineDirective(sl errors.SourceLocation) {
+	if !cw.lineDirectives || cw.unitName == "" || cw.WritingGpu() || sl.Line == 0 {
+		return
+	}
+
+	path, fnd := cw.sourcePaths[sl.Filename]
+	if !fnd {
+		path = sl.Filename
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	// the compiler counts on from the last one, so it may already be on this line
+	if cw.lineMapped && path == cw.mappedPath && cw.mappedLine+cw.w().Lines()-cw.mappedAt == sl.Line {
+		return
+	}
+
+	cw.w().WriteRaw(fmt.Sprintf("#line %v \"%v\"\n", sl.Line, cStringEscaper.Replace(path)))
+	cw.lineMapped = true
+	cw.mappedPath = path
+	cw.mappedLine = sl.Line
+	cw.mappedAt = cw.w().Lines()
+}
+
+/*
+Point the lines that follow back at the unit itself, once the eyot code is written
+
+The unit is named without the folder it is built in, so the unit is the same from one build to the
+next, and its object can be taken from the build cache
+*/
+func (cw *CWriter) restoreLineDirective() {
+	if !cw.lineMapped {
+		return
+	}
+
+	// the line after the directive
+	next := cw.w().Lines() + 2
+	cw.w().WriteRaw(fmt.Sprintf("#line %v \"%v\"\n", next, cStringEscaper.Replace(cw.unitName)))
+	cw.lineMapped = false
+}
+
 func (cw *CWriter) StartScope() {
 	cw.scopes = append(cw.scopes, &CWriterScope{SavedPointers: []ast.LValue{}})
 }
@@ -245,6 +324,9 @@ func (cw *CWriter) WriteExpression(re ast.Expression) {
 	case *ast.IntegerTerminal:
 		cw.w().AddComponentf(`%v`, e.Value)
 
+	case *ast.CStringTerminal:
+		cw.w().AddComponent(strconv.Quote(e.Value))
+
 	case *ast.CharacterTerminal:
 		cw.w().AddComponents(fmt.Sprintf("%v", e.CodePoint))
 
@@ -365,7 +447,7 @@ func (cw *CWriter) WriteExpression(re ast.Expression) {
 	case *ast.ClosureExpression:
 		cw.w().AddComponents(
 			"ey_closure_create", "(",
-			namespaceFunctionEnumId(e.CalledFunctionId),
+			cw.functionIdReference(e.CalledFunctionId),
 			",",
 			e.ArgumentArrayName,
 			")",
@@ -470,8 +552,14 @@ func (cw *CWriter) WriteExpression(re ast.Expression) {
 	case *ast.CreateWorkerExpression:
 		switch e.Destination {
 		case ast.KDestinationGpu:
+			cw.w().AddComponents("ey_worker_create_opencl", "(")
+			if e.Device != nil {
+				cw.WriteExpression(e.Device)
+			} else {
+				cw.w().AddComponent("-1")
+			}
 			cw.w().AddComponents(
-				"ey_worker_create_opencl", "(",
+				",",
 				`"`+namespaceFunctionId(e.KernelId)+`"`, ",",
 				"sizeof(",
 			)
@@ -693,6 +781,44 @@ func (cw *CWriter) WriteAssign(st *ast.AssignStatement) {
 	}
 }
 
+// Declare the variables a const assigns, for code in other translation units
+func (cw *CWriter) WriteConstDeclaration(st *ast.AssignStatement) {
+	for _, d := range cw.constDeclarations(st) {
+		d.write()
+	}
+}
+
+// The declaration of each variable a const assigns, by the name it has in the C
+func (cw *CWriter) constDeclarations(st *ast.AssignStatement) []declaration {
+	declarations := []declaration{}
+	declare := func(ty ast.Type, lv ast.LValue) {
+		buf := bytes.NewBuffer([]byte{})
+		cw.PushWriter(textwriter.NewWriter(buf))
+		cw.WriteLValue(lv)
+		cw.PopWriter()
+
+		declarations = append(declarations, declaration{
+			name: strings.TrimSpace(buf.String()),
+			write: func() {
+				cw.w().AddComponent("extern")
+				cw.WriteType(ty)
+				cw.WriteLValue(lv)
+				cw.w().AddComponentNoSpace(";")
+				cw.w().EndLine()
+			},
+		})
+	}
+
+	if mlv, ok := st.Lhs.(*ast.MultipleLValue); ok {
+		for lvi, lv := range mlv.LValues {
+			declare(st.NewType.Types[lvi], lv)
+		}
+	} else {
+		declare(st.NewType, st.Lhs)
+	}
+	return declarations
+}
+
 func (cw *CWriter) WriteStatement(rst ast.Statement) {
 	switch st := rst.(type) {
 	case *ast.ModifyInPlaceStatement:
@@ -862,6 +988,38 @@ func (cw *CWriter) WriteStatement(rst ast.Statement) {
 		cw.WriteExpression(st.Expression)
 		cw.w().AddComponentNoSpace(";")
 
+	case *ast.AssertStatement:
+		if cw.WritingGpu() {
+			// a kernel has nowhere to report a failure, so asserts only run on the cpu
+			return
+		}
+
+		sl := st.SourceLocation()
+		path, fnd := cw.sourcePaths[sl.Filename]
+		if !fnd {
+			path = sl.Filename
+		}
+		where := fmt.Sprintf("%v:%v:%v", path, sl.Line, sl.Column)
+
+		cw.w().AddComponents("if", "(", "!", "(")
+		cw.WriteExpression(st.Condition)
+		cw.w().AddComponentNoSpace(")")
+		cw.w().AddComponentNoSpace(")")
+		cw.w().AddComponent("{")
+		cw.w().EndLine()
+		cw.w().Indent()
+		cw.w().AddComponents(
+			"ey_runtime_assert_failed", "(",
+			namespaceExecutionContext(), ",",
+			"\""+cStringEscaper.Replace(where)+"\"", ",",
+		)
+		cw.WriteExpression(st.Message)
+		cw.w().AddComponentNoSpace(")")
+		cw.w().AddComponentNoSpace(";")
+		cw.w().EndLine()
+		cw.w().Unindent()
+		cw.w().AddComponent("}")
+
 	case *ast.SendPipeStatement:
 		cw.WriteExpression(st.Pipe)
 		cw.w().AddComponentNoSpace("->")
@@ -870,7 +1028,13 @@ func (cw *CWriter) WriteStatement(rst ast.Statement) {
 		cw.w().SuppressNextSpace()
 		cw.WriteExpression(st.Pipe)
 		cw.w().AddComponentNoSpace(",")
-		cw.WriteExpression(st.Value)
+		if cw.benching {
+			cw.w().AddComponents("ey_runtime_bench_sent", "(", namespaceExecutionContext(), ",")
+			cw.WriteExpression(st.Value)
+			cw.w().AddComponentNoSpace(")")
+		} else {
+			cw.WriteExpression(st.Value)
+		}
 		cw.w().SuppressNextSpace()
 		cw.w().AddComponentNoSpace(")")
 		cw.w().AddComponentNoSpace(";")
@@ -917,7 +1081,14 @@ func (cw *CWriter) WriteStatementBlock(ss *ast.StatementBlock, withGcScope bool)
 
 	cw.StartScope()
 
-	for _, sc := range ss.Statements {
+	for sci, sc := range ss.Statements {
+		// the temporaries the checker pulls out of a statement are written before it, and have no location of their own
+		for _, next := range ss.Statements[sci:] {
+			if sl := ast.LocationOf(next.Statement); sl.Line != 0 {
+				cw.writeLineDirective(sl)
+				break
+			}
+		}
 		cw.WriteStatement(sc.Statement)
 		cw.w().EndLine()
 	}
@@ -1184,6 +1355,7 @@ func (cw *CWriter) WriteFunction(fd *ast.FunctionDefinition) {
 		return
 	}
 
+	cw.writeLineDirective(fd.SourceLocation())
 	cw.WriteType(fd.Return)
 	cw.w().ForceSpace()
 	cw.w().AddComponent(namespaceFunctionId(fd.Id))
@@ -1207,6 +1379,7 @@ func (cw *CWriter) WriteFunction(fd *ast.FunctionDefinition) {
 
 	cw.w().AddComponentNoSpace(")")
 	cw.WriteStatementBlock(fd.Block, true)
+	cw.restoreLineDirective()
 
 	// wrapper
 	sig := fd.Signature()
@@ -1286,6 +1459,9 @@ func (cw *CWriter) WritingGpu() bool {
 	return cw.writingGpu
 }
 
+// Escapes for text put in a one line C string literal
+var cStringEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
 func escapeString(s string) string {
 	rs := []rune{}
 
@@ -1318,20 +1494,189 @@ func (cw *CWriter) WriteMain(p *program.Program) {
 	cw.w().EndLine()
 	cw.w().Indent()
 
-	mainFid := ast.FunctionId{
-		Module: p.RootModuleId,
-		Struct: ast.BlankStructId(),
-		Name:   "main",
+	root := p.Modules[p.RootModuleId.Key()]
+	if p.Testing {
+		cw.writeIndexSwitch(root.Tests(), func(fd *ast.FunctionDefinition) {
+			cw.w().AddComponents(namespaceFunctionId(fd.Id), "(", "ctx", ")", ";")
+		})
+	} else if p.Benching {
+		cw.writeIndexSwitch(root.Benches(), func(fd *ast.FunctionDefinition) {
+			gpu := "k_false"
+			if dest, fnd := fd.Bench.Destination(); fnd && dest == ast.KDestinationGpu {
+				gpu = "k_true"
+			}
+			cw.w().AddComponents("ey_runtime_bench", "(", "ctx", ",", namespaceFunctionId(fd.Id), ",", gpu, ")", ";")
+		})
+	} else {
+		mainFid := ast.FunctionId{
+			Module: p.RootModuleId,
+			Struct: ast.BlankStructId(),
+			Name:   "main",
+		}
+		cw.w().AddComponents(namespaceFunctionId(mainFid), "(", "ctx", ")", ";")
+		cw.w().EndLine()
 	}
-	cw.w().AddComponents(namespaceFunctionId(mainFid), "(", "ctx", ")", ";")
-	cw.w().EndLine()
 
 	cw.w().Unindent()
 	cw.w().AddComponents("}")
 	cw.w().EndLine()
 }
 
+// Run the test or bench eyot test or eyot bench asked for, writing the call to each with run
+func (cw *CWriter) writeIndexSwitch(fds []*ast.FunctionDefinition, run func(*ast.FunctionDefinition)) {
+	cw.w().AddComponents("switch", "(", "ey_runtime_test_index", "(", ")", ")", "{")
+	cw.w().EndLine()
+
+	for i, fd := range fds {
+		cw.w().AddComponents("case", fmt.Sprintf("%v", i), ":")
+		cw.w().EndLine()
+		cw.w().Indent()
+		run(fd)
+		cw.w().EndLine()
+		cw.w().AddComponents("break", ";")
+		cw.w().EndLine()
+		cw.w().Unindent()
+	}
+
+	cw.w().AddComponent("}")
+	cw.w().EndLine()
+}
+
+// Set up what every part of the program needs to know as it is written
+func (cw *CWriter) prepare(p *program.Program) {
+	cw.benching = p.Benching
+	cw.sourcePaths = map[string]string{}
+	for key, m := range p.Modules {
+		if path := p.Env.FindModule(m.Id); path != "" {
+			cw.sourcePaths[key] = path
+		}
+	}
+}
+
+// The modules in the order they are written, which is the same from one build to the next
+func sortedModules(p *program.Program) []*ast.Module {
+	keys := []string{}
+	for key := range p.Modules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	modules := []*ast.Module{}
+	for _, key := range keys {
+		modules = append(modules, p.Modules[key])
+	}
+	return modules
+}
+
+// Write the whole program as one file of C
 func (cw *CWriter) WriteProgram(p *program.Program) {
+	cw.prepare(p)
+	cw.writeClSource(p)
+	cw.writeProgram(p)
+}
+
+// The header declaring what all the modules' units share
+const ProgramHeader string = "eyot-program.h"
+
+// The name of the translation unit for a module
+func moduleUnitName(m *ast.Module) string {
+	return "eyot-module-" + m.Id.Namespace() + ".c"
+}
+
+// The name of the header declaring what a module's unit uses from the rest of the program
+func moduleHeaderName(m *ast.Module) string {
+	return "eyot-module-" + m.Id.Namespace() + ".h"
+}
+
+/*
+Write the program as a translation unit for each module, so they can be compiled separately
+
+The writer's own stream takes the unit holding what is generated for the program as a whole: the
+string pool, the function enum, the function caller and main. open is called to create the header
+they all include, and each module's unit and header, by name.
+
+The shared header only has the runtime and the structs. Each module's own header declares just the
+functions, vectors, ffi functions and consts its unit uses, so a unit, and its object in the build
+cache, is left alone by changes to the rest of the program that it does not use
+*/
+func (cw *CWriter) WriteProgramUnits(p *program.Program, open func(name string) (io.Writer, error)) error {
+	cw.prepare(p)
+	pool := p.GetStringPool()
+
+	header, err := open(ProgramHeader)
+	if err != nil {
+		return err
+	}
+	cw.PushWriter(textwriter.NewWriter(header))
+	cw.w().WriteRaw("#ifndef EYOT_PROGRAM_H\n#define EYOT_PROGRAM_H\n\n")
+	cw.writePrelude(p)
+	cw.writeStructDeclarations(p)
+	cw.w().WriteRaw("#endif\n")
+	cw.PopWriter()
+
+	groups := cw.declarationGroups(p)
+	for _, m := range sortedModules(p) {
+		// the unit is written first, to know what its header has to declare
+		buf := bytes.NewBuffer([]byte{})
+		cw.PushWriter(textwriter.NewWriter(buf))
+		cw.unitName = moduleUnitName(m)
+		cw.writeInclude(ProgramHeader, false)
+		cw.writeInclude(moduleHeaderName(m), true)
+		cw.WriteFile(m, true, pool)
+		cw.writeModuleCode(m, pool)
+		cw.unitName = ""
+		cw.PopWriter()
+
+		moduleHeader, err := open(moduleHeaderName(m))
+		if err != nil {
+			return err
+		}
+		cw.PushWriter(textwriter.NewWriter(moduleHeader))
+		cw.writeDeclarationGroups(groups, usedIdentifiers(buf.String()))
+		cw.PopWriter()
+
+		unit, err := open(moduleUnitName(m))
+		if err != nil {
+			return err
+		}
+		if _, err := unit.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	cw.writeInclude(ProgramHeader, true)
+	cw.WriteNewFunctionEnum(p)
+	cw.writeFunctionIdVariables(p)
+	cw.writeDeclarationGroups(groups, nil)
+	cw.writeClSource(p)
+	cw.WriteStringPool(pool)
+	cw.w().EndLine()
+	cw.writeSharedCode(p)
+
+	return nil
+}
+
+func (cw *CWriter) writeInclude(header string, blankLine bool) {
+	cw.w().WriteRaw(fmt.Sprintf(`#include "%v"`, header))
+	cw.w().EndLine()
+	if blankLine {
+		cw.w().EndLine()
+	}
+}
+
+var cIdentifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// Every identifier in some C
+func usedIdentifiers(code string) map[string]bool {
+	used := map[string]bool{}
+	for _, id := range cIdentifier.FindAllString(code, -1) {
+		used[id] = true
+	}
+	return used
+}
+
+// Write the OpenCL source of the program to ey_runtime_cl_src, or a null pointer if there is no gpu code
+func (cw *CWriter) writeClSource(p *program.Program) {
 	// calculate the ocl appropriate variant
 	cw.w().AddComponent("const")
 	cw.w().AddComponent("char")
@@ -1340,24 +1685,35 @@ func (cw *CWriter) WriteProgram(p *program.Program) {
 	cw.w().AddComponent("=")
 
 	if p.GpuRequired {
-		buf := bytes.NewBuffer([]byte{})
-		oclWriter := textwriter.NewWriter(buf)
-		cw.writingGpu = true
-		cw.PushWriter(oclWriter)
-		cw.writeProgram(p)
-		cw.PopWriter()
-		cw.writingGpu = false
-
 		cw.w().EndLine()
-		cw.w().WriteRaw(escapeString(buf.String()))
+		cw.w().WriteRaw(escapeString(cw.clSource(p)))
 	} else {
 		cw.w().AddComponent("0")
 	}
 
 	cw.w().AddComponent(";")
 	cw.w().EndLine()
+}
 
+// The program as OpenCL C, for the gpu
+func (cw *CWriter) clSource(p *program.Program) string {
+	buf := bytes.NewBuffer([]byte{})
+	cw.writingGpu = true
+	cw.PushWriter(textwriter.NewWriter(buf))
 	cw.writeProgram(p)
+	cw.PopWriter()
+	cw.writingGpu = false
+	return buf.String()
+}
+
+// Write the OpenCL C the runtime builds the program's kernels from, which is nothing without gpu code
+func (cw *CWriter) WriteKernels(p *program.Program) {
+	if !p.GpuRequired {
+		return
+	}
+
+	cw.prepare(p)
+	cw.w().WriteRaw(cw.clSource(p))
 }
 
 func (cw *CWriter) WriteArgCountFunction(p *program.Program) {
@@ -1383,30 +1739,26 @@ func (cw *CWriter) WriteArgCountFunction(p *program.Program) {
 	)
 	cw.w().EndLine()
 
-	for _, fs := range p.Functions.Functions {
-		for loc, ids := range fs.AllIds {
-			if !cw.CanWriteRequirement(loc) {
-				continue
-			}
-			for _, fid := range ids {
-				cw.w().AddComponents(
-					"case",
-					namespaceFunctionEnumId(fid),
-					":",
-				)
-				cw.w().EndLine()
-				cw.w().Indent()
-				
-				cw.w().AddComponents(
-					"return",
-					fmt.Sprintf("%v", len(fs.Signature.Types)),
-					";",
-				)
-				cw.w().EndLine()
-				
-				cw.w().Unindent()
-			}
+	for _, fe := range p.Functions.FunctionEntries() {
+		if !cw.CanWriteRequirement(fe.Location) {
+			continue
 		}
+		cw.w().AddComponents(
+			"case",
+			namespaceFunctionEnumId(fe.Fid),
+			":",
+		)
+		cw.w().EndLine()
+		cw.w().Indent()
+
+		cw.w().AddComponents(
+			"return",
+			fmt.Sprintf("%v", len(fe.Signature.Types)),
+			";",
+		)
+		cw.w().EndLine()
+
+		cw.w().Unindent()
 	}
 
 	// end switch
@@ -1513,6 +1865,40 @@ func (cw *CWriter) WriteNewFunctionEnum(p *program.Program) {
 	cw.w().EndLine()
 }
 
+/*
+How the cpu code refers to the enum value of a function
+
+Module units refer to it by a variable defined alongside the enum, so they need not include the enum,
+which changes whenever any function in the program does. The gpu code is all one file, and uses the
+enum value itself
+*/
+func (cw *CWriter) functionIdReference(fid ast.FunctionId) string {
+	if cw.WritingGpu() {
+		return namespaceFunctionEnumId(fid)
+	}
+	return namespaceFunctionIdVariable(fid)
+}
+
+// Define the variables holding the enum value of each function, for the cpu code
+func (cw *CWriter) writeFunctionIdVariables(p *program.Program) {
+	if cw.WritingGpu() {
+		return
+	}
+
+	for _, fe := range p.Functions.FunctionEntries() {
+		if cw.CanWriteRequirement(fe.Location) {
+			cw.w().AddComponents(
+				"const", "int",
+				namespaceFunctionIdVariable(fe.Fid),
+				"=", namespaceFunctionEnumId(fe.Fid),
+			)
+			cw.w().AddComponentNoSpace(";")
+			cw.w().EndLine()
+		}
+	}
+	cw.w().EndLine()
+}
+
 func (cw *CWriter) WriteFunctionArgSize(p *program.Program) {
 	cw.w().AddComponents(
 		"int",
@@ -1537,69 +1923,62 @@ func (cw *CWriter) WriteFunctionArgSize(p *program.Program) {
 	cw.w().Indent()
 
 	// cases
-	for _, fs := range p.Functions.Functions {
-		if len(fs.Signature.Types) == 0 {
+	for _, fe := range p.Functions.FunctionEntries() {
+		if len(fe.Signature.Types) == 0 || !cw.CanWriteRequirement(fe.Location) {
 			continue
 		}
 
-		for loc, ids := range fs.AllIds {
-			if !cw.CanWriteRequirement(loc) {
-				continue
-			}
-			for _, fid := range ids {
+		cw.w().AddComponents(
+			"case",
+			namespaceFunctionEnumId(fe.Fid),
+			":",
+		)
+		cw.w().EndLine()
+		cw.w().Indent()
+
+		if cw.CanWriteRequirement(fe.Signature.Location) {
+			cw.w().AddComponents(
+				"switch",
+				"(",
+				"arg",
+				")",
+				"{",
+			)
+			cw.w().EndLine()
+			cw.w().Indent()
+
+			for tyi, ty := range fe.Signature.Types {
 				cw.w().AddComponents(
 					"case",
-					namespaceFunctionEnumId(fid),
+					fmt.Sprintf("%v", tyi),
 					":",
 				)
 				cw.w().EndLine()
 				cw.w().Indent()
 
-				if cw.CanWriteRequirement(fs.Signature.Location) {
-					cw.w().AddComponents(
-						"switch",
-						"(",
-						"arg",
-						")",
-						"{",
-					)
-					cw.w().EndLine()
-					cw.w().Indent()
-
-					for tyi, ty := range fs.Signature.Types {
-						cw.w().AddComponents(
-							"case",
-							fmt.Sprintf("%v", tyi),
-							":",
-						)
-						cw.w().EndLine()
-						cw.w().Indent()
-
-						cw.w().AddComponents(
-							"return", "sizeof", "(",
-						)
-						cw.WriteType(ty)
-						cw.w().AddComponents(
-							")", ";",
-						)
-						cw.w().EndLine()
-						cw.w().Unindent()
-					}
-
-					cw.w().Unindent()
-					cw.w().AddComponents(
-						"}",
-					)
-					cw.w().EndLine()
-				} else {
-					cw.w().AddComponents(
-						"return", "0", ";", "// not available on gpu",
-					)
-				}
+				cw.w().AddComponents(
+					"return", "sizeof", "(",
+				)
+				cw.WriteType(ty)
+				cw.w().AddComponents(
+					")", ";",
+				)
 				cw.w().EndLine()
 				cw.w().Unindent()
 			}
+
+			cw.w().Unindent()
+			cw.w().AddComponents(
+				"}",
+			)
+			cw.w().EndLine()
+		} else {
+			cw.w().AddComponents(
+				"return", "0", ";", "// not available on gpu",
+			)
 		}
+		cw.w().EndLine()
+		cw.w().Unindent()
 	}
 
 	// handle functions with no args
@@ -1656,50 +2035,43 @@ func (cw *CWriter) WriteFunctionCaller(p *program.Program) {
 	cw.w().Indent()
 
 	// cases
-	for _, fs := range p.Functions.Functions {
-		if len(fs.Signature.Types) == 0 {
+	for _, fe := range p.Functions.FunctionEntries() {
+		if len(fe.Signature.Types) == 0 || !cw.CanWriteRequirement(fe.Location) {
 			continue
 		}
 
-		for loc, ids := range fs.AllIds {
-			if !cw.CanWriteRequirement(loc) {
-				continue
-			}
-			for _, fid := range ids {
-				cw.w().AddComponents(
-					"case",
-					namespaceFunctionEnumId(fid),
-					":",
-				)
-				cw.w().EndLine()
-				cw.w().Indent()
-
-				if cw.CanWriteRequirement(fs.Signature.Location) {
-					cw.w().AddComponents(
-						namespaceFunctionCallerId(fid),
-						"(",
-					)
-					cw.w().AddComponents(
-						namespaceExecutionContext(), ",",
-						"result", ",",
-						"args",
-						")",
-						";",
-					)
-				} else {
-					// this keeps the cl compiler happy
-					cw.w().AddComponent("// function not available on GPU")
-				}
-				cw.w().EndLine()
+		cw.w().AddComponents(
+			"case",
+			namespaceFunctionEnumId(fe.Fid),
+			":",
+		)
+		cw.w().EndLine()
+		cw.w().Indent()
 
-				cw.w().AddComponents(
-					"break",
-					";",
-				)
-				cw.w().EndLine()
-				cw.w().Unindent()
-			}
+		if cw.CanWriteRequirement(fe.Signature.Location) {
+			cw.w().AddComponents(
+				namespaceFunctionCallerId(fe.Fid),
+				"(",
+			)
+			cw.w().AddComponents(
+				namespaceExecutionContext(), ",",
+				"result", ",",
+				"args",
+				")",
+				";",
+			)
+		} else {
+			// this keeps the cl compiler happy
+			cw.w().AddComponent("// function not available on GPU")
 		}
+		cw.w().EndLine()
+
+		cw.w().AddComponents(
+			"break",
+			";",
+		)
+		cw.w().EndLine()
+		cw.w().Unindent()
 	}
 
 	cw.w().Unindent()
@@ -1721,6 +2093,32 @@ func cleanupCode(code string) string {
 }
 
 func (cw *CWriter) writeProgram(p *program.Program) {
+	cw.writePrelude(p)
+
+	if !cw.WritingGpu() {
+		cw.WriteStringPool(p.GetStringPool())
+		cw.w().EndLine()
+	}
+
+	cw.writeDeclarations(p)
+
+	pool := p.GetStringPool()
+
+	cw.w().AddComponent("// Consts")
+	cw.w().EndLine()
+	for _, m := range sortedModules(p) {
+		cw.WriteFile(m, true, pool)
+	}
+
+	for _, m := range sortedModules(p) {
+		cw.writeModuleCode(m, pool)
+	}
+
+	cw.writeSharedCode(p)
+}
+
+// The runtime, and on the gpu the definitions it needs from the program
+func (cw *CWriter) writePrelude(p *program.Program) {
 	if cw.WritingGpu() {
 		cw.w().AddComponents(
 			"#define",
@@ -1755,19 +2153,21 @@ func (cw *CWriter) writeProgram(p *program.Program) {
 	}
 	cw.w().EndLine()
 	cw.w().EndLine()
+}
 
-	if !cw.WritingGpu() {
-		cw.WriteStringPool(p.GetStringPool())
-		cw.w().EndLine()
-	}
-
+// Declare everything one module may use from another
+func (cw *CWriter) writeDeclarations(p *program.Program) {
 	cw.WriteNewFunctionEnum(p)
-	cw.WriteArgCountFunction(p)
-	cw.w().EndLine()
+	cw.writeFunctionIdVariables(p)
+	cw.writeStructDeclarations(p)
+	cw.writeDeclarationGroups(cw.declarationGroups(p), nil)
+}
 
+// Declare and define the structs of every module
+func (cw *CWriter) writeStructDeclarations(p *program.Program) {
 	cw.w().AddComponent("// Forward struct definitions")
 	cw.w().EndLine()
-	for _, m := range p.Modules {
+	for _, m := range sortedModules(p) {
 		for si, s := range m.Structs {
 			if si > 0 {
 				cw.w().EndLine()
@@ -1779,7 +2179,7 @@ func (cw *CWriter) writeProgram(p *program.Program) {
 
 	cw.w().AddComponent("// Struct definitions")
 	cw.w().EndLine()
-	for _, m := range p.Modules {
+	for _, m := range sortedModules(p) {
 		for si, s := range m.Structs {
 			namespaced := s.Id.Name
 			if !s.GeneratedForTuple {
@@ -1812,85 +2212,158 @@ func (cw *CWriter) writeProgram(p *program.Program) {
 		}
 	}
 	cw.w().EndLine()
+}
 
-	cw.w().AddComponent("// Forward decls for all functions")
-	cw.w().EndLine()
-	for _, fs := range p.Functions.Functions {
-		for loc, ids := range fs.AllIds {
-			if !cw.CanWriteRequirement(loc) {
-				continue
-			}
-			for _, fid := range ids {
-				cw.WriteFunctionPrototype(fs.Signature, fid)
+// Something declared for code in other translation units, by the name it declares
+type declaration struct {
+	name  string
+	write func()
+}
+
+// Declarations written together under a comment
+type declarationGroup struct {
+	comment      string
+	declarations []declaration
+}
+
+// Everything besides the structs that one module may use from another, in the same order every build
+func (cw *CWriter) declarationGroups(p *program.Program) []declarationGroup {
+	functions := declarationGroup{comment: "// Forward decls for all functions"}
+	for _, fe := range p.Functions.FunctionEntries() {
+		if !cw.CanWriteRequirement(fe.Location) {
+			continue
+		}
+
+		fe := fe
+		functions.declarations = append(functions.declarations, declaration{
+			name: namespaceFunctionId(fe.Fid),
+			write: func() {
+				cw.WriteFunctionPrototype(fe.Signature, fe.Fid)
 				cw.w().AddComponentNoSpace(";")
 				cw.w().EndLine()
-			}
+			},
+		})
+
+		if !cw.WritingGpu() {
+			functions.declarations = append(functions.declarations, declaration{
+				name: namespaceFunctionIdVariable(fe.Fid),
+				write: func() {
+					cw.w().AddComponents("extern", "const", "int", namespaceFunctionIdVariable(fe.Fid))
+					cw.w().AddComponentNoSpace(";")
+					cw.w().EndLine()
+				},
+			})
 		}
 	}
-	for _, vt := range p.Vectors {
-		cw.w().AddComponents(
-			"void", vt.VectorAddName(), "(",
-			"EyExecutionContext", "*", namespaceExecutionContext(), ",",
-			"EyVector", "*", "vec", ",",
-		)
-		cw.WriteType(vt)
-		cw.w().AddComponents("val", ")", ";")
-		cw.w().EndLine()
+	for _, vt := range sortedVectors(p) {
+		vt := vt
+		functions.declarations = append(functions.declarations, declaration{
+			name: vt.VectorAddName(),
+			write: func() {
+				cw.w().AddComponents(
+					"void", vt.VectorAddName(), "(",
+					"EyExecutionContext", "*", namespaceExecutionContext(), ",",
+					"EyVector", "*", "vec", ",",
+				)
+				cw.WriteType(vt)
+				cw.w().AddComponents("val", ")", ";")
+				cw.w().EndLine()
+			},
+		})
 	}
-	cw.w().EndLine()
 
-	if !cw.WritingGpu() {
-		cw.w().AddComponent("// Forward decls for ffi")
-		cw.w().EndLine()
-		for _, m := range p.Modules {
-			if m.Ffid == nil {
-				continue
-			}
+	if cw.WritingGpu() {
+		return []declarationGroup{functions}
+	}
 
+	ffi := declarationGroup{comment: "// Forward decls for ffi"}
+	consts := declarationGroup{comment: "// Forward decls for consts"}
+	for _, m := range sortedModules(p) {
+		if m.Ffid != nil {
 			for _, cfn := range m.Ffid.Functions {
-				sig := ast.FunctionSignature{
-					Location: ast.KLocationCpu,
-					Return:   cfn.ReturnType,
-					Types:    cfn.ArgumentTypes,
-				}
+				cfn := cfn
+				ffi.declarations = append(ffi.declarations, declaration{
+					name: cfn.Name,
+					write: func() {
+						sig := ast.FunctionSignature{
+							Location: ast.KLocationCpu,
+							Return:   cfn.ReturnType,
+							Types:    cfn.ArgumentTypes,
+						}
+
+						cw.WriteFunctionPrototypeRawName(sig, cfn.Name)
+						cw.w().AddComponent(";")
+						cw.w().EndLine()
+					},
+				})
+			}
+		}
 
-				cw.WriteFunctionPrototypeRawName(sig, cfn.Name)
-				cw.w().AddComponent(";")
-				cw.w().EndLine()
+		for _, tle := range m.TopLevelElements {
+			if ct, ok := tle.TopLevelElement.(*ast.ConstTle); ok {
+				consts.declarations = append(consts.declarations, cw.constDeclarations(ct.Assign)...)
 			}
 		}
 	}
 
-	pool := p.GetStringPool()
+	return []declarationGroup{functions, ffi, consts}
+}
 
-	cw.w().AddComponent("// Consts")
-	cw.w().EndLine()
-	for _, m := range p.Modules {
-		cw.WriteFile(m, true, pool)
+// Write the declarations, or if used is given only those of the names in it
+func (cw *CWriter) writeDeclarationGroups(groups []declarationGroup, used map[string]bool) {
+	for _, group := range groups {
+		cw.w().AddComponent(group.comment)
+		cw.w().EndLine()
+		for _, d := range group.declarations {
+			if used == nil || used[d.name] {
+				d.write()
+			}
+		}
+		cw.w().EndLine()
+	}
+}
+
+// The vector types used in the program, in the same order every build
+func sortedVectors(p *program.Program) []ast.Type {
+	keys := []string{}
+	for key := range p.Vectors {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
+	vectors := []ast.Type{}
+	for _, key := range keys {
+		vectors = append(vectors, p.Vectors[key])
+	}
+	return vectors
+}
+
+// The functions of a module, bound and unbound
+func (cw *CWriter) writeModuleCode(m *ast.Module, pool []string) {
 	cw.w().AddComponent("// Struct functions")
 	cw.w().EndLine()
-	for _, m := range p.Modules {
-		for _, s := range m.Structs {
-			for _, fn := range s.Definition.Functions {
-				cw.WriteFunction(fn)
-				cw.w().EndLine()
-			}
+	for _, s := range m.Structs {
+		for _, fn := range s.Definition.Functions {
+			cw.WriteFunction(fn)
+			cw.w().EndLine()
 		}
 	}
 	cw.w().EndLine()
 
 	cw.w().AddComponent("// Non-struct code")
 	cw.w().EndLine()
-	for _, m := range p.Modules {
-		cw.WriteFile(m, false, pool)
-	}
+	cw.WriteFile(m, false, pool)
+}
+
+// What is generated for the program as a whole, rather than any one module
+func (cw *CWriter) writeSharedCode(p *program.Program) {
+	cw.WriteArgCountFunction(p)
+	cw.w().EndLine()
 
 	cw.w().AddComponent("// Synthesized code")
 	cw.w().EndLine()
 	if !cw.WritingGpu() {
-		for _, vt := range p.Vectors {
+		for _, vt := range sortedVectors(p) {
 			cw.w().AddComponents(
 				"void", vt.VectorAddName(), "(",
 				"EyExecutionContext", "*", namespaceExecutionContext(), ",",
@@ -1920,7 +2393,13 @@ func (cw *CWriter) writeProgram(p *program.Program) {
 	cw.WriteFunctionArgSize(p)
 	cw.WriteFunctionCaller(p)
 
-	if !cw.WritingGpu() {
+	if cw.WritingGpu() {
+		return
+	}
+
+	if p.Library {
+		cw.writeLibraryApi(p)
+	} else {
 		cw.w().AddComponent("// Main function")
 		cw.w().EndLine()
 		cw.WriteMain(p)
@@ -0,0 +1,194 @@
+package cwriter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"eyot/errors"
+	"eyot/output/textwriter"
+	"eyot/program"
+)
+
+// Check src as the module main, as it would be found in dir
+func checkedProgram(t *testing.T, dir, src string, library bool) *program.Program {
+	root, err := filepath.Abs(filepath.Join("..", "..", "..", "lib"))
+	if err != nil {
+		t.Fatalf("No library root: %v", err)
+	}
+
+	env := &program.Environment{
+		Roots:    []string{dir, root},
+		Packages: map[string]string{},
+		Overlay:  map[string]string{filepath.Join(dir, "main.ey"): src},
+	}
+
+	es := errors.NewErrors()
+	p := program.NewProgram(env, es)
+	p.Library = library
+	p.ParseRoot("main")
+	if !es.Clean() {
+		buf := bytes.NewBuffer([]byte{})
+		es.LogErrors(buf)
+		t.Fatalf("Failed to check:\n%v", buf.String())
+	}
+	return p
+}
+
+// Write the program's units to files in dir, returning the C of the main module's unit
+func writeUnits(t *testing.T, dir string, p *program.Program, lines bool) string {
+	files := []*os.File{}
+	open := func(name string) (io.Writer, error) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err == nil {
+			files = append(files, f)
+		}
+		return f, err
+	}
+
+	cw := NewCWriter(textwriter.NewWriter(io.Discard))
+	cw.SetLineDirectives(lines)
+	if err := cw.WriteProgramUnits(p, open); err != nil {
+		t.Fatalf("Failed to write units: %v", err)
+	}
+	for _, f := range files {
+		f.Close()
+	}
+
+	blob, err := os.ReadFile(filepath.Join(dir, "eyot-module-main.c"))
+	if err != nil {
+		t.Fatalf("No unit for main: %v", err)
+	}
+	return string(blob)
+}
+
+const lineSource = `fn add(a i64, b i64) i64 {
+    return a + b
+}
+
+cpu fn main() {
+    print_ln(add(1, 2))
+}
+`
+
+func TestLineDirectives(t *testing.T) {
+	dir := t.TempDir()
+	c := writeUnits(t, dir, checkedProgram(t, dir, lineSource, false), true)
+
+	// the functions point at where they are written
+	source := filepath.Join(dir, "main.ey")
+	for _, line := range []string{"#line 2 \"" + source + "\"", "#line 6 \"" + source + "\""} {
+		if !strings.Contains(c, line) {
+			t.Fatalf("Missing %v in:\n%v", line, c)
+		}
+	}
+
+	// and the code after them points back at the unit, by a name that is the same in any build folder
+	unit := "eyot-module-main.c"
+	restore := ""
+	for i, line := range strings.Split(c, "\n") {
+		if strings.HasPrefix(line, "#line ") && strings.HasSuffix(line, " \""+unit+"\"") {
+			restore = line
+			if line != "#line "+strconv.Itoa(i+2)+" \""+unit+"\"" {
+				t.Fatalf("Restored to the wrong line on line %v: %v", i+1, line)
+			}
+		}
+	}
+	if restore == "" {
+		t.Fatalf("The unit is never restored in:\n%v", c)
+	}
+}
+
+func TestNoLineDirectives(t *testing.T) {
+	dir := t.TempDir()
+	c := writeUnits(t, dir, checkedProgram(t, dir, lineSource, false), false)
+	if strings.Contains(c, "#line") {
+		t.Fatalf("-nolines still wrote #line in:\n%v", c)
+	}
+}
+
+const shapesSource = `export fn area(w i64, h i64) i64 {
+    return w * h
+}
+
+export fn perimeter(w i64, h i64) i64 {
+    return 2 * (w + h)
+}
+`
+
+// Read every file written to dir
+func readFiles(t *testing.T, dir string) map[string]string {
+	names, err := filepath.Glob(filepath.Join(dir, "eyot-*"))
+	if err != nil {
+		t.Fatalf("Failed to list %v: %v", dir, err)
+	}
+
+	files := map[string]string{}
+	for _, name := range names {
+		blob, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read %v: %v", name, err)
+		}
+		files[filepath.Base(name)] = string(blob)
+	}
+	return files
+}
+
+// A unit and its header only change when what the unit uses does, so the build cache can keep its object
+func TestUnitsAreStable(t *testing.T) {
+	src := `import shapes
+
+fn add(a i64, b i64) i64 {
+    return a + b
+}
+
+cpu fn main() {
+    let w = cpu (partial add(5, _))
+    send(w, [i64] { 1, 2 })
+    for v: drain(w) {
+        print_ln(shapes::area(v, 3))
+    }
+}
+`
+	write := func(src string) map[string]string {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "shapes.ey"), []byte(shapesSource), 0644); err != nil {
+			t.Fatalf("Failed to write shapes: %v", err)
+		}
+		writeUnits(t, dir, checkedProgram(t, dir, src, false), false)
+		return readFiles(t, dir)
+	}
+
+	first, second := write(src), write(src)
+	for _, name := range []string{ProgramHeader, "eyot-module-main.c", "eyot-module-main.h", "eyot-module-shapes.c", "eyot-module-shapes.h"} {
+		if first[name] == "" || first[name] != second[name] {
+			t.Fatalf("%v differs between writes:\n%v\n\n%v", name, first[name], second[name])
+		}
+	}
+
+	// main's header declares only what it uses of shapes, and no unit needs the function enum
+	if !strings.Contains(first["eyot-module-main.h"], "ey_function_shapes___unbound___area(") ||
+		strings.Contains(first["eyot-module-main.h"], "perimeter") {
+		t.Fatalf("Wrong declarations for main:\n%v", first["eyot-module-main.h"])
+	}
+	for name, text := range first {
+		if name != "eyot-main.c" && strings.Contains(text, "k_ey_function_") {
+			t.Fatalf("%v has the function enum:\n%v", name, text)
+		}
+	}
+
+	// a new function in main renumbers the functions, but leaves shapes alone
+	changed := write(strings.Replace(src, "cpu fn main", "fn unused() {\n}\n\ncpu fn main", 1))
+	if changed["eyot-module-main.c"] == first["eyot-module-main.c"] {
+		t.Fatalf("main did not change")
+	}
+	for _, name := range []string{ProgramHeader, "eyot-module-shapes.c", "eyot-module-shapes.h"} {
+		if changed[name] != first[name] {
+			t.Fatalf("%v changed with main:\n%v\n\n%v", name, first[name], changed[name])
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package interp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"eyot/ast"
+)
+
+// Evaluate an expression on the rhs of an assignment, copying string literals as ey_runtime_string_use_literal does
+func (fr *frame) evalAssigned(e ast.Expression) value {
+	v := fr.eval(e)
+	if s, ok := v.(*str); ok && s.static {
+		return &str{chars: append([]rune{}, s.chars...)}
+	}
+	return v
+}
+
+func (fr *frame) eval(re ast.Expression) value {
+	switch e := re.(type) {
+	case *ast.NullLiteral:
+		return nil
+
+	case *ast.CastExpression:
+		return convert(fr.eval(e.Casted), e.NewType)
+
+	case *ast.SelfTerminal:
+		return fr.self
+
+	case *ast.GpuBuiltinTerminal:
+		return nativeValue{name: e.Name}
+
+	case *ast.DereferenceExpression:
+		return deref(fr.eval(e.Pointer))
+
+	case *ast.SizeofExpression:
+		return sizeofValue{ty: e.SizedType}
+
+	case *ast.IntegerTerminal:
+		return int32(e.Value)
+
+	case *ast.CStringTerminal:
+		return e.Value
+
+	case *ast.CharacterTerminal:
+		return character(e.CodePoint)
+
+	case *ast.StringTerminal:
+		return &str{chars: []rune(fr.th.in.pool[e.Id]), static: true}
+
+	case *ast.FloatTerminal:
+		// written to C as a double literal, and narrowed where it is stored
+		zeros := ""
+		for i := int64(0); i < e.Zeros; i += 1 {
+			zeros += "0"
+		}
+		f, err := strconv.ParseFloat(fmt.Sprintf("%v.%v%v", e.LValue, zeros, e.RValue), 64)
+		if err != nil {
+			panic(fmt.Sprintf("interp: bad float literal: %v", err))
+		}
+		return f
+
+	case *ast.TupleExpression:
+		l := fr.th.in.typeLayout(e.Type())
+		sv := &structValue{layout: l, fields: make([]value, len(l.types))}
+		for ei, ee := range e.Expressions {
+			sv.fields[ei] = convert(fr.evalAssigned(ee), l.types[ei])
+		}
+		return sv
+
+	case *ast.IdentifierTerminal:
+		if e.Fid != nil {
+			return functionValue(*e.Fid)
+		}
+		if c, fnd := fr.lookup(e.Name); fnd {
+			return c.load()
+		}
+		// anything else is a part of the runtime
+		return nativeValue{name: e.Name}
+
+	case *ast.BooleanTerminal:
+		return e.Value
+
+	case *ast.RangeExpression:
+		start := toInt(fr.eval(e.Start))
+		end := toInt(fr.eval(e.Count))
+		step := toInt(fr.eval(e.Step))
+		return makeRange(start, end, step)
+
+	case *ast.BinaryExpression:
+		if e.Lhs.Type().Selector == ast.KTypeString {
+			lhs := fr.eval(e.Lhs).(*str)
+			rhs := fr.eval(e.Rhs).(*str)
+
+			switch e.Operator {
+			case ast.KOperatorAdd:
+				return &str{chars: append(append([]rune{}, lhs.chars...), rhs.chars...)}
+
+			case ast.KOperatorEquality:
+				return stringsEqual(lhs, rhs)
+
+			case ast.KOperatorInequality:
+				return !stringsEqual(lhs, rhs)
+
+			default:
+				panic(fmt.Errorf("Do not understand binary operator %v", e.Operator))
+			}
+		}
+
+		switch e.Operator {
+		case ast.KOperatorAnd:
+			return toBool(fr.eval(e.Lhs)) && toBool(fr.eval(e.Rhs))
+
+		case ast.KOperatorOr:
+			return toBool(fr.eval(e.Lhs)) || toBool(fr.eval(e.Rhs))
+		}
+
+		return binary(e.Operator, fr.eval(e.Lhs), fr.eval(e.Rhs))
+
+	case *ast.AccessExpression:
+		accessed := fr.eval(e.Accessed)
+		if w, ok := accessed.(worker); ok {
+			return workerMethod{w: w, name: e.Identifier}
+		}
+		if e.Accessed.Type().Selector == ast.KTypePointer {
+			accessed = deref(accessed)
+		}
+		sv := accessed.(*structValue)
+		return sv.fields[sv.layout.index(e.Identifier)]
+
+	case *ast.NewExpression:
+		return fr.eval(e.Replacement)
+
+	case *ast.ClosureExpression:
+		array, _ := fr.lookup(e.ArgumentArrayName)
+		refs := array.load().(argArray)
+
+		c := &closureValue{
+			fn:      funcValue{key: functionKey(e.CalledFunctionId), name: e.CalledFunctionId.Name},
+			args:    make([]value, len(refs)),
+			present: make([]bool, len(refs)),
+		}
+		for i, r := range refs {
+			if r != nil {
+				c.args[i] = copyValue(r.load())
+				c.present[i] = true
+			}
+		}
+		return c
+
+	case *ast.CallExpression:
+		if e.StackedResultVariableName != "" {
+			c, _ := fr.lookup(e.StackedResultVariableName)
+			return c.load()
+		}
+
+		called := fr.eval(e.CalledExpression)
+		args := make([]value, len(e.Arguments))
+		for i, arg := range e.Arguments {
+			args[i] = fr.eval(arg)
+		}
+		return fr.th.call(called, args)
+
+	case *ast.StructLiteralExpression:
+		sv := fr.th.in.zero(ast.Type{Selector: ast.KTypeStruct, StructId: e.Id}).(*structValue)
+		for _, pair := range e.Pairs {
+			i := sv.layout.index(pair.FieldName)
+			sv.fields[i] = convert(fr.eval(pair.Value), sv.layout.types[i])
+		}
+		return sv
+
+	case *ast.UnaryExpression:
+		switch e.Operator {
+		case ast.KOperatorNot:
+			return !toBool(fr.eval(e.Rhs))
+
+		case ast.KOperatorAddressOf:
+			return fr.addressOf(e.Rhs)
+
+		case ast.KOperatorNegate:
+			return binary(ast.KOperatorSubtract, int32(0), fr.eval(e.Rhs))
+		}
+
+	case *ast.VectorLiteralExpression:
+		return fr.eval(e.Replacement)
+
+	case *ast.IndexExpression:
+		indexed := fr.eval(e.Indexed)
+		index := toInt(fr.eval(e.Index))
+
+		switch e.AccessedType {
+		case ast.KTypeVector:
+			vec := indexed.(*vector)
+			checkAccess(vec, index)
+			return vec.elems[index]
+
+		case ast.KTypeString:
+			return character(indexed.(*str).chars[index])
+		}
+
+	case *ast.CreatePipelineExpression:
+		lhs := fr.eval(e.LhsWorker).(worker)
+		rhs := fr.eval(e.RhsWorker).(worker)
+		return newPipeline(fr.th.in, lhs, rhs, e.ReceiveType)
+
+	case *ast.CreateWorkerExpression:
+		var fn value
+		if e.ClosureVariable != "" {
+			c, _ := fr.lookup(e.ClosureVariable)
+			fn = c.load()
+		} else {
+			fn = fr.eval(e.Worker)
+		}
+
+		switch e.Destination {
+		case ast.KDestinationGpu:
+			if e.Device != nil {
+				device := toInt(fr.eval(e.Device))
+				if device < 0 || int(device) >= fr.th.in.deviceCount() {
+					runtimePanic("ey_worker_create_opencl", "no device at that index")
+				}
+			}
+			return newGpuWorker(fr.th.in, fn, e.ReceiveType)
+
+		case ast.KDestinationCpu:
+			return newCpuWorker(fr.th.in, fn, e.ReceiveType)
+		}
+
+	case *ast.ReceiveWorkerExpression:
+		return fr.eval(e.Received)
+	}
+
+	panic(fmt.Sprintf("interp: Do not recognise expression %v", re))
+}
+
+// The value referring to a function, from its id
+func functionValue(fid ast.FunctionId) value {
+	if fid.Struct.Blank() && fid.Module.IsBuiltin() {
+		return nativeValue{name: fid.Name}
+	}
+	return funcValue{key: functionKey(fid), name: fid.Name}
+}
+
+// Take the address of an expression, which for anything but a variable, field or element is a temporary
+func (fr *frame) addressOf(re ast.Expression) ref {
+	switch e := re.(type) {
+	case *ast.IdentifierTerminal:
+		if e.Fid == nil {
+			if c, fnd := fr.lookup(e.Name); fnd {
+				return c
+			}
+		}
+
+	case *ast.AccessExpression:
+		accessed := fr.eval(e.Accessed)
+		if e.Accessed.Type().Selector == ast.KTypePointer {
+			accessed = deref(accessed)
+		}
+		if sv, ok := accessed.(*structValue); ok {
+			return fieldRef{s: sv, i: sv.layout.index(e.Identifier)}
+		}
+
+	case *ast.IndexExpression:
+		if e.AccessedType == ast.KTypeVector {
+			vec := fr.eval(e.Indexed).(*vector)
+			index := toInt(fr.eval(e.Index))
+			checkAccess(vec, index)
+			return elemRef{vec: vec, i: int(index)}
+		}
+
+	case *ast.DereferenceExpression:
+		if r, ok := fr.eval(e.Pointer).(ref); ok {
+			return r
+		}
+		runtimePanic("interp", "null pointer dereference")
+	}
+
+	return &cell{v: fr.eval(re)}
+}
+
+// The location an lvalue refers to
+func (fr *frame) lvalueRef(rlv ast.LValue) ref {
+	switch lv := rlv.(type) {
+	case *ast.IdentifierLValue:
+		if c, fnd := fr.lookup(lv.Name); fnd {
+			return c
+		}
+		panic(fmt.Sprintf("interp: no variable named %v", lv.Name))
+
+	case *ast.SelfLValue:
+		return &cell{v: fr.self}
+
+	case *ast.DerefLValue:
+		if r, ok := fr.lvalueValue(lv.Inner).(ref); ok {
+			return r
+		}
+		runtimePanic("interp", "null pointer dereference")
+
+	case *ast.AccessorLValue:
+		inner := fr.lvalueValue(lv.Inner)
+		if lv.Inner.Type().Selector == ast.KTypePointer {
+			inner = deref(inner)
+		}
+		sv := inner.(*structValue)
+		return fieldRef{s: sv, i: sv.layout.index(lv.FieldName)}
+
+	case *ast.IndexLValue:
+		indexed := fr.lvalueValue(lv.Indexed)
+		index := toInt(fr.eval(lv.Index))
+
+		switch s := indexed.(type) {
+		case *vector:
+			checkAccess(s, index)
+			return elemRef{vec: s, i: int(index)}
+
+		case *str:
+			return charRef{s: s, i: int(index)}
+		}
+	}
+
+	panic(fmt.Sprintf("interp: Do not recognise lvalue %v", rlv))
+}
+
+func (fr *frame) lvalueValue(lv ast.LValue) value {
+	if _, ok := lv.(*ast.SelfLValue); ok {
+		return fr.self
+	}
+	return fr.lvalueRef(lv).load()
+}
+
+func checkAccess(vec *vector, index int32) {
+	if vec == nil {
+		runtimePanic("interp", "null pointer dereference")
+	}
+	if index < 0 {
+		runtimePanic("ey_vector_access", "index out of range (-ve)")
+	}
+	if int(index) >= len(vec.elems) {
+		runtimePanic("ey_vector_access", "index out of range (+ve)")
+	}
+}
+
+func makeRange(start, end, step int32) *vector {
+	r := &vector{elemType: ast.Type{Selector: ast.KTypeInteger}}
+	if step == 0 {
+		return r
+	}
+
+	if step < 0 {
+		for val := start; val > end; val += step {
+			r.elems = append(r.elems, val)
+		}
+	} else {
+		for val := start; val < end; val += step {
+			r.elems = append(r.elems, val)
+		}
+	}
+	return r
+}
+
+func stringsEqual(lhs, rhs *str) bool {
+	if len(lhs.chars) != len(rhs.chars) {
+		return false
+	}
+	for i, c := range lhs.chars {
+		if rhs.chars[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// The rank of a number in C's usual arithmetic conversions
+func numericRank(v value) int {
+	switch v.(type) {
+	case float64:
+		return 4
+	case float32:
+		return 3
+	case character:
+		return 2
+	case int32, bool:
+		return 1
+	}
+	return 0
+}
+
+// Apply an arithmetic or comparison operator, converting the operands as C would
+func binary(op ast.BinaryOperator, lhs, rhs value) value {
+	rank := numericRank(lhs)
+	if r := numericRank(rhs); r > rank {
+		rank = r
+	}
+
+	switch rank {
+	case 0:
+		// pointers, which can only be compared
+		switch op {
+		case ast.KOperatorEquality:
+			return lhs == rhs
+		case ast.KOperatorInequality:
+			return lhs != rhs
+		}
+
+	case 4, 3:
+		l, r := toFloat(lhs), toFloat(rhs)
+		var result float64
+		switch op {
+		case ast.KOperatorAdd:
+			result = l + r
+		case ast.KOperatorSubtract:
+			result = l - r
+		case ast.KOperatorMultiply:
+			result = l * r
+		case ast.KOperatorDivide:
+			result = l / r
+		case ast.KOperatorMod:
+			result = math.Mod(l, r)
+		default:
+			return compare(op, l, r)
+		}
+
+		if rank == 3 {
+			// float32 arithmetic rounds at each step
+			return float32(result)
+		}
+		return result
+
+	case 2:
+		l, r := uint32(toInt(lhs)), uint32(toInt(rhs))
+		switch op {
+		case ast.KOperatorAdd:
+			return character(l + r)
+		case ast.KOperatorSubtract:
+			return character(l - r)
+		case ast.KOperatorMultiply:
+			return character(l * r)
+		case ast.KOperatorDivide:
+			checkDivisor(int64(r))
+			return character(l / r)
+		case ast.KOperatorMod:
+			checkDivisor(int64(r))
+			return character(l % r)
+		default:
+			return compare(op, float64(l), float64(r))
+		}
+
+	case 1:
+		l, r := toInt(lhs), toInt(rhs)
+		switch op {
+		case ast.KOperatorAdd:
+			return l + r
+		case ast.KOperatorSubtract:
+			return l - r
+		case ast.KOperatorMultiply:
+			return l * r
+		case ast.KOperatorDivide:
+			checkDivisor(int64(r))
+			return l / r
+		case ast.KOperatorMod:
+			checkDivisor(int64(r))
+			return l % r
+		default:
+			return compare(op, float64(l), float64(r))
+		}
+	}
+
+	panic(fmt.Sprintf("interp: cannot apply operator %v to %v and %v", op, lhs, rhs))
+}
+
+func compare(op ast.BinaryOperator, l, r float64) bool {
+	switch op {
+	case ast.KOperatorEquality:
+		return l == r
+	case ast.KOperatorInequality:
+		return l != r
+	case ast.KOperatorLT:
+		return l < r
+	case ast.KOperatorLTE:
+		return l <= r
+	case ast.KOperatorGT:
+		return l > r
+	case ast.KOperatorGTE:
+		return l >= r
+	}
+	panic(fmt.Sprintf("interp: Do not understand binary operator %v", op))
+}
+
+func checkDivisor(d int64) {
+	if d == 0 {
+		runtimePanic("interp", "integer division by zero")
+	}
+}
@@ -0,0 +1,477 @@
+/*
+A tree-walking interpreter for checked programs
+
+This runs the same lowered AST the C writer is given, with the runtime written in Go, so a
+program can be run without a C compiler. Cpu workers run as goroutines, and gpu workers are
+emulated on the cpu, printing their logs as the OpenCL runtime does.
+*/
+package interp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"eyot/ast"
+	"eyot/program"
+)
+
+// The message given when a program calls something only the compiled runtime has
+const unavailableMessage = "not available in the interpreter"
+
+// Returned by Run when the program exits with a non zero code, reading as an exec.ExitError would
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %v", e.Code)
+}
+
+// The cpu and gpu variants of one function
+type functionSet struct {
+	cpu, gpu *ast.FunctionDefinition
+}
+
+type Interpreter struct {
+	p *program.Program
+
+	// every function by functionKey
+	functions map[string]*functionSet
+
+	// every struct by its key, and tuples by their identifier
+	layouts     map[string]*layout
+	tupleMutex  sync.Mutex
+	tupleLayout map[string]*layout
+
+	// the consts of all modules, which like the C globals share one namespace
+	globals map[string]*cell
+
+	pool        []string
+	sourcePaths map[string]string
+	args        []string
+
+	stdin  *bufio.Reader
+	stdout *bufio.Writer
+	stderr io.Writer
+
+	// held to write to stdout or stderr
+	outMutex sync.Mutex
+	exited   bool
+
+	// the exit code is sent here, once
+	done chan int
+
+	randMutex sync.Mutex
+	rand      *glibcRand
+}
+
+// Run the main function of a checked program, with args as the program's arguments
+func Run(p *program.Program, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	in := &Interpreter{
+		p:           p,
+		functions:   map[string]*functionSet{},
+		layouts:     map[string]*layout{},
+		tupleLayout: map[string]*layout{},
+		globals:     map[string]*cell{},
+		pool:        p.GetStringPool(),
+		sourcePaths: map[string]string{},
+		args:        args,
+		stdin:       bufio.NewReader(stdin),
+		stdout:      bufio.NewWriter(stdout),
+		stderr:      stderr,
+		done:        make(chan int, 1),
+	}
+	in.load()
+
+	mainFid := ast.FunctionId{
+		Module: p.RootModuleId,
+		Struct: ast.BlankStructId(),
+		Name:   "main",
+	}
+
+	go in.guard(func() {
+		th := &thread{in: in}
+		th.initGlobals()
+		th.callFunction(funcValue{key: functionKey(mainFid), name: "main"}, []value{})
+		in.exit(0)
+	})
+
+	if code := <-in.done; code != 0 {
+		return &ExitError{Code: code}
+	}
+	return nil
+}
+
+// The key of a function in the table, methods are keyed by their struct as the module in their id can be wrong
+func functionKey(fid ast.FunctionId) string {
+	if fid.Struct.Blank() {
+		return fid.Module.Key() + "::" + fid.Name
+	}
+	return fid.Struct.Key() + "." + fid.Name
+}
+
+// Gather the functions and structs of every module
+func (in *Interpreter) load() {
+	keys := []string{}
+	for key := range in.p.Modules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		m := in.p.Modules[key]
+		if path := in.p.Env.FindModule(m.Id); path != "" {
+			in.sourcePaths[key] = path
+		}
+
+		for _, s := range m.Structs {
+			if !s.GeneratedForTuple {
+				in.addStruct(s.Id, s.Definition)
+			}
+		}
+
+		for _, tlec := range m.TopLevelElements {
+			switch tle := tlec.TopLevelElement.(type) {
+			case *ast.FunctionDefinitionTle:
+				in.addFunction(tle.Definition)
+
+			case *ast.StructDefinitionStatement:
+				in.addStruct(tle.Id, tle.Definition)
+			}
+		}
+	}
+}
+
+func (in *Interpreter) addStruct(id ast.StructId, sd ast.StructDefinition) {
+	if _, fnd := in.layouts[id.Key()]; !fnd {
+		l := &layout{}
+		for _, field := range sd.Fields {
+			l.names = append(l.names, field.Name)
+			l.types = append(l.types, field.Type)
+		}
+		in.layouts[id.Key()] = l
+	}
+
+	for _, fd := range sd.Functions {
+		in.addFunction(fd)
+	}
+}
+
+func (in *Interpreter) addFunction(fd *ast.FunctionDefinition) {
+	key := functionKey(fd.Id)
+	fs, fnd := in.functions[key]
+	if !fnd {
+		fs = &functionSet{}
+		in.functions[key] = fs
+	}
+
+	switch fd.Location {
+	case ast.KLocationCpu:
+		fs.cpu = fd
+
+	case ast.KLocationGpu:
+		fs.gpu = fd
+
+	case ast.KLocationAnywhere:
+		// a variant written for one location takes priority
+		if fs.cpu == nil {
+			fs.cpu = fd
+		}
+		if fs.gpu == nil {
+			fs.gpu = fd
+		}
+	}
+}
+
+// Find the variant of a function to run on the cpu, or the gpu
+func (in *Interpreter) lookupFunction(f funcValue, gpu bool) *ast.FunctionDefinition {
+	fs, fnd := in.functions[f.key]
+	if !fnd {
+		panic(fmt.Sprintf("interp: no function named %v", f.name))
+	}
+
+	if gpu && fs.gpu != nil {
+		return fs.gpu
+	}
+	if fs.cpu != nil {
+		return fs.cpu
+	}
+	return fs.gpu
+}
+
+func (in *Interpreter) structLayout(id ast.StructId) *layout {
+	l, fnd := in.layouts[id.Key()]
+	if !fnd {
+		panic(fmt.Sprintf("interp: no struct named %v", id.Name))
+	}
+	return l
+}
+
+func (in *Interpreter) typeLayout(ty ast.Type) *layout {
+	if ty.Selector == ast.KTypeStruct {
+		return in.structLayout(ty.StructId)
+	}
+
+	in.tupleMutex.Lock()
+	defer in.tupleMutex.Unlock()
+
+	key := ty.TupleIdentifier()
+	if l, fnd := in.tupleLayout[key]; fnd {
+		return l
+	}
+
+	l := &layout{}
+	for i, fty := range ty.Types {
+		l.names = append(l.names, fmt.Sprintf("f%v", i))
+		l.types = append(l.types, fty)
+	}
+	in.tupleLayout[key] = l
+	return l
+}
+
+// The value a variable of the type holds before it is assigned, zeroed as the C runtime's allocations are
+func (in *Interpreter) zero(ty ast.Type) value {
+	switch ty.Selector {
+	case ast.KTypeInteger:
+		return int32(0)
+
+	case ast.KTypeFloat:
+		if ty.Width == 32 {
+			return float32(0)
+		}
+		return float64(0)
+
+	case ast.KTypeCharacter:
+		return character(0)
+
+	case ast.KTypeBoolean:
+		return false
+
+	case ast.KTypeString:
+		return &str{}
+
+	case ast.KTypeStruct, ast.KTypeTuple:
+		l := in.typeLayout(ty)
+		sv := &structValue{layout: l, fields: make([]value, len(l.types))}
+		for i, fty := range l.types {
+			sv.fields[i] = in.zero(fty)
+		}
+		return sv
+
+	default:
+		return nil
+	}
+}
+
+// An error that stops the program, as ey_runtime_panic does
+type runtimeError struct {
+	unit, msg string
+}
+
+// A request to stop the program with the code
+type exitRequest struct {
+	code int
+}
+
+func runtimePanic(unit, msg string) {
+	panic(&runtimeError{unit: unit, msg: msg})
+}
+
+func unavailable(name string) {
+	runtimePanic(name, unavailableMessage)
+}
+
+// Run f as a thread of the program, so a runtime error or exit within it stops the program
+func (in *Interpreter) guard(f func()) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		switch e := r.(type) {
+		case *runtimeError:
+			in.writeError(fmt.Sprintf("%v: %v\n", e.unit, e.msg))
+			in.exit(1)
+
+		case *exitRequest:
+			in.exit(e.code)
+
+		default:
+			in.writeError(fmt.Sprintf("interp: internal error: %v\n%s", r, debug.Stack()))
+			in.exit(1)
+		}
+	}()
+
+	f()
+}
+
+// Flush the output and stop the program, later output from other threads is dropped
+func (in *Interpreter) exit(code int) {
+	in.outMutex.Lock()
+	if !in.exited {
+		in.stdout.Flush()
+		in.exited = true
+		in.done <- code
+	}
+	in.outMutex.Unlock()
+}
+
+func (in *Interpreter) write(b []byte) {
+	in.outMutex.Lock()
+	if !in.exited {
+		in.stdout.Write(b)
+	}
+	in.outMutex.Unlock()
+}
+
+// Write to stderr, flushing stdout first so the two appear in order
+func (in *Interpreter) writeError(msg string) {
+	in.outMutex.Lock()
+	if !in.exited {
+		in.stdout.Flush()
+		io.WriteString(in.stderr, msg)
+	}
+	in.outMutex.Unlock()
+}
+
+// A thread of execution, on the cpu or as a gpu work item
+type thread struct {
+	in *Interpreter
+
+	// true when running as a gpu work item
+	gpu bool
+
+	// where a gpu work item prints to
+	log *logSlot
+}
+
+func (th *thread) print(b []byte) {
+	if th.log != nil {
+		th.log.write(b)
+	} else {
+		th.in.write(b)
+	}
+}
+
+// Evaluate the consts of every module into the globals
+func (th *thread) initGlobals() {
+	fr := &frame{th: th, vars: &scope{vars: th.in.globals}}
+
+	keys := []string{}
+	for key := range th.in.p.Modules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, tlec := range th.in.p.Modules[key].TopLevelElements {
+			if ct, ok := tlec.TopLevelElement.(*ast.ConstTle); ok {
+				fr.assign(ct.Assign)
+			}
+		}
+	}
+}
+
+func (th *thread) call(fn value, args []value) value {
+	switch f := fn.(type) {
+	case funcValue:
+		return th.callFunction(f, args)
+
+	case *closureValue:
+		return th.callFunction(f.fn, f.resolve(args))
+
+	case nativeValue:
+		return th.callNative(f.name, args)
+
+	case workerMethod:
+		return th.callWorkerMethod(f, args)
+
+	case nil:
+		runtimePanic("interp", "call of a null function")
+	}
+
+	panic(fmt.Sprintf("interp: cannot call %v", fn))
+}
+
+func (th *thread) callFunction(f funcValue, args []value) value {
+	fd := th.in.lookupFunction(f, th.gpu)
+
+	fr := &frame{th: th, vars: &scope{vars: map[string]*cell{}}}
+	if !fd.Id.Struct.Blank() {
+		fr.self = args[0]
+		args = args[1:]
+	}
+	for i, param := range fd.Parameters {
+		c := newCell(param.Type)
+		c.store(args[i])
+		fr.vars.vars[param.Name] = c
+	}
+
+	fr.execBlock(fd.Block)
+
+	if fd.Return.Selector == ast.KTypeVoid {
+		return nil
+	}
+	return convert(fr.ret, fd.Return)
+}
+
+// The arguments for the underlying function, from those frozen in the closure and those passed
+func (c *closureValue) resolve(args []value) []value {
+	resolved := make([]value, len(c.args))
+	passed := 0
+	for i := range c.args {
+		if c.present[i] {
+			resolved[i] = c.args[i]
+		} else {
+			resolved[i] = args[passed]
+			passed += 1
+		}
+	}
+	return resolved
+}
+
+// Variables declared in one block
+type scope struct {
+	vars   map[string]*cell
+	parent *scope
+}
+
+// A function being run
+type frame struct {
+	th   *thread
+	vars *scope
+
+	// ey_self, the pointer a method was called on
+	self value
+
+	// the returned value, once a return is run
+	ret value
+}
+
+func (fr *frame) lookup(name string) (*cell, bool) {
+	for s := fr.vars; s != nil; s = s.parent {
+		if c, fnd := s.vars[name]; fnd {
+			return c, true
+		}
+	}
+
+	c, fnd := fr.th.in.globals[name]
+	return c, fnd
+}
+
+func (fr *frame) declare(name string, c *cell) {
+	fr.vars.vars[name] = c
+}
+
+func (fr *frame) push() {
+	fr.vars = &scope{vars: map[string]*cell{}, parent: fr.vars}
+}
+
+func (fr *frame) pop() {
+	fr.vars = fr.vars.parent
+}
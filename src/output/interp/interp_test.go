@@ -0,0 +1,153 @@
+package interp
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eyot/errors"
+	"eyot/program"
+)
+
+// Check src as the module main and run it, returning what it printed and the error from Run
+func run(t *testing.T, src string) (string, error) {
+	root, err := filepath.Abs(filepath.Join("..", "..", "..", "lib"))
+	if err != nil {
+		t.Fatalf("No library root: %v", err)
+	}
+
+	dir := t.TempDir()
+	env := &program.Environment{
+		Roots:    []string{dir, root},
+		Packages: map[string]string{},
+		Overlay:  map[string]string{filepath.Join(dir, "main.ey"): src},
+	}
+
+	es := errors.NewErrors()
+	p := program.NewProgram(env, es)
+	p.ParseRoot("main")
+	if !es.Clean() {
+		buf := bytes.NewBuffer([]byte{})
+		es.LogErrors(buf)
+		t.Fatalf("Failed to check:\n%v", buf.String())
+	}
+
+	out := bytes.NewBuffer([]byte{})
+	err = Run(p, []string{"main"}, strings.NewReader(""), out, out)
+	return out.String(), err
+}
+
+func expectOutput(t *testing.T, src, expected string) {
+	out, err := run(t, src)
+	if err != nil {
+		t.Fatalf("Run failed with %v, after printing '%v'", err, out)
+	}
+	if out != expected {
+		t.Fatalf("Wrong output, expecting '%v', got '%v'", expected, out)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	expectOutput(t, `
+struct Point {
+    x i64
+    y i64
+
+    fn sum() i64 {
+        return self.x + self.y
+    }
+}
+
+cpu fn main() {
+    let p = Point { x: 2, y: 3 }
+    let total = 0
+    for v: [i64] { 1, 2, 3 } {
+        total = total + v * p.sum()
+    }
+    print_ln("total ", total, " ", 7 / 2, " ", 1.5 * 2.0)
+}
+`, "total 30 3 3.000000\n")
+}
+
+func TestCpuWorker(t *testing.T) {
+	expectOutput(t, `
+fn square(v i64) i64 {
+    return v * v
+}
+
+cpu fn main() {
+    let w = cpu square
+    send(w, [i64] { 1, 2, 3 })
+    for v: drain(w) {
+        print_ln(v)
+    }
+}
+`, "1\n4\n9\n")
+}
+
+func TestGpuWorker(t *testing.T) {
+	expectOutput(t, `
+import std::runtime
+
+fn log(val i64) i64 {
+    print_ln("val = ", val)
+    return val + 1
+}
+
+cpu fn main() {
+    print_ln(runtime::can_use_gpu(), " ", runtime::gpu_devices().length())
+
+    let c = gpu log
+    send(c, [i64] { 1, 2 })
+    for v: drain(c) {
+        print_ln(v)
+    }
+}
+`, "true 1\n(gpu 0) val = 1\n(gpu 1) val = 2\n2\n3\n")
+}
+
+// As with the compiled runtime, the gpu is only there for programs that have gpu code
+func TestNoGpuCode(t *testing.T) {
+	expectOutput(t, `
+import std::runtime
+
+cpu fn main() {
+    print_ln(runtime::can_use_gpu(), " ", runtime::gpu_devices().length())
+}
+`, "false 0\n")
+}
+
+func TestExit(t *testing.T) {
+	out, err := run(t, `
+import std::os
+
+cpu fn main() {
+    print_ln("before")
+    os::exit(3)
+    print_ln("after")
+}
+`)
+
+	exit, ok := err.(*ExitError)
+	if !ok || exit.Code != 3 {
+		t.Fatalf("Expecting exit status 3, have %v", err)
+	}
+	if out != "before\n" {
+		t.Fatalf("Wrong output: '%v'", out)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	out, err := run(t, `
+import std::runtime
+
+cpu fn main() {
+    print_ln(runtime::gc_stats().collections)
+}
+`)
+
+	if err == nil || !strings.Contains(out, unavailableMessage) {
+		t.Fatalf("Expecting the program to stop as unavailable, have %v '%v'", err, out)
+	}
+}
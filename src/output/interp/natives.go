@@ -0,0 +1,409 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"eyot/ast"
+)
+
+// A function of the C runtime or standard library, reimplemented in Go
+type nativeFunction func(th *thread, args []value) value
+
+// The number of gpu devices the interpreter pretends to have, when the program has gpu code
+const emulatedDeviceCount = 1
+
+/*
+The number of emulated gpu devices
+
+As the compiled runtime only starts OpenCL for a program with gpu code, a program without any
+sees no devices
+*/
+func (in *Interpreter) deviceCount() int {
+	if !in.p.GpuRequired {
+		return 0
+	}
+	return emulatedDeviceCount
+}
+
+// The device at index, as the compiled runtime gives "" and 0 for one out of range
+func (th *thread) hasDevice(index value) bool {
+	device := toInt(index)
+	return device >= 0 && int(device) < th.in.deviceCount()
+}
+
+var natives map[string]nativeFunction
+
+func init() {
+	natives = map[string]nativeFunction{
+		"ey_noop": func(th *thread, args []value) value {
+			return nil
+		},
+
+		/*
+		   Printing, as the runtime's ey_print_* functions
+		*/
+		"ey_print_nl": func(th *thread, args []value) value {
+			th.print([]byte{'\n'})
+			return nil
+		},
+		"ey_print_int": func(th *thread, args []value) value {
+			th.print(formatInt(toInt(args[0]), 0))
+			return nil
+		},
+		"ey_print_float32": func(th *thread, args []value) value {
+			th.print(formatFloat32(float32(toFloat(args[0]))))
+			return nil
+		},
+		"ey_print_float64": func(th *thread, args []value) value {
+			th.print(formatFloat64(toFloat(args[0])))
+			return nil
+		},
+		"ey_print_string": func(th *thread, args []value) value {
+			if s, ok := args[0].(*str); ok {
+				th.print([]byte(s.String()))
+			}
+			return nil
+		},
+		"ey_print_boolean": func(th *thread, args []value) value {
+			if toBool(args[0]) {
+				th.print([]byte("true"))
+			} else {
+				th.print([]byte("false"))
+			}
+			return nil
+		},
+		"ey_print_character": func(th *thread, args []value) value {
+			th.print(encodeCharacter(uint32(toInt(args[0]))))
+			return nil
+		},
+
+		/*
+		   Allocation
+		*/
+		"ey_runtime_gc": func(th *thread, args []value) value {
+			return nil
+		},
+		"ey_runtime_gc_alloc_tagged": func(th *thread, args []value) value {
+			ty := args[1].(sizeofValue).ty
+			c := newCell(ty)
+			c.v = th.in.zero(ty)
+			return c
+		},
+
+		/*
+		   Vectors
+		*/
+		"ey_vector_create": func(th *thread, args []value) value {
+			return &vector{elemType: args[0].(sizeofValue).ty}
+		},
+		"ey_vector_append": func(th *thread, args []value) value {
+			vec := args[0].(*vector)
+			vec.elems = append(vec.elems, convert(args[1].(ref).load(), vec.elemType))
+			return nil
+		},
+		"ey_vector_erase": func(th *thread, args []value) value {
+			vec := args[0].(*vector)
+			start, count := toInt(args[1]), toInt(args[2])
+			if count == 0 {
+				return nil
+			}
+			if int(start+count) > len(vec.elems) {
+				runtimePanic("ey_vector_erase", "deleting out of range of vector")
+			}
+			vec.elems = append(vec.elems[:start], vec.elems[start+count:]...)
+			return nil
+		},
+		"ey_vector_resize": func(th *thread, args []value) value {
+			vec := args[0].(*vector)
+			length := int(toInt(args[1]))
+			if length <= len(vec.elems) {
+				vec.elems = vec.elems[:length]
+			}
+			for len(vec.elems) < length {
+				vec.elems = append(vec.elems, th.in.zero(vec.elemType))
+			}
+			return nil
+		},
+		"ey_vector_length": func(th *thread, args []value) value {
+			return int32(len(args[0].(*vector).elems))
+		},
+
+		/*
+		   Strings
+		*/
+		"ey_runtime_string_character_length": func(th *thread, args []value) value {
+			return int32(len(args[0].(*str).chars))
+		},
+		"ey_runtime_string_resize": func(th *thread, args []value) value {
+			s := args[0].(*str)
+			if s.static {
+				// the copy is returned, and dropped by the caller
+				s = &str{chars: append([]rune{}, s.chars...)}
+			}
+			length := int(toInt(args[1]))
+			if length <= len(s.chars) {
+				s.chars = s.chars[:length]
+			}
+			for len(s.chars) < length {
+				s.chars = append(s.chars, ' ')
+			}
+			return s
+		},
+
+		/*
+		   Closures
+		*/
+		"ey_closure_call": func(th *thread, args []value) value {
+			c := args[0].(*closureValue)
+			passed := []value{}
+			for _, r := range args[2].(argArray) {
+				if r != nil {
+					passed = append(passed, r.load())
+				}
+			}
+
+			result := th.call(c, passed)
+			if output, ok := args[1].(ref); ok {
+				output.store(result)
+			}
+			return nil
+		},
+
+		/*
+		   The gpu builtins, as kernels see them
+		*/
+		"sqrt": func(th *thread, args []value) value {
+			return float32(math.Sqrt(toFloat(args[0])))
+		},
+		"exp": func(th *thread, args []value) value {
+			return float32(math.Exp(toFloat(args[0])))
+		},
+
+		/*
+		   std
+		*/
+		"ey_stdlib_readline": func(th *thread, args []value) value {
+			line, err := th.in.stdin.ReadString('\n')
+			if err != nil && err != io.EOF {
+				line = ""
+			}
+			return newStr(line)
+		},
+		"ey_stdlib_read_text_file": func(th *thread, args []value) value {
+			path := args[0].(*str).String()
+			blob, err := os.ReadFile(path)
+			if err != nil {
+				th.in.write([]byte(fmt.Sprintf("No file found at '%s'\n", path)))
+				return newStr("")
+			}
+			return newStr(string(blob))
+		},
+		"ey_stdlib_rand": func(th *thread, args []value) value {
+			th.in.randMutex.Lock()
+			defer th.in.randMutex.Unlock()
+			if th.in.rand == nil {
+				th.in.rand = newGlibcRand(1)
+			}
+			return th.in.rand.next()
+		},
+		"ey_stdlib_rand_max": func(th *thread, args []value) value {
+			return int32(math.MaxInt32)
+		},
+		"ey_stdlib_sqrtf": func(th *thread, args []value) value {
+			return float32(math.Sqrt(toFloat(args[0])))
+		},
+		"ey_stdlib_sqrtd": func(th *thread, args []value) value {
+			return math.Sqrt(toFloat(args[0]))
+		},
+		"ey_stdlib_expd": func(th *thread, args []value) value {
+			return math.Exp(toFloat(args[0]))
+		},
+		"ey_stdlib_expf": func(th *thread, args []value) value {
+			return float32(math.Exp(toFloat(args[0])))
+		},
+		"ey_stdlib_cosd": func(th *thread, args []value) value {
+			return math.Cos(toFloat(args[0]))
+		},
+		"ey_stdlib_logd": func(th *thread, args []value) value {
+			return math.Log(toFloat(args[0]))
+		},
+		"ey_stdlib_sind": func(th *thread, args []value) value {
+			return math.Sin(toFloat(args[0]))
+		},
+		"ey_stdlib_tand": func(th *thread, args []value) value {
+			return math.Tan(toFloat(args[0]))
+		},
+		"ey_runtime_get_args": func(th *thread, args []value) value {
+			vec := &vector{elemType: ast.Type{Selector: ast.KTypeString}}
+			for _, arg := range th.in.args {
+				vec.elems = append(vec.elems, newStr(arg))
+			}
+			return vec
+		},
+		"ffi_os_usleep": func(th *thread, args []value) value {
+			time.Sleep(time.Duration(toInt(args[0])) * time.Microsecond)
+			return nil
+		},
+		"ffi_os_exit": func(th *thread, args []value) value {
+			panic(&exitRequest{code: int(toInt(args[0]))})
+		},
+		"ffi_panic": func(th *thread, args []value) value {
+			runtimePanic("runtime", "panic")
+			return nil
+		},
+
+		/*
+		   std/runtime, with emulated devices and a collector that is Go's
+		*/
+		"ey_runtime_check_cl": func(th *thread, args []value) value {
+			return th.in.deviceCount() > 0
+		},
+		"ey_runtime_cl_device_count": func(th *thread, args []value) value {
+			return int32(th.in.deviceCount())
+		},
+		"ey_runtime_cl_default_device": func(th *thread, args []value) value {
+			if th.in.deviceCount() == 0 {
+				return int32(-1)
+			}
+			return int32(0)
+		},
+		"ey_runtime_cl_device_name": func(th *thread, args []value) value {
+			if !th.hasDevice(args[0]) {
+				return newStr("")
+			}
+			return newStr("eyot interpreter")
+		},
+		"ey_runtime_cl_device_memory_mb": func(th *thread, args []value) value {
+			return int32(0)
+		},
+		"ey_runtime_cl_device_compute_units": func(th *thread, args []value) value {
+			if !th.hasDevice(args[0]) {
+				return int32(0)
+			}
+			return int32(1)
+		},
+		"ey_runtime_collect": func(th *thread, args []value) value {
+			return nil
+		},
+		"ey_runtime_collect_young": func(th *thread, args []value) value {
+			return nil
+		},
+		"ey_runtime_collect_if_needed": func(th *thread, args []value) value {
+			return nil
+		},
+	}
+}
+
+func (th *thread) callNative(name string, args []value) value {
+	if fn, fnd := natives[name]; fnd {
+		return fn(th, args)
+	}
+
+	if strings.HasPrefix(name, "ey_vector_add_") {
+		vec := args[0].(*vector)
+		vec.elems = append(vec.elems, convert(args[1], vec.elemType))
+		return nil
+	}
+
+	// the gc internals, tracing and any other ffi need the compiled runtime
+	unavailable(name)
+	return nil
+}
+
+// Format an integer as ey_print_int_core does, with at least digits digits
+func formatInt(val int32, digits int) []byte {
+	out := []byte{}
+	n := int64(val)
+	if n < 0 {
+		out = append(out, '-')
+		n = -n
+	}
+
+	s := fmt.Sprintf("%v", n)
+	for len(s) < digits {
+		s = "0" + s
+	}
+	return append(out, s...)
+}
+
+func formatFloat64(val float64) []byte {
+	out := []byte{}
+	if val < 0 {
+		out = append(out, '-')
+		val *= -1.0
+	}
+
+	integral := int32(val)
+	fractional := val - float64(integral)
+
+	out = append(out, formatInt(integral, 0)...)
+	out = append(out, '.')
+	return append(out, formatInt(int32(fractional*1000000.0), 6)...)
+}
+
+func formatFloat32(val float32) []byte {
+	out := []byte{}
+	if val < 0 {
+		out = append(out, '-')
+		val *= -1.0
+	}
+
+	integral := int32(val)
+	fractional := val - float32(integral)
+
+	out = append(out, formatInt(integral, 0)...)
+	out = append(out, '.')
+	return append(out, formatInt(int32(float64(fractional)*1000000.0), 6)...)
+}
+
+func encodeCharacter(code uint32) []byte {
+	buf := make([]byte, utf8.UTFMax)
+	return buf[:utf8.EncodeRune(buf, rune(code))]
+}
+
+// The rand() of glibc, so seeded programs print what they would compiled
+type glibcRand struct {
+	r     []int32
+	index int
+}
+
+func newGlibcRand(seed int32) *glibcRand {
+	r := make([]int32, 34)
+	r[0] = seed
+	for i := 1; i < 31; i += 1 {
+		hi := int64(r[i-1]) / 127773
+		lo := int64(r[i-1]) % 127773
+		word := 16807*lo - 2836*hi
+		if word < 0 {
+			word += 2147483647
+		}
+		r[i] = int32(word)
+	}
+	for i := 31; i < 34; i += 1 {
+		r[i] = r[i-31]
+	}
+
+	g := &glibcRand{r: r}
+	// the first 310 outputs are discarded
+	for i := 0; i < 310; i += 1 {
+		g.step()
+	}
+	return g
+}
+
+func (g *glibcRand) step() uint32 {
+	n := len(g.r)
+	v := uint32(g.r[n-31]) + uint32(g.r[n-3])
+	g.r = append(g.r[1:], int32(v))
+	return v
+}
+
+func (g *glibcRand) next() int32 {
+	return int32(g.step() >> 1)
+}
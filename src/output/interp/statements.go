@@ -0,0 +1,216 @@
+package interp
+
+import (
+	"fmt"
+
+	"eyot/ast"
+)
+
+// How control leaves a statement
+type flow int
+
+const (
+	kFlowNormal flow = iota
+	kFlowBreak
+	kFlowReturn
+)
+
+// Run a block in its own scope
+func (fr *frame) execBlock(ss *ast.StatementBlock) flow {
+	fr.push()
+	defer fr.pop()
+
+	for _, sc := range ss.Statements {
+		if f := fr.exec(sc.Statement); f != kFlowNormal {
+			return f
+		}
+	}
+	return kFlowNormal
+}
+
+func (fr *frame) exec(rst ast.Statement) flow {
+	switch st := rst.(type) {
+	case *ast.ModifyInPlaceStatement:
+		r := fr.lvalueRef(st.Modified)
+		rhs := fr.eval(st.Expression)
+
+		switch st.Operator {
+		case ast.KModifyPlus:
+			r.store(binary(ast.KOperatorAdd, r.load(), rhs))
+
+		case ast.KModifyMinus:
+			r.store(binary(ast.KOperatorSubtract, r.load(), rhs))
+
+		case ast.KModifyTimes:
+			r.store(binary(ast.KOperatorMultiply, r.load(), rhs))
+
+		case ast.KModifyDivide:
+			r.store(binary(ast.KOperatorDivide, r.load(), rhs))
+		}
+
+	case *ast.AssignStatement:
+		fr.assign(st)
+
+	case *ast.BreakStatement:
+		return kFlowBreak
+
+	case *ast.DummyStatement:
+		// do nothing, it is just here to hold source locations
+
+	case *ast.WhileStatement:
+		for toBool(fr.eval(st.Condition)) {
+			if f := fr.execBlock(st.Block); f == kFlowBreak {
+				break
+			} else if f == kFlowReturn {
+				return f
+			}
+		}
+
+	case *ast.ForeachStatement:
+		switch st.Variant {
+		case ast.KForEach:
+			vec := fr.eval(st.Iterable).(*vector)
+			for index := 0; index < len(vec.elems); index += 1 {
+				fr.push()
+				it := newCell(st.IteratedType)
+				it.store(vec.elems[index])
+				fr.declare(st.TemporaryVariableName, it)
+				f := fr.execBlock(st.Body)
+				fr.pop()
+
+				if f == kFlowBreak {
+					break
+				} else if f == kFlowReturn {
+					return f
+				}
+			}
+
+		case ast.KForRange:
+			start, _ := fr.lookup(st.StartName)
+			end, _ := fr.lookup(st.EndName)
+			step, _ := fr.lookup(st.StepName)
+
+			fr.push()
+			defer fr.pop()
+
+			// one variable for the whole loop, as the C for loop has
+			it := newCell(ast.Type{Selector: ast.KTypeInteger})
+			it.store(start.load())
+			fr.declare(st.TemporaryVariableName, it)
+
+			for continueIterating(toInt(step.load()), toInt(it.load()), toInt(end.load())) {
+				if f := fr.execBlock(st.Body); f == kFlowBreak {
+					break
+				} else if f == kFlowReturn {
+					return f
+				}
+				it.store(toInt(it.load()) + toInt(step.load()))
+			}
+		}
+
+	case *ast.ClosureArgDeclarationStatement:
+		array := make(argArray, len(st.Args))
+		for argi, arg := range st.Args {
+			if arg != "" {
+				c, _ := fr.lookup(arg)
+				array[argi] = c
+			}
+		}
+		fr.declare(st.Name, &cell{v: array})
+
+	case *ast.ReturnStatement:
+		if st.ReturnedValue != nil {
+			fr.ret = fr.eval(st.ReturnedValue)
+		}
+		return kFlowReturn
+
+	case *ast.ExpressionStatement:
+		fr.eval(st.Expression)
+
+	case *ast.AssertStatement:
+		if fr.th.gpu {
+			// a kernel has nowhere to report a failure, so asserts only run on the cpu
+			return kFlowNormal
+		}
+
+		if !toBool(fr.eval(st.Condition)) {
+			sl := st.SourceLocation()
+			path, fnd := fr.th.in.sourcePaths[sl.Filename]
+			if !fnd {
+				path = sl.Filename
+			}
+
+			msg := fr.eval(st.Message).(*str)
+			fr.th.in.writeError(fmt.Sprintf("%v:%v:%v: assertion failed: %v\n", path, sl.Line, sl.Column, msg))
+			panic(&exitRequest{code: 1})
+		}
+
+	case *ast.SendPipeStatement:
+		w := fr.eval(st.Pipe).(worker)
+		vec := fr.eval(st.Value).(*vector)
+
+		sent := make([]value, len(vec.elems))
+		for i, v := range vec.elems {
+			sent[i] = copyValue(v)
+		}
+		w.send(fr.th, sent)
+
+	case *ast.IfStatement:
+		for _, seg := range st.Segments {
+			if seg.Condition == nil || toBool(fr.eval(seg.Condition)) {
+				return fr.execBlock(seg.Block)
+			}
+		}
+
+	default:
+		panic(fmt.Sprintf("interp: Do not recognise statement %v", rst))
+	}
+
+	return kFlowNormal
+}
+
+func continueIterating(step, lhs, rhs int32) bool {
+	if step == 0 {
+		return false
+	} else if step > 0 {
+		return lhs < rhs
+	} else {
+		return lhs > rhs
+	}
+}
+
+func (fr *frame) assign(st *ast.AssignStatement) {
+	declaring := st.Type == ast.KAssignLet || st.Type == ast.KAssignConst
+
+	if mlv, ok := st.Lhs.(*ast.MultipleLValue); ok {
+		// unpack the tuple into each lvalue
+		tuple := fr.evalAssigned(st.Rhs).(*structValue)
+		for lvi, lv := range mlv.LValues {
+			fr.store(lv, st.NewType.Types[lvi], declaring, tuple.fields[lvi])
+		}
+		return
+	}
+
+	if st.Rhs == nil {
+		fr.store(st.Lhs, st.NewType, declaring, fr.th.in.zero(st.NewType))
+		return
+	}
+
+	fr.store(st.Lhs, st.NewType, declaring, fr.evalAssigned(st.Rhs))
+}
+
+func (fr *frame) store(lv ast.LValue, ty ast.Type, declaring bool, v value) {
+	if declaring {
+		ilv, ok := lv.(*ast.IdentifierLValue)
+		if !ok {
+			panic(fmt.Sprintf("interp: cannot declare %v", lv))
+		}
+
+		c := newCell(ty)
+		c.store(v)
+		fr.declare(ilv.Name, c)
+		return
+	}
+
+	fr.lvalueRef(lv).store(v)
+}
@@ -0,0 +1,260 @@
+package interp
+
+import (
+	"fmt"
+
+	"eyot/ast"
+)
+
+/*
+A value held by the interpreter
+
+The dynamic type follows the C the program would otherwise be compiled to
+- integers are int32, floats are float32 or float64, characters are character and booleans bool
+- strings are *str and vectors *vector, both shared by reference as EyString and EyVector* are
+- structs and tuples are *structValue, copied whenever C would copy them
+- pointers are a ref, or nil for null
+*/
+type value interface{}
+
+type character uint32
+
+// A string, which like EyString can be changed in place through any copy of the reference
+type str struct {
+	chars []rune
+
+	// true for strings in the pool, which are copied before they are assigned anywhere
+	static bool
+}
+
+func newStr(s string) *str {
+	return &str{chars: []rune(s)}
+}
+
+func (s *str) String() string {
+	return string(s.chars)
+}
+
+type vector struct {
+	elems    []value
+	elemType ast.Type
+}
+
+// The field names and types of a struct or tuple, shared by every value of it
+type layout struct {
+	names []string
+	types []ast.Type
+}
+
+func (l *layout) index(name string) int {
+	for i, n := range l.names {
+		if n == name {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("interp: no field named '%v'", name))
+}
+
+type structValue struct {
+	layout *layout
+	fields []value
+}
+
+// A function defined in eyot, which resolves to its cpu or gpu variant when called
+type funcValue struct {
+	key  string
+	name string
+}
+
+// A runtime or ffi function implemented by the interpreter itself
+type nativeValue struct {
+	name string
+}
+
+// A closure, with the arguments it was given frozen in place
+type closureValue struct {
+	fn      funcValue
+	args    []value
+	present []bool
+}
+
+// The argument array written by a ClosureArgDeclarationStatement, nil where the argument is not supplied
+type argArray []ref
+
+// The type given to sizeof, which the runtime calls use to know what they hold
+type sizeofValue struct {
+	ty ast.Type
+}
+
+// A worker method taken with ->, as in w->receive
+type workerMethod struct {
+	w    worker
+	name string
+}
+
+// A pointer
+type ref interface {
+	load() value
+	store(v value)
+}
+
+// A variable, or a block allocated with new
+type cell struct {
+	v value
+
+	// the declared type values are converted to when stored, or nil to keep them as they are
+	ty *ast.Type
+}
+
+func newCell(ty ast.Type) *cell {
+	t := ty
+	return &cell{ty: &t}
+}
+
+func (c *cell) load() value {
+	return c.v
+}
+
+func (c *cell) store(v value) {
+	if c.ty == nil {
+		c.v = copyValue(v)
+	} else {
+		c.v = convert(v, *c.ty)
+	}
+}
+
+type fieldRef struct {
+	s *structValue
+	i int
+}
+
+func (f fieldRef) load() value {
+	return f.s.fields[f.i]
+}
+
+func (f fieldRef) store(v value) {
+	f.s.fields[f.i] = convert(v, f.s.layout.types[f.i])
+}
+
+type elemRef struct {
+	vec *vector
+	i   int
+}
+
+func (e elemRef) load() value {
+	return e.vec.elems[e.i]
+}
+
+func (e elemRef) store(v value) {
+	e.vec.elems[e.i] = convert(v, e.vec.elemType)
+}
+
+type charRef struct {
+	s *str
+	i int
+}
+
+func (c charRef) load() value {
+	return character(c.s.chars[c.i])
+}
+
+func (c charRef) store(v value) {
+	c.s.chars[c.i] = rune(toInt(v))
+}
+
+// Copy a struct or tuple, as C does on assignment, leaving anything held by reference shared
+func copyValue(v value) value {
+	sv, ok := v.(*structValue)
+	if !ok {
+		return v
+	}
+
+	fields := make([]value, len(sv.fields))
+	for i, f := range sv.fields {
+		fields[i] = copyValue(f)
+	}
+	return &structValue{layout: sv.layout, fields: fields}
+}
+
+// Convert a value to the given type, as C does when it is assigned or passed
+func convert(v value, ty ast.Type) value {
+	switch ty.Selector {
+	case ast.KTypeInteger:
+		return toInt(v)
+
+	case ast.KTypeFloat:
+		f := toFloat(v)
+		if ty.Width == 32 {
+			return float32(f)
+		}
+		return f
+
+	case ast.KTypeCharacter:
+		return character(toInt(v))
+
+	case ast.KTypeBoolean:
+		return toBool(v)
+
+	default:
+		return copyValue(v)
+	}
+}
+
+func toInt(v value) int32 {
+	switch n := v.(type) {
+	case int32:
+		return n
+	case float32:
+		return int32(n)
+	case float64:
+		return int32(n)
+	case character:
+		return int32(n)
+	case bool:
+		if n {
+			return 1
+		}
+		return 0
+	}
+	panic(fmt.Sprintf("interp: %v is not a number", v))
+}
+
+func toFloat(v value) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case float64:
+		return n
+	case character:
+		return float64(n)
+	case bool:
+		if n {
+			return 1
+		}
+		return 0
+	}
+	panic(fmt.Sprintf("interp: %v is not a number", v))
+}
+
+func toBool(v value) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case nil:
+		return false
+	}
+	return toInt(v) != 0
+}
+
+// follow a pointer, as -> and * do
+func deref(v value) value {
+	if r, ok := v.(ref); ok {
+		return r.load()
+	}
+	if v == nil {
+		runtimePanic("interp", "null pointer dereference")
+	}
+	return v
+}
@@ -0,0 +1,371 @@
+package interp
+
+import (
+	"fmt"
+	"sync"
+
+	"eyot/ast"
+)
+
+// A worker, as the runtime's EyWorker with its send, receive and drain
+type worker interface {
+	send(th *thread, values []value)
+	receive(th *thread) value
+	drain(th *thread) value
+}
+
+func (th *thread) callWorkerMethod(m workerMethod, args []value) value {
+	switch m.name {
+	case "receive":
+		v := m.w.receive(th)
+		if r, ok := args[1].(ref); ok {
+			r.store(v)
+		}
+		return nil
+
+	case "drain":
+		return m.w.drain(th)
+	}
+
+	panic(fmt.Sprintf("interp: no worker method named %v", m.name))
+}
+
+// An unbounded first in first out queue, as EyPipe
+type queue struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	items []value
+}
+
+func newQueue() *queue {
+	q := &queue{}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+func (q *queue) push(v value) {
+	q.mutex.Lock()
+	q.items = append(q.items, v)
+	q.mutex.Unlock()
+	q.cond.Signal()
+}
+
+// Wait for and remove the oldest item
+func (q *queue) pop() value {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.items) == 0 {
+		q.cond.Wait()
+	}
+	v := q.items[0]
+	q.items = q.items[1:]
+	return v
+}
+
+/*
+A cpu worker
+
+This computes within a goroutine, in the order values are sent
+*/
+type cpuWorker struct {
+	outputType    ast.Type
+	input, output *queue
+	underwayMutex sync.Mutex
+	underwayCount int
+}
+
+func newCpuWorker(in *Interpreter, fn value, outputType ast.Type) *cpuWorker {
+	w := &cpuWorker{
+		outputType: outputType,
+		input:      newQueue(),
+		output:     newQueue(),
+	}
+
+	go in.guard(func() {
+		th := &thread{in: in}
+		for {
+			w.output.push(th.call(fn, []value{w.input.pop()}))
+		}
+	})
+
+	return w
+}
+
+func (w *cpuWorker) send(th *thread, values []value) {
+	w.underwayMutex.Lock()
+	w.underwayCount += len(values)
+	w.underwayMutex.Unlock()
+
+	for _, v := range values {
+		w.input.push(v)
+	}
+}
+
+func (w *cpuWorker) receive(th *thread) value {
+	v := w.output.pop()
+
+	w.underwayMutex.Lock()
+	w.underwayCount -= 1
+	w.underwayMutex.Unlock()
+
+	return v
+}
+
+func (w *cpuWorker) drain(th *thread) value {
+	w.underwayMutex.Lock()
+	requiredCount := w.underwayCount
+	w.underwayMutex.Unlock()
+
+	return drainInto(th, w, w.outputType, requiredCount)
+}
+
+// Receive count values into a vector, or nil when nothing is returned
+func drainInto(th *thread, w worker, outputType ast.Type, count int) value {
+	if outputType.Selector == ast.KTypeVoid {
+		for i := 0; i < count; i += 1 {
+			w.receive(th)
+		}
+		return nil
+	}
+
+	results := &vector{elemType: outputType}
+	for i := 0; i < count; i += 1 {
+		results.elems = append(results.elems, w.receive(th))
+	}
+	return results
+}
+
+/*
+A pipeline of two workers
+
+Everything received from the first is forwarded to the second in its own goroutine, so the
+results arrive in the order they were sent
+*/
+type pipeline struct {
+	lhs, rhs      worker
+	outputType    ast.Type
+	sentCounts    *queue
+	underwayMutex sync.Mutex
+	underwayCount int
+}
+
+func newPipeline(in *Interpreter, lhs, rhs worker, outputType ast.Type) *pipeline {
+	p := &pipeline{
+		lhs:        lhs,
+		rhs:        rhs,
+		outputType: outputType,
+		sentCounts: newQueue(),
+	}
+
+	go in.guard(func() {
+		th := &thread{in: in}
+		for {
+			count := p.sentCounts.pop().(int)
+			for i := 0; i < count; i += 1 {
+				p.rhs.send(th, []value{p.lhs.receive(th)})
+			}
+		}
+	})
+
+	return p
+}
+
+func (p *pipeline) send(th *thread, values []value) {
+	p.underwayMutex.Lock()
+	p.underwayCount += len(values)
+	p.underwayMutex.Unlock()
+
+	p.lhs.send(th, values)
+	p.sentCounts.push(len(values))
+}
+
+func (p *pipeline) receive(th *thread) value {
+	v := p.rhs.receive(th)
+
+	p.underwayMutex.Lock()
+	p.underwayCount -= 1
+	p.underwayMutex.Unlock()
+
+	return v
+}
+
+func (p *pipeline) drain(th *thread) value {
+	p.underwayMutex.Lock()
+	requiredCount := p.underwayCount
+	p.underwayMutex.Unlock()
+
+	return drainInto(th, p, p.outputType, requiredCount)
+}
+
+/*
+A gpu worker, emulated on the cpu
+
+Each batch sent runs there and then, one work item after another, with prints going to the log
+buffer of the item's local id. Those buffers are pumped to stdout and cleared at the same points
+the OpenCL runtime does, so the output matches a real device.
+*/
+const (
+	kWorkgroupSize    = 64
+	kWorkerBufferSize = 1020
+)
+
+// The log buffer of one local id, as EyWorkerShared
+type logSlot struct {
+	buffer []byte
+}
+
+func (s *logSlot) write(b []byte) {
+	for _, c := range b {
+		if len(s.buffer) < kWorkerBufferSize {
+			s.buffer = append(s.buffer, c)
+		}
+	}
+}
+
+type workBatch struct {
+	outputs   []value
+	readIndex int
+}
+
+type gpuWorker struct {
+	in         *Interpreter
+	fn         value
+	outputType ast.Type
+
+	mutex sync.Mutex
+
+	shared     [kWorkgroupSize]logSlot
+	bufferUsed [kWorkgroupSize]int
+
+	batches       []*workBatch
+	activityCount int
+}
+
+func newGpuWorker(in *Interpreter, fn value, outputType ast.Type) *gpuWorker {
+	return &gpuWorker{
+		in:         in,
+		fn:         fn,
+		outputType: outputType,
+	}
+}
+
+func (w *gpuWorker) send(th *thread, values []value) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	batch := &workBatch{readIndex: -1}
+	for i, v := range values {
+		item := &thread{in: w.in, gpu: true, log: &w.shared[i%kWorkgroupSize]}
+		batch.outputs = append(batch.outputs, item.call(w.fn, []value{v}))
+	}
+
+	w.batches = append(w.batches, batch)
+	w.activityCount += len(values)
+}
+
+func (w *gpuWorker) receive(th *thread) value {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.batches) == 0 {
+		runtimePanic("ey_worker_receive", "failed to receive")
+	}
+
+	batch := w.batches[0]
+	if batch.readIndex < 0 {
+		w.pumpLogs()
+		batch.readIndex = 0
+
+		w.activityCount -= len(batch.outputs)
+		w.activityCountReduced()
+	}
+
+	v := batch.outputs[batch.readIndex]
+	batch.readIndex += 1
+
+	if batch.readIndex == len(batch.outputs) {
+		w.batches = w.batches[1:]
+	}
+
+	return v
+}
+
+func (w *gpuWorker) drain(th *thread) value {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	results := &vector{elemType: w.outputType}
+	if len(w.batches) == 0 {
+		return results
+	}
+
+	if w.batches[len(w.batches)-1].readIndex < 0 {
+		w.pumpLogs()
+	}
+
+	for _, batch := range w.batches {
+		if batch.readIndex < 0 {
+			batch.readIndex = 0
+		}
+		results.elems = append(results.elems, batch.outputs[batch.readIndex:]...)
+	}
+	w.batches = nil
+
+	w.activityCount -= len(results.elems)
+	w.activityCountReduced()
+
+	return results
+}
+
+// Push the complete lines of each log to stdout, prefixed with the local id
+func (w *gpuWorker) pumpLogs() {
+	for i := range w.shared {
+		s := &w.shared[i]
+
+		lastNl := -1
+		for j := w.bufferUsed[i]; j < len(s.buffer); j += 1 {
+			if s.buffer[j] == '\n' {
+				lastNl = j + 1
+			}
+		}
+
+		if lastNl >= 0 {
+			out := []byte{}
+			showSource := true
+			for j := w.bufferUsed[i]; j < lastNl; j += 1 {
+				if showSource {
+					out = append(out, fmt.Sprintf("(gpu %v) ", i)...)
+					showSource = false
+				}
+				out = append(out, s.buffer[j])
+				if s.buffer[j] == '\n' {
+					showSource = true
+				}
+			}
+			w.in.write(out)
+			w.bufferUsed[i] = lastNl
+		}
+	}
+}
+
+// Clear the logs once nothing is running that could still write to them
+func (w *gpuWorker) activityCountReduced() {
+	if w.activityCount > 0 {
+		return
+	}
+
+	logUsed := false
+	for _, used := range w.bufferUsed {
+		if used != 0 {
+			logUsed = true
+		}
+	}
+
+	if logUsed {
+		for i := range w.shared {
+			w.shared[i].buffer = nil
+			w.bufferUsed[i] = 0
+		}
+	}
+}
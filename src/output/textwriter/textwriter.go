@@ -3,6 +3,7 @@ package textwriter
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 type lineComponent struct {
@@ -15,6 +16,9 @@ type W struct {
 	cpts                []lineComponent
 	indent              int
 	noSpace, forceSpace bool
+
+	// the number of lines written so far
+	lines int
 }
 
 func NewWriter(w io.Writer) *W {
@@ -81,9 +85,16 @@ func (w *W) Unindent() {
 
 func (w *W) WriteRaw(src string) {
 	fmt.Fprint(w.w, src)
+	w.lines += strings.Count(src, "\n")
+}
+
+// The number of lines written so far
+func (w *W) Lines() int {
+	return w.lines
 }
 
 func (w *W) EndLine() {
+	w.lines += 1
 	if len(w.cpts) == 0 {
 		fmt.Fprintln(w.w)
 		return
@@ -50,6 +50,15 @@ type Parser struct {
 
 	disallowedIds map[string]bool
 
+	// the test and bench blocks so far, which number the functions they become
+	testCount, benchCount int
+
+	// where worker expressions in the bench being parsed send their work, or nil outside one
+	benchDestination *ast.PipeDestination
+
+	// the functions for a bench's other destinations, which follow the first in the module
+	pendingTles []ast.TopLevelElement
+
 	ffi *ast.FfiDefinitions
 }
 
@@ -88,16 +97,111 @@ func (p *Parser) CurrentLocation() errors.SourceLocation {
 			Line:     -1,
 		}
 	} else {
+		tkn := p.tokens[cp]
 		return errors.SourceLocation{
-			Filename: p.fileName,
-			Line:     p.tokens[cp].Line,
+			Filename:  p.fileName,
+			Line:      tkn.Line,
+			Column:    tkn.Column,
+			EndLine:   tkn.EndLine,
+			EndColumn: tkn.EndColumn,
+		}
+	}
+}
+
+// The span from the token at start up to the last consumed token, ignoring trailing semicolons
+func (p *Parser) spanFrom(start int) errors.SourceLocation {
+	end := p.CurrentFrame().Position - 1
+	for end > start && p.tokens[end].Type == token.Semicolon {
+		end -= 1
+	}
+	if start >= len(p.tokens) || end < start {
+		return p.CurrentLocation()
+	}
+
+	return errors.SourceLocation{
+		Filename:  p.fileName,
+		Line:      p.tokens[start].Line,
+		Column:    p.tokens[start].Column,
+		EndLine:   p.tokens[end].EndLine,
+		EndColumn: p.tokens[end].EndColumn,
+	}
+}
+
+// The /// comment above the token at position
+func (p *Parser) docAt(position int) string {
+	if position >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[position].Doc
+}
+
+// Give n the span from start, unless something more specific already set it
+func (p *Parser) locate(start int, n interface{}) {
+	l, ok := n.(ast.Locatable)
+	if !ok || l == nil {
+		return
+	}
+
+	if sl := l.SourceLocation(); sl.Column == 0 {
+		l.SetSourceLocation(p.spanFrom(start))
+	}
+}
+
+// Where a statement or top level element started, so parsing can recover if it fails
+type recoveryPoint struct {
+	position, frames, errors int
+}
+
+func (p *Parser) recoveryPoint() recoveryPoint {
+	return recoveryPoint{
+		position: p.CurrentFrame().Position,
+		frames:   len(p.frames),
+		errors:   p.es.Count(),
+	}
+}
+
+// True when something parsed since rp logged an error
+func (p *Parser) failedSince(rp recoveryPoint) bool {
+	return p.es.Count() > rp.errors
+}
+
+/*
+Skip past whatever started at rp after it failed to parse, so the next statement or top level element
+can be tried
+
+This stops after the next semicolon outside of any braces, or before a closing brace that ends the
+enclosing block. At the top level there is no enclosing block, so stray closing braces are skipped
+*/
+func (p *Parser) resync(rp recoveryPoint, topLevel bool) {
+	// a failed parse can leave save frames behind
+	p.frames = p.frames[:rp.frames]
+
+	position := rp.position
+	depth := 0
+	for position < len(p.tokens) && p.tokens[position].Type != token.Eof {
+		tkn := p.tokens[position]
+
+		if tkn.Type == token.CloseCurly && depth == 0 && !topLevel {
+			break
+		}
+		position += 1
+
+		if tkn.Type == token.OpenCurly {
+			depth += 1
+		} else if tkn.Type == token.CloseCurly && depth > 0 {
+			depth -= 1
+		} else if tkn.Type == token.Semicolon && depth == 0 {
+			break
 		}
 	}
+
+	p.CurrentFrame().Position = position
 }
 
 func (p *Parser) FindImport(module string) *ast.Module {
 	for _, ie := range p.imports {
 		if ie.ImportAs == module {
+			ie.Used = true
 			return ie.Mod
 		}
 	}
@@ -105,6 +209,38 @@ func (p *Parser) FindImport(module string) *ast.Module {
 	return nil
 }
 
+// The import that brought in the function or struct by name, as in from geo import area, or nil
+func (p *Parser) importedBy(name string) *ast.ImportElement {
+	for _, ie := range p.imports {
+		for _, symbol := range ie.Symbols {
+			if symbol == name {
+				return ie
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+The module an unqualified struct name belongs to
+
+This is the current one, unless the struct was imported by name. Any use of a name imported that way
+marks its import as used
+*/
+func (p *Parser) structModuleId(name string) ast.ModuleId {
+	ie := p.importedBy(name)
+	if ie == nil {
+		return p.CurrentModuleId()
+	}
+
+	ie.Used = true
+	if _, fnd := ie.Mod.LookupStruct(name); !fnd {
+		return p.CurrentModuleId()
+	}
+	return ie.Mod.Id
+}
+
 // Log an error at the current position
 func (p *Parser) LogError(description string, args ...interface{}) {
 	p.es.SetCurrentLocation(p.CurrentLocation())
@@ -112,7 +248,7 @@ func (p *Parser) LogError(description string, args ...interface{}) {
 }
 
 func (p *Parser) LogExpectingError(expecting, context string) {
-	p.LogError(fmt.Sprintf("Expecting '%v' in %v, have %v", expecting, context, p.DebugPeekToken()))
+	p.LogError("Expecting '%v' in %v, have %v", expecting, context, p.DebugPeekToken())
 }
 
 // Current parse context
@@ -324,7 +460,7 @@ func (p *Parser) WholeType() (ast.Type, bool) {
 		return ast.Type{
 			Selector: ast.KTypeStruct,
 			StructId: ast.StructId{
-				Module: p.CurrentModuleId(),
+				Module: p.structModuleId(tok.Tval),
 				Name:   tok.Tval,
 			},
 		}, true
@@ -354,36 +490,37 @@ func (p *Parser) Type() (ast.Type, bool) {
 	return ty, true
 }
 
-func (p *Parser) ResolvedId() (*ast.Module, string, bool) {
+// module::name, returning the module, what it was imported as and the name
+func (p *Parser) ResolvedId() (*ast.Module, string, string, bool) {
 	p.Save()
 	tok, fnd := p.Token(token.Identifier)
 	if !fnd {
 		p.Reject()
-		return nil, "", false
+		return nil, "", "", false
 	}
 
 	_, ok := p.Token(token.ScopeResolution)
 	if !ok {
 		p.Reject()
-		return nil, tok.Tval, false
+		return nil, "", tok.Tval, false
 	}
 
 	sym, ok := p.Token(token.Identifier)
 	if !ok {
 		p.LogError("No identifier found after scope resolution operator")
 		p.Reject()
-		return nil, "", false
+		return nil, "", "", false
 	}
 
 	mod := p.FindImport(tok.Tval)
 	if mod == nil {
 		p.LogModuleNotFound(tok.Tval)
 		p.Reject()
-		return nil, "", false
+		return nil, "", "", false
 	}
 
 	p.Accept()
-	return mod, sym.Tval, true
+	return mod, tok.Tval, sym.Tval, true
 }
 
 func (p *Parser) LogModuleNotFound(name string) {
@@ -497,7 +634,7 @@ func (p *Parser) LiteralValueExpression() (ast.Expression, bool) {
 		return &ast.GpuBuiltinTerminal { Name: it.Tval }, true
 	}
 
-	mod, name, fnd := p.ResolvedId()
+	mod, qualifier, name, fnd := p.ResolvedId()
 	if fnd {
 		def, foundFunction := mod.LookupFunction(name)
 		sd, foundStruct := mod.LookupStruct(name)
@@ -510,6 +647,7 @@ func (p *Parser) LiteralValueExpression() (ast.Expression, bool) {
 
 			return &ast.IdentifierTerminal{
 				Name:           name,
+				Qualifier:      qualifier,
 				DontNamespace:  false,
 				CachedType:     def.OurType(),
 				TypeSetInParse: true,
@@ -524,6 +662,7 @@ func (p *Parser) LiteralValueExpression() (ast.Expression, bool) {
 				return nil, false
 			}
 
+			sl.Qualifier = qualifier
 			return sl, true
 		} else {
 			p.LogError("Do not recognise the scoped identifier in this context")
@@ -533,11 +672,12 @@ func (p *Parser) LiteralValueExpression() (ast.Expression, bool) {
 
 	tok, fnd = p.Token(token.Identifier)
 	if fnd {
+		moduleId := p.structModuleId(tok.Tval)
 		if p.structLiteralOk < 0 {
 			// force a basic identifier
 			return &ast.IdentifierTerminal{Name: tok.Tval, DontNamespace: false}, true
 		} else {
-			sl, fnd := p.StructLiteralBody(p.CurrentModuleId(), tok.Tval)
+			sl, fnd := p.StructLiteralBody(moduleId, tok.Tval)
 			if fnd {
 				return sl, true
 			} else {
@@ -599,10 +739,18 @@ func (p *Parser) PrimaryExpression() (ast.Expression, bool) {
 			return nil, false
 		}
 
+		if l, ok := innerExpression.(ast.Locatable); ok {
+			l.SetBracketed()
+		}
 		return innerExpression, true
 	}
 
-	return p.LiteralValueExpression()
+	start := p.CurrentFrame().Position
+	e, fnd := p.LiteralValueExpression()
+	if fnd {
+		p.locate(start, e)
+	}
+	return e, fnd
 }
 
 // Parse an expression list
@@ -669,7 +817,7 @@ func (p *Parser) LiteralPair() (ast.StructLiteralPair, bool) {
 
 	_, fnd = p.Token(token.Colon)
 	if !fnd {
-		p.LogError("Expecting colon after identifier in struct (" + ident.Tval + ")")
+		p.LogError("Expecting colon after identifier in struct (%v)", ident.Tval)
 		return ast.StructLiteralPair{}, false
 	}
 
@@ -686,6 +834,7 @@ func (p *Parser) LiteralPair() (ast.StructLiteralPair, bool) {
 }
 
 func (p *Parser) PostfixExpression() (ast.Expression, bool) {
+	start := p.CurrentFrame().Position
 	pe, fnd := p.PrimaryExpression()
 	if !fnd {
 		return nil, false
@@ -704,6 +853,7 @@ func (p *Parser) PostfixExpression() (ast.Expression, bool) {
 				Casted: pe,
 				CheckCastable: true,
 			}
+			p.locate(start, pe)
 		}
 		
 		_, fnd = p.Token(token.OpenCurved)
@@ -724,6 +874,7 @@ func (p *Parser) PostfixExpression() (ast.Expression, bool) {
 				CalledExpression: pe,
 				Arguments:        el,
 			}
+			p.locate(start, pe)
 			continue
 		}
 
@@ -737,7 +888,7 @@ func (p *Parser) PostfixExpression() (ast.Expression, bool) {
 
 			_, fnd = p.Token(token.CloseSquare)
 			if !fnd {
-				p.LogError(fmt.Sprintf("Expecting ']' in access statement, have %v", p.DebugPeekToken()))
+				p.LogError("Expecting ']' in access statement, have %v", p.DebugPeekToken())
 				return nil, false
 			}
 
@@ -745,6 +896,7 @@ func (p *Parser) PostfixExpression() (ast.Expression, bool) {
 				Indexed: pe,
 				Index:   e,
 			}
+			p.locate(start, pe)
 			continue
 		}
 
@@ -761,6 +913,7 @@ func (p *Parser) PostfixExpression() (ast.Expression, bool) {
 				AllowRaw:   false,
 				Identifier: ident.Tval,
 			}
+			p.locate(start, pe)
 			continue
 		}
 
@@ -776,6 +929,7 @@ func (p *Parser) UnaryExpression() (ast.Expression, bool) {
 		token.Minus: ast.KOperatorNegate,
 	}
 
+	start := p.CurrentFrame().Position
 	for tokType, op := range tokMap {
 		_, fnd := p.Token(tokType)
 		if !fnd {
@@ -784,14 +938,16 @@ func (p *Parser) UnaryExpression() (ast.Expression, bool) {
 
 		e, fnd := p.PostfixExpression()
 		if !fnd {
-			p.LogError(fmt.Sprintf("Expecting expression after %v", tokType))
+			p.LogError("Expecting expression after %v", tokType)
 			return nil, false
 		}
 
-		return &ast.UnaryExpression{
+		ue := &ast.UnaryExpression{
 			Operator: op,
 			Rhs:      e,
-		}, true
+		}
+		p.locate(start, ue)
+		return ue, true
 	}
 
 	return p.PostfixExpression()
@@ -799,6 +955,7 @@ func (p *Parser) UnaryExpression() (ast.Expression, bool) {
 
 // A factory method for infix expressions
 func (p *Parser) binaryInfixExpression(tkns map[token.TokenType]ast.BinaryOperator, next func() (ast.Expression, bool)) (ast.Expression, bool) {
+	start := p.CurrentFrame().Position
 	pe, fnd := next()
 	if !fnd {
 		return nil, false
@@ -829,6 +986,7 @@ func (p *Parser) binaryInfixExpression(tkns map[token.TokenType]ast.BinaryOperat
 			Rhs:      rhs,
 			Operator: binop,
 		}
+		p.locate(start, pe)
 	}
 
 	return pe, true
@@ -884,6 +1042,7 @@ func (p *Parser) LogicalOrExpression() (ast.Expression, bool) {
 }
 
 func (p *Parser) AllocationExpression() (ast.Expression, bool) {
+	start := p.CurrentFrame().Position
 	p.Save()
 
 	_, isAllocated := p.Token(token.New)
@@ -895,9 +1054,11 @@ func (p *Parser) AllocationExpression() (ast.Expression, bool) {
 	p.Accept()
 
 	if isAllocated {
-		return &ast.NewExpression{
+		ne := &ast.NewExpression{
 			Initialiser: lor,
-		}, true
+		}
+		p.locate(start, ne)
+		return ne, true
 	} else {
 		return lor, true
 	}
@@ -1099,6 +1260,28 @@ func (p *Parser) PrefixedExpression() (ast.Expression, bool) {
 		}, true
 	}
 
+	if _, fnd := p.Token(token.Worker); fnd {
+		if p.benchDestination == nil {
+			p.LogError("A worker without cpu or gpu can only be created in a bench with destinations")
+			p.Reject()
+			return nil, false
+		}
+
+		worker, ok := p.Expression()
+		if !ok {
+			p.LogError("Expecting a function after 'worker'")
+			p.Reject()
+			return nil, false
+		}
+		p.Accept()
+
+		return &ast.CreateWorkerExpression{
+			Worker:      worker,
+			Destination: *p.benchDestination,
+			FromBench:   true,
+		}, true
+	}
+
 	var isCpu = false
 	var isGpu = false
 	_, isCpu = p.Token(token.Cpu)
@@ -1106,6 +1289,11 @@ func (p *Parser) PrefixedExpression() (ast.Expression, bool) {
 		_, isGpu = p.Token(token.Gpu)
 	}
 	if isCpu || isGpu {
+		var device ast.Expression
+		if isGpu {
+			device = p.WorkerDevice()
+		}
+
 		worker, ok := p.Expression()
 		if !ok {
 			p.Reject()
@@ -1120,6 +1308,7 @@ func (p *Parser) PrefixedExpression() (ast.Expression, bool) {
 		return &ast.CreateWorkerExpression{
 			Worker:      worker,
 			Destination: dest,
+			Device:      device,
 		}, true
 	}
 
@@ -1128,13 +1317,53 @@ func (p *Parser) PrefixedExpression() (ast.Expression, bool) {
 	return p.AllocationExpression()
 }
 
+/*
+Parse the optional device index in `gpu(index) worker`
+
+A bracketed expression with nothing after it is the worker itself, i.e. `gpu (worker)`, so this
+returns nil and leaves the tokens in place in that case
+*/
+func (p *Parser) WorkerDevice() ast.Expression {
+	p.Save()
+
+	if _, fnd := p.Token(token.OpenCurved); !fnd {
+		p.Reject()
+		return nil
+	}
+
+	device, ok := p.Expression()
+	if !ok {
+		p.Reject()
+		return nil
+	}
+
+	if _, fnd := p.Token(token.CloseCurved); !fnd {
+		p.Reject()
+		return nil
+	}
+
+	// only identifiers and partial applications can be made into workers
+	next := p.DebugPeekToken()
+	if next.Type != token.Identifier && next.Type != token.Partial && next.Type != token.OpenCurved {
+		p.Reject()
+		return nil
+	}
+
+	p.Accept()
+	return device
+}
+
 /*
 This is an expression, disallowing tuple expressions, which are not exposed types to the user
 */
 func (p *Parser) Expression() (ast.Expression, bool) {
+	start := p.CurrentFrame().Position
 	p.structLiteralOk += 1
 	e, ok := p.PrefixedExpression()
 	p.structLiteralOk -= 1
+	if ok {
+		p.locate(start, e)
+	}
 	return e, ok
 }
 
@@ -1142,6 +1371,7 @@ func (p *Parser) Expression() (ast.Expression, bool) {
 Parse out a potential tuple
 */
 func (p *Parser) TupleExpression() (ast.Expression, bool) {
+	start := p.CurrentFrame().Position
 	leadingExpression, fnd := p.Expression()
 	if !fnd {
 		return nil, false
@@ -1157,9 +1387,11 @@ func (p *Parser) TupleExpression() (ast.Expression, bool) {
 			if len(es) == 1 {
 				return leadingExpression, true
 			} else {
-				return &ast.TupleExpression{
+				te := &ast.TupleExpression{
 					Expressions: es,
-				}, true
+				}
+				p.locate(start, te)
+				return te, true
 			}
 		}
 
@@ -1175,9 +1407,12 @@ func (p *Parser) TupleExpression() (ast.Expression, bool) {
 
 // single token lvalue
 func (p *Parser) SimpleLValue() (ast.LValue, bool) {
+	start := p.CurrentFrame().Position
 	_, fnd := p.Token(token.Self)
 	if fnd {
-		return &ast.SelfLValue{}, true
+		slv := &ast.SelfLValue{}
+		p.locate(start, slv)
+		return slv, true
 	}
 
 	ident, fnd := p.Token(token.Identifier)
@@ -1185,7 +1420,9 @@ func (p *Parser) SimpleLValue() (ast.LValue, bool) {
 		return nil, false
 	}
 
-	return &ast.IdentifierLValue{Name: ident.Tval}, true
+	ilv := &ast.IdentifierLValue{Name: ident.Tval}
+	p.locate(start, ilv)
+	return ilv, true
 
 }
 
@@ -1193,6 +1430,7 @@ func (p *Parser) SimpleLValue() (ast.LValue, bool) {
 a.b
 */
 func (p *Parser) AccessLValue() (ast.LValue, bool) {
+	start := p.CurrentFrame().Position
 	lv, fnd := p.SimpleLValue()
 	if !fnd {
 		return nil, false
@@ -1206,7 +1444,9 @@ func (p *Parser) AccessLValue() (ast.LValue, bool) {
 			return nil, false
 		}
 
-		return &ast.AccessorLValue{Inner: lv, FieldName: ident.Tval}, true
+		alv := &ast.AccessorLValue{Inner: lv, FieldName: ident.Tval}
+		p.locate(start, alv)
+		return alv, true
 	}
 
 	return lv, true
@@ -1216,6 +1456,7 @@ func (p *Parser) AccessLValue() (ast.LValue, bool) {
 a[b] =
 */
 func (p *Parser) IndexingLValue() (ast.LValue, bool) {
+	start := p.CurrentFrame().Position
 	lv, fnd := p.AccessLValue()
 	if !fnd {
 		return nil, false
@@ -1243,6 +1484,7 @@ func (p *Parser) IndexingLValue() (ast.LValue, bool) {
 			Indexed: lv,
 			Index:   ind,
 		}
+		p.locate(start, lv)
 	}
 
 	return lv, true
@@ -1252,6 +1494,7 @@ func (p *Parser) IndexingLValue() (ast.LValue, bool) {
  *x =
  */
 func (p *Parser) DeferenceLValue() (ast.LValue, bool) {
+	start := p.CurrentFrame().Position
 	p.Save()
 	_, isDeref := p.Token(token.Multiply)
 
@@ -1263,7 +1506,9 @@ func (p *Parser) DeferenceLValue() (ast.LValue, bool) {
 	p.Accept()
 
 	if isDeref {
-		return &ast.DerefLValue{Inner: lv}, true
+		dlv := &ast.DerefLValue{Inner: lv}
+		p.locate(start, dlv)
+		return dlv, true
 	} else {
 		return lv, true
 	}
@@ -1273,6 +1518,7 @@ func (p *Parser) DeferenceLValue() (ast.LValue, bool) {
 multiple lvalues
 */
 func (p *Parser) LValue() (ast.LValue, bool) {
+	start := p.CurrentFrame().Position
 	lv, fnd := p.DeferenceLValue()
 	if !fnd {
 		return nil, false
@@ -1285,7 +1531,9 @@ func (p *Parser) LValue() (ast.LValue, bool) {
 			if len(lvalues) == 1 {
 				return lv, true
 			} else {
-				return &ast.MultipleLValue{LValues: lvalues}, true
+				mlv := &ast.MultipleLValue{LValues: lvalues}
+				p.locate(start, mlv)
+				return mlv, true
 			}
 		}
 
@@ -1305,15 +1553,109 @@ func (p *Parser) ImportLine() (ast.TopLevelElement, bool) {
 		return nil, false
 	}
 
+	start := p.CurrentFrame().Position
+	ie, fnd := p.importedModule("import")
+	if !fnd {
+		return nil, false
+	}
+	ie.ImportAs = ie.Names[len(ie.Names)-1]
+
+	_, fnd = p.Token(token.As)
+	if fnd {
+		id, fnd := p.Token(token.Identifier)
+		if !fnd {
+			p.LogExpectingError("identifier", "import statement")
+			return nil, false
+		}
+
+		ie.ImportAs = id.Tval
+	}
+
+	for _, other := range p.imports {
+		if other.ImportAs == ie.ImportAs {
+			p.es.ErrorfAt(p.spanFrom(start), "%v is already imported as %v, use import ... as to give one another name", other.ImportedId().DisplayName(), ie.ImportAs)
+			return nil, false
+		}
+	}
+
+	p.imports = append(p.imports, ie)
+	return ie, true
+}
+
+// from geo::shapes import area, Square
+func (p *Parser) SelectiveImportLine() (ast.TopLevelElement, bool) {
+	_, fnd := p.Token(token.From)
+	if !fnd {
+		return nil, false
+	}
+
+	ie, fnd := p.importedModule("from")
+	if !fnd {
+		return nil, false
+	}
+
+	_, fnd = p.Token(token.Import)
+	if !fnd {
+		p.LogExpectingError("import", "from import")
+		return nil, false
+	}
+
+	for {
+		symbolStart := p.CurrentFrame().Position
+		id, fnd := p.Token(token.Identifier)
+		if !fnd {
+			p.LogExpectingError("identifier", "from import")
+			return nil, false
+		}
+		name := id.Tval
+		symbol := p.spanFrom(symbolStart)
+
+		def, isFunction := ie.Mod.LookupFunction(name)
+		sd, isStruct := ie.Mod.LookupStruct(name)
+		if isFunction && !def.Exported {
+			p.es.ErrorfAt(symbol, "Function %v in module %v is not exported", name, ie.Mod.Id.DisplayName())
+			return nil, false
+		} else if isStruct && !sd.Exported {
+			p.es.ErrorfAt(symbol, "struct %v in module %v is not exported", name, ie.Mod.Id.DisplayName())
+			return nil, false
+		} else if !isFunction && !isStruct {
+			p.es.ErrorfAt(symbol, "%v is not found in module %v", name, ie.Mod.Id.DisplayName())
+			return nil, false
+		}
+
+		if other := p.importedBy(name); other != nil {
+			p.es.ErrorfAt(symbol, "%v is already imported from %v", name, other.ImportedId().DisplayName())
+			return nil, false
+		}
+		for _, earlier := range ie.Symbols {
+			if earlier == name {
+				p.es.ErrorfAt(symbol, "%v is already imported from %v", name, ie.ImportedId().DisplayName())
+				return nil, false
+			}
+		}
+		ie.Symbols = append(ie.Symbols, name)
+
+		_, fnd = p.Token(token.Comma)
+		if !fnd {
+			break
+		}
+	}
+
+	p.imports = append(p.imports, ie)
+	return ie, true
+}
+
+// The path of the module an import line names, e.g. geo::shapes, which is then parsed
+func (p *Parser) importedModule(keyword string) (*ast.ImportElement, bool) {
+	pathStart := p.CurrentFrame().Position
 	id, fnd := p.Token(token.Identifier)
 	if !fnd {
-		p.LogError("Expecting identifer after import")
+		p.LogError("Expecting identifer after %v", keyword)
 		return nil, false
 	}
 
 	ie := &ast.ImportElement{
-		Names:    []string{id.Tval},
-		ImportAs: id.Tval,
+		Names: []string{id.Tval},
 	}
 
 	for {
@@ -1328,31 +1670,19 @@ func (p *Parser) ImportLine() (ast.TopLevelElement, bool) {
 		}
 
 		ie.Names = append(ie.Names, id.Tval)
-		ie.ImportAs = id.Tval
 	}
+	path := p.spanFrom(pathStart)
 
 	if _, fnd := p.disallowedIds[ie.ImportedId().Key()]; fnd {
-		p.LogError("Import cycle found when importing '%v' from '%v'", ie.ImportedId(), p.id)
+		p.es.ErrorfAt(path, "Import cycle found when importing '%v' from '%v'", ie.ImportedId(), p.id)
 		return nil, false
 	}
 
-	_, fnd = p.Token(token.As)
-	if fnd {
-		id, fnd = p.Token(token.Identifier)
-		if !fnd {
-			p.LogExpectingError("identifier", "import statement")
-			return nil, false
-		}
-
-		ie.ImportAs = id.Tval
-	}
-
 	ie.Mod = p.mp.GetModule(ie.Names, p.disallowedIds)
 	if ie.Mod == nil {
-		p.LogError("Parser failed to find module %v", strings.Join(ie.Names, "."))
+		p.es.ErrorfAt(path, "Parser failed to find module %v", strings.Join(ie.Names, "."))
 		return nil, false
 	}
-	p.imports = append(p.imports, ie)
 
 	for _, s := range ie.Mod.Structs {
 		p.scope.SetStruct(s.Id, s.Definition)
@@ -1362,6 +1692,7 @@ func (p *Parser) ImportLine() (ast.TopLevelElement, bool) {
 }
 
 func (p *Parser) StructDefinition() (ast.TopLevelElement, bool) {
+	doc := p.docAt(p.CurrentFrame().Position)
 	p.Save()
 	_, exported := p.Token(token.Export)
 
@@ -1403,22 +1734,37 @@ func (p *Parser) StructDefinition() (ast.TopLevelElement, bool) {
 	for {
 		p.EatSemicolons()
 
+		// the doc comment is above pub, when there is one
+		memberDoc := p.docAt(p.CurrentFrame().Position)
+		_, public := p.Token(token.Pub)
+
 		fn, fnd := p.FunctionDefinition()
 		if fnd {
 			fn.Id.Struct = structId
+			fn.Public = public
+			if fn.Doc == "" {
+				fn.Doc = memberDoc
+			}
 			sd.Functions = append(sd.Functions, fn)
 			continue
 		}
 
 		fps, fnd := p.ParameterListSegment()
 		if !fnd || len(fps) == 0 {
+			if public {
+				p.LogError("Expecting a field or function after pub")
+				return nil, false
+			}
 			break
 		}
 
 		for _, fp := range fps {
 			sd.Fields = append(sd.Fields, ast.StructField{
-				Name: fp.Name,
-				Type: fp.Type,
+				Name:    fp.Name,
+				Type:    fp.Type,
+				Public:  public,
+				Located: fp.Located,
+				Doc:     memberDoc,
 			})
 		}
 	}
@@ -1434,6 +1780,7 @@ func (p *Parser) StructDefinition() (ast.TopLevelElement, bool) {
 		Exported:   exported,
 		Id:         structId,
 		Definition: sd,
+		Doc:        doc,
 	}, true
 }
 
@@ -1480,6 +1827,52 @@ func (p *Parser) SendStatement() (ast.Statement, bool) {
 	}, true
 }
 
+// assert(condition, message), where assert is only a keyword at the start of a statement
+func (p *Parser) AssertStatement() (ast.Statement, bool) {
+	p.Save()
+	tok, fnd := p.Token(token.Identifier)
+	if !fnd || tok.Tval != "assert" {
+		p.Reject()
+		return nil, false
+	}
+
+	_, fnd = p.Token(token.OpenCurved)
+	if !fnd {
+		p.Reject()
+		return nil, false
+	}
+	p.Accept()
+
+	condition, fnd := p.Expression()
+	if !fnd {
+		p.LogError("Expecting condition after 'assert'")
+		return nil, false
+	}
+
+	_, fnd = p.Token(token.Comma)
+	if !fnd {
+		p.LogError("Expecting comma after 'assert' condition")
+		return nil, false
+	}
+
+	message, fnd := p.Expression()
+	if !fnd {
+		p.LogError("Expecting message after 'assert' condition")
+		return nil, false
+	}
+
+	_, fnd = p.Token(token.CloseCurved)
+	if !fnd {
+		p.LogError("Expecting ) after 'assert' message")
+		return nil, false
+	}
+
+	return &ast.AssertStatement{
+		Condition: condition,
+		Message:   message,
+	}, true
+}
+
 func (p *Parser) ForeachStatement() (ast.Statement, bool) {
 	_, fnd := p.Token(token.Foreach)
 	if !fnd {
@@ -1541,9 +1934,13 @@ func (p *Parser) LetLikeStatement(tt token.TokenType, at ast.AssignType) (*ast.A
 		return nil, false
 	}
 
+	errorCount := p.es.Count()
 	e, fnd := p.Expression()
 	if !fnd {
-		p.LogError("No expression found in let statement")
+		// a broken expression will already have said what is wrong with it
+		if p.es.Count() == errorCount {
+			p.LogError("No expression found in let statement")
+		}
 		return nil, false
 	}
 
@@ -1825,18 +2222,27 @@ func (p *Parser) Statement() (ast.Statement, bool) {
 		p.IfStatement,
 		p.WhileStatement,
 		p.BreakStatement,
+		p.AssertStatement,
 
 		p.ModifyInPlaceStatement,
 		p.AssignStatement,
 		p.ExpressionStatement,
 	}
 
+	start := p.CurrentFrame().Position
+	errorCount := p.es.Count()
 	for _, statement := range statements {
 		cs, ok := statement()
 		if ok {
+			p.locate(start, cs)
 			p.EatSemicolons()
 			return cs, true
 		}
+
+		// the other alternatives would only add confusing errors on top of this one
+		if p.es.Count() > errorCount {
+			return nil, false
+		}
 	}
 
 	return nil, false
@@ -1844,6 +2250,7 @@ func (p *Parser) Statement() (ast.Statement, bool) {
 
 // list of statements in curly parents
 func (p *Parser) StatementBlock() (*ast.StatementBlock, bool) {
+	start := p.CurrentFrame().Position
 	_, fnd := p.Token(token.OpenCurly)
 	if !fnd {
 		return nil, false
@@ -1856,9 +2263,16 @@ func (p *Parser) StatementBlock() (*ast.StatementBlock, bool) {
 	statements := []ast.StatementContainer{}
 
 	for {
+		p.EatSemicolons()
 		loc := p.CurrentLocation()
 
+		// a statement can parse while logging errors, when part of it was parsed another way after failing
+		rp := p.recoveryPoint()
 		s, fnd := p.Statement()
+		if p.failedSince(rp) {
+			p.resync(rp, false)
+			continue
+		}
 		if !fnd {
 			break
 		}
@@ -1887,6 +2301,7 @@ func (p *Parser) StatementBlock() (*ast.StatementBlock, bool) {
 	return &ast.StatementBlock{
 		Statements: statements,
 		Context:    p.scope,
+		Located:    ast.Located{Span: p.spanFrom(start)},
 	}, true
 }
 
@@ -1894,25 +2309,33 @@ func (p *Parser) StatementBlock() (*ast.StatementBlock, bool) {
 func (p *Parser) ParameterListSegment() ([]ast.FunctionParameter, bool) {
 	names := []ast.FunctionParameter{}
 
+	start := p.CurrentFrame().Position
 	leadingName, fnd := p.Token(token.Identifier)
 	if !fnd {
 		return names, true
 	}
 
-	names = append(names, ast.FunctionParameter{Name: leadingName.Tval})
+	names = append(names, ast.FunctionParameter{
+		Name:    leadingName.Tval,
+		Located: ast.Located{Span: p.spanFrom(start)},
+	})
 	for {
 		_, fnd = p.Token(token.Comma)
 		if !fnd {
 			break
 		}
 
+		start = p.CurrentFrame().Position
 		nextName, fnd := p.Token(token.Identifier)
 		if !fnd {
 			p.LogError("Expecting identifier after command in parameter list")
 			return nil, false
 		}
 
-		names = append(names, ast.FunctionParameter{Name: nextName.Tval})
+		names = append(names, ast.FunctionParameter{
+			Name:    nextName.Tval,
+			Located: ast.Located{Span: p.spanFrom(start)},
+		})
 	}
 
 	ty, fnd := p.Type()
@@ -1958,6 +2381,7 @@ func (p *Parser) ParameterList() ([]ast.FunctionParameter, bool) {
 }
 
 func (p *Parser) FunctionDefinition() (*ast.FunctionDefinition, bool) {
+	start := p.CurrentFrame().Position
 	p.Save()
 
 	loc := ast.KLocationAnywhere
@@ -2016,6 +2440,9 @@ func (p *Parser) FunctionDefinition() (*ast.FunctionDefinition, bool) {
 		returnType = ast.Type{Selector: ast.KTypeVoid}
 	}
 
+	// errors about the function as a whole point at its signature, not the body
+	signature := p.spanFrom(start)
+
 	statements, fnd := p.StatementBlock()
 	if !fnd {
 		p.LogError("FunctionDefinition(): No statement block found following definition")
@@ -2034,10 +2461,13 @@ func (p *Parser) FunctionDefinition() (*ast.FunctionDefinition, bool) {
 		Block:           statements,
 		Parameters:      parameters,
 		Location:        loc,
+		Located:         ast.Located{Span: signature},
+		Doc:             p.docAt(start),
 	}, true
 }
 
 func (p *Parser) ConstTle() (ast.TopLevelElement, bool) {
+	doc := p.docAt(p.CurrentFrame().Position)
 	cs, ok := p.LetLikeStatement(token.Const, ast.KAssignConst)
 	if !ok {
 		return nil, false
@@ -2045,6 +2475,7 @@ func (p *Parser) ConstTle() (ast.TopLevelElement, bool) {
 
 	return &ast.ConstTle{
 		Assign: cs,
+		Doc:    doc,
 	}, true
 }
 
@@ -2059,19 +2490,180 @@ func (p *Parser) FunctionDefinitionTle() (ast.TopLevelElement, bool) {
 	}, true
 }
 
+/*
+test "name" { ... }, where test is only a keyword at the top level
+
+This becomes a cpu function taking nothing, which eyot test calls
+*/
+func (p *Parser) TestDefinitionTle() (ast.TopLevelElement, bool) {
+	start := p.CurrentFrame().Position
+	p.Save()
+	tok, fnd := p.Token(token.Identifier)
+	if !fnd || tok.Tval != "test" {
+		p.Reject()
+		return nil, false
+	}
+
+	name, fnd := p.Token(token.String)
+	if !fnd {
+		p.Reject()
+		return nil, false
+	}
+	p.Accept()
+
+	signature := p.spanFrom(start)
+
+	p.StartScope()
+	defer p.EndScope()
+	ourScope := p.scope
+
+	statements, fnd := p.StatementBlock()
+	if !fnd {
+		p.LogError("Expecting a block after test name")
+		return nil, false
+	}
+
+	fd := p.blockFunction(fmt.Sprintf("__test_%v", p.testCount), signature, ourScope, statements)
+	fd.Doc = p.docAt(start)
+	fd.Test = name.Tval
+	p.testCount += 1
+
+	return &ast.FunctionDefinitionTle{
+		Definition: fd,
+	}, true
+}
+
+// The cpu function a test or bench block becomes, taking nothing and returning nothing
+func (p *Parser) blockFunction(name string, signature errors.SourceLocation, scope *ast.Scope, block *ast.StatementBlock) *ast.FunctionDefinition {
+	return &ast.FunctionDefinition{
+		Id: ast.FunctionId{
+			// the leading underscores keep it apart from the module's own functions, and from unused warnings
+			Name:   name,
+			Struct: ast.BlankStructId(),
+			Module: p.id,
+		},
+		Return:     ast.Type{Selector: ast.KTypeVoid},
+		Scope:      scope,
+		Block:      block,
+		Parameters: []ast.FunctionParameter{},
+		Location:   ast.KLocationCpu,
+		Located:    ast.Located{Span: signature},
+	}
+}
+
+/*
+Parse a bench block, as in `bench "name" { ... }` or `bench "name" for cpu, gpu { ... }`
+
+With destinations the block is parsed once for each, so that `worker f` within it creates a worker
+on that destination. The first function is returned and the rest are left in pendingTles
+*/
+func (p *Parser) BenchDefinitionTle() (ast.TopLevelElement, bool) {
+	start := p.CurrentFrame().Position
+	p.Save()
+	tok, fnd := p.Token(token.Identifier)
+	if !fnd || tok.Tval != "bench" {
+		p.Reject()
+		return nil, false
+	}
+
+	name, fnd := p.Token(token.String)
+	if !fnd {
+		p.Reject()
+		return nil, false
+	}
+	p.Accept()
+
+	destinations := []ast.PipeDestination{}
+	if _, fnd := p.Token(token.Foreach); fnd {
+		for {
+			if _, fnd := p.Token(token.Cpu); fnd {
+				destinations = append(destinations, ast.KDestinationCpu)
+			} else if _, fnd := p.Token(token.Gpu); fnd {
+				destinations = append(destinations, ast.KDestinationGpu)
+			} else {
+				p.LogError("Expecting cpu or gpu in the destinations of a bench")
+				return nil, false
+			}
+
+			if _, fnd := p.Token(token.Comma); !fnd {
+				break
+			}
+		}
+	}
+
+	signature := p.spanFrom(start)
+	doc := p.docAt(start)
+
+	variants := len(destinations)
+	if variants == 0 {
+		variants = 1
+	}
+
+	tles := []ast.TopLevelElement{}
+	for variant := 0; variant < variants; variant += 1 {
+		bench := &ast.Bench{
+			Name:         name.Tval,
+			Destinations: destinations,
+			Variant:      variant,
+		}
+
+		fnName := fmt.Sprintf("__bench_%v", p.benchCount)
+		if dest, fnd := bench.Destination(); fnd {
+			p.benchDestination = &dest
+			fnName = fmt.Sprintf("%v_%v", fnName, variant)
+		}
+
+		// every variant but the last is parsed from a copy of the position, so the next can start again
+		last := variant == variants-1
+		if !last {
+			p.Save()
+		}
+
+		p.StartScope()
+		ourScope := p.scope
+		statements, fnd := p.StatementBlock()
+		p.EndScope()
+		p.benchDestination = nil
+
+		if !last {
+			p.Reject()
+		}
+		if !fnd {
+			p.LogError("Expecting a block after bench name")
+			return nil, false
+		}
+
+		fd := p.blockFunction(fnName, signature, ourScope, statements)
+		fd.Doc = doc
+		fd.Bench = bench
+		tles = append(tles, &ast.FunctionDefinitionTle{
+			Definition: fd,
+		})
+	}
+	p.benchCount += 1
+
+	p.pendingTles = append(p.pendingTles, tles[1:]...)
+	return tles[0], true
+}
+
 func (p *Parser) TopLevelElement() (ast.TopLevelElement, bool) {
 	tles := []func() (ast.TopLevelElement, bool){
 		p.StructDefinition,
+		p.TestDefinitionTle,
+		p.BenchDefinitionTle,
 		p.FunctionDefinitionTle,
 		p.ConstTle,
 		p.ImportLine,
+		p.SelectiveImportLine,
 	}
 
 	for _, tle := range tles {
 		p.EatSemicolons()
 
+		start := p.CurrentFrame().Position
 		el, ok := tle()
 		if ok {
+			p.locate(start, el)
 			return el, true
 		}
 	}
@@ -2094,6 +2686,7 @@ func (p *Parser) Module() *ast.Module {
 	}
 
 	for {
+		p.EatSemicolons()
 		dtlec := ast.TopLevelElementContainer{
 			TopLevelElement: &ast.DummyTle{
 				Location: p.CurrentLocation(),
@@ -2101,12 +2694,21 @@ func (p *Parser) Module() *ast.Module {
 			Context: p.scope,
 		}
 
+		rp := p.recoveryPoint()
 		tle, fnd := p.TopLevelElement()
 		if !fnd {
-			break
-		}
-		if !p.es.Clean() {
-			return nil
+			if p.failedSince(rp) {
+				p.resync(rp, true)
+				continue
+			}
+
+			if p.Eof() || p.tokens[p.CurrentFrame().Position].Type == token.Eof {
+				break
+			}
+
+			p.LogError("Expecting EOF, got %v", p.DebugPeekToken())
+			p.resync(rp, true)
+			continue
 		}
 
 		tlec := ast.TopLevelElementContainer{
@@ -2115,11 +2717,18 @@ func (p *Parser) Module() *ast.Module {
 		}
 
 		f.TopLevelElements = append(f.TopLevelElements, dtlec, tlec)
+
+		for _, pending := range p.pendingTles {
+			f.TopLevelElements = append(f.TopLevelElements, ast.TopLevelElementContainer{
+				TopLevelElement: pending,
+				Context:         p.scope,
+			})
+		}
+		p.pendingTles = nil
 	}
 
-	t, fnd := p.Token(token.Eof)
-	if !fnd {
-		p.LogError("Expecting EOF, got %v", t)
+	if _, fnd := p.Token(token.Eof); !fnd {
+		p.LogError("Expecting EOF")
 		return nil
 	}
 
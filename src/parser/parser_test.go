@@ -223,3 +223,87 @@ func TestLValue(t *testing.T) {
 		t.Fatal("Top level was not accessor")
 	}
 }
+
+func TestStatementRecovery(t *testing.T) {
+	p := pf(t, "{\nlet = 1\nhello(12)\nlet x = 1 + * 2\nthere(13)\n }")
+	s, ok := p.StatementBlock()
+	if !ok {
+		t.Fatal("Could not recover from broken statements")
+	}
+	if p.es.Count() != 2 {
+		t.Fatalf("Expected one error for each broken statement, have %v", p.es.Count())
+	}
+	if len(s.Statements) != 4 {
+		t.Fatal("Wrong number of statements kept in block")
+	}
+}
+
+func TestTopLevelRecovery(t *testing.T) {
+	p := pf(t, "fn foo( {\n}\nfn bar() {\nhello(12)\n}\nlet x = 1\nfn baz() {\n}\n")
+	m := p.Module()
+	if m == nil {
+		t.Fatal("Could not recover from broken top level elements")
+	}
+	if p.es.Count() != 2 {
+		t.Fatalf("Expected one error for each broken top level element, have %v", p.es.Count())
+	}
+	if len(m.TopLevelElements) != 4 {
+		t.Fatal("Wrong number of top level elements kept in module")
+	}
+}
+
+func TestTestDefinition(t *testing.T) {
+	p := pf(t, "test \"adds up\" {\nassert(true, \"maths\")\n }")
+	tle, ok := p.TopLevelElement()
+	if !ok {
+		t.Fatal("Could not parse test definition")
+	}
+	fn, ok := tle.(*ast.FunctionDefinitionTle)
+	if !ok {
+		t.Fatal("Tle did not return function defn")
+	}
+	if fn.Definition.Test != "adds up" {
+		t.Fatalf("Wrong test name %v", fn.Definition.Test)
+	}
+	asserts := 0
+	for _, sc := range fn.Definition.Block.Statements {
+		if _, ok := sc.Statement.(*ast.AssertStatement); ok {
+			asserts += 1
+		}
+	}
+	if asserts != 1 {
+		t.Fatal("Test did not hold an assert")
+	}
+
+	// both are only keywords where they are used as one
+	p = pf(t, "{\ntest(1)\nassert = 2\n }")
+	if _, ok := p.StatementBlock(); !ok || !p.es.Clean() {
+		t.Fatal("Could not parse test and assert as identifiers")
+	}
+}
+
+func TestBenchDefinition(t *testing.T) {
+	p := pf(t, "bench \"squares\" for cpu, gpu {\nlet w = worker square\n}\n")
+	m := p.Module()
+	if m == nil || !p.es.Clean() {
+		t.Fatal("Could not parse bench definition")
+	}
+
+	dests := []ast.PipeDestination{}
+	for _, fd := range m.Benches() {
+		if fd.Bench.Name != "squares" {
+			t.Fatalf("Wrong bench name %v", fd.Bench.Name)
+		}
+
+		for _, sc := range fd.Block.Statements {
+			if as, ok := sc.Statement.(*ast.AssignStatement); ok {
+				dests = append(dests, as.Rhs.(*ast.CreateWorkerExpression).Destination)
+			}
+		}
+	}
+
+	// the block is parsed for each destination, with worker taking it
+	if len(dests) != 2 || dests[0] != ast.KDestinationCpu || dests[1] != ast.KDestinationGpu {
+		t.Fatalf("Wrong worker destinations %v", dests)
+	}
+}
@@ -2,16 +2,29 @@ package program
 
 import (
 	"eyot/ast"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // build time setter (-ldflags "-X eyot.program.EyotRoot=/usr/share/blah")
 var EyotRoot string
 
 type Environment struct {
-	// root path of the std library
+	// the folders modules are found in, the main file's first and the std library's last
 	Roots []string
+
+	// the folders of the project's dependencies by name, whose modules are imported under that name
+	Packages map[string]string
+
+	// the project's manifest, nil when there is none
+	Manifest *Manifest
+
+	// The contents of files by path, used in place of what is on disk, e.g. unsaved editor buffers
+	Overlay map[string]string
 }
 
 // default env creator, pulls from environment or build settings
@@ -25,23 +38,147 @@ func CreateEnvironment(localPath string) *Environment {
 	}
 
 	return &Environment{
-		Roots: []string{localPath, root},
+		Roots:    []string{localPath, root},
+		Packages: map[string]string{},
+		Overlay:  map[string]string{},
 	}
 }
 
+/*
+Create the environment for a file, using the manifest of the project it is in if there is one
+
+The manifest's roots are searched after the file's own folder, and before the std library
+*/
+func CreateProjectEnvironment(localPath string) (*Environment, error) {
+	e := CreateEnvironment(localPath)
+
+	m, err := FindManifest(localPath)
+	if err != nil || m == nil {
+		return e, err
+	}
+
+	e.Manifest = m
+	e.Roots = append(append([]string{localPath}, m.RootPaths()...), e.LibraryRoot())
+
+	// once vendored, dependencies are only found in the vendor folder, as they were locked
+	locked, err := m.ReadLock()
+	if err != nil {
+		return nil, err
+	}
+	if locked != nil {
+		e.Packages, err = m.CheckVendored(locked)
+		return e, err
+	}
+
+	for _, d := range m.Dependencies {
+		if d.Git != "" {
+			return nil, fmt.Errorf("Dependency %v is a git repository, run eyot mod vendor to fetch it", d.Name)
+		}
+
+		dir := m.DependencyDir(d)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("Dependency %v is not found at %v", d.Name, dir)
+		}
+		e.Packages[d.Name] = dir
+	}
+
+	return e, nil
+}
+
+// The root of the std library and the runtime
+func (e *Environment) LibraryRoot() string {
+	return e.Roots[len(e.Roots)-1]
+}
+
 func (e *Environment) RuntimeRoot() string {
 	// TODO test properly here
-	return filepath.Join(e.Roots[1], "runtime")
+	return filepath.Join(e.LibraryRoot(), "runtime")
+}
+
+// Where the module could be, in the order they are searched, without the .ey extension
+func (e *Environment) candidates(cpts ast.ModuleId) []string {
+	paths := []string{}
+	for _, root := range e.Roots {
+		paths = append(paths, filepath.Join(root, filepath.Join(cpts...)))
+	}
+
+	if len(cpts) > 0 {
+		if dir, fnd := e.Packages[cpts[0]]; fnd {
+			paths = append(paths, filepath.Join(dir, filepath.Join(cpts[1:]...)))
+		}
+	}
+	return paths
+}
+
+// True when the module is found next to the program, rather than in the standard library
+func (e *Environment) IsLocal(cpts ast.ModuleId) bool {
+	return e.exists(filepath.Join(e.Roots[0], filepath.Join(cpts...)) + ".ey")
+}
+
+func (e *Environment) exists(path string) bool {
+	if _, fnd := e.Overlay[path]; fnd {
+		return true
+	}
+
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Read a file, from the overlay if it is there
+func (e *Environment) ReadFile(path string) ([]byte, error) {
+	if text, fnd := e.Overlay[path]; fnd {
+		return []byte(text), nil
+	}
+
+	return os.ReadFile(path)
 }
 
 // Find a path to a module
 func (e *Environment) FindModule(cpts ast.ModuleId) string {
-	for _, root := range e.Roots {
-		path := filepath.Join(root, filepath.Join(cpts...)) + ".ey"
-		if _, err := os.Stat(path); err == nil {
+	for _, candidate := range e.candidates(cpts) {
+		path := candidate + ".ey"
+		if e.exists(path) {
 			return path
 		}
 	}
 
 	return ""
 }
+
+// The module and every module in the folder of the same name below it, from all roots, sorted by key
+func (e *Environment) ModulesUnder(cpts ast.ModuleId) []ast.ModuleId {
+	found := map[string]ast.ModuleId{}
+
+	for _, base := range e.candidates(cpts) {
+		if e.exists(base + ".ey") {
+			found[cpts.Key()] = cpts
+		}
+
+		filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(path) != ".ey" {
+				return nil
+			}
+
+			rel, err := filepath.Rel(base, strings.TrimSuffix(path, ".ey"))
+			if err != nil {
+				return nil
+			}
+
+			id := append(append(ast.ModuleId{}, cpts...), strings.Split(filepath.ToSlash(rel), "/")...)
+			found[id.Key()] = id
+			return nil
+		})
+	}
+
+	keys := []string{}
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ids := []ast.ModuleId{}
+	for _, key := range keys {
+		ids = append(ids, found[key])
+	}
+	return ids
+}
@@ -0,0 +1,443 @@
+package program
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"eyot/token"
+)
+
+// The name of a project's manifest, found in the project's folder or any above the file being built
+const ManifestName string = "eyot.toml"
+
+/*
+A project, as described by its eyot.toml
+
+	name = "mandelbrot"
+	main = "src/main.ey"
+	roots = ["lib"]
+	cflags = ["-march=native"]
+
+	[dependencies]
+	shared = { path = "../shared" }
+	colours = { git = "https://example.com/colours.git", rev = "v1.2" }
+
+Paths are relative to the folder holding the manifest
+*/
+type Manifest struct {
+	// the manifest itself, and the folder holding it
+	Path, Dir string
+
+	Name string
+
+	// the file built when no file is given
+	Main string
+
+	// more folders to find modules in, searched after the main file's folder
+	Roots []string
+
+	// passed on to the C compiler and the linker, before any given to the command
+	CFlags, LdFlags []string
+
+	// sorted by name
+	Dependencies []Dependency
+}
+
+/*
+A project this one uses, whose modules are imported under its name, e.g. shared::maths
+
+It is found in a local folder, or a git repository checked out into the vendor folder
+*/
+type Dependency struct {
+	Name string
+	Path string
+	Git  string
+	Rev  string
+}
+
+// The folder a project keeps the dependencies it has fetched in
+const VendorFolder string = "vendor"
+
+// The folder the dependency's modules are found in
+func (m *Manifest) DependencyDir(d Dependency) string {
+	if d.Git != "" {
+		return m.VendorDir(d.Name)
+	}
+	return m.resolve(d.Path)
+}
+
+// A path from the manifest, relative to its folder
+func (m *Manifest) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(m.Dir, path)
+}
+
+// The file built when no file is given
+func (m *Manifest) MainPath() string {
+	return m.resolve(m.Main)
+}
+
+// The module search roots, in the order they are searched
+func (m *Manifest) RootPaths() []string {
+	roots := []string{}
+	for _, root := range m.Roots {
+		roots = append(roots, m.resolve(root))
+	}
+	return roots
+}
+
+// Look for a manifest in the folder and the folders above it, returning nil if there is none
+func FindManifest(dir string) (*Manifest, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		path := filepath.Join(dir, ManifestName)
+		if _, err := os.Stat(path); err == nil {
+			return LoadManifest(path)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// Read and check a manifest
+func LoadManifest(path string) (*Manifest, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{Path: abs, Dir: filepath.Dir(abs)}
+	if err := m.parse(string(blob)); err != nil {
+		return nil, fmt.Errorf("%v:%v", path, err)
+	}
+
+	if m.Name == "" {
+		return nil, fmt.Errorf("%v: the project has no name", path)
+	}
+	for _, d := range m.Dependencies {
+		if (d.Path == "") == (d.Git == "") {
+			return nil, fmt.Errorf("%v: dependency %v should have one of path or git", path, d.Name)
+		}
+		if d.Rev != "" && d.Git == "" {
+			return nil, fmt.Errorf("%v: dependency %v has a rev but no git repository", path, d.Name)
+		}
+	}
+	return m, nil
+}
+
+/*
+Fill the manifest from the TOML it was written in
+
+This understands the part of TOML a manifest needs: tables, strings, arrays of strings and inline
+tables, with comments. Errors start with the line number
+*/
+func (m *Manifest) parse(text string) error {
+	table := ""
+	dependencies := map[string]*Dependency{}
+
+	lines := strings.Split(text, "\n")
+	for li := 0; li < len(lines); li += 1 {
+		lineNumber := li + 1
+		line := strings.TrimSpace(stripTomlComment(lines[li]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("%v: unterminated table header", lineNumber)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table != "dependencies" && !strings.HasPrefix(table, "dependencies.") {
+				return fmt.Errorf("%v: unknown table %v", lineNumber, table)
+			}
+			continue
+		}
+
+		key, value, fnd := strings.Cut(line, "=")
+		if !fnd {
+			return fmt.Errorf("%v: expected key = value", lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		// arrays can carry on over lines until they are closed
+		for strings.HasPrefix(value, "[") && !tomlClosed(value) && li+1 < len(lines) {
+			li += 1
+			value += " " + strings.TrimSpace(stripTomlComment(lines[li]))
+		}
+
+		parsed, rest, err := parseTomlValue(value)
+		if err != nil {
+			return fmt.Errorf("%v: %v", lineNumber, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return fmt.Errorf("%v: unexpected %v after the value", lineNumber, strings.TrimSpace(rest))
+		}
+
+		switch {
+		case table == "":
+			if err := m.setKey(key, parsed); err != nil {
+				return fmt.Errorf("%v: %v", lineNumber, err)
+			}
+
+		case table == "dependencies":
+			fields, ok := parsed.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%v: dependency %v should be a table, as in { path = \"../%v\" }", lineNumber, key, key)
+			}
+			d, err := dependencyNamed(dependencies, key)
+			if err != nil {
+				return fmt.Errorf("%v: %v", lineNumber, err)
+			}
+			for field, fieldValue := range fields {
+				if err := d.setKey(field, fieldValue); err != nil {
+					return fmt.Errorf("%v: %v", lineNumber, err)
+				}
+			}
+
+		default:
+			d, err := dependencyNamed(dependencies, strings.TrimPrefix(table, "dependencies."))
+			if err != nil {
+				return fmt.Errorf("%v: %v", lineNumber, err)
+			}
+			if err := d.setKey(key, parsed); err != nil {
+				return fmt.Errorf("%v: %v", lineNumber, err)
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.Dependencies = append(m.Dependencies, *dependencies[name])
+	}
+	return nil
+}
+
+func dependencyNamed(dependencies map[string]*Dependency, name string) (*Dependency, error) {
+	if d, fnd := dependencies[name]; fnd {
+		return d, nil
+	}
+	if err := checkDependencyName(name); err != nil {
+		return nil, err
+	}
+	d := &Dependency{Name: name}
+	dependencies[name] = d
+	return d, nil
+}
+
+/*
+Check a dependency's name is a plain identifier
+
+It is imported as the first part of a module's name, and names the dependency's folder in vendor, so
+it can't hold anything a path could be built from, e.g. ../
+*/
+func checkDependencyName(name string) error {
+	for i, r := range name {
+		if !token.IsIdentifier(r) || (i == 0 && !token.IsIdentifierStart(r)) {
+			return fmt.Errorf("dependency name %q should be an identifier, as in shared_maths", name)
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("a dependency has no name")
+	}
+	return nil
+}
+
+func (m *Manifest) setKey(key string, value interface{}) error {
+	switch key {
+	case "name":
+		return setTomlString(key, value, &m.Name)
+	case "main":
+		return setTomlString(key, value, &m.Main)
+	case "roots":
+		return setTomlStrings(key, value, &m.Roots)
+	case "cflags":
+		return setTomlStrings(key, value, &m.CFlags)
+	case "ldflags":
+		return setTomlStrings(key, value, &m.LdFlags)
+	}
+	return fmt.Errorf("unknown key %v", key)
+}
+
+func (d *Dependency) setKey(key string, value interface{}) error {
+	switch key {
+	case "path":
+		return setTomlString(key, value, &d.Path)
+	case "git":
+		return setTomlString(key, value, &d.Git)
+	case "rev":
+		return setTomlString(key, value, &d.Rev)
+	}
+	return fmt.Errorf("unknown key %v in dependency %v", key, d.Name)
+}
+
+func setTomlString(key string, value interface{}, to *string) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%v should be a string", key)
+	}
+	*to = s
+	return nil
+}
+
+func setTomlStrings(key string, value interface{}, to *[]string) error {
+	values, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("%v should be an array of strings", key)
+	}
+
+	*to = []string{}
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%v should be an array of strings", key)
+		}
+		*to = append(*to, s)
+	}
+	return nil
+}
+
+// The line without any comment, which starts at a # outside of a string
+func stripTomlComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i += 1 {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				// skip what is escaped, which may be a quote
+				i += 1
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Has every bracket opened in the value been closed
+func tomlClosed(value string) bool {
+	_, _, err := parseTomlValue(value)
+	return err == nil
+}
+
+// Parse the value at the start of text, returning it and what follows it
+func parseTomlValue(text string) (interface{}, string, error) {
+	text = strings.TrimLeft(text, " \t")
+	if text == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+
+	switch text[0] {
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(text); i += 1 {
+			switch text[i] {
+			case '"':
+				return sb.String(), text[i+1:], nil
+			case '\\':
+				if i+1 >= len(text) {
+					return nil, "", fmt.Errorf("unterminated string")
+				}
+				i += 1
+				switch text[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				case '"', '\\':
+					sb.WriteByte(text[i])
+				default:
+					return nil, "", fmt.Errorf("unknown escape \\%c", text[i])
+				}
+			default:
+				sb.WriteByte(text[i])
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string")
+
+	case '\'':
+		end := strings.IndexByte(text[1:], '\'')
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return text[1 : end+1], text[end+2:], nil
+
+	case '[':
+		values := []interface{}{}
+		rest := strings.TrimLeft(text[1:], " \t")
+		for {
+			if strings.HasPrefix(rest, "]") {
+				return values, rest[1:], nil
+			}
+
+			value, after, err := parseTomlValue(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			values = append(values, value)
+
+			rest = strings.TrimLeft(after, " \t")
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimLeft(rest[1:], " \t")
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, "", fmt.Errorf("expected , or ] in array")
+			}
+		}
+
+	case '{':
+		fields := map[string]interface{}{}
+		rest := strings.TrimLeft(text[1:], " \t")
+		for {
+			if strings.HasPrefix(rest, "}") {
+				return fields, rest[1:], nil
+			}
+
+			key, after, fnd := strings.Cut(rest, "=")
+			if !fnd {
+				return nil, "", fmt.Errorf("expected key = value in table")
+			}
+			value, after, err := parseTomlValue(after)
+			if err != nil {
+				return nil, "", err
+			}
+			fields[strings.TrimSpace(key)] = value
+
+			rest = strings.TrimLeft(after, " \t")
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimLeft(rest[1:], " \t")
+			} else if !strings.HasPrefix(rest, "}") {
+				return nil, "", fmt.Errorf("expected , or } in table")
+			}
+		}
+	}
+
+	return nil, "", fmt.Errorf("unsupported value %v, only strings, arrays and tables are", text)
+}
@@ -0,0 +1,199 @@
+package program
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"eyot/ast"
+	"eyot/errors"
+)
+
+// Write files below dir, by their path relative to it
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for rel, text := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("Failed to create %v: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0666); err != nil {
+			t.Fatalf("Failed to write %v: %v", path, err)
+		}
+	}
+}
+
+// Load text as the manifest of a new project
+func loadManifest(t *testing.T, text string) (*Manifest, error) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{ManifestName: text})
+	return LoadManifest(filepath.Join(dir, ManifestName))
+}
+
+func mustLoadManifest(t *testing.T, text string) *Manifest {
+	m, err := loadManifest(t, text)
+	if err != nil {
+		t.Fatalf("Failed to load the manifest: %v", err)
+	}
+	return m
+}
+
+func TestManifestMultiLineArrays(t *testing.T) {
+	m := mustLoadManifest(t, `name = "mandelbrot"
+roots = [
+    "lib",  # the project's own modules
+    "third-party",
+]
+cflags = ["-O3",
+          "-march=native"]
+ldflags = []
+`)
+
+	if !reflect.DeepEqual(m.Roots, []string{"lib", "third-party"}) {
+		t.Fatalf("Wrong roots: %#v", m.Roots)
+	}
+	if !reflect.DeepEqual(m.CFlags, []string{"-O3", "-march=native"}) {
+		t.Fatalf("Wrong cflags: %#v", m.CFlags)
+	}
+	if len(m.LdFlags) != 0 {
+		t.Fatalf("Wrong ldflags: %#v", m.LdFlags)
+	}
+}
+
+func TestManifestCommentsInStrings(t *testing.T) {
+	m := mustLoadManifest(t, `name = "hash#tag" # the name has a # in it
+main = 'src/#main.ey'
+cflags = ["-DQUOTE=\"#\"", '-DSINGLE=#'] # "not a string
+`)
+
+	if m.Name != "hash#tag" || m.Main != "src/#main.ey" {
+		t.Fatalf("Wrong name or main: %v, %v", m.Name, m.Main)
+	}
+	if !reflect.DeepEqual(m.CFlags, []string{`-DQUOTE="#"`, "-DSINGLE=#"}) {
+		t.Fatalf("Wrong cflags: %#v", m.CFlags)
+	}
+}
+
+func TestManifestDependencyTables(t *testing.T) {
+	inline := mustLoadManifest(t, `name = "app"
+
+[dependencies]
+shared = { path = "../shared" }
+colours = { git = "https://example.com/colours.git", rev = "v1.2" }
+`)
+
+	tables := mustLoadManifest(t, `name = "app"
+
+[dependencies.shared]
+path = "../shared"
+
+[dependencies.colours]
+git = "https://example.com/colours.git"
+rev = "v1.2"
+`)
+
+	// both are sorted by name
+	expected := []Dependency{
+		{Name: "colours", Git: "https://example.com/colours.git", Rev: "v1.2"},
+		{Name: "shared", Path: "../shared"},
+	}
+	if !reflect.DeepEqual(inline.Dependencies, expected) {
+		t.Fatalf("Wrong inline dependencies: %#v", inline.Dependencies)
+	}
+	if !reflect.DeepEqual(tables.Dependencies, expected) {
+		t.Fatalf("Wrong table dependencies: %#v", tables.Dependencies)
+	}
+
+	// a folder is found relative to the manifest, and a repository in vendor
+	if dir := inline.DependencyDir(inline.Dependencies[1]); dir != filepath.Join(filepath.Dir(inline.Dir), "shared") {
+		t.Fatalf("Wrong folder for shared: %v", dir)
+	}
+	if dir := inline.DependencyDir(inline.Dependencies[0]); dir != filepath.Join(inline.Dir, VendorFolder, "colours") {
+		t.Fatalf("Wrong folder for colours: %v", dir)
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	for _, c := range []struct {
+		text, expected string
+	}{
+		{"name = \"app\"\nversion = \"1.0\"\n", ":2: unknown key version"},
+		{"name = \"app\"\n\n[dependencies.shared]\npath = \"../shared\"\nbranch = \"main\"\n", ":5: unknown key branch in dependency shared"},
+		{"name = \"app\"\n[dependencies]\nshared = { path = \"../shared\", tag = \"v1\" }\n", ":3: unknown key tag in dependency shared"},
+		{"name = \"app\"\n[package]\n", ":2: unknown table package"},
+		{"# a comment\n\nname = \"app\nmain = \"main.ey\"\n", ":3: unterminated string"},
+		{"name = \"app\"\nroots = [\n    \"lib\"\n    \"src\",\n]\n", ":2: expected , or ] in array"},
+		{"name = \"app\"\n[dependencies]\nshared = \"../shared\"\n", ":3: dependency shared should be a table"},
+		{"name = \"app\"\nroots = \"lib\"\n", ":2: roots should be an array of strings"},
+		{"main = \"main.ey\"\n", "the project has no name"},
+		{"name = \"app\"\n[dependencies]\nshared = { path = \"../shared\", git = \"https://example.com/shared.git\" }\n", "dependency shared should have one of path or git"},
+		{"name = \"app\"\n[dependencies.shared]\npath = \"../shared\"\nrev = \"v1\"\n", "dependency shared has a rev but no git repository"},
+	} {
+		_, err := loadManifest(t, c.text)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Loading:\n%v\nexpecting an error with '%v', have %v", c.text, c.expected, err)
+		}
+	}
+}
+
+// Modules are found in the file's folder, then the manifest's roots, then path dependencies by their name
+func TestProjectModules(t *testing.T) {
+	library, err := filepath.Abs(filepath.Join("..", "..", "lib"))
+	if err != nil {
+		t.Fatalf("No library root: %v", err)
+	}
+	t.Setenv("EyotRoot", library)
+
+	base := t.TempDir()
+	project := filepath.Join(base, "app")
+	writeFiles(t, base, map[string]string{
+		"app/eyot.toml": `name = "app"
+main = "src/main.ey"
+roots = ["lib"]
+
+[dependencies]
+shared = { path = "../shared" }
+`,
+		"app/src/main.ey": `import helpers
+import shared::maths
+
+cpu fn main() {
+    print_ln(maths::double(helpers::three()))
+}
+`,
+		"app/lib/helpers.ey": `export fn three() i64 {
+    return 3
+}
+`,
+		"shared/maths.ey": `export fn double(v i64) i64 {
+    return v * 2
+}
+`,
+	})
+
+	m, err := LoadManifest(filepath.Join(project, ManifestName))
+	if err != nil {
+		t.Fatalf("Failed to load the manifest: %v", err)
+	}
+	env, err := CreateProjectEnvironment(filepath.Dir(m.MainPath()))
+	if err != nil {
+		t.Fatalf("Failed to create the environment: %v", err)
+	}
+
+	if path := env.FindModule(ast.ModuleId{"helpers"}); path != filepath.Join(project, "lib", "helpers.ey") {
+		t.Fatalf("Wrong path for helpers: %v", path)
+	}
+	if path := env.FindModule(ast.ModuleId{"shared", "maths"}); path != filepath.Join(base, "shared", "maths.ey") {
+		t.Fatalf("Wrong path for shared::maths: %v", path)
+	}
+
+	es := errors.NewErrors()
+	NewProgram(env, es).ParseRoot("main")
+	if !es.Clean() {
+		buf := bytes.NewBuffer([]byte{})
+		es.LogErrors(buf)
+		t.Fatalf("Failed to check:\n%v", buf.String())
+	}
+}
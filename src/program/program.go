@@ -2,7 +2,6 @@ package program
 
 import (
 	"fmt"
-	"os"
 
 	"eyot/ast"
 	"eyot/errors"
@@ -34,6 +33,18 @@ type Program struct {
 	// all vector types found in the program (that must be later 
 	Vectors map[string]ast.Type
 
+	// the comments in each module by key, which only the formatter needs
+	Comments map[string][]token.Comment
+
+	// true when building the root module's tests to be run by eyot test, rather than its main
+	Testing bool
+
+	// true when building the root module's benches to be run by eyot bench
+	Benching bool
+
+	// true when building the root module as a library, called from C through its exported functions
+	Library bool
+
 	es *errors.Errors
 }
 
@@ -43,6 +54,7 @@ func NewProgram(e *Environment, es *errors.Errors) *Program {
 	return &Program{
 		Modules:            map[string]*ast.Module{},
 		Vectors:            map[string]ast.Type {},
+		Comments:           map[string][]token.Comment{},
 		GpuRequired:        false,
 		Env:                e,
 		es:                 es,
@@ -81,13 +93,15 @@ func (p *Program) innerParse(id ast.ModuleId, disallowedIds map[string]bool) *as
 		return nil
 	}
 
-	blob, err := os.ReadFile(path)
+	blob, err := p.Env.ReadFile(path)
 	if err != nil {
 		p.es.LogInternalError(fmt.Errorf("Failed to read file %v", path))
 		return nil
 	}
 
-	tkns, err := token.Tokenise(string(blob))
+	p.es.AddSource(id.Key(), path, string(blob))
+
+	tkns, comments, err := token.TokeniseWithComments(string(blob))
 	if err != nil {
 		p.es.LogInternalError(fmt.Errorf("Tokenise failed with error: %v", err))
 		return nil
@@ -100,6 +114,7 @@ func (p *Program) innerParse(id ast.ModuleId, disallowedIds map[string]bool) *as
 
 	m.Id = id
 	p.Modules[id.Key()] = m
+	p.Comments[id.Key()] = comments
 	return m
 }
 
@@ -120,16 +135,61 @@ func (p *Program) GetModule(id ast.ModuleId, disallowedIds map[string]bool) *ast
 	return mod
 }
 
-func (p *Program) ParseRoot(moduleName string) {
+/*
+Parse and check the root module, without requiring a main function
+
+The module is returned even when checking fails, as it may still be partly checked. It is nil if
+it could not be parsed
+*/
+func (p *Program) ParseRootModule(moduleName string) *ast.Module {
+	rootModule := p.ParseRootUnchecked(moduleName)
+	p.CheckModule(rootModule)
+	return rootModule
+}
+
+/*
+Parse the root module, checking it only as far as the given pass, for eyot ast to show the tree then
+
+Imported modules are checked fully. The module is returned even when checking fails
+*/
+func (p *Program) ParseRootModuleUntil(moduleName string, last ast.CheckPass) *ast.Module {
+	rootModule := p.ParseRootUnchecked(moduleName)
+	p.checkModuleUntil(rootModule, last)
+	return rootModule
+}
+
+/*
+Parse the root module without checking it, so it is as it was written
+
+Modules it imports are still checked, as the parser needs what they export
+*/
+func (p *Program) ParseRootUnchecked(moduleName string) *ast.Module {
 	p.RootModuleId = []string{moduleName}
-	rootModule := p.innerParse(p.RootModuleId, map[string]bool{})
-	if rootModule == nil {
+	return p.ParseUnchecked(p.RootModuleId)
+}
+
+// Parse a module without checking it, so it is as it was written. The modules it imports are checked
+func (p *Program) ParseUnchecked(id ast.ModuleId) *ast.Module {
+	m := p.innerParse(id, map[string]bool{})
+	if m == nil && p.es.Clean() {
 		p.es.Errorf("file not found")
 	}
 	if !p.es.Clean() {
+		return nil
+	}
+	return m
+}
+
+func (p *Program) ParseRoot(moduleName string) {
+	rootModule := p.ParseRootModule(moduleName)
+	if rootModule == nil {
+		return
+	}
+
+	if p.Library {
+		p.checkLibraryExports(rootModule)
 		return
 	}
-	p.CheckModule(rootModule)
 
 	// add the synthesized main function
 	mainFd, fnd := rootModule.LookupFunction("main")
@@ -143,10 +203,49 @@ func (p *Program) ParseRoot(moduleName string) {
 	}
 }
 
+// Can a value of this type be passed between C and a library
+func isLibraryType(ty ast.Type) bool {
+	switch ty.Selector {
+	case ast.KTypeInteger, ast.KTypeFloat, ast.KTypeString, ast.KTypeCharacter, ast.KTypeBoolean:
+		return true
+	}
+	return false
+}
+
+// A library has no main, but C has to be able to call the functions it exports from the cpu
+func (p *Program) checkLibraryExports(m *ast.Module) {
+	exported := false
+	for _, fd := range m.Exports() {
+		if fd.Location == ast.KLocationGpu {
+			continue
+		}
+		exported = true
+
+		if fd.Return.Selector != ast.KTypeVoid && !isLibraryType(fd.Return) {
+			p.es.ErrorfAt(fd.SourceLocation(), "%v is exported from a library, but returns %v which C can't take", fd.Id.Name, fd.Return.String())
+		}
+		for _, param := range fd.Parameters {
+			if !isLibraryType(param.Type) {
+				p.es.ErrorfAt(fd.SourceLocation(), "%v is exported from a library, but takes %v which C can't pass", fd.Id.Name, param.Type.String())
+			}
+		}
+	}
+
+	if !exported {
+		// the whole module is at fault, so this is reported at its start
+		p.es.ErrorfAt(errors.SourceLocation{Filename: m.Id.Key(), Line: 1}, "A library should export at least one cpu function")
+	}
+}
+
 // Top level type checking
 //
 // This fills in a bunch of data that is needed for output
 func (p *Program) CheckModule(m *ast.Module) {
+	p.checkModuleUntil(m, ast.KPassAnalyse)
+}
+
+// Check the module, stopping after the last pass
+func (p *Program) checkModuleUntil(m *ast.Module, last ast.CheckPass) {
 	if m == nil {
 		return
 	}
@@ -157,6 +256,9 @@ func (p *Program) CheckModule(m *ast.Module) {
 	ctx.Pass = ast.KPassSetTleTypes
 	ctx.PrepareForPass(m)
 	m.Check(ctx)
+	if last == ast.KPassSetTleTypes {
+		return
+	}
 
 	ctx.Errors.SetActivity("Set types")
 	ctx.Pass = ast.KPassSetTypes
@@ -180,18 +282,34 @@ func (p *Program) CheckModule(m *ast.Module) {
 		p.Vectors[vecId] = vec
 	}
 
+	if last == ast.KPassSetTypes {
+		return
+	}
+
 	ctx.Errors.SetActivity("Mutate tree")
 	ctx.Pass = ast.KPassMutate
 	ctx.PrepareForPass(m)
 	m.Check(ctx)
-	if !p.es.Clean() {
+	if last == ast.KPassMutate {
 		return
 	}
 
+	// anything broken by an earlier pass is skipped, so this only finds new mistakes
 	ctx.Errors.SetActivity("Check types")
 	ctx.Pass = ast.KPassCheckTypes
 	ctx.PrepareForPass(m)
 	m.Check(ctx)
+	if last == ast.KPassCheckTypes {
+		return
+	}
+
+	// warnings are only worth giving for correct code the user wrote, not the standard library
+	if p.es.Clean() && p.Env.IsLocal(m.Id) {
+		ctx.Errors.SetActivity("Analyse")
+		ctx.Pass = ast.KPassAnalyse
+		ctx.PrepareForPass(m)
+		m.Check(ctx)
+	}
 
 	if !p.es.Clean() {
 		return
@@ -0,0 +1,407 @@
+package program
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The name of a project's lockfile, next to its manifest
+const LockName string = "eyot.lock"
+
+// A dependency as eyot mod vendor copied it into the vendor folder
+type LockedDependency struct {
+	Name string
+
+	// a local folder relative to the project, or a git repository
+	Path, Git string
+
+	// the revision asked for, and the commit it was when vendored
+	Rev, Commit string
+
+	// of the vendored files, see HashTree
+	Hash string
+}
+
+func (ld LockedDependency) source() string {
+	if ld.Git != "" {
+		return strings.TrimSpace(ld.Git + " " + ld.Rev)
+	}
+	return ld.Path
+}
+
+// The folder the dependency was vendored into
+func (m *Manifest) VendorDir(name string) string {
+	return filepath.Join(m.Dir, VendorFolder, name)
+}
+
+/*
+The folder the dependency is vendored into, checked to be directly inside vendor
+
+Vendoring removes and replaces this folder, so this guards against a name that reaches outside vendor,
+though names are checked when they are read
+*/
+func (m *Manifest) vendorTarget(name string) (string, error) {
+	vendor := filepath.Join(m.Dir, VendorFolder)
+	dir := m.VendorDir(name)
+	rel, err := filepath.Rel(vendor, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || strings.ContainsRune(rel, filepath.Separator) {
+		return "", fmt.Errorf("Dependency %v would be vendored outside of %v", name, vendor)
+	}
+	return dir, nil
+}
+
+func (m *Manifest) lockPath() string {
+	return filepath.Join(m.Dir, LockName)
+}
+
+/*
+Read the project's lockfile, which is nil when the project has not been vendored
+
+Each line is a dependency, its fields separated by tabs: name, path or git, where from, the git
+revision and commit (- for a path), and the hash
+*/
+func (m *Manifest) ReadLock() ([]LockedDependency, error) {
+	blob, err := os.ReadFile(m.lockPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	locked := []LockedDependency{}
+	for li, line := range strings.Split(string(blob), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 || (fields[1] != "path" && fields[1] != "git") {
+			return nil, fmt.Errorf("%v:%v: expected name, path or git, source, rev, commit and hash", m.lockPath(), li+1)
+		}
+
+		if err := checkDependencyName(fields[0]); err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", m.lockPath(), li+1, err)
+		}
+
+		ld := LockedDependency{Name: fields[0], Hash: fields[5]}
+		if fields[1] == "git" {
+			ld.Git, ld.Rev, ld.Commit = fields[2], fields[3], fields[4]
+			if ld.Rev == "-" {
+				ld.Rev = ""
+			}
+		} else {
+			ld.Path = fields[2]
+		}
+		locked = append(locked, ld)
+	}
+	return locked, nil
+}
+
+func (m *Manifest) writeLock(locked []LockedDependency) error {
+	buf := bytes.NewBufferString("# Written by eyot mod vendor, the hash is of what was copied into vendor\n")
+	for _, ld := range locked {
+		if ld.Git != "" {
+			rev := ld.Rev
+			if rev == "" {
+				rev = "-"
+			}
+			fmt.Fprintf(buf, "%v\tgit\t%v\t%v\t%v\t%v\n", ld.Name, ld.Git, rev, ld.Commit, ld.Hash)
+		} else {
+			fmt.Fprintf(buf, "%v\tpath\t%v\t-\t-\t%v\n", ld.Name, ld.Path, ld.Hash)
+		}
+	}
+	return os.WriteFile(m.lockPath(), buf.Bytes(), 0666)
+}
+
+// The dependency as it would be locked, with a path relative to the project, before it is fetched
+func (m *Manifest) lockedFrom(d Dependency, dir string) (LockedDependency, error) {
+	if d.Git != "" {
+		return LockedDependency{Name: d.Name, Git: d.Git, Rev: d.Rev}, nil
+	}
+
+	path := d.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	rel, err := filepath.Rel(m.Dir, path)
+	if err != nil {
+		return LockedDependency{}, err
+	}
+	return LockedDependency{Name: d.Name, Path: filepath.ToSlash(rel)}, nil
+}
+
+/*
+Check the vendored dependencies are those the manifest asks for, and unchanged since they were vendored
+
+This returns the folder of each by name
+*/
+func (m *Manifest) CheckVendored(locked []LockedDependency) (map[string]string, error) {
+	byName := map[string]LockedDependency{}
+	for _, ld := range locked {
+		byName[ld.Name] = ld
+	}
+
+	for _, d := range m.Dependencies {
+		want, err := m.lockedFrom(d, m.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if ld, fnd := byName[d.Name]; !fnd || ld.source() != want.source() {
+			return nil, fmt.Errorf("%v is out of date with %v, run eyot mod vendor", LockName, ManifestName)
+		}
+	}
+
+	dirs := map[string]string{}
+	for _, ld := range locked {
+		dir := m.VendorDir(ld.Name)
+		hash, err := HashTree(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Dependency %v is not vendored, run eyot mod vendor: %v", ld.Name, err)
+		}
+		if hash != ld.Hash {
+			return nil, fmt.Errorf("%v does not match its hash in %v, run eyot mod vendor to fetch it again", dir, LockName)
+		}
+		dirs[ld.Name] = dir
+	}
+	return dirs, nil
+}
+
+/*
+The hash of the files in a folder, and their paths within it, as sha256:<hex>
+
+This is of the files treeFiles finds, so version control folders are left out
+*/
+func HashTree(dir string) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%v is not a folder", dir)
+	}
+
+	files, err := treeFiles(dir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, rel := range files {
+		blob, err := os.ReadFile(filepath.Join(dir, rel))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%v\x00%v\x00", filepath.ToSlash(rel), len(blob))
+		h.Write(blob)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+/*
+The files below the folder relative to it, sorted
+
+Those in folders starting with a . are left out, as is the folder's own vendor folder, whose
+dependencies are vendored alongside it instead
+*/
+func treeFiles(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if path == filepath.Join(dir, VendorFolder) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+/*
+Copy every dependency into the vendor folder, and write the lockfile
+
+The dependencies of dependencies are vendored too, alongside them, as modules are imported by the
+name of their dependency alone. A git dependency already in the lockfile is checked out at the commit
+it was locked at, so vendoring again gives the same files until its revision is changed
+*/
+func (m *Manifest) Vendor() ([]LockedDependency, error) {
+	previous, err := m.ReadLock()
+	if err != nil {
+		return nil, err
+	}
+	lockedCommits := map[string]string{}
+	for _, ld := range previous {
+		if ld.Git != "" {
+			lockedCommits[ld.source()] = ld.Commit
+		}
+	}
+
+	work, err := os.MkdirTemp("", "eyot-vendor-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	type pending struct {
+		dep Dependency
+
+		// the folder the dependency's manifest is in, which its paths are relative to
+		from string
+
+		// true when that is a git checkout, which has nothing outside it to find paths in
+		fromGit bool
+	}
+	queue := []pending{}
+	for _, d := range m.Dependencies {
+		queue = append(queue, pending{d, m.Dir, false})
+	}
+
+	vendored := map[string]LockedDependency{}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		if next.fromGit && next.dep.Git == "" {
+			return nil, fmt.Errorf("Dependency %v is a path, but is needed by a git dependency", next.dep.Name)
+		}
+		ld, err := m.lockedFrom(next.dep, next.from)
+		if err != nil {
+			return nil, err
+		}
+		if earlier, fnd := vendored[ld.Name]; fnd {
+			if earlier.source() != ld.source() {
+				return nil, fmt.Errorf("Dependency %v is needed from both %v and %v", ld.Name, earlier.source(), ld.source())
+			}
+			continue
+		}
+
+		src := filepath.Join(m.Dir, filepath.FromSlash(ld.Path))
+		if ld.Git != "" {
+			src = filepath.Join(work, ld.Name)
+			ld.Commit, err = checkout(ld.Git, ld.Rev, lockedCommits[ld.source()], src)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to fetch %v: %v", ld.Name, err)
+			}
+		}
+
+		dst, err := m.vendorTarget(ld.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return nil, err
+		}
+		if err := copyTree(src, dst); err != nil {
+			return nil, fmt.Errorf("Failed to vendor %v: %v", ld.Name, err)
+		}
+		if ld.Hash, err = HashTree(dst); err != nil {
+			return nil, err
+		}
+		vendored[ld.Name] = ld
+
+		// the dependency's own dependencies, relative to where it came from
+		if _, err := os.Stat(filepath.Join(src, ManifestName)); err == nil {
+			dm, err := LoadManifest(filepath.Join(src, ManifestName))
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range dm.Dependencies {
+				queue = append(queue, pending{d, src, ld.Git != ""})
+			}
+		}
+	}
+
+	// what was vendored before, but is no longer needed
+	for _, ld := range previous {
+		if _, fnd := vendored[ld.Name]; !fnd {
+			if dir, err := m.vendorTarget(ld.Name); err == nil {
+				os.RemoveAll(dir)
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range vendored {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	locked := []LockedDependency{}
+	for _, name := range names {
+		locked = append(locked, vendored[name])
+	}
+	return locked, m.writeLock(locked)
+}
+
+// Clone the repository into dir at the commit, or else the revision, returning the commit checked out
+func checkout(repository, rev, commit, dir string) (string, error) {
+	git := func(args ...string) (string, error) {
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %v: %v\n%v", args[0], err, strings.TrimSpace(string(out)))
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	if _, err := git("clone", "--quiet", repository, dir); err != nil {
+		return "", err
+	}
+
+	at := commit
+	if at == "" {
+		at = rev
+	}
+	if at != "" {
+		if _, err := git("-C", dir, "checkout", "--quiet", at); err != nil {
+			return "", err
+		}
+	}
+	return git("-C", dir, "rev-parse", "HEAD")
+}
+
+// Copy the files HashTree hashes from one folder to another
+func copyTree(src, dst string) error {
+	files, err := treeFiles(src)
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range files {
+		blob, err := os.ReadFile(filepath.Join(src, rel))
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, blob, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
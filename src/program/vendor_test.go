@@ -0,0 +1,302 @@
+package program
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLockRoundTrip(t *testing.T) {
+	m := &Manifest{Dir: t.TempDir()}
+	locked := []LockedDependency{
+		{Name: "colours", Git: "https://example.com/colours.git", Rev: "v1.2", Commit: "0123abcd", Hash: "sha256:aa"},
+		{Name: "latest", Git: "file:///srv/latest", Commit: "4567ef01", Hash: "sha256:bb"},
+		{Name: "shared", Path: "../shared", Hash: "sha256:cc"},
+	}
+	if err := m.writeLock(locked); err != nil {
+		t.Fatalf("Failed to write the lock: %v", err)
+	}
+
+	read, err := m.ReadLock()
+	if err != nil {
+		t.Fatalf("Failed to read the lock: %v", err)
+	}
+	if !reflect.DeepEqual(read, locked) {
+		t.Fatalf("The lock changed, from %#v to %#v", locked, read)
+	}
+}
+
+func TestLockErrors(t *testing.T) {
+	for _, c := range []struct {
+		text, expected string
+	}{
+		{"# comment\nshared\tpath\t../shared\t-\t-\n", ":2: expected name"},
+		{"shared\tsvn\t../shared\t-\t-\tsha256:aa\n", ":1: expected name"},
+		{"../evil\tpath\t../shared\t-\t-\tsha256:aa\n", `:1: dependency name "../evil" should be an identifier`},
+	} {
+		m := &Manifest{Dir: t.TempDir()}
+		writeFiles(t, m.Dir, map[string]string{LockName: c.text})
+		if _, err := m.ReadLock(); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Reading:\n%v\nexpecting an error with '%v', have %v", c.text, c.expected, err)
+		}
+	}
+}
+
+func TestDependencyNames(t *testing.T) {
+	for _, c := range []struct {
+		text, expected string
+	}{
+		{"name = \"app\"\n[dependencies]\n\"../evil\" = { path = \"../shared\" }\n", `:3: dependency name "\"../evil\"" should be an identifier`},
+		{"name = \"app\"\n[dependencies.\"../evil\"]\npath = \"../shared\"\n", `:3: dependency name "\"../evil\"" should be an identifier`},
+		{"name = \"app\"\n[dependencies.shared/maths]\npath = \"../shared\"\n", `:3: dependency name "shared/maths" should be an identifier`},
+		{"name = \"app\"\n[dependencies]\n2d = { path = \"../shared\" }\n", `:3: dependency name "2d" should be an identifier`},
+		{"name = \"app\"\n[dependencies.]\npath = \"../shared\"\n", ":3: a dependency has no name"},
+	} {
+		if _, err := loadManifest(t, c.text); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Loading:\n%v\nexpecting an error with '%v', have %v", c.text, c.expected, err)
+		}
+	}
+
+	// as are the names in a dependency's own manifest, before anything is vendored under them
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"app/eyot.toml":      "name = \"app\"\n\n[dependencies]\ngeometry = { path = \"../geometry\" }\n",
+		"geometry/eyot.toml": "name = \"geometry\"\n\n[dependencies]\n\"..\" = { path = \"../shared\" }\n",
+		"geometry/pt.ey":     "export fn origin() i64 {\n    return 0\n}\n",
+	})
+	app, err := LoadManifest(filepath.Join(base, "app", ManifestName))
+	if err != nil {
+		t.Fatalf("Failed to load the manifest: %v", err)
+	}
+	if _, err := app.Vendor(); err == nil || !strings.Contains(err.Error(), filepath.Join("geometry", ManifestName)+":4: dependency name") {
+		t.Fatalf("Expecting a bad name in geometry, have %v", err)
+	}
+
+	// vendoring checks the folder it replaces is in vendor, whatever the name
+	m := &Manifest{Dir: t.TempDir()}
+	for _, name := range []string{"..", ".", "../evil", "a/b", ""} {
+		if _, err := m.vendorTarget(name); err == nil {
+			t.Errorf("%q can be vendored outside of vendor", name)
+		}
+	}
+	if dir, err := m.vendorTarget("shared"); err != nil || dir != filepath.Join(m.Dir, VendorFolder, "shared") {
+		t.Errorf("Wrong folder for shared: %v, %v", dir, err)
+	}
+}
+
+func TestHashTree(t *testing.T) {
+	files := map[string]string{
+		"maths.ey":        "fn double(v i64) i64 {\n    return v * 2\n}\n",
+		"geometry/pt.ey":  "struct Point {\n    x i64\n}\n",
+		"eyot.toml":       "name = \"shared\"\n",
+		".git/HEAD":       "ref: refs/heads/main\n",
+		"vendor/x/one.ey": "fn one() i64 {\n    return 1\n}\n",
+	}
+
+	first := t.TempDir()
+	writeFiles(t, first, files)
+	hash, err := HashTree(first)
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "sha256:") {
+		t.Fatalf("Wrong hash format: %v", hash)
+	}
+	if again, _ := HashTree(first); again != hash {
+		t.Fatalf("The hash changed between runs, from %v to %v", hash, again)
+	}
+
+	// the same files elsewhere hash the same, without the folders that are left out
+	second := t.TempDir()
+	writeFiles(t, second, map[string]string{
+		"eyot.toml":      files["eyot.toml"],
+		"geometry/pt.ey": files["geometry/pt.ey"],
+		"maths.ey":       files["maths.ey"],
+	})
+	if other, _ := HashTree(second); other != hash {
+		t.Fatalf("The same files hashed differently, %v and %v", hash, other)
+	}
+
+	// and any change to a file, or its path, changes it
+	writeFiles(t, second, map[string]string{"maths.ey": files["maths.ey"] + "\n"})
+	if other, _ := HashTree(second); other == hash {
+		t.Fatalf("Changing a file did not change the hash")
+	}
+	writeFiles(t, second, map[string]string{"maths.ey": files["maths.ey"]})
+	if err := os.Rename(filepath.Join(second, "geometry"), filepath.Join(second, "shapes")); err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	if other, _ := HashTree(second); other == hash {
+		t.Fatalf("Moving a file did not change the hash")
+	}
+}
+
+// A project in base/app, with a path dependency on base/shared, returning the project's manifest
+func pathProject(t *testing.T, base string) *Manifest {
+	writeFiles(t, base, map[string]string{
+		"app/eyot.toml":   "name = \"app\"\n\n[dependencies]\nshared = { path = \"../shared\" }\n",
+		"shared/maths.ey": "export fn double(v i64) i64 {\n    return v * 2\n}\n",
+	})
+	m, err := LoadManifest(filepath.Join(base, "app", ManifestName))
+	if err != nil {
+		t.Fatalf("Failed to load the manifest: %v", err)
+	}
+	return m
+}
+
+func TestCheckVendored(t *testing.T) {
+	base := t.TempDir()
+	m := pathProject(t, base)
+
+	locked, err := m.Vendor()
+	if err != nil {
+		t.Fatalf("Failed to vendor: %v", err)
+	}
+	if len(locked) != 1 || locked[0].Path != "../shared" {
+		t.Fatalf("Wrong lock: %#v", locked)
+	}
+
+	dirs, err := m.CheckVendored(locked)
+	if err != nil {
+		t.Fatalf("The fresh vendor folder failed its check: %v", err)
+	}
+	if dirs["shared"] != filepath.Join(base, "app", VendorFolder, "shared") {
+		t.Fatalf("Wrong folders: %v", dirs)
+	}
+
+	// a vendored file is changed
+	vendored := filepath.Join(base, "app", VendorFolder, "shared", "maths.ey")
+	writeFiles(t, base, map[string]string{"app/vendor/shared/maths.ey": "export fn double(v i64) i64 {\n    return v * 3\n}\n"})
+	if _, err := m.CheckVendored(locked); err == nil || !strings.Contains(err.Error(), "does not match its hash") {
+		t.Fatalf("The change to %v was not caught: %v", vendored, err)
+	}
+
+	// vendoring again puts it back
+	if locked, err = m.Vendor(); err != nil {
+		t.Fatalf("Failed to vendor again: %v", err)
+	}
+	if _, err := m.CheckVendored(locked); err != nil {
+		t.Fatalf("Vendoring again did not restore the file: %v", err)
+	}
+
+	// the manifest moves the dependency, leaving the lock stale
+	writeFiles(t, base, map[string]string{
+		"app/eyot.toml":    "name = \"app\"\n\n[dependencies]\nshared = { path = \"../shared2\" }\n",
+		"shared2/maths.ey": "export fn double(v i64) i64 {\n    return v * 2\n}\n",
+	})
+	moved, err := LoadManifest(m.Path)
+	if err != nil {
+		t.Fatalf("Failed to load the manifest: %v", err)
+	}
+	if _, err := moved.CheckVendored(locked); err == nil || !strings.Contains(err.Error(), "is out of date") {
+		t.Fatalf("The stale lock was not caught: %v", err)
+	}
+}
+
+// Run git in dir, skipping the test when git is not installed
+func runGit(t *testing.T, dir string, args ...string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Eyot", "-c", "user.email=eyot@example.com", "-c", "init.defaultBranch=main"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Commit the files to the repository in dir, returning the commit
+func commitFiles(t *testing.T, dir string, files map[string]string) string {
+	writeFiles(t, dir, files)
+	runGit(t, dir, "add", "-A")
+	runGit(t, dir, "commit", "--quiet", "-m", "update")
+	return runGit(t, dir, "rev-parse", "HEAD")
+}
+
+func TestVendorGitPinned(t *testing.T) {
+	base := t.TempDir()
+	repository := filepath.Join(base, "colours")
+	if err := os.MkdirAll(repository, 0777); err != nil {
+		t.Fatalf("Failed to create the repository: %v", err)
+	}
+	runGit(t, repository, "init", "--quiet")
+	first := commitFiles(t, repository, map[string]string{"colours.ey": "export fn red() i64 {\n    return 1\n}\n"})
+
+	writeFiles(t, base, map[string]string{
+		"app/eyot.toml": "name = \"app\"\n\n[dependencies]\ncolours = { git = \"file://" + filepath.ToSlash(repository) + "\" }\n",
+	})
+	m, err := LoadManifest(filepath.Join(base, "app", ManifestName))
+	if err != nil {
+		t.Fatalf("Failed to load the manifest: %v", err)
+	}
+
+	locked, err := m.Vendor()
+	if err != nil {
+		t.Fatalf("Failed to vendor: %v", err)
+	}
+	if len(locked) != 1 || locked[0].Commit != first {
+		t.Fatalf("Expecting colours at %v, have %#v", first, locked)
+	}
+
+	// a later commit is not picked up, as vendoring again checks out the locked commit
+	commitFiles(t, repository, map[string]string{"colours.ey": "export fn red() i64 {\n    return 2\n}\n"})
+	if locked, err = m.Vendor(); err != nil {
+		t.Fatalf("Failed to vendor again: %v", err)
+	}
+	if locked[0].Commit != first {
+		t.Fatalf("Vendoring again moved colours from %v to %v", first, locked[0].Commit)
+	}
+	blob, err := os.ReadFile(filepath.Join(m.VendorDir("colours"), "colours.ey"))
+	if err != nil || !strings.Contains(string(blob), "return 1") {
+		t.Fatalf("Wrong vendored file: %s, %v", blob, err)
+	}
+	if _, err := m.CheckVendored(locked); err != nil {
+		t.Fatalf("The vendored repository failed its check: %v", err)
+	}
+}
+
+func TestVendorConflicts(t *testing.T) {
+	// a git dependency has nothing outside its repository for a path to point into
+	base := t.TempDir()
+	repository := filepath.Join(base, "colours")
+	if err := os.MkdirAll(repository, 0777); err != nil {
+		t.Fatalf("Failed to create the repository: %v", err)
+	}
+	runGit(t, repository, "init", "--quiet")
+	commitFiles(t, repository, map[string]string{
+		"eyot.toml":  "name = \"colours\"\n\n[dependencies]\nshared = { path = \"../shared\" }\n",
+		"colours.ey": "export fn red() i64 {\n    return 1\n}\n",
+	})
+	writeFiles(t, base, map[string]string{
+		"app/eyot.toml": "name = \"app\"\n\n[dependencies]\ncolours = { git = \"file://" + filepath.ToSlash(repository) + "\" }\n",
+	})
+	m, err := LoadManifest(filepath.Join(base, "app", ManifestName))
+	if err != nil {
+		t.Fatalf("Failed to load the manifest: %v", err)
+	}
+	if _, err := m.Vendor(); err == nil || !strings.Contains(err.Error(), "Dependency shared is a path, but is needed by a git dependency") {
+		t.Fatalf("Expecting a path needed by a git dependency, have %v", err)
+	}
+
+	// the same name from a path and from git
+	base = t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"app/eyot.toml":      "name = \"app\"\n\n[dependencies]\ngeometry = { path = \"../geometry\" }\nshared = { path = \"../shared\" }\n",
+		"geometry/eyot.toml": "name = \"geometry\"\n\n[dependencies]\nshared = { git = \"https://example.com/shared.git\" }\n",
+		"geometry/pt.ey":     "export fn origin() i64 {\n    return 0\n}\n",
+		"shared/maths.ey":    "export fn double(v i64) i64 {\n    return v * 2\n}\n",
+	})
+	m, err = LoadManifest(filepath.Join(base, "app", ManifestName))
+	if err != nil {
+		t.Fatalf("Failed to load the manifest: %v", err)
+	}
+	if _, err := m.Vendor(); err == nil || !strings.Contains(err.Error(), "Dependency shared is needed from both ../shared and https://example.com/shared.git") {
+		t.Fatalf("Expecting shared to be needed from both, have %v", err)
+	}
+}
@@ -21,22 +21,51 @@ func usage() error {
 	return nil
 }
 
-func runTest(sourcePath, outputPath string, isOut, isErr bool) (bool, bool, error) {
+// How a test went
+type testResult int
+
+const (
+	kTestPassed testResult = iota
+	kTestFailed
+
+	// the test could not be run here, which is neither a pass nor a failure
+	kTestSkipped
+)
+
+func runTest(sourcePath, outputPath string, isOut, isErr bool) (testResult, bool, error) {
 	useOclGrind := os.Getenv("EyotTestOclGrind") == "y"
+	useInterp := os.Getenv("EyotTestInterp") == "y"
 	binaryPath := os.Args[1]
 
 	testOutputBlob, err := os.ReadFile(outputPath)
 	if err != nil {
-		return false, false, fmt.Errorf("Failed to read output at '%v': %v", outputPath, err)
+		return kTestFailed, false, fmt.Errorf("Failed to read output at '%v': %v", outputPath, err)
 	}
 	referenceOutput := sortLineEndings(string(testOutputBlob))
 
+	// output tests are about the program, where error tests can check for warnings too
+	warningFlag := "-w"
+	if isErr {
+		warningFlag = "-Werror"
+	}
+
+	// a test can give the command it is checked with in name.args.txt, e.g. lint -lib
+	runArgs := []string{"run"}
+	if argsBlob, err := os.ReadFile(strings.TrimSuffix(sourcePath, ".ey") + ".args.txt"); err == nil {
+		runArgs = strings.Fields(string(argsBlob))
+	}
+	runArgs = append(runArgs, warningFlag)
+	if useInterp {
+		runArgs = append(runArgs, "-interp")
+	}
+	runArgs = append(runArgs, sourcePath)
+
 	buf := bytes.NewBuffer([]byte{})
 	var cmd *exec.Cmd
 	if useOclGrind {
-		cmd = exec.Command("oclgrind", binaryPath, "run", sourcePath)
+		cmd = exec.Command("oclgrind", append([]string{binaryPath}, runArgs...)...)
 	} else {
-		cmd = exec.Command(binaryPath, "run", sourcePath)
+		cmd = exec.Command(binaryPath, runArgs...)
 	}
 	cmd.Stdout = buf
 	cmd.Stderr = buf
@@ -53,7 +82,13 @@ func runTest(sourcePath, outputPath string, isOut, isErr bool) (bool, bool, erro
 	actualOutput := sortLineEndings(buf.String())
 	if strings.Contains(actualOutput, "ey-test-reserved-pass") {
 		fmt.Println("  Forced pass")
-		return true, false, nil
+		return kTestPassed, false, nil
+	}
+
+	// ffi and the gc internals need the compiled runtime
+	if useInterp && strings.Contains(actualOutput, "not available in the interpreter") {
+		fmt.Println("  Skipped, not available in the interpreter")
+		return kTestSkipped, false, nil
 	}
 
 	if isOut {
@@ -61,7 +96,7 @@ func runTest(sourcePath, outputPath string, isOut, isErr bool) (bool, bool, erro
 			// there seems to be some issues on GHA with 24.04, and I think oclgrind in this case too
 			// It is possible that this is a real issue, but I see no useful logs, and the issue only happens with oclgrind. I would expect the sanitiser to throw issues in non ocl grind case
 			fmt.Printf("  Execution of '%v' failed with\n    %v\n", sourcePath, testRunError)
-			return false, true, nil
+			return kTestFailed, true, nil
 		}
 		if actualOutput != referenceOutput {
 			fmt.Printf("  Bad output when running '%v'\n    Got '%v'\n", sourcePath, actualOutput)
@@ -70,10 +105,10 @@ func runTest(sourcePath, outputPath string, isOut, isErr bool) (bool, bool, erro
 			// It shows up sometimes in GHA on the ubuntu 24.04 machine
 			// I don't believe it is an actual issue with Eyot, so a retry seems reasonable
 			if strings.Contains(actualOutput, "[Oclgrind] Failed to get path to library") {
-				return false, true, nil
+				return kTestFailed, true, nil
 			}
 
-			return false, false, nil
+			return kTestFailed, false, nil
 		}
 	} else if isErr {
 		actualOutput = strings.ToLower(actualOutput)
@@ -81,29 +116,29 @@ func runTest(sourcePath, outputPath string, isOut, isErr bool) (bool, bool, erro
 
 		if testRunError == nil {
 			fmt.Printf("  No error when running '%v'\n", sourcePath)
-			return false, false, nil
+			return kTestFailed, false, nil
 		}
 
 		if !strings.Contains(actualOutput, referenceOutput) {
 			fmt.Printf("  Bad output when running '%v'\n    Got '%v'\n", sourcePath, actualOutput)
-			return false, false, nil
+			return kTestFailed, false, nil
 		}
 	}
 
-	return true, false, nil
+	return kTestPassed, false, nil
 }
 
-func runTestWithRetries(sourcePath, outputPath string, isOut, isErr bool, retries int) (bool, error) {
+func runTestWithRetries(sourcePath, outputPath string, isOut, isErr bool, retries int) (testResult, error) {
 	for i := 0; i < retries; i += 1 {
 		fmt.Printf("%v (%v / %v)\n", sourcePath, i + 1, retries)
 
-		pass, retry, err := runTest(sourcePath, outputPath, isOut, isErr)
+		result, retry, err := runTest(sourcePath, outputPath, isOut, isErr)
 		if err != nil {
-			return false, err
+			return kTestFailed, err
 		}
 
-		if pass {
-			return true, nil
+		if result != kTestFailed {
+			return result, nil
 		}
 
 		if !retry {
@@ -111,7 +146,7 @@ func runTestWithRetries(sourcePath, outputPath string, isOut, isErr bool, retrie
 		}
 	}
 
-	return false, nil
+	return kTestFailed, nil
 }
 
 func errMain() error {
@@ -122,6 +157,8 @@ func errMain() error {
 	rootFolder := os.Args[2]
 
 	failures := []string{}
+	skipped := []string{}
+	passed := 0
 
 	err := filepath.Walk(rootFolder, func(outputPath string, info os.FileInfo, err error) error {
 		nm := filepath.Base(outputPath)
@@ -137,13 +174,18 @@ func errMain() error {
 		if isOut || isErr {
 			sourcePath := outputPath[:len(outputPath)-7] + "ey"
 
-			pass, err := runTestWithRetries(sourcePath, outputPath, isOut, isErr, 3)
+			result, err := runTestWithRetries(sourcePath, outputPath, isOut, isErr, 3)
 			if err != nil {
 				return err
 			}
 
-			if !pass {
+			switch result {
+			case kTestPassed:
+				passed += 1
+			case kTestFailed:
 				failures = append(failures, outputPath)
+			case kTestSkipped:
+				skipped = append(skipped, outputPath)
 			}
 		}
 		return nil
@@ -153,6 +195,11 @@ func errMain() error {
 		return err
 	}
 
+	fmt.Printf("%v passed, %v skipped, %v failed\n", passed, len(skipped), len(failures))
+	for _, skip := range skipped {
+		fmt.Printf("  skipped %v\n", skip)
+	}
+
 	if len(failures) > 0 {
 		buf := bytes.NewBuffer([]byte{})
 		fmt.Fprintf(buf, "%v failures: ", len(failures))
@@ -8,6 +8,9 @@ import (
 type tokeniserState struct {
 	Line     int
 	Position int
+
+	// the position of the first rune on Line, so columns don't need a scan back
+	LineStart int
 }
 
 type tokeniser struct {
@@ -23,6 +26,15 @@ type tokeniser struct {
 
 	// eof for "no token" or the token type
 	lastTokenType TokenType
+
+	// where the last token ended, which is where an inserted semicolon goes
+	lastEndLine, lastEndColumn int
+
+	comments []Comment
+
+	// the /// lines not yet given to a token, and the line the last of them is on
+	docLines   []string
+	docEndLine int
 }
 
 func IsIdentifierStart(r rune) bool {
@@ -55,6 +67,7 @@ func (t *tokeniser) getNextRune() (rune, bool) {
 	t.state.Position += 1
 	if r == '\n' {
 		t.state.Line += 1
+		t.state.LineStart = t.state.Position
 	}
 	return r, true
 }
@@ -84,6 +97,12 @@ func (t *tokeniser) backup() {
 	t.state.Position -= 1
 	if t.peekNextRune() == '\n' {
 		t.state.Line -= 1
+
+		// only walks back over the one line, once per newline backed over
+		t.state.LineStart = t.state.Position
+		for t.state.LineStart > 0 && t.src[t.state.LineStart-1] != '\n' {
+			t.state.LineStart -= 1
+		}
 	}
 }
 
@@ -200,6 +219,7 @@ func (t *tokeniser) eatJunk() {
 
 		if r1 == '/' && r2 == '/' {
 			t.gather(nil, IsNotEol)
+			t.addComment(savePoint)
 			continue
 		}
 
@@ -207,6 +227,7 @@ func (t *tokeniser) eatJunk() {
 			t.eat2(func(r1, r2 rune) bool {
 				return r1 == '*' && r2 == '/'
 			})
+			t.addComment(savePoint)
 			continue
 		}
 
@@ -215,9 +236,45 @@ func (t *tokeniser) eatJunk() {
 	}
 }
 
-func (t *tokeniser) getNextInner() (Token, error) {
-	t.eatJunk()
+// Record the comment from start up to the current position
+func (t *tokeniser) addComment(start tokeniserState) {
+	t.comments = append(t.comments, Comment{
+		Text:      string(t.src[start.Position:t.state.Position]),
+		Line:      start.Line,
+		Column:    start.column(),
+		EndLine:   t.state.Line,
+		EndColumn: t.state.column(),
+	})
+
+	// consecutive /// lines make up a doc comment, anything else breaks it
+	text := t.comments[len(t.comments)-1].Text
+	isDoc := strings.HasPrefix(text, "///") && !strings.HasPrefix(text, "////")
+	if !isDoc || start.Line != t.docEndLine+1 {
+		t.docLines = nil
+	}
+	if isDoc {
+		line := strings.TrimPrefix(text[3:], " ")
+		t.docLines = append(t.docLines, strings.TrimRight(line, " \t\r"))
+		t.docEndLine = start.Line
+	}
+}
+
+// The doc comment for a token starting on line, which must directly follow it
+func (t *tokeniser) takeDoc(line int) string {
+	doc := ""
+	if len(t.docLines) > 0 && line == t.docEndLine+1 {
+		doc = strings.Join(t.docLines, "\n")
+	}
+	t.docLines = nil
+	return doc
+}
+
+// the column of a state's position, counting runes from 1
+func (s tokeniserState) column() int {
+	return s.Position - s.LineStart + 1
+}
 
+func (t *tokeniser) getNextInner() (Token, error) {
 	r, ok := t.getNextRune()
 	if !ok {
 		return Token{Type: Eof}, nil
@@ -348,18 +405,35 @@ func (t *tokeniser) getNext() (Token, error) {
 		return tk, nil
 	}
 
+	t.eatJunk()
+	start := t.getSavePoint()
+
 	tk, err := t.getNextInner()
 	if err != nil {
 		return tk, err
 	}
 
+	tk.Line = start.Line
+	tk.Column = start.column()
+	tk.EndLine = t.state.Line
+	tk.EndColumn = t.state.column()
+	tk.Doc = t.takeDoc(tk.Line)
+
 	if t.pendingSemicolon {
 		t.pendingToken = &tk
 		t.pendingSemicolon = false
-		return Token{Type: Semicolon}, nil
+		return Token{
+			Type:      Semicolon,
+			Line:      t.lastEndLine,
+			Column:    t.lastEndColumn,
+			EndLine:   t.lastEndLine,
+			EndColumn: t.lastEndColumn,
+		}, nil
 	}
 
 	t.lastTokenType = tk.Type
+	t.lastEndLine = tk.EndLine
+	t.lastEndColumn = tk.EndColumn
 	return tk, nil
 }
 
@@ -389,6 +463,12 @@ func (t *tokeniser) currentSurround() (string, string, string) {
 }
 
 func Tokenise(text string) ([]Token, error) {
+	tkns, _, err := TokeniseWithComments(text)
+	return tkns, err
+}
+
+// Tokenise, also returning the comments in the order they appear
+func TokeniseWithComments(text string) ([]Token, []Comment, error) {
 	t := tokeniser{
 		lastTokenType: Eof,
 		tkns:          []Token{},
@@ -403,6 +483,8 @@ func Tokenise(text string) ([]Token, error) {
 			"struct":   Struct,
 			"self":     Self,
 			"as":       As,
+			"from":     From,
+			"pub":      Pub,
 			"new":      New,
 			"fn":       Function,
 			"gpubuiltin":       GpuBuiltin,
@@ -474,9 +556,8 @@ func Tokenise(text string) ([]Token, error) {
 	for {
 		tkn, err := t.getNext()
 		if err != nil {
-			return nil, fmt.Errorf("Error in tokenise: %v", err)
+			return nil, nil, fmt.Errorf("Error in tokenise: %v", err)
 		}
-		tkn.Line = t.state.Line
 
 		t.tkns = append(t.tkns, tkn)
 
@@ -485,5 +566,5 @@ func Tokenise(text string) ([]Token, error) {
 		}
 	}
 
-	return t.tkns, nil
+	return t.tkns, t.comments, nil
 }
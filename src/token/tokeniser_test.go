@@ -1,6 +1,7 @@
 package token
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -197,3 +198,114 @@ func TestTokeniseFloatA(t *testing.T) {
 		t.Fatalf("wrong number leading zero")
 	}
 }
+
+func TestTokenSpans(t *testing.T) {
+	tkns, err := Tokenise("let ab = 12\n\tfoo(\"x\")")
+	if err != nil {
+		t.Fatalf("Tokenise failed with error %v", err)
+	}
+
+	type span struct {
+		ty                               TokenType
+		line, column, endLine, endColumn int
+	}
+	expected := []span{
+		{Let, 1, 1, 1, 4},
+		{Identifier, 1, 5, 1, 7},
+		{Equals, 1, 8, 1, 9},
+		{Integer, 1, 10, 1, 12},
+		// inserted semicolons sit at the end of the previous token
+		{Semicolon, 1, 12, 1, 12},
+		{Identifier, 2, 2, 2, 5},
+		{OpenCurved, 2, 5, 2, 6},
+		{String, 2, 6, 2, 9},
+		{CloseCurved, 2, 9, 2, 10},
+	}
+
+	for i, e := range expected {
+		tkn := tkns[i]
+		if tkn.Type != e.ty || tkn.Line != e.line || tkn.Column != e.column || tkn.EndLine != e.endLine || tkn.EndColumn != e.endColumn {
+			t.Fatalf("Token %v has the wrong span: %v %v:%v-%v:%v", i, tkn.Type, tkn.Line, tkn.Column, tkn.EndLine, tkn.EndColumn)
+		}
+	}
+}
+
+func TestLongLineColumns(t *testing.T) {
+	// columns come from the tracked line start, so this stays linear in the line length
+	const count = 50000
+	src := "// lead\nlet x = " + strings.Repeat("a + ", count) + "a"
+	tkns, err := Tokenise(src)
+	if err != nil {
+		t.Fatalf("Tokenise failed with error %v", err)
+	}
+
+	last := tkns[len(tkns)-1]
+	for last.Type != Identifier {
+		tkns = tkns[:len(tkns)-1]
+		last = tkns[len(tkns)-1]
+	}
+	column := len("let x = ") + count*len("a + ") + 1
+	if last.Type != Identifier || last.Line != 2 || last.Column != column || last.EndColumn != column+1 {
+		t.Fatalf("Last identifier has the wrong span: %v %v:%v-%v", last.Type, last.Line, last.Column, last.EndColumn)
+	}
+}
+
+func TestComments(t *testing.T) {
+	tkns, comments, err := TokeniseWithComments("// lead\nlet a = 1 // trail\n/* block\n over lines */ b")
+	if err != nil {
+		t.Fatalf("Tokenise failed with error %v", err)
+	}
+
+	// the comments are not tokens, but the newline after a line comment still ends the statement
+	tts := []TokenType{Let, Identifier, Equals, Integer, Semicolon, Identifier, Eof}
+	if len(tkns) != len(tts) {
+		t.Fatalf("Tokenise returned the wrong number of tokens: %v", tkns)
+	}
+	for i, _ := range tkns {
+		if tkns[i].Type != tts[i] {
+			t.Fatalf("Token %v of wrong type: %v", i, tkns)
+		}
+	}
+
+	expected := []Comment{
+		{Text: "// lead", Line: 1, Column: 1, EndLine: 1, EndColumn: 8},
+		{Text: "// trail", Line: 2, Column: 11, EndLine: 2, EndColumn: 19},
+		{Text: "/* block\n over lines */", Line: 3, Column: 1, EndLine: 4, EndColumn: 15},
+	}
+	if len(comments) != len(expected) {
+		t.Fatalf("Tokenise returned the wrong number of comments: %v", comments)
+	}
+	for i, e := range expected {
+		if comments[i] != e {
+			t.Fatalf("Comment %v is wrong: %+v", i, comments[i])
+		}
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	src := "/// Adds\n///   indented\nfn a() {}\n/// lost\n\nfn b() {}\n// plain\n/// kept\nstruct C {}\n//// not doc\nconst d = 1"
+	tkns, err := Tokenise(src)
+	if err != nil {
+		t.Fatalf("Tokenise failed with error %v", err)
+	}
+
+	docs := map[string]string{}
+	for i, tk := range tkns {
+		if tk.Doc != "" {
+			docs[tkns[i+1].Tval] = tk.Doc
+		}
+	}
+
+	expected := map[string]string{
+		"a": "Adds\n  indented",
+		"C": "kept",
+	}
+	if len(docs) != len(expected) {
+		t.Fatalf("Wrong doc comments found: %v", docs)
+	}
+	for name, doc := range expected {
+		if docs[name] != doc {
+			t.Fatalf("Doc comment for %v is %q, expecting %q", name, docs[name], doc)
+		}
+	}
+}
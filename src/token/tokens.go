@@ -55,6 +55,8 @@ const (
 	Break
 	Pipeline
 	As
+	From
+	Pub
 	Range
 	True
 	False
@@ -102,7 +104,24 @@ type Token struct {
 	// the floating val
 	Fval int64
 
-	Line int
+	// where this starts, columns count runes from 1
+	Line, Column int
+
+	// just past the last rune of this
+	EndLine, EndColumn int
+
+	// the /// comment lines directly above this, without the slashes
+	Doc string
+}
+
+// A comment, which is kept apart from the tokens so the parser never sees it
+type Comment struct {
+	// the whole comment, including the // or /* */
+	Text string
+
+	// where this starts, and just past where it ends, as in Token
+	Line, Column       int
+	EndLine, EndColumn int
 }
 
 func (t Token) String() string {
@@ -175,6 +194,12 @@ func (t Token) String() string {
 	case As:
 		fmt.Fprintf(buf, "As")
 
+	case From:
+		fmt.Fprintf(buf, "From")
+
+	case Pub:
+		fmt.Fprintf(buf, "Pub")
+
 	case GpuBuiltin:
 		fmt.Fprintf(buf, "GpuBuiltin")
 